@@ -39,10 +39,10 @@ import (
 )
 
 func init() {
-	common.SetTripleCodec(constant.PBCodecName, NewProtobufCodec)
-	common.SetTripleCodec(constant.HessianCodecName, NewHessianCodec)
-	common.SetTripleCodec(constant.MsgPackCodecName, NewMsgPackCodec)
-	common.SetTripleCodec(constant.JSONMapStructCodec, NewJSONMapStruct)
+	common.MustRegisterCodec(constant.PBCodecName, NewProtobufCodec)
+	common.MustRegisterCodec(constant.HessianCodecName, NewHessianCodec)
+	common.MustRegisterCodec(constant.MsgPackCodecName, NewMsgPackCodec)
+	common.MustRegisterCodec(constant.JSONMapStructCodec, NewJSONMapStruct)
 }
 
 // MsgPackCodec is the msgpack impl of common.Codec interface
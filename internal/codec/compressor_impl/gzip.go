@@ -0,0 +1,103 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package compressor_impl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+import (
+	"github.com/dubbogo/triple/internal/codes"
+	"github.com/dubbogo/triple/internal/status"
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
+)
+
+func init() {
+	common.SetCompressor(constant.GzipCompressorName, NewGzipCompressor)
+}
+
+// GzipCompressor is the gzip impl of common.Compressor, it compresses each message with the same level
+type GzipCompressor struct {
+	level      int
+	writerPool sync.Pool
+}
+
+// NewGzipCompressor returns GzipCompressor with compression @level, which should be in
+// [gzip.HuffmanOnly, gzip.BestCompression], or gzip.DefaultCompression
+func NewGzipCompressor(level int) (common.Compressor, error) {
+	// check level by creating the first writer
+	w, err := gzip.NewWriterLevel(ioutil.Discard, level)
+	if err != nil {
+		return nil, err
+	}
+	c := &GzipCompressor{
+		level: level,
+	}
+	c.writerPool.Put(w)
+	return c, nil
+}
+
+// Name returns grpc-encoding name "gzip"
+func (c *GzipCompressor) Name() string {
+	return constant.GzipCompressorName
+}
+
+// Compress compresses @data with level of compressor
+func (c *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)/2))
+	w, ok := c.writerPool.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(buf)
+	} else {
+		// level has been checked in constructor
+		w, _ = gzip.NewWriterLevel(buf, c.level)
+	}
+	defer c.writerPool.Put(w)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses gzip @data, it fails with ResourceExhausted if the decompressed message is larger than
+// constant.MaxDecompressedMessageSize
+func (c *GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// read one more byte to tell the message exceeding the limit from the one of exactly the limit
+	decompressed, err := ioutil.ReadAll(io.LimitReader(r, constant.MaxDecompressedMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > constant.MaxDecompressedMessageSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "grpc: decompressed message exceeds max size %d",
+			constant.MaxDecompressedMessageSize)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,100 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package compressor_impl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"math/rand"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	"github.com/dubbogo/triple/internal/codes"
+	"github.com/dubbogo/triple/internal/status"
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
+)
+
+// compressibleData returns text like data with words picked randomly from a small dictionary
+func compressibleData() []byte {
+	words := []string{"triple ", "dubbo ", "grpc ", "http2 ", "stream ", "unary ", "codec ", "gzip "}
+	r := rand.New(rand.NewSource(1))
+	buf := bytes.NewBuffer(nil)
+	for buf.Len() < 256*1024 {
+		buf.WriteString(words[r.Intn(len(words))])
+	}
+	return buf.Bytes()
+}
+
+func TestGzipCompressorLevel(t *testing.T) {
+	data := compressibleData()
+	sizes := make(map[int]int)
+	for _, level := range []int{gzip.HuffmanOnly, gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression} {
+		c, err := common.GetCompressor(constant.GzipCompressorName, level)
+		assert.Nil(t, err)
+		assert.Equal(t, constant.GzipCompressorName, c.Name())
+
+		// compress twice to make sure the pooled writer keeps level
+		for i := 0; i < 2; i++ {
+			compressed, err := c.Compress(data)
+			assert.Nil(t, err)
+			sizes[level] = len(compressed)
+
+			decompressed, err := c.Decompress(compressed)
+			assert.Nil(t, err)
+			assert.Equal(t, data, decompressed)
+		}
+	}
+	assert.Greater(t, sizes[gzip.HuffmanOnly], sizes[gzip.BestSpeed])
+	assert.Greater(t, sizes[gzip.BestSpeed], sizes[gzip.BestCompression])
+	assert.GreaterOrEqual(t, sizes[gzip.DefaultCompression], sizes[gzip.BestCompression])
+}
+
+func TestGzipCompressorInvalidLevel(t *testing.T) {
+	_, err := common.GetCompressor(constant.GzipCompressorName, gzip.BestCompression+1)
+	assert.NotNil(t, err)
+	_, err = common.GetCompressor(constant.GzipCompressorName, gzip.HuffmanOnly-1)
+	assert.NotNil(t, err)
+}
+
+func TestGzipCompressorMaxDecompressedSize(t *testing.T) {
+	c, err := common.GetCompressor(constant.GzipCompressorName, gzip.BestSpeed)
+	assert.Nil(t, err)
+
+	// message of max size is decompressed
+	data := make([]byte, constant.MaxDecompressedMessageSize)
+	compressed, err := c.Compress(data)
+	assert.Nil(t, err)
+	decompressed, err := c.Decompress(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), len(decompressed))
+
+	// a small compressed message exceeding max size after decompression fails with ResourceExhausted
+	compressed, err = c.Compress(append(data, 0))
+	assert.Nil(t, err)
+	assert.Less(t, len(compressed), 1024*1024)
+	_, err = c.Decompress(compressed)
+	tErr, ok := err.(*status.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, codes.ResourceExhausted, tErr.Status().Code())
+}
@@ -21,7 +21,7 @@ import (
 	"context"
 	"net/http"
 	"net/textproto"
-	"strings"
+	"time"
 )
 
 import (
@@ -39,6 +39,18 @@ func init() {
 	common.SetProtocolHeaderHandler(constant.TRIPLE, NewTripleHeaderHandler)
 }
 
+// tripleHeaderFields are the request header fields of triple protocol, which are always written by
+// WriteTripleReqHeaderField
+var tripleHeaderFields = []string{
+	constant.TripleRequestID,
+	constant.TripleTraceID,
+	constant.TripleTraceRPCID,
+	constant.TripleTraceProtoBin,
+	constant.TripleUnitInfo,
+	constant.TripleServiceVersion,
+	constant.TripleServiceGroup,
+}
+
 // TripleHeader stores the needed http2 header fields of triple protocol
 type TripleHeader struct {
 	Path           string
@@ -54,11 +66,17 @@ type TripleHeader struct {
 	GrpcMessage    string
 	Authorization  []string
 	Attachment     common.TripleAttachment
+
+	// parentCtx is the parent of ctx returned by FieldToCtx, it is the request ctx of server
+	parentCtx context.Context
 }
 
-func NewTripleHeader(path string, header http.Header) h2Triple.ProtocolHeader {
+// NewTripleHeader parse triple header from http2 @header, @ctx is used as parent of rpc ctx, attachments are read
+// with PreserveCaseKeys and AttachmentValueCodecs of @opt
+func NewTripleHeader(ctx context.Context, path string, header http.Header, opt *config.Option) h2Triple.ProtocolHeader {
 	tripleHeader := &TripleHeader{
 		Attachment: make(common.TripleAttachment),
+		parentCtx:  ctx,
 	}
 	tripleHeader.Path = path
 	for k, v := range header {
@@ -87,9 +105,12 @@ func NewTripleHeader(path string, header http.Header) h2Triple.ProtocolHeader {
 		//case "grpc-message":
 		default:
 			// attachment
-			tripleHeader.Attachment[strings.ToLower(k)] = v[0]
+			for _, value := range v {
+				tripleHeader.Attachment.Add(k, decodeAttachmentValue(k, value, opt.AttachmentValueCodecs))
+			}
 		}
 	}
+	common.RestoreAttachmentKeyCase(tripleHeader.Attachment, opt.PreserveCaseKeys)
 	return tripleHeader
 }
 
@@ -99,7 +120,11 @@ func (t *TripleHeader) GetPath() string {
 
 // FieldToCtx parse triple Header that protocol defined, to ctx of server.
 func (t *TripleHeader) FieldToCtx() context.Context {
-	ctx := context.WithValue(context.Background(), constant.TripleCtxKey(constant.TripleServiceVersion), t.ServiceVersion)
+	parentCtx := t.parentCtx
+	if parentCtx == nil {
+		parentCtx = context.Background()
+	}
+	ctx := context.WithValue(parentCtx, constant.TripleCtxKey(constant.TripleServiceVersion), t.ServiceVersion)
 	ctx = context.WithValue(ctx, constant.TripleCtxKey(constant.TripleServiceGroup), t.ServiceGroup)
 	ctx = context.WithValue(ctx, constant.TripleCtxKey(constant.TripleRequestID), t.RPCID)
 	ctx = context.WithValue(ctx, constant.TripleCtxKey(constant.TripleTraceID), t.TracingID)
@@ -139,8 +164,18 @@ func (t *TripleHeaderHandler) WriteTripleReqHeaderField(header http.Header) http
 	outerAttachment, ok := t.Ctx.Value(string(constant.CtxAttachmentKey)).(common.DubboAttachment)
 	if ok {
 		for k, v := range outerAttachment {
-			if str, ok := v.(string); ok {
-				header[k] = []string{str}
+			var values []string
+			switch value := v.(type) {
+			case string:
+				values = []string{value}
+			case []string:
+				values = value
+			}
+			for _, str := range values {
+				// invalid value is rejected by controller before sending
+				if value, err := common.EncodeAttachmentValue(k, str); err == nil {
+					header[k] = append(header[k], value)
+				}
 			}
 		}
 	}
@@ -151,12 +186,34 @@ func (t *TripleHeaderHandler) WriteTripleReqHeaderField(header http.Header) http
 	header[constant.TripleTraceRPCID] = []string{getCtxVaSave(t.Ctx, constant.TripleTraceRPCID)}
 	header[constant.TripleTraceProtoBin] = []string{getCtxVaSave(t.Ctx, constant.TripleTraceProtoBin)}
 	header[constant.TripleUnitInfo] = []string{getCtxVaSave(t.Ctx, constant.TripleUnitInfo)}
+	if key, ok := common.IdempotencyKeyFromContext(t.Ctx); ok && key != "" {
+		header[constant.TripleIdempotencyKey] = []string{key}
+	}
 	//header["tri-service-version"] = []string{getCtxVaSave(t.Ctx, "tri-service-version")}
 	//header["tri-service-group"] = []string{getCtxVaSave(t.Ctx, "tri-service-group")}
 
 	// get from opt
 	header[constant.TripleServiceVersion] = []string{t.Opt.HeaderAppVersion}
 	header[constant.TripleServiceGroup] = []string{t.Opt.HeaderGroup}
+	if t.Opt.GrpcMetadataCompatible {
+		// plain grpc servers take all header fields as metadata, so triple fields are only sent with values
+		for _, k := range tripleHeaderFields {
+			if header[k][0] == "" {
+				delete(header, k)
+			}
+		}
+	}
+	compressorType := t.Opt.CompressorType
+	if name, ok := common.CompressionFromContext(t.Ctx); ok {
+		compressorType = name
+	}
+	if compressorType != "" {
+		header[constant.GrpcEncoding] = []string{compressorType}
+	}
+	// deadline of ctx is told to server by grpc-timeout
+	if deadline, ok := t.Ctx.Deadline(); ok {
+		header[constant.GrpcTimeout] = []string{common.EncodeGrpcTimeout(time.Until(deadline))}
+	}
 
 	// set authorization key
 	if v, ok := t.Ctx.Value("authorization").([]string); !ok || len(v) != 2 {
@@ -173,6 +230,14 @@ func (t *TripleHeaderHandler) WriteTripleReqHeaderField(header http.Header) http
 func (t *TripleHeaderHandler) WriteTripleFinalRspHeaderField(w http.ResponseWriter, grpcStatusCode int, grpcMessage string, traceProtoBin int) {
 }
 
+// decodeAttachmentValue decodes attachment header field @value of @key with @codecs, invalid binary value is kept as it is
+func decodeAttachmentValue(key, value string, codecs map[string]config.AttachmentValueCodec) string {
+	if decoded, err := common.DecodeAttachmentValueWithCodecs(key, value, codecs); err == nil {
+		return decoded
+	}
+	return value
+}
+
 // getCtxVaSave get key @fields value and return, if not exist, return empty string
 func getCtxVaSave(ctx context.Context, field string) string {
 	val, ok := ctx.Value(constant.TripleCtxKey(field)).(string)
@@ -213,7 +278,9 @@ func (t *TripleHeaderHandler) ReadFromTripleReqHeader(r *http.Request) h2Triple.
 		//case "grpc-message":
 		default:
 			// attachment
-			tripleHeader.Attachment[strings.ToLower(k)] = v[0]
+			for _, value := range v {
+				tripleHeader.Attachment.Add(k, decodeAttachmentValue(k, value, t.Opt.AttachmentValueCodecs))
+			}
 		}
 	}
 	t.Opt.Logger.Debugf("TripleHeaderHandler.ReadFromTripleReqHeader read meta header field from h2 header = %+v", tripleHeader)
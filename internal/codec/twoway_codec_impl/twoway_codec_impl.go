@@ -17,6 +17,10 @@
 
 package twoway_codec_impl
 
+import (
+	"context"
+)
+
 import (
 	"github.com/dubbogo/triple/internal/codec"
 	"github.com/dubbogo/triple/internal/codec/codec_impl"
@@ -61,11 +65,16 @@ func NewPBWrapperTwoWayCodec(codecName constant.CodecType) (common.TwoWayCodec,
 
 // MarshalRequest marshal interface @v to []byte
 func (h *PBWrapperTwoWayCodec) MarshalRequest(v interface{}) ([]byte, error) {
+	return h.MarshalRequestContext(context.Background(), v)
+}
+
+// MarshalRequestContext marshal interface @v to []byte, @ctx is passed to inner codec
+func (h *PBWrapperTwoWayCodec) MarshalRequestContext(ctx context.Context, v interface{}) ([]byte, error) {
 	argsBytes := make([][]byte, 0)
 	argsTypes := make([]string, 0)
 	reqList := v.([]interface{})
 	for _, value := range reqList {
-		data, err := h.codec.Marshal(value)
+		data, err := common.MarshalContext(ctx, h.codec, value)
 		if err != nil {
 			return nil, err
 		}
@@ -83,6 +92,11 @@ func (h *PBWrapperTwoWayCodec) MarshalRequest(v interface{}) ([]byte, error) {
 
 // UnmarshalRequest unmarshal bytes @data to interface
 func (h *PBWrapperTwoWayCodec) UnmarshalRequest(data []byte, v interface{}) error {
+	return h.UnmarshalRequestContext(context.Background(), data, v)
+}
+
+// UnmarshalRequestContext unmarshal bytes @data to interface, @ctx is passed to inner codec
+func (h *PBWrapperTwoWayCodec) UnmarshalRequestContext(ctx context.Context, data []byte, v interface{}) error {
 	wrapperRequest := proto2.TripleRequestWrapper{}
 	err := h.pbCodec.Unmarshal(data, &wrapperRequest)
 	if err != nil {
@@ -95,7 +109,7 @@ func (h *PBWrapperTwoWayCodec) UnmarshalRequest(data []byte, v interface{}) erro
 	}
 
 	for idx, value := range wrapperRequest.Args {
-		if err := h.codec.Unmarshal(value, paramsInterfaces[idx]); err != nil {
+		if err := common.UnmarshalContext(ctx, h.codec, value, paramsInterfaces[idx]); err != nil {
 			return err
 		}
 	}
@@ -105,7 +119,12 @@ func (h *PBWrapperTwoWayCodec) UnmarshalRequest(data []byte, v interface{}) erro
 
 // MarshalResponse marshal interface @v to []byte
 func (h *PBWrapperTwoWayCodec) MarshalResponse(v interface{}) ([]byte, error) {
-	data, err := h.codec.Marshal(v)
+	return h.MarshalResponseContext(context.Background(), v)
+}
+
+// MarshalResponseContext marshal interface @v to []byte, @ctx is passed to inner codec
+func (h *PBWrapperTwoWayCodec) MarshalResponseContext(ctx context.Context, v interface{}) ([]byte, error) {
+	data, err := common.MarshalContext(ctx, h.codec, v)
 	if err != nil {
 		return nil, err
 	}
@@ -120,6 +139,11 @@ func (h *PBWrapperTwoWayCodec) MarshalResponse(v interface{}) ([]byte, error) {
 
 // UnmarshalResponse unmarshal bytes @data to interface
 func (h *PBWrapperTwoWayCodec) UnmarshalResponse(data []byte, v interface{}) error {
+	return h.UnmarshalResponseContext(context.Background(), data, v)
+}
+
+// UnmarshalResponseContext unmarshal bytes @data to interface, @ctx is passed to inner codec
+func (h *PBWrapperTwoWayCodec) UnmarshalResponseContext(ctx context.Context, data []byte, v interface{}) error {
 	wrapperResponse := proto2.TripleResponseWrapper{}
 	err := h.pbCodec.Unmarshal(data, &wrapperResponse)
 	if err != nil {
@@ -128,7 +152,7 @@ func (h *PBWrapperTwoWayCodec) UnmarshalResponse(data []byte, v interface{}) err
 	if v == nil { // empty respose
 		return nil
 	}
-	return h.codec.Unmarshal(wrapperResponse.Data, v)
+	return common.UnmarshalContext(ctx, h.codec, wrapperResponse.Data, v)
 }
 
 // PBTwoWayCodec is pb impl of TwoWayCodec
@@ -39,6 +39,19 @@ const (
 	// may be converted to this error.
 	Unknown Code = 2
 
+	// InvalidArgument indicates client specified an invalid argument.
+	// Note that this differs from FailedPrecondition. It indicates arguments
+	// that are problematic regardless of the state of the system
+	// (e.g., a malformed file name).
+	InvalidArgument Code = 3
+
+	// DeadlineExceeded means operation expired before completion.
+	// For operations that change the state of the system, this error may be
+	// returned even if the operation has completed successfully. For
+	// example, a successful response from a server could have been delayed
+	// long enough for the deadline to expire.
+	DeadlineExceeded Code = 4
+
 	// PermissionDenied indicates the caller does not have permission to
 	// execute the specified operation. It must not be used for rejections
 	// caused by exhausting some resource (use ResourceExhausted
@@ -84,6 +97,8 @@ var strToCode = map[string]Code{
 	`"OK"`: OK,
 	`"CANCELED"`:/* [sic] */ Canceled,
 	`"UNKNOWN"`:            Unknown,
+	`"INVALID_ARGUMENT"`:   InvalidArgument,
+	`"DEADLINE_EXCEEDED"`:  DeadlineExceeded,
 	`"PERMISSION_DENIED"`:  PermissionDenied,
 	`"RESOURCE_EXHAUSTED"`: ResourceExhausted,
 	`"UNIMPLEMENTED"`:      Unimplemented,
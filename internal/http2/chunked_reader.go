@@ -0,0 +1,108 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common"
+)
+
+/*
+chunkedReader is the io.ReadCloser returned by UnaryInvokeChunked.
+
+framing details:
+the response of a chunked unary call is a sequence of length-prefixed data messages, exactly like the messages of a
+server streaming rpc, [:5] is compressed flag and length, [5:length] is the raw chunk. There is no content-length header,
+the number and the size of chunks are decided by server, and the end of response is told by END_STREAM with trailer
+fields. So at most one chunk is buffered at client side. When all chunks are read, Read returns io.EOF if grpc-status
+in trailer is OK, otherwise it returns the common.TripleError parsed from trailer.
+*/
+type chunkedReader struct {
+	dataChan    chan *bytes.Buffer
+	trailerChan chan http.Header
+	parse       func(trailer http.Header) (common.TripleAttachment, error)
+	// cancel resets the stream of rpc which is not finished
+	cancel func()
+
+	current    *bytes.Buffer
+	err        error
+	attachment common.TripleAttachment
+
+	closeOnce sync.Once
+}
+
+func newChunkedReader(dataChan chan *bytes.Buffer, trailerChan chan http.Header,
+	parse func(trailer http.Header) (common.TripleAttachment, error), cancel func()) *chunkedReader {
+	return &chunkedReader{
+		dataChan:    dataChan,
+		trailerChan: trailerChan,
+		parse:       parse,
+		cancel:      cancel,
+	}
+}
+
+// Read reads chunk data to @p, and it returns final status of the rpc after the last chunk
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	for r.current == nil || r.current.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		chunk, ok := <-r.dataChan
+		if !ok || chunk == nil {
+			r.attachment, r.err = r.parse(<-r.trailerChan)
+			if r.err == nil {
+				r.err = io.EOF
+			}
+			return 0, r.err
+		}
+		r.current = chunk
+	}
+	return r.current.Read(p)
+}
+
+// Attachment returns trailer attachment of the rpc, it is only available after Read returns error
+func (r *chunkedReader) Attachment() common.TripleAttachment {
+	return r.attachment
+}
+
+// Close resets the stream by RST_STREAM if the rpc is not finished, so that server stops sending chunks, and drains
+// the chunks left in background, to release the goroutines of http2 client
+func (r *chunkedReader) Close() error {
+	r.closeOnce.Do(func() {
+		if r.err != nil {
+			return
+		}
+		r.cancel()
+		go func() {
+			for chunk := range r.dataChan {
+				if chunk == nil {
+					break
+				}
+			}
+			// trailer is still parsed, to finish the rpc
+			_, _ = r.parse(<-r.trailerChan)
+		}()
+	})
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/triple/internal/codes"
+	"github.com/dubbogo/triple/internal/status"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+// ConcurrencyLimiter limits concurrent executions of methods on server by config.Option.MethodConcurrencyLimits.
+// It is shared by the TripleControllers of a server, so that the concurrency is kept after services are refreshed.
+type ConcurrencyLimiter struct {
+	methods map[string]*methodConcurrency
+}
+
+// methodConcurrency is the state of a limited method, each running rpc holds a token of tokens
+type methodConcurrency struct {
+	limit  config.ConcurrencyLimit
+	tokens chan struct{}
+}
+
+// NewConcurrencyLimiter creates ConcurrencyLimiter with @limits, method path -> limit
+func NewConcurrencyLimiter(limits map[string]config.ConcurrencyLimit) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{
+		methods: make(map[string]*methodConcurrency),
+	}
+	for method, limit := range limits {
+		if limit.Max <= 0 {
+			continue
+		}
+		l.methods[method] = &methodConcurrency{
+			limit:  limit,
+			tokens: make(chan struct{}, limit.Max),
+		}
+	}
+	return l
+}
+
+// Acquire occupies an execution of @method, it returns the release function which must be called after rpc finishes.
+// If the method is full, it returns ResourceExhausted status, or waits with ConcurrencyOverflowQueue strategy,
+// and the waiting is aborted when @ctx is done.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, method string) (func(), *status.Status) {
+	m, ok := l.methods[method]
+	if !ok {
+		return func() {}, nil
+	}
+	release := func() {
+		<-m.tokens
+	}
+	select {
+	case m.tokens <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if m.limit.Overflow != config.ConcurrencyOverflowQueue {
+		return nil, status.NewStatus(codes.ResourceExhausted, fmt.Sprintf("concurrency of method %s exceeds limit %d", method, m.limit.Max))
+	}
+
+	var timeout <-chan time.Time
+	if m.limit.QueueTimeout > 0 {
+		timer := time.NewTimer(m.limit.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case m.tokens <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		return nil, status.NewStatus(codes.ResourceExhausted,
+			fmt.Sprintf("concurrency of method %s exceeds limit %d, queue timeout after %s", method, m.limit.Max, m.limit.QueueTimeout))
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, status.NewStatus(codes.DeadlineExceeded, fmt.Sprintf("deadline exceeded while waiting for concurrency of method %s", method))
+		}
+		return nil, status.NewStatus(codes.Canceled, fmt.Sprintf("canceled while waiting for concurrency of method %s", method))
+	}
+}
+
+// Concurrency returns the number of running rpcs of @method, it is always zero if @method is not limited
+func (l *ConcurrencyLimiter) Concurrency(method string) int {
+	if m, ok := l.methods[method]; ok {
+		return len(m.tokens)
+	}
+	return 0
+}
@@ -0,0 +1,248 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+// loadBalancer picks one of resolved endpoints for each client rpc with @ctx, endpoints is not empty
+type loadBalancer interface {
+	pick(ctx context.Context, endpoints []config.Endpoint) config.Endpoint
+}
+
+// newLoadBalancer returns loadBalancer of @policy name, empty name means constant.RandomLoadBalancePolicy.
+// @hashKey is required by constant.ConsistentHashLoadBalancePolicy.
+func newLoadBalancer(policy string, hashKey func(ctx context.Context) string) (loadBalancer, error) {
+	switch policy {
+	case "", constant.RandomLoadBalancePolicy:
+		return &randomLoadBalancer{intn: rand.Intn}, nil
+	case constant.RoundRobinLoadBalancePolicy:
+		return &smoothRoundRobinLoadBalancer{ignoreWeight: true, currentWeights: make(map[string]int)}, nil
+	case constant.WeightedRoundRobinLoadBalancePolicy:
+		return &smoothRoundRobinLoadBalancer{currentWeights: make(map[string]int)}, nil
+	case constant.ConsistentHashLoadBalancePolicy:
+		if hashKey == nil {
+			return nil, fmt.Errorf("load balance policy %s requires ConsistentHashKey", policy)
+		}
+		return &consistentHashLoadBalancer{hashKey: hashKey, fallback: &randomLoadBalancer{intn: rand.Intn}}, nil
+	}
+	return nil, fmt.Errorf("load balance policy %s is not supported", policy)
+}
+
+// randomLoadBalancer picks endpoint randomly by weight
+type randomLoadBalancer struct {
+	intn func(n int) int
+}
+
+func (b *randomLoadBalancer) pick(_ context.Context, endpoints []config.Endpoint) config.Endpoint {
+	return pickEndpoint(endpoints, b.intn)
+}
+
+// pickEndpoint picks one of @endpoints randomly by weight with @intn, endpoints with zero weight are picked only if
+// all weights are zero
+func pickEndpoint(endpoints []config.Endpoint, intn func(n int) int) config.Endpoint {
+	total := 0
+	for _, endpoint := range endpoints {
+		if endpoint.Weight > 0 {
+			total += endpoint.Weight
+		}
+	}
+	if total == 0 {
+		return endpoints[intn(len(endpoints))]
+	}
+	n := intn(total)
+	for _, endpoint := range endpoints {
+		if endpoint.Weight <= 0 {
+			continue
+		}
+		if n < endpoint.Weight {
+			return endpoint
+		}
+		n -= endpoint.Weight
+	}
+	return endpoints[len(endpoints)-1]
+}
+
+/*
+smoothRoundRobinLoadBalancer is the smooth weighted round-robin of nginx. For each pick, current weight of every
+endpoint is increased by its weight, the endpoint with the max current weight is picked, and its current weight is
+decreased by the total weight. E.g. weights {a:5, b:1, c:1} are picked as a a b a c a a, rather than bursts of a.
+
+If all weights are zero, or ignoreWeight is true, every endpoint has weight 1, which is the plain round-robin.
+Endpoints with zero weight are not picked if any weight is positive, which is the same as randomLoadBalancer.
+Current weights are kept by address, so the order is kept when resolver refreshes endpoints.
+*/
+type smoothRoundRobinLoadBalancer struct {
+	ignoreWeight bool
+
+	lock           sync.Mutex
+	currentWeights map[string]int
+}
+
+func (b *smoothRoundRobinLoadBalancer) pick(_ context.Context, endpoints []config.Endpoint) config.Endpoint {
+	weightOf := func(endpoint config.Endpoint) int {
+		if b.ignoreWeight {
+			return 1
+		}
+		return endpoint.Weight
+	}
+	if !b.ignoreWeight {
+		allZero := true
+		for _, endpoint := range endpoints {
+			if endpoint.Weight > 0 {
+				allZero = false
+				break
+			}
+		}
+		if allZero {
+			weightOf = func(config.Endpoint) int { return 1 }
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	total, best := 0, -1
+	for i, endpoint := range endpoints {
+		weight := weightOf(endpoint)
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+		b.currentWeights[endpoint.Address] += weight
+		if best < 0 || b.currentWeights[endpoint.Address] > b.currentWeights[endpoints[best].Address] {
+			best = i
+		}
+	}
+	b.currentWeights[endpoints[best].Address] -= total
+	if len(b.currentWeights) > len(endpoints) {
+		// forget endpoints removed by resolver
+		alive := make(map[string]int, len(endpoints))
+		for _, endpoint := range endpoints {
+			if weight, ok := b.currentWeights[endpoint.Address]; ok {
+				alive[endpoint.Address] = weight
+			}
+		}
+		b.currentWeights = alive
+	}
+	return endpoints[best]
+}
+
+// consistentHashReplicas is the number of virtual nodes of each endpoint on the hash ring
+const consistentHashReplicas = 160
+
+/*
+consistentHashLoadBalancer picks endpoint by the key of rpc returned by hashKey, on the hash ring of endpoints with
+consistentHashReplicas virtual nodes each. Rpcs with the same key are sent to the same endpoint while endpoints are
+stable, and only the keys of an added or removed endpoint are moved. Weights are ignored, except that endpoints with
+zero weight are not picked if any weight is positive, the same as randomLoadBalancer. Rpcs without key are picked
+randomly by weight.
+
+The ring is rebuilt only when the endpoints change.
+*/
+type consistentHashLoadBalancer struct {
+	hashKey  func(ctx context.Context) string
+	fallback loadBalancer
+
+	lock      sync.Mutex
+	signature string
+	ring      []hashRingNode
+}
+
+// hashRingNode is a virtual node of endpoint on the hash ring
+type hashRingNode struct {
+	hash     uint32
+	endpoint config.Endpoint
+}
+
+func (b *consistentHashLoadBalancer) pick(ctx context.Context, endpoints []config.Endpoint) config.Endpoint {
+	key := b.hashKey(ctx)
+	if key == "" {
+		return b.fallback.pick(ctx, endpoints)
+	}
+	ring := b.getRing(endpoints)
+	hash := hashOf(key)
+	i := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= hash
+	})
+	if i == len(ring) {
+		i = 0
+	}
+	return ring[i].endpoint
+}
+
+// getRing returns hash ring of @endpoints, which is rebuilt if @endpoints are different from the last ones
+func (b *consistentHashLoadBalancer) getRing(endpoints []config.Endpoint) []hashRingNode {
+	picked := make([]config.Endpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if endpoint.Weight > 0 {
+			picked = append(picked, endpoint)
+		}
+	}
+	if len(picked) == 0 {
+		picked = endpoints
+	}
+	addresses := make([]string, 0, len(picked))
+	for _, endpoint := range picked {
+		addresses = append(addresses, endpoint.Address)
+	}
+	sort.Strings(addresses)
+	signature := strings.Join(addresses, ",")
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if signature == b.signature {
+		return b.ring
+	}
+	ring := make([]hashRingNode, 0, len(picked)*consistentHashReplicas)
+	for _, endpoint := range picked {
+		for i := 0; i < consistentHashReplicas; i++ {
+			ring = append(ring, hashRingNode{hash: hashOf(endpoint.Address + "#" + strconv.Itoa(i)), endpoint: endpoint})
+		}
+	}
+	// the order of nodes with the same hash doesn't depend on the order of endpoints
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash != ring[j].hash {
+			return ring[i].hash < ring[j].hash
+		}
+		return ring[i].endpoint.Address < ring[j].endpoint.Address
+	})
+	b.signature = signature
+	b.ring = ring
+	return ring
+}
+
+// hashOf returns the first 4 bytes of md5 of @s like ketama, which spreads similar strings, e.g. addresses with
+// different ports, better than fnv
+func hashOf(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.LittleEndian.Uint32(sum[:4])
+}
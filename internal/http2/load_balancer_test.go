@@ -0,0 +1,108 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+// pickSequence returns addresses picked by @b for @n times
+func pickSequence(b loadBalancer, endpoints []config.Endpoint, n int) string {
+	picked := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		picked = append(picked, b.pick(context.Background(), endpoints).Address)
+	}
+	return strings.Join(picked, " ")
+}
+
+func TestSmoothRoundRobinLoadBalancer(t *testing.T) {
+	weighted := []config.Endpoint{{Address: "a", Weight: 5}, {Address: "b", Weight: 1}, {Address: "c", Weight: 1}}
+	unweighted := []config.Endpoint{{Address: "a"}, {Address: "b"}, {Address: "c"}}
+
+	wrr, err := newLoadBalancer(constant.WeightedRoundRobinLoadBalancePolicy, nil)
+	assert.Nil(t, err)
+	// picks are interleaved without bursts
+	assert.Equal(t, "a a b a c a a a a b a c a a", pickSequence(wrr, weighted, 14))
+
+	// it is plain round-robin without weights
+	wrr, err = newLoadBalancer(constant.WeightedRoundRobinLoadBalancePolicy, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "a b c a b c", pickSequence(wrr, unweighted, 6))
+
+	// endpoints with zero weight are skipped if any weight is positive
+	assert.Equal(t, "b b", pickSequence(wrr, []config.Endpoint{{Address: "a"}, {Address: "b", Weight: 2}}, 2))
+
+	// weights are ignored by round-robin
+	rr, err := newLoadBalancer(constant.RoundRobinLoadBalancePolicy, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "a b c a b c", pickSequence(rr, weighted, 6))
+
+	_, err = newLoadBalancer("unknown", nil)
+	assert.NotNil(t, err)
+}
+
+type testHashKey struct{}
+
+func TestConsistentHashLoadBalancer(t *testing.T) {
+	hashKey := func(ctx context.Context) string {
+		key, _ := ctx.Value(testHashKey{}).(string)
+		return key
+	}
+	_, err := newLoadBalancer(constant.ConsistentHashLoadBalancePolicy, nil)
+	assert.NotNil(t, err)
+	b, err := newLoadBalancer(constant.ConsistentHashLoadBalancePolicy, hashKey)
+	assert.Nil(t, err)
+
+	endpoints := []config.Endpoint{{Address: "a"}, {Address: "b"}, {Address: "c"}, {Address: "d"}}
+	picked := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		ctx := context.WithValue(context.Background(), testHashKey{}, key)
+		picked[key] = b.pick(ctx, endpoints).Address
+		counts[picked[key]]++
+		// the same key is sticky, whatever the order of endpoints is
+		reversed := []config.Endpoint{endpoints[3], endpoints[2], endpoints[1], endpoints[0]}
+		assert.Equal(t, picked[key], b.pick(ctx, reversed).Address)
+	}
+	for _, endpoint := range endpoints {
+		assert.True(t, counts[endpoint.Address] > 150, "counts = %v", counts)
+	}
+
+	// only keys of the removed endpoint are moved
+	for key, address := range picked {
+		ctx := context.WithValue(context.Background(), testHashKey{}, key)
+		if newAddress := b.pick(ctx, endpoints[:3]).Address; address != "d" {
+			assert.Equal(t, address, newAddress)
+		} else {
+			assert.NotEqual(t, "d", newAddress)
+		}
+	}
+}
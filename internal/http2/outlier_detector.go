@@ -0,0 +1,216 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+const (
+	defaultEjectionDuration   = 30 * time.Second
+	defaultMaxEjectionPercent = 10
+)
+
+/*
+outlierDetector is the passive health checking of config.OutlierDetection. Results of rpcs are reported by onResult
+per endpoint address, and filter removes ejected endpoints before load balancer picks one of them.
+
+An endpoint is ejected when its consecutive failures reach the threshold, unless MaxEjectionPercent of endpoints are
+ejected already, in which case it keeps counting and is ejected when there is room. After EjectionDuration the
+ejection ends lazily in filter, and in the next EjectionDuration the endpoint is kept by filter with probability
+elapsed/EjectionDuration, so that its traffic grows gradually instead of at once. Results of rpcs sent before
+ejection are ignored while the endpoint is ejected.
+*/
+type outlierDetector struct {
+	conf config.OutlierDetection
+
+	lock      sync.Mutex
+	endpoints map[string]*endpointHealth
+	// total is the number of endpoints of the last filter, MaxEjectionPercent is a percentage of it
+	total int
+
+	// now and random are replaceable for test
+	now    func() time.Time
+	random func() float64
+}
+
+// endpointHealth is not concurrent safe, it's protected by outlierDetector.lock
+type endpointHealth struct {
+	consecutiveFailures uint32
+	ejected             bool
+	// ejectedAt is the time of the last ejection, it is zero if endpoint is never ejected
+	ejectedAt time.Time
+}
+
+// newOutlierDetector returns outlierDetector of @conf, zero fields are set to default
+func newOutlierDetector(conf config.OutlierDetection) *outlierDetector {
+	if conf.EjectionDuration <= 0 {
+		conf.EjectionDuration = defaultEjectionDuration
+	}
+	if conf.MaxEjectionPercent == 0 {
+		conf.MaxEjectionPercent = defaultMaxEjectionPercent
+	}
+	if conf.IsFailure == nil {
+		conf.IsFailure = common.IsServerFailure
+	}
+	return &outlierDetector{
+		conf:      conf,
+		endpoints: make(map[string]*endpointHealth),
+		now:       time.Now,
+		random:    rand.Float64,
+	}
+}
+
+// filter returns endpoints which are not ejected in @endpoints, it returns @endpoints if all of them are ejected
+func (d *outlierDetector) filter(endpoints []config.Endpoint) []config.Endpoint {
+	now := d.now()
+	var events []config.EjectionEvent
+	picked := make([]config.Endpoint, 0, len(endpoints))
+
+	d.lock.Lock()
+	d.total = len(endpoints)
+	for _, endpoint := range endpoints {
+		health, ok := d.endpoints[endpoint.Address]
+		if !ok || health.ejectedAt.IsZero() {
+			picked = append(picked, endpoint)
+			continue
+		}
+		elapsed := now.Sub(health.ejectedAt) - d.conf.EjectionDuration
+		if health.ejected {
+			if elapsed < 0 {
+				continue
+			}
+			health.ejected = false
+			events = append(events, config.EjectionEvent{Address: endpoint.Address, Time: now})
+		}
+		if elapsed < d.conf.EjectionDuration && d.random() >= float64(elapsed)/float64(d.conf.EjectionDuration) {
+			continue
+		}
+		picked = append(picked, endpoint)
+	}
+	if len(d.endpoints) > len(endpoints) {
+		// forget endpoints removed by resolver
+		alive := make(map[string]*endpointHealth, len(endpoints))
+		for _, endpoint := range endpoints {
+			if health, ok := d.endpoints[endpoint.Address]; ok {
+				alive[endpoint.Address] = health
+			}
+		}
+		d.endpoints = alive
+	}
+	d.lock.Unlock()
+
+	d.notify(events...)
+	if len(picked) == 0 {
+		return endpoints
+	}
+	return picked
+}
+
+// onResult reports the result @err of rpc to endpoint @address
+func (d *outlierDetector) onResult(address string, err error) {
+	failure := d.conf.IsFailure(err)
+	now := d.now()
+
+	d.lock.Lock()
+	health, ok := d.endpoints[address]
+	if !ok {
+		if !failure {
+			d.lock.Unlock()
+			return
+		}
+		health = &endpointHealth{}
+		d.endpoints[address] = health
+	}
+	if !failure {
+		health.consecutiveFailures = 0
+		d.lock.Unlock()
+		return
+	}
+	if health.ejected {
+		d.lock.Unlock()
+		return
+	}
+	health.consecutiveFailures++
+	if health.consecutiveFailures < d.conf.ConsecutiveFailures || d.ejectedCount(now) >= d.maxEjections() {
+		d.lock.Unlock()
+		return
+	}
+	health.consecutiveFailures = 0
+	health.ejected = true
+	health.ejectedAt = now
+	d.lock.Unlock()
+
+	d.notify(config.EjectionEvent{Address: address, Ejected: true, Time: now})
+}
+
+// ejected returns sorted addresses of endpoints which are ejected now
+func (d *outlierDetector) ejected() []string {
+	now := d.now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	addresses := make([]string, 0)
+	for address, health := range d.endpoints {
+		if d.isEjected(health, now) {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// isEjected reports whether endpoint of @health is ejected at @now, whose ejection may not be ended by filter yet
+func (d *outlierDetector) isEjected(health *endpointHealth, now time.Time) bool {
+	return health.ejected && now.Sub(health.ejectedAt) < d.conf.EjectionDuration
+}
+
+func (d *outlierDetector) ejectedCount(now time.Time) int {
+	count := 0
+	for _, health := range d.endpoints {
+		if d.isEjected(health, now) {
+			count++
+		}
+	}
+	return count
+}
+
+// maxEjections returns max number of endpoints ejected at the same time, it is at least one
+func (d *outlierDetector) maxEjections() int {
+	max := d.total * int(d.conf.MaxEjectionPercent) / 100
+	if max < 1 {
+		return 1
+	}
+	return max
+}
+
+func (d *outlierDetector) notify(events ...config.EjectionEvent) {
+	if d.conf.OnEjection == nil {
+		return
+	}
+	for _, event := range events {
+		d.conf.OnEjection(event)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	"github.com/dubbogo/triple/internal/codes"
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+// addressesOf returns addresses of @endpoints
+func addressesOf(endpoints []config.Endpoint) []string {
+	addresses := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addresses = append(addresses, endpoint.Address)
+	}
+	return addresses
+}
+
+func TestOutlierDetector(t *testing.T) {
+	var events []config.EjectionEvent
+	d := newOutlierDetector(config.OutlierDetection{
+		ConsecutiveFailures: 3,
+		EjectionDuration:    10 * time.Second,
+		MaxEjectionPercent:  50,
+		OnEjection: func(event config.EjectionEvent) {
+			events = append(events, event)
+		},
+	})
+	now := time.Unix(1000, 0)
+	d.now = func() time.Time { return now }
+	random := 0.0
+	d.random = func() float64 { return random }
+
+	endpoints := []config.Endpoint{{Address: "a"}, {Address: "b"}, {Address: "c"}, {Address: "d"}}
+	unavailable := common.NewTripleError("unavailable", int(codes.Unavailable), "", nil)
+	assert.Equal(t, endpoints, d.filter(endpoints))
+
+	// failures must be consecutive, and errors of client or application are not failures
+	for _, err := range []error{nil, context.Canceled, common.NewTripleError("bad request", int(codes.InvalidArgument), "", nil)} {
+		d.onResult("a", unavailable)
+		d.onResult("a", unavailable)
+		d.onResult("a", err)
+	}
+	d.onResult("a", unavailable)
+	d.onResult("a", unavailable)
+	assert.Equal(t, endpoints, d.filter(endpoints))
+	assert.Empty(t, events)
+
+	d.onResult("a", errors.New("conn reset"))
+	assert.Equal(t, []string{"b", "c", "d"}, addressesOf(d.filter(endpoints)))
+	assert.Equal(t, []string{"a"}, d.ejected())
+	assert.Equal(t, []config.EjectionEvent{{Address: "a", Ejected: true, Time: now}}, events)
+
+	// at most 50% of endpoints are ejected, c is ejected after b's ejection
+	for _, address := range []string{"b", "c", "c", "c"} {
+		for i := 0; i < 3; i++ {
+			d.onResult(address, unavailable)
+		}
+	}
+	assert.Equal(t, []string{"a", "b"}, d.ejected())
+	assert.Equal(t, []string{"c", "d"}, addressesOf(d.filter(endpoints)))
+
+	// the ejection ends after EjectionDuration, and endpoint is picked with growing probability
+	now = now.Add(10 * time.Second)
+	random = 0.5
+	assert.Equal(t, []string{"c", "d"}, addressesOf(d.filter(endpoints)))
+	assert.Equal(t, []config.EjectionEvent{{Address: "a", Time: now}, {Address: "b", Time: now}}, events[len(events)-2:])
+	assert.Empty(t, d.ejected())
+	d.onResult("c", unavailable)
+	assert.Equal(t, []string{"c"}, d.ejected())
+	now = now.Add(6 * time.Second)
+	assert.Equal(t, []string{"a", "b", "d"}, addressesOf(d.filter(endpoints)))
+	now = now.Add(4 * time.Second)
+	random = 0.99
+	assert.Equal(t, []string{"a", "b", "d"}, addressesOf(d.filter(endpoints)))
+
+	// all endpoints are picked if all of them are ejected
+	d = newOutlierDetector(config.OutlierDetection{ConsecutiveFailures: 1})
+	single := []config.Endpoint{{Address: "a"}}
+	d.filter(single)
+	d.onResult("a", unavailable)
+	assert.Equal(t, []string{"a"}, d.ejected())
+	assert.Equal(t, single, d.filter(single))
+}
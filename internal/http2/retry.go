@@ -0,0 +1,145 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/triple/internal/codes"
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+/*
+retrier retries failed unary rpcs by config.RetryPolicy, throttled by config.RetryBudget. After each failed attempt,
+retry decides whether the next attempt happens and its backoff, and config.Option.OnRetry is told about it, even if
+it's suppressed by budget. Rpcs terminated at client side, by ctx, CancelAll or closing client, are not retried.
+*/
+type retrier struct {
+	policy    config.RetryPolicy
+	retryable map[codes.Code]struct{}
+	onRetry   func(info config.RetryInfo)
+
+	budget config.RetryBudget
+	lock   sync.Mutex
+	tokens float64
+
+	// random is replaceable for test
+	random func() float64
+}
+
+// newRetrier returns retrier of @policy and @budget, it returns nil if retry is disabled
+func newRetrier(policy config.RetryPolicy, budget config.RetryBudget, onRetry func(info config.RetryInfo)) *retrier {
+	if policy.MaxAttempts < 2 {
+		return nil
+	}
+	retryable := make(map[codes.Code]struct{}, len(policy.RetryableCodes))
+	for _, code := range policy.RetryableCodes {
+		retryable[codes.Code(code)] = struct{}{}
+	}
+	return &retrier{
+		policy:    policy,
+		retryable: retryable,
+		onRetry:   onRetry,
+		budget:    budget,
+		tokens:    budget.MaxTokens,
+		random:    rand.Float64,
+	}
+}
+
+// retry returns the backoff before the next attempt of rpc to @path, after the @attempt-th attempt of it ends with
+// @err and response @attachment. It returns false if there is no next attempt.
+func (r *retrier) retry(ctx context.Context, path string, attempt int, err error, attachment common.TripleAttachment) (time.Duration, bool) {
+	if err == nil {
+		r.onSuccess()
+		return 0, false
+	}
+	if ctx.Err() != nil || errors.Is(err, common.ErrCanceledAll) || errors.Is(err, common.ErrClientClosed) {
+		return 0, false
+	}
+	tripleErr, ok := err.(*common.TripleError)
+	if !ok {
+		return 0, false
+	}
+	if _, ok := r.retryable[codes.Code(tripleErr.Code())]; !ok {
+		return 0, false
+	}
+	allowed := r.onFailure()
+	if attempt >= r.policy.MaxAttempts {
+		return 0, false
+	}
+
+	backoff := r.backoff(attempt)
+	// the delay told by server takes precedence, and negative or malformed one means no retry
+	if pushback, ok := attachment.Lookup(constant.TrailerKeyGrpcRetryPushbackMs); ok {
+		ms, err := strconv.ParseInt(pushback, 10, 64)
+		if err != nil || ms < 0 {
+			return 0, false
+		}
+		backoff = time.Duration(ms) * time.Millisecond
+	}
+	if r.onRetry != nil {
+		r.onRetry(config.RetryInfo{
+			Method:     path,
+			Attempt:    attempt + 1,
+			Err:        err,
+			Backoff:    backoff,
+			Suppressed: !allowed,
+		})
+	}
+	return backoff, allowed
+}
+
+// backoff returns random backoff after the @attempt-th attempt
+func (r *retrier) backoff(attempt int) time.Duration {
+	bound := float64(r.policy.InitialBackoff) * math.Pow(math.Max(r.policy.BackoffMultiplier, 1), float64(attempt-1))
+	if r.policy.MaxBackoff > 0 {
+		bound = math.Min(bound, float64(r.policy.MaxBackoff))
+	}
+	return time.Duration(r.random() * bound)
+}
+
+// onFailure takes a token of budget for failed attempt, and returns whether retry is allowed by budget
+func (r *retrier) onFailure() bool {
+	if r.budget.MaxTokens <= 0 {
+		return true
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.tokens = math.Max(r.tokens-1, 0)
+	return r.tokens > r.budget.MaxTokens/2
+}
+
+// onSuccess gives tokens back to budget for successful rpc
+func (r *retrier) onSuccess() {
+	if r.budget.MaxTokens <= 0 {
+		return
+	}
+	r.lock.Lock()
+	r.tokens = math.Min(r.tokens+r.budget.TokenRatio, r.budget.MaxTokens)
+	r.lock.Unlock()
+}
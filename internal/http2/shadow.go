@@ -0,0 +1,92 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+// newShadowController returns controller of shadow endpoint config.Option.ShadowTarget of @opt, which unary rpcs
+// are mirrored to. Shadow rpcs are neither retried nor counted by circuit breaker, stats handler and access log of
+// @opt, so that they don't affect the primary ones.
+func newShadowController(opt *config.Option) (*TripleController, error) {
+	shadowOpt := *opt
+	shadowOpt.Location = opt.ShadowTarget
+	shadowOpt.ShadowTarget = ""
+	shadowOpt.Resolver = nil
+	shadowOpt.CircuitBreaker = nil
+	shadowOpt.RetryPolicy = config.RetryPolicy{}
+	shadowOpt.OnRetry = nil
+	shadowOpt.StatsHandler = nil
+	shadowOpt.AccessLogSink = nil
+	return NewTripleController(&shadowOpt)
+}
+
+// newShadowLimiter returns semaphore of in-flight shadow rpcs of @opt, whose capacity is ShadowMaxInFlight
+func newShadowLimiter(opt *config.Option) chan struct{} {
+	maxInFlight := opt.ShadowMaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = constant.DefaultShadowMaxInFlight
+	}
+	return make(chan struct{}, maxInFlight)
+}
+
+// mirror sends copy of unary rpc to @path with request @sendData to shadow endpoint in background, the response is
+// discarded and error is only logged. The shadow rpc has the values and deadline of @ctx, but it isn't canceled by
+// @ctx, so that it isn't aborted when the primary rpc returns. If ShadowMaxInFlight shadow rpcs are in flight, e.g.
+// shadow endpoint is slow, the copy is dropped and counted by ShadowDropped.
+func (hc *TripleController) mirror(ctx context.Context, path string, sendData []byte) {
+	if hc.shadow == nil || hc.checkAvailable() != nil {
+		return
+	}
+	select {
+	case hc.shadowInFlight <- struct{}{}:
+	default:
+		atomic.AddUint64(&hc.shadowDropped, 1)
+		return
+	}
+	// @sendData may be reused by caller after the primary rpc returns
+	shadowData := append([]byte(nil), sendData...)
+	shadowCtx, cancel := context.WithCancel(common.WithoutCancel(ctx))
+	if deadline, ok := ctx.Deadline(); ok {
+		shadowCtx, cancel = context.WithDeadline(common.WithoutCancel(ctx), deadline)
+	}
+	go func() {
+		defer func() {
+			cancel()
+			<-hc.shadowInFlight
+		}()
+		if _, _, err := hc.shadow.UnaryInvokeRaw(shadowCtx, path, shadowData); err != nil {
+			hc.option.Logger.Warnf("TripleController.mirror: shadow rpc of path %s to %s error = %v", path,
+				hc.option.ShadowTarget, err)
+		}
+	}()
+}
+
+// ShadowDropped returns the number of unary rpcs not mirrored to shadow endpoint, because ShadowMaxInFlight shadow
+// rpcs were in flight
+func (hc *TripleController) ShadowDropped() uint64 {
+	return atomic.LoadUint64(&hc.shadowDropped)
+}
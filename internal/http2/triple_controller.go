@@ -22,26 +22,34 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 import (
 	gxsync "github.com/dubbogo/gost/sync"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 
 	perrors "github.com/pkg/errors"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
 
 	"google.golang.org/grpc"
 )
 
 import (
+	"github.com/dubbogo/triple/internal/buffer"
 	"github.com/dubbogo/triple/internal/codec"
 	"github.com/dubbogo/triple/internal/codec/codec_impl"
 	codecImpl "github.com/dubbogo/triple/internal/codec/twoway_codec_impl"
@@ -52,6 +60,8 @@ import (
 	"github.com/dubbogo/triple/internal/tools"
 	"github.com/dubbogo/triple/pkg/common"
 	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/common/logger"
+	"github.com/dubbogo/triple/pkg/common/peer"
 	"github.com/dubbogo/triple/pkg/config"
 	"github.com/dubbogo/triple/pkg/http2"
 	http2Config "github.com/dubbogo/triple/pkg/http2/config"
@@ -69,22 +79,60 @@ type TripleController struct {
 	rpcServiceMap *sync.Map
 
 	closeChan chan struct{}
+	// destroyOnce makes Destroy safe to be called concurrently and repeatedly
+	destroyOnce sync.Once
+	// refs is the number of TripleClients sharing the controller, it is destroyed when the last one is released
+	refs     int32
+	refsLock sync.Mutex
 
 	// option is 10M by default
 	option *config.Option
 
 	twoWayCodec common.TwoWayCodec
+	// methodCodecs stores method path -> common.TwoWayCodec of Option.MethodCodecTypes for client rpcs, it is read only
+	methodCodecs map[string]common.TwoWayCodec
+
+	// serverCodecs caches constant.CodecType -> common.TwoWayCodec of requests' content-type, except option's codec
+	serverCodecs sync.Map
 
 	genericCodec common.GenericCodec
 
+	// compressor compresses request messages of client, it's nil if compression is not enabled
+	compressor common.Compressor
+	// compressors caches compressor name -> common.Compressor of calls with compression set in ctx
+	compressors sync.Map
+	// loadBalancer picks endpoint of Resolver for client rpc
+	loadBalancer loadBalancer
+	// outlierDetector ejects failing endpoints of Resolver, it's nil if outlier detection is disabled
+	outlierDetector *outlierDetector
+	// unwatchResolver stops watching WatchableResolver, it's nil if Resolver isn't watchable
+	unwatchResolver func()
+	// retrier retries failed unary rpcs, it's nil if retry is disabled
+	retrier *retrier
+	// shadow is the controller of config.Option.ShadowTarget, it's nil if mirroring is disabled
+	shadow *TripleController
+	// shadowInFlight is the semaphore of shadow rpcs in flight, and shadowDropped counts the dropped ones, it is
+	// accessed atomically
+	shadowInFlight chan struct{}
+	shadowDropped  uint64
+	// defaultAttachment is the copy of Option.DefaultAttachments with lower case keys, it is read only
+	defaultAttachment common.DubboAttachment
+
 	http2Client *http2.Client
 
 	pool gxsync.WorkerPool
+
+	// concurrencyLimiter limits concurrent executions of methods on server
+	concurrencyLimiter *ConcurrencyLimiter
+
+	// running stores cancel funcs of running client rpcs, which are called by CancelAll
+	running     map[*common.CancelCauseFunc]struct{}
+	runningLock sync.Mutex
 }
 
 // GetHandler is called by server when receiving tcp conn, to deal with http2 request
-func (hc *TripleController) GetHandler(rpcService interface{}) http2.Handler {
-	return func(path string, header http.Header, recvChan chan *bytes.Buffer,
+func (hc *TripleController) GetHandler(rpcService interface{}) http2.HandlerContext {
+	return func(reqCtx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
 		sendChan chan *bytes.Buffer, ctrlch chan http.Header,
 		errCh chan interface{}) {
 		/*
@@ -105,11 +153,41 @@ func (hc *TripleController) GetHandler(rpcService interface{}) http2.Handler {
 			)
 
 			rspHeader := make(map[string][]string)
-			rspHeader["content-type"] = []string{constant.TripleContentType}
+			// response content-type is the same as request
+			rspHeader["content-type"] = []string{getResponseContentType(header.Get("content-type"))}
 			ctrlch <- rspHeader
 
-			ctx, cancel := context.WithCancel(context.Background())
+			// incomingCtx contains attachments of request
+			incomingCtx := codec.NewTripleHeader(reqCtx, path, header, hc.option).FieldToCtx()
+			// ctx keeps attachments of incomingCtx, with the effective deadline of rpc
+			ctx, cancel := hc.withServerTimeout(incomingCtx, incomingCtx, path)
 			defer cancel()
+
+			if tripleStatus, rspAttachment = hc.checkRateLimit(incomingCtx, path); tripleStatus != nil {
+				close(sendChan)
+				hc.handleStatusAttachmentAndResponse(tripleStatus, rspAttachment, ctrlch)
+				return
+			}
+
+			release, tripleStatus := hc.concurrencyLimiter.Acquire(ctx, path)
+			if tripleStatus != nil {
+				hc.option.Logger.Warnf("TripleController.http2HandlerFunction: rpc of path %s is rejected by concurrency limiter: %s", path, tripleStatus.Message())
+				close(sendChan)
+				hc.handleStatusAttachmentAndResponse(tripleStatus, nil, ctrlch)
+				return
+			}
+			defer release()
+			if threshold := hc.option.GetSlowRequestThreshold(path); threshold > 0 {
+				defer hc.warnSlowRequest(reqCtx, path, threshold, time.Now())
+			}
+
+			if hc.option.UnknownMethodStrategy != config.UnknownMethodUnimplemented && hc.isUnknownMethod(rpcService, path, header) {
+				tripleStatus, rspAttachment = hc.handleUnknownMethod(ctx, path, recvChan, sendChan)
+				close(sendChan)
+				hc.handleStatusAttachmentAndResponse(tripleStatus, rspAttachment, ctrlch)
+				return
+			}
+
 			// new server stream
 			st, err := hc.newServerStreamFromTripleHeader(ctx, path, header, rpcService, hc.pool)
 			if st == nil || err != nil {
@@ -158,6 +236,10 @@ func (hc *TripleController) GetHandler(rpcService interface{}) http2.Handler {
 						if sendMsg.Status != nil {
 							tripleStatus = status.FromProto(sendMsg.Status.Proto())
 						}
+						// close message carries trailing attachments of failed rpc
+						if len(sendMsg.Attachment) > 0 {
+							rspAttachment = sendMsg.Attachment
+						}
 						break Loop
 					}
 					rspAttachment = sendMsg.Attachment
@@ -184,18 +266,174 @@ func (hc *TripleController) GetHandler(rpcService interface{}) http2.Handler {
 	}
 }
 
-func (hc *TripleController) handleStatusAttachmentAndResponse(tripleStatus *status.Status, attachment map[string]string, ctrlch chan http.Header) {
+// getResponseContentType returns response content-type of request @contentType
+func getResponseContentType(contentType string) string {
+	if strings.HasPrefix(contentType, constant.TripleContentTypePrefix) {
+		return contentType
+	}
+	return constant.TripleContentType
+}
+
+// getServerCodec returns the codec of request by sub-type of @contentType, e.g. "hessian2" of
+// "application/grpc+hessian2". Requests without sub-type or with "proto" use the codec of option, that is because
+// wrapper codecs are also sent as "application/grpc+proto" by java clients. Unknown sub-type returns Unimplemented.
+func (hc *TripleController) getServerCodec(contentType string) (constant.CodecType, common.TwoWayCodec, *status.TripleError) {
+	subType := tools.GetContentSubType(contentType)
+	if subType == "" || subType == constant.PBContentSubType {
+		return hc.option.CodecType, hc.twoWayCodec, nil
+	}
+	codecType := constant.CodecType(subType)
+	if twoWayCodec, ok := hc.serverCodecs.Load(codecType); ok {
+		return codecType, twoWayCodec.(common.TwoWayCodec), nil
+	}
+	twoWayCodec, err := codecImpl.NewTwoWayCodec(codecType)
+	if err != nil {
+		return "", nil, status.Errorf(codes.Unimplemented, "unsupported content-type %s: %v", contentType, err)
+	}
+	hc.serverCodecs.Store(codecType, twoWayCodec)
+	return codecType, twoWayCodec, nil
+}
+
+// withServerTimeout returns ctx with the effective deadline of rpc, which is decided by timeout in @incomingCtx sent by
+// client, and the server timeout policy of @path
+func (hc *TripleController) withServerTimeout(ctx context.Context, incomingCtx context.Context, path string) (context.Context, context.CancelFunc) {
+	clientTimeout, ok := common.TimeoutFromIncomingContext(incomingCtx)
+	if timeout, ok := hc.option.GetServerTimeout(path).Effective(clientTimeout, ok); ok {
+		hc.option.Logger.Debugf("TripleController.withServerTimeout: rpc of path %s with effective timeout %s", path, timeout)
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// warnSlowRequest logs warning if rpc of @path handled since @begin takes longer than @threshold
+func (hc *TripleController) warnSlowRequest(reqCtx context.Context, path string, threshold time.Duration, begin time.Time) {
+	elapsed := time.Since(begin)
+	if elapsed <= threshold {
+		return
+	}
+	var addr net.Addr
+	if p, ok := peer.FromContext(reqCtx); ok {
+		addr = p.Addr
+	}
+	hc.option.Logger.Warnf("Server.http2HandleFunction: slow rpc of path %s from peer %v takes %s, longer than threshold %s",
+		path, addr, elapsed, threshold)
+}
+
+// checkRateLimit consults RateLimiter of option before dispatching, if the rpc is rejected, it returns ResourceExhausted
+// status and the attachment with retry delay. @incomingCtx contains incoming attachments, which may be used by
+// rate limiter to get client identity.
+func (hc *TripleController) checkRateLimit(incomingCtx context.Context, path string) (*status.Status, common.TripleAttachment) {
+	if hc.option.RateLimiter == nil {
+		return nil, nil
+	}
+	if ok, delay := hc.option.RateLimiter.Allow(incomingCtx, path); !ok {
+		hc.option.Logger.Warnf("TripleController.checkRateLimit: rpc of path %s is rejected by rate limiter, retry after %s", path, delay)
+		delayMs := (delay + time.Millisecond - 1) / time.Millisecond
+		return status.NewStatus(codes.ResourceExhausted, fmt.Sprintf("rate limit exceeded, retry after %s", delay)),
+			common.TripleAttachment{constant.TrailerKeyGrpcRetryPushbackMs: {strconv.FormatInt(int64(delayMs), 10)}}
+	}
+	return nil, nil
+}
+
+// isUnknownMethod reports whether method of @path is not provided by @rpcService, nil @rpcService means the service
+// of @path is not registered.
+func (hc *TripleController) isUnknownMethod(rpcService interface{}, path string, header http.Header) bool {
+	if rpcService == nil {
+		return true
+	}
+	_, methodName, err := tools.GetServiceKeyAndUpperCaseMethodNameFromPath(path)
+	if err != nil {
+		return false
+	}
+	codecType, _, err := hc.getServerCodec(header.Get("content-type"))
+	if err != nil {
+		// let server stream report the codec error
+		return false
+	}
+	if codecType == constant.PBCodecName {
+		service, ok := rpcService.(common.TripleGrpcService)
+		if !ok {
+			return false
+		}
+		methodMap, streamMap := getMethodAndStreamDescMap(service)
+		_, unaryOk := methodMap[methodName]
+		_, streamOk := streamMap[methodName]
+		return !unaryOk && !streamOk
+	}
+	service, ok := rpcService.(common.TripleUnaryService)
+	if !ok || methodName == "$invoke" {
+		return false
+	}
+	_, ok = service.GetReqParamsInterfaces(methodName)
+	return !ok
+}
+
+// handleUnknownMethod handles rpc of unknown method with UnknownMethodStrategy of option, the response message is
+// sent to @sendChan, and it returns the final status. @ctx contains incoming attachments and the deadline of rpc.
+func (hc *TripleController) handleUnknownMethod(ctx context.Context, path string, recvChan chan *bytes.Buffer,
+	sendChan chan *bytes.Buffer) (*status.Status, common.TripleAttachment) {
+	switch hc.option.UnknownMethodStrategy {
+	case config.UnknownMethodCustomStatus:
+		return status.NewStatus(codes.Code(hc.option.UnknownMethodCode), hc.option.UnknownMethodMessage), nil
+	case config.UnknownMethodFallback:
+		if hc.option.UnknownMethodHandler == nil {
+			break
+		}
+		var req []byte
+		select {
+		case <-ctx.Done():
+			return status.NewStatus(codes.DeadlineExceeded, "rpc deadline exceeded before receiving request"), nil
+		case reqData := <-recvChan:
+			if reqData != nil {
+				req = append([]byte{}, reqData.Bytes()...)
+				if hc.option.EnableBufferPool {
+					buffer.PutBuffer(reqData)
+				}
+			}
+		}
+		rsp, err := hc.option.UnknownMethodHandler(ctx, path, req)
+		if err != nil {
+			hc.option.Logger.Errorf("TripleController.handleUnknownMethod: fallback of path %s error = %v", path, err)
+			if tripleErr, ok := err.(*common.TripleError); ok {
+				return status.NewStatus(codes.Code(tripleErr.Code()), tripleErr.Error()), tripleErr.Attachment()
+			}
+			if statusErr, ok := status.FromGRPCStatus(err); ok {
+				return statusErr.Status(), nil
+			}
+			return status.NewStatus(codes.Unknown, err.Error()), nil
+		}
+		sendChan <- bytes.NewBuffer(rsp)
+		return status.NewStatus(codes.OK, ""), nil
+	}
+	return status.NewStatus(codes.Unimplemented, fmt.Sprintf("method of path %s is not provided by server", path)), nil
+}
+
+func (hc *TripleController) handleStatusAttachmentAndResponse(tripleStatus *status.Status, attachment common.TripleAttachment, ctrlch chan http.Header) {
 	// second response header with trailer fields
-	hc.option.Logger.Debugf("TripleController.handleStatusAttachmentAndResponse: with response \ntripleStatus = %+v\n"+
-		"attachment = %+v", tripleStatus.Proto(), attachment)
+	if logger.DebugEnabled(hc.option.Logger) {
+		hc.option.Logger.Debugf("TripleController.handleStatusAttachmentAndResponse: with response \ntripleStatus = %+v\n"+
+			"attachment = %+v", tripleStatus.Proto(), attachment)
+	}
 	rspTrialer := make(map[string][]string)
-	rspTrialer[constant.TrailerKeyGrpcStatus] = []string{strconv.Itoa(int(tripleStatus.Code()))} //[]string{strconv.Itoa(int(tripleStatus.Code()))}
-	rspTrialer[constant.TrailerKeyGrpcMessage] = []string{tripleStatus.Message()}
 	if attachment != nil {
-		for k, v := range attachment {
-			rspTrialer[k] = []string{v}
+		for k, values := range attachment {
+			encoded := make([]string, 0, len(values))
+			for _, v := range values {
+				value, err := common.EncodeAttachmentValue(k, v)
+				if err != nil {
+					hc.option.Logger.Errorf("TripleController.handleStatusAttachmentAndResponse: invalid response attachment, error = %v", err)
+					tripleStatus = status.NewStatus(codes.Internal, fmt.Sprintf("invalid response attachment: %v", err))
+					continue
+				}
+				encoded = append(encoded, value)
+			}
+			if len(encoded) > 0 {
+				rspTrialer[k] = encoded
+			}
 		}
 	}
+	rspTrialer[constant.TrailerKeyGrpcStatus] = []string{strconv.Itoa(int(tripleStatus.Code()))}
+	rspTrialer[constant.TrailerKeyGrpcMessage] = []string{tripleStatus.Message()}
 	statusProto := tripleStatus.Proto()
 	if statusProto != nil {
 		if stBytes, err := proto.Marshal(statusProto); err != nil {
@@ -212,6 +450,95 @@ func (hc *TripleController) handleStatusAttachmentAndResponse(tripleStatus *stat
 	ctrlch <- rspTrialer
 }
 
+// GetServiceInfo returns ServiceInfo of @rpcService registered with @interfaceKey. Methods of TripleGrpcService are
+// read from ServiceDesc, and methods of TripleUnaryService are the exported ones accepted by GetReqParamsInterfaces.
+func GetServiceInfo(interfaceKey string, rpcService interface{}) common.ServiceInfo {
+	methods := make(map[string]struct{})
+	if service, ok := rpcService.(common.TripleGrpcService); ok {
+		methodMap, streamMap := getMethodAndStreamDescMap(service)
+		for name := range methodMap {
+			methods[name] = struct{}{}
+		}
+		for name := range streamMap {
+			methods[name] = struct{}{}
+		}
+	}
+	if service, ok := rpcService.(interface{ Methods() []string }); ok {
+		// e.g. common.NewReflectionUnaryService, whose methods are the ones of impl
+		for _, name := range service.Methods() {
+			methods[name] = struct{}{}
+		}
+	} else if service, ok := rpcService.(common.TripleUnaryService); ok {
+		typ := reflect.TypeOf(service)
+		for i := 0; i < typ.NumMethod(); i++ {
+			name := typ.Method(i).Name
+			if name == "InvokeWithArgs" || name == "GetReqParamsInterfaces" {
+				continue
+			}
+			if _, ok := service.GetReqParamsInterfaces(name); ok {
+				methods[name] = struct{}{}
+			}
+		}
+	}
+
+	info := common.ServiceInfo{
+		InterfaceName: interfaceKey,
+		Methods:       make([]string, 0, len(methods)),
+	}
+	for name := range methods {
+		info.Methods = append(info.Methods, name)
+	}
+	sort.Strings(info.Methods)
+	return info
+}
+
+// GetRoutes returns routes of @service registered with @interfaceKey, in ascending order of path. They are exactly what
+// server dispatches to with pb codec: unary method wins if a stream has the same name, and methods whose name starts with
+// lower case are never dispatched, as the method of path is upper cased.
+func GetRoutes(interfaceKey string, service common.TripleGrpcService) []common.Route {
+	methodMap, streamMap := getMethodAndStreamDescMap(service)
+	routes := make([]common.Route, 0, len(methodMap)+len(streamMap))
+	for name, desc := range methodMap {
+		if !isDispatchedMethodName(name) {
+			continue
+		}
+		desc := desc
+		routes = append(routes, common.Route{
+			Path:       "/" + interfaceKey + "/" + name,
+			MethodName: name,
+			Kind:       common.UnaryMethodKind,
+			MethodDesc: &desc,
+		})
+	}
+	for name, desc := range streamMap {
+		if _, ok := methodMap[name]; ok || !isDispatchedMethodName(name) {
+			continue
+		}
+		desc := desc
+		kind := common.BidiStreamMethodKind
+		if desc.ClientStreams && !desc.ServerStreams {
+			kind = common.ClientStreamMethodKind
+		} else if desc.ServerStreams && !desc.ClientStreams {
+			kind = common.ServerStreamMethodKind
+		}
+		routes = append(routes, common.Route{
+			Path:       "/" + interfaceKey + "/" + name,
+			MethodName: name,
+			Kind:       kind,
+			StreamDesc: &desc,
+		})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	})
+	return routes
+}
+
+// isDispatchedMethodName returns if desc of @name can be found by method name of path, see GetServiceKeyAndUpperCaseMethodNameFromPath
+func isDispatchedMethodName(name string) bool {
+	return name != "" && strings.ToUpper(name[:1]) == name[:1]
+}
+
 // getMethodAndStreamDescMap get unary method desc map and stream method desc map from dubbo3 stub
 func getMethodAndStreamDescMap(ds common.TripleGrpcService) (map[string]grpc.MethodDesc, map[string]grpc.StreamDesc) {
 	sdMap := make(map[string]grpc.MethodDesc, len(ds.ServiceDesc().Methods))
@@ -238,17 +565,82 @@ func NewTripleController(opt *config.Option) (*TripleController, error) {
 		return nil, err
 	}
 
+	methodCodecs := make(map[string]common.TwoWayCodec, len(opt.MethodCodecTypes))
+	for method, codecType := range opt.MethodCodecTypes {
+		if methodCodecs[method], err = codecImpl.NewTwoWayCodec(codecType); err != nil {
+			opt.Logger.Errorf("find serializer named %s of method %s error = %v", codecType, method, err)
+			return nil, err
+		}
+	}
+
 	genericCodec, _ := codec_impl.NewGenericCodec()
 
+	var compressor common.Compressor
+	if opt.CompressorType != "" && opt.CompressorType != constant.IdentityCompressorName {
+		if compressor, err = common.GetCompressor(opt.CompressorType, opt.CompressionLevel); err != nil {
+			opt.Logger.Errorf("find compressor named %s with level %d error = %v", opt.CompressorType, opt.CompressionLevel, err)
+			return nil, err
+		}
+	}
+
+	loadBalancer, err := newLoadBalancer(opt.LoadBalancePolicy, opt.ConsistentHashKey)
+	if err != nil {
+		opt.Logger.Errorf("find load balancer error = %v", err)
+		return nil, err
+	}
+
+	if opt.TLSConfig != nil {
+		if _, err = http2.NextProtos(opt.NextProtos); err != nil {
+			opt.Logger.Errorf("invalid ALPN protocols, error = %v", err)
+			return nil, err
+		}
+	}
+
+	if opt.Authority != "" {
+		if err = common.ValidateAuthority(opt.Authority); err != nil {
+			opt.Logger.Errorf("invalid authority, error = %v", err)
+			return nil, err
+		}
+	}
+
+	defaultAttachment := make(common.DubboAttachment, len(opt.DefaultAttachments))
+	for k, v := range opt.DefaultAttachments {
+		defaultAttachment[strings.ToLower(k)] = append([]string(nil), v...)
+	}
+	if err = common.ValidateAttachment(defaultAttachment); err != nil {
+		opt.Logger.Errorf("invalid default attachment, error = %v", err)
+		return nil, err
+	}
+
 	h2c := &TripleController{
 		pkgHandler:   pkgHandler,
 		option:       opt,
 		address:      opt.Location,
 		closeChan:    make(chan struct{}),
 		twoWayCodec:  twowayCodec,
+		methodCodecs: methodCodecs,
 		genericCodec: genericCodec,
+		compressor:   compressor,
+		loadBalancer: loadBalancer,
+
+		defaultAttachment: defaultAttachment,
+		running:           make(map[*common.CancelCauseFunc]struct{}),
+		// the limiter is replaced by SetConcurrencyLimiter if it is shared by server
+		concurrencyLimiter: NewConcurrencyLimiter(opt.MethodConcurrencyLimits),
 		// todo server end, this is useless
-		http2Client: http2.NewClient(config.Option{Logger: opt.Logger}),
+		http2Client: http2.NewClient(config.Option{
+			Logger:               opt.Logger,
+			DialContext:          opt.DialContext,
+			FrameObserver:        opt.FrameObserver,
+			TCPKeepalive:         opt.TCPKeepalive,
+			ProxyURL:             opt.ProxyURL,
+			ProxyFromEnvironment: opt.ProxyFromEnvironment,
+			ConnectParams:        opt.ConnectParams,
+			MaxHeaderListSize:    opt.MaxHeaderListSize,
+			TLSConfig:            opt.TLSConfig,
+			NextProtos:           opt.NextProtos,
+			StrayFrameStrategy:   opt.StrayFrameStrategy,
+		}),
 		pool: gxsync.NewConnectionPool(gxsync.WorkerPoolConfig{
 			NumWorkers: int(opt.NumWorkers),
 			NumQueues:  runtime.NumCPU(),
@@ -256,6 +648,20 @@ func NewTripleController(opt *config.Option) (*TripleController, error) {
 			Logger:     opt.Logger,
 		}),
 	}
+	if opt.Resolver != nil && opt.OutlierDetection.ConsecutiveFailures > 0 {
+		h2c.outlierDetector = newOutlierDetector(opt.OutlierDetection)
+	}
+	h2c.retrier = newRetrier(opt.RetryPolicy, opt.RetryBudget, opt.OnRetry)
+	if opt.ShadowTarget != "" {
+		if h2c.shadow, err = newShadowController(opt); err != nil {
+			opt.Logger.Errorf("new controller of shadow target %s error = %v", opt.ShadowTarget, err)
+			return nil, err
+		}
+		h2c.shadowInFlight = newShadowLimiter(opt)
+	}
+	if watchable, ok := opt.Resolver.(config.WatchableResolver); ok {
+		h2c.unwatchResolver = watchable.Watch(h2c.onEndpointsUpdate)
+	}
 	return h2c, nil
 }
 
@@ -275,15 +681,20 @@ func (hc *TripleController) newServerStreamFromTripleHeader(ctx context.Context,
 	if err != nil {
 		return nil, err
 	}
+	codecType, twoWayCodec, err := hc.getServerCodec(header.Get("content-type"))
+	if err != nil {
+		hc.option.Logger.Errorf("TripleController.newServerStreamFromTripleHeader: get codec of content-type %s error = %v", header.Get("content-type"), err)
+		return nil, err
+	}
 	hc.option.Logger.Debugf("TripleController.newServerStreamFromTripleHeader: with interfaceKey = %s, methodName = %s"+
-		"server defined serialization type = %s", interfaceKey, methodName, hc.option.CodecType)
+		"request serialization type = %s", interfaceKey, methodName, codecType)
 
 	var newStream stream.Stream
-	triHeader := codec.NewTripleHeader(path, header)
+	triHeader := codec.NewTripleHeader(ctx, path, header, hc.option)
 	hc.option.Logger.Debugf("TripleController.newServerStreamFromTripleHeader: parse triple header = %+v", triHeader)
 
 	// creat server stream
-	if hc.option.CodecType == constant.PBCodecName {
+	if codecType == constant.PBCodecName {
 		service, ok := rpcService.(common.TripleGrpcService)
 		if !ok {
 			hc.option.Logger.Errorf("TripleController.newServerStreamFromTripleHeader: can't assert impl of interface %s to TripleGrpcService", interfaceKey)
@@ -298,7 +709,7 @@ func (hc *TripleController) newServerStreamFromTripleHeader(ctx context.Context,
 		if unaryOk {
 			hc.option.Logger.Debugf("TripleController.newServerStreamFromTripleHeader: find unary rpc impl in server")
 			newStream, err = stream.NewServerStreamForPB(ctx, triHeader, unaryRPCDiscovery, hc.option,
-				pool, service, hc.twoWayCodec)
+				pool, service, twoWayCodec)
 			if err != nil {
 				hc.option.Logger.Errorf("TripleController.newServerStreamFromTripleHeader: newServerStream error = %v", err)
 				return nil, err
@@ -306,7 +717,7 @@ func (hc *TripleController) newServerStreamFromTripleHeader(ctx context.Context,
 		} else if streamOk {
 			hc.option.Logger.Debugf("TripleController.newServerStreamFromTripleHeader: find streaming rpc impl in server")
 			newStream, err = stream.NewServerStreamForPB(ctx, triHeader, streamRPCDiscovery, hc.option,
-				pool, service, hc.twoWayCodec)
+				pool, service, twoWayCodec)
 			if err != nil {
 				hc.option.Logger.Errorf("TripleController.newServerStreamFromTripleHeader: newServerStream error = %v", err)
 				return nil, err
@@ -324,7 +735,7 @@ func (hc *TripleController) newServerStreamFromTripleHeader(ctx context.Context,
 		}
 		// unary service doesn't need to use grpc.Desc, and now only support unary invocation
 		var err *status.TripleError
-		newStream, err = stream.NewServerStreamForNonPB(ctx, triHeader, hc.option, pool, service, hc.twoWayCodec, hc.genericCodec)
+		newStream, err = stream.NewServerStreamForNonPB(ctx, triHeader, hc.option, pool, service, twoWayCodec, hc.genericCodec)
 		if err != nil {
 			hc.option.Logger.Errorf("TripleController.newServerStreamFromTripleHeader: unary service new server stream error = %v", err)
 			return nil, err
@@ -337,6 +748,33 @@ func (hc *TripleController) newServerStreamFromTripleHeader(ctx context.Context,
 
 // StreamInvoke can start streaming invocation, called by triple client, with @path
 func (hc *TripleController) StreamInvoke(ctx context.Context, path string) (grpc.ClientStream, error) {
+	if err := hc.checkAvailable(); err != nil {
+		return nil, err
+	}
+	ctx = hc.withIdempotencyKey(ctx, path)
+	ctx, err := hc.marshalRequestAttachment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	compressor, err := hc.getCompressor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	authority, err := hc.getAuthority(ctx)
+	if err != nil {
+		return nil, err
+	}
+	address, err := hc.pickAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done, err := hc.allowByCircuitBreaker(address, path)
+	if err != nil {
+		return nil, err
+	}
+	codecType, twoWayCodec := hc.clientCodec(path)
+	onResponseHeader, onMessage, endStats := hc.startStats(path)
+	onResponseHeader, completeAttachment := hc.withHeaderMetadata(onResponseHeader)
 	clientStream := stream.NewClientStream()
 	tosend := clientStream.GetSend()
 	sendStreamChan := make(chan *bytes.Buffer)
@@ -345,7 +783,7 @@ func (hc *TripleController) StreamInvoke(ctx context.Context, path string) (grpc
 		for {
 			select {
 			case <-closeChan:
-				clientStream.Close()
+				clientStream.CloseSend()
 				return
 			case sendMsg := <-tosend:
 				if sendMsg.MsgType == message.ServerStreamCloseMsgType {
@@ -355,26 +793,69 @@ func (hc *TripleController) StreamInvoke(ctx context.Context, path string) (grpc
 			}
 		}
 	}()
-	headerHandler, _ := common.GetProtocolHeaderHandler(hc.option, ctx)
+	headerHandler, _ := common.GetProtocolHeaderHandler(hc.option, hc.withDefaultAttachment(ctx))
 	newHeader := headerHandler.WriteTripleReqHeaderField(http.Header{})
-	dataChan, rspHeaderChan, err := hc.http2Client.StreamPost(hc.address, path, sendStreamChan, &http2Config.PostConfig{
-		ContentType: constant.TripleContentType,
-		BufferSize:  hc.option.BufferSize,
-		Timeout:     hc.option.Timeout,
-		HeaderField: newHeader,
+	// the stream is reset if @ctx is done or it is stopped by user, and cancel is called after the rpc is finished,
+	// terminateCause is set before the trailer made up by client is received
+	streamCtx, cancel := common.WithCancelCause(ctx)
+	untrack := hc.trackRPC(cancel)
+	var terminateCause error
+	dataChan, rspHeaderChan, err := hc.http2Client.StreamPost(address, path, sendStreamChan, &http2Config.PostConfig{
+		ContentType:      tools.GetContentType(codecType),
+		BufferSize:       hc.option.BufferSize,
+		Timeout:          hc.option.Timeout,
+		HeaderField:      newHeader,
+		Compressor:       compressor,
+		Authority:        authority,
+		OnResponseHeader: onResponseHeader,
+		OnMessage:        onMessage,
+		Context:          streamCtx,
+		OnTerminate: func(cause error) {
+			terminateCause = cause
+		},
 	})
 	if err != nil {
 		hc.option.Logger.Errorf("http2 request error = %s", err)
 		// close send stream and return
+		untrack()
+		cancel(nil)
 		close(closeChan)
+		done(err)
+		endStats(err)
 		return nil, err
 	}
+	userStream := stream.NewClientUserStream(clientStream, twoWayCodec, hc.option)
+	userStream.SetMethod(path)
+	userStream.SetCancel(func() {
+		cancel(common.ErrStreamStopped)
+	})
+	if hc.option.HeartbeatPredicate != nil {
+		userStream.SetHeartbeatPredicate(func(data []byte) bool {
+			return hc.option.HeartbeatPredicate(path, data)
+		})
+	}
+	if hc.option.OnStreamClose != nil {
+		userStream.OnClose(func(err error) {
+			hc.option.OnStreamClose(path, err)
+		})
+	}
 	go func() {
 	Loop:
 		for {
 			select {
 			case <-hc.closeChan:
+				// controller is destroyed, trailer may never come
+				untrack()
+				cancel(common.ErrClientClosed)
 				close(closeChan)
+				closeErr := common.NewTripleErrorWithCause("triple controller is destroyed", int(codes.Canceled), common.ErrClientClosed, nil)
+				// the result is told to circuit breaker before the status, which waits until user receives messages
+				done(closeErr)
+				clientStream.PutRecvStatus(status.NewStatus(codes.Canceled, "triple controller is destroyed").WithCause(common.ErrClientClosed), nil)
+				clientStream.CloseRecv()
+				endStats(closeErr)
+				userStream.SetClosed(closeErr)
+				return
 			case data := <-dataChan:
 				if data == nil {
 					// stream receive done, close send go routine
@@ -387,44 +868,503 @@ func (hc *TripleController) StreamInvoke(ctx context.Context, path string) (grpc
 		trailer := <-rspHeaderChan
 		code, _ := strconv.Atoi(trailer.Get(constant.TrailerKeyGrpcStatus))
 		msg := trailer.Get(constant.TrailerKeyGrpcMessage)
-		if codes.Code(code) != codes.OK {
-			hc.option.Logger.Errorf("grpc status not success,msg = %s, code = %d", msg, code)
-		}
+		attachment, err := hc.parseTrailer(trailer, terminateCause)
+		completeAttachment(attachment)
+		untrack()
+		cancel(nil)
+		done(err)
+		endStats(err)
+		// the final status and trailer attachment are received by user after all messages
+		clientStream.PutRecvStatus(status.NewStatus(codes.Code(code), msg).WithCause(terminateCause), attachment)
+		clientStream.CloseRecv()
+		userStream.SetClosed(err)
 	}()
 
-	return stream.NewClientUserStream(clientStream, hc.twoWayCodec, hc.option), nil
+	return userStream, nil
 }
 
 // UnaryInvoke can start unary invocation, called by dubbo3 client, with @path and request @data
 func (hc *TripleController) UnaryInvoke(ctx context.Context, path string, arg, reply interface{}) common.ErrorWithAttachment {
-	var code int
-	var msg string
 	var attachment = make(common.TripleAttachment)
 
 	hc.option.Logger.Debugf("TripleController.UnaryInvoke: with path = %s, args = %+v, reply = %+v", path, arg, reply)
-	sendData, err := hc.twoWayCodec.MarshalRequest(arg)
+	_, twoWayCodec := hc.clientCodec(path)
+	sendData, err := common.MarshalRequestContext(ctx, twoWayCodec, arg)
 	if err != nil {
 		hc.option.Logger.Errorf("TripleController.UnaryInvoke: client request marshal error = %v", err)
 		return *common.NewErrorWithAttachment(err, attachment)
 	}
 
-	headerHandler, _ := common.GetProtocolHeaderHandler(hc.option, ctx)
+	rspData, attachment, err := hc.UnaryInvokeRaw(ctx, path, sendData)
+	if err != nil {
+		// Now only error returned by server side rpc function can user level error get attachment of triple
+		// that is because error is nil when rpc success, and user can't get attachment.
+		return *common.NewErrorWithAttachment(err, attachment)
+	}
+
+	// all split data are collected and to unmarshal
+	if err := common.UnmarshalResponseContext(ctx, twoWayCodec, rspData, reply); err != nil {
+		msg := "response " + tools.UnmarshalErrorMessage(path, reply, rspData, err)
+		hc.option.Logger.Errorf("TripleController.UnaryInvoke: %s", msg)
+		return *common.NewErrorWithAttachment(common.NewTripleError(msg, int(codes.Internal), "", nil), attachment)
+	}
+	return *common.NewErrorWithAttachment(nil, attachment)
+}
+
+// UnaryInvokeRaw starts unary invocation with @path like UnaryInvoke, but codec is bypassed: @sendData is sent as the
+// request message as is, and the raw response message is returned with trailer attachment. Failed invocation is
+// retried by RetryPolicy of option, and invocation is mirrored to ShadowTarget of option once if it's set. The
+// default timeout of @path in MethodTimeouts of option covers all the attempts.
+func (hc *TripleController) UnaryInvokeRaw(ctx context.Context, path string, sendData []byte) ([]byte, common.TripleAttachment, error) {
+	ctx, cancel := hc.withMethodTimeout(ctx, path)
+	defer cancel()
+	ctx = hc.withIdempotencyKey(ctx, path)
+	hc.mirror(ctx, path, sendData)
+	if hc.retrier == nil {
+		return hc.unaryInvokeRawAttempt(ctx, path, sendData)
+	}
+	for attempt := 1; ; attempt++ {
+		rspData, attachment, err := hc.unaryInvokeRawAttempt(ctx, path, sendData)
+		backoff, ok := hc.retrier.retry(ctx, path, attempt, err, attachment)
+		if !ok {
+			return rspData, attachment, err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return rspData, attachment, err
+		case <-timer.C:
+		}
+	}
+}
+
+// unaryInvokeRawAttempt is an attempt of UnaryInvokeRaw
+func (hc *TripleController) unaryInvokeRawAttempt(ctx context.Context, path string, sendData []byte) ([]byte, common.TripleAttachment, error) {
+	var attachment = make(common.TripleAttachment)
+
+	if err := hc.checkAvailable(); err != nil {
+		return nil, attachment, err
+	}
+	ctx, err := hc.marshalRequestAttachment(ctx)
+	if err != nil {
+		return nil, attachment, err
+	}
+	compressor, err := hc.getCompressor(ctx)
+	if err != nil {
+		return nil, attachment, err
+	}
+	authority, err := hc.getAuthority(ctx)
+	if err != nil {
+		return nil, attachment, err
+	}
+	address, err := hc.pickAddress(ctx)
+	if err != nil {
+		return nil, attachment, err
+	}
+
+	done, err := hc.allowByCircuitBreaker(address, path)
+	if err != nil {
+		return nil, attachment, err
+	}
+
+	headerHandler, _ := common.GetProtocolHeaderHandler(hc.option, hc.withDefaultAttachment(ctx))
 	newHeader := http.Header{}
 	newHeader = headerHandler.WriteTripleReqHeaderField(newHeader)
 
-	rspData, rspTrailerHeader, err := hc.http2Client.Post(hc.address, path, sendData, &http2Config.PostConfig{
-		ContentType: constant.TripleContentType,
-		BufferSize:  hc.option.BufferSize,
-		Timeout:     hc.option.Timeout,
-		HeaderField: newHeader,
+	// the request is reset if @ctx is done or CancelAll is called
+	ctx, cancel := common.WithCancelCause(ctx)
+	defer cancel(nil)
+	defer hc.trackRPC(cancel)()
+	onResponseHeader, onMessage, endStats := hc.startStats(path)
+	onResponseHeader, completeAttachment := hc.withHeaderMetadata(onResponseHeader)
+	codecType, _ := hc.clientCodec(path)
+	rspData, rspTrailerHeader, err := hc.http2Client.Post(address, path, sendData, &http2Config.PostConfig{
+		ContentType:      tools.GetContentType(codecType),
+		BufferSize:       hc.option.BufferSize,
+		Timeout:          hc.clientTimeout(path),
+		HeaderField:      newHeader,
+		Compressor:       compressor,
+		Authority:        authority,
+		OnResponseHeader: onResponseHeader,
+		OnMessage:        onMessage,
+		SetContentLength: hc.option.SetUnaryContentLength,
+		Context:          ctx,
 	})
 	if err != nil {
-		hc.option.Logger.Error("TripleController.UnaryInvoke: triple unary invoke path" + path + " with addr = " + hc.address + " error = " + err.Error())
-		return *common.NewErrorWithAttachment(err, attachment)
+		hc.option.Logger.Error("TripleController.UnaryInvokeRaw: triple unary invoke path" + path + " with addr = " + address + " error = " + err.Error())
+		done(err)
+		endStats(err)
+		return nil, attachment, err
+	}
+	if logger.DebugEnabled(hc.option.Logger) {
+		hc.option.Logger.Debugf("TripleController.UnaryInvokeRaw: triple unary invoke get rsp data = %s, trailerHeader = %+v", string(rspData), rspTrailerHeader)
+	}
+
+	attachment, err = hc.parseTrailer(rspTrailerHeader, nil)
+	completeAttachment(attachment)
+	done(err)
+	endStats(err)
+	if err != nil {
+		return nil, attachment, err
+	}
+	return rspData, attachment, nil
+}
+
+// withIdempotencyKey returns ctx with idempotency key generated by IdempotencyKeyGenerator of option, if @ctx has no
+// key set by common.WithIdempotencyKey. It is called once per rpc, so that all attempts carry the same key.
+func (hc *TripleController) withIdempotencyKey(ctx context.Context, path string) context.Context {
+	if _, ok := common.IdempotencyKeyFromContext(ctx); ok || hc.option.IdempotencyKeyGenerator == nil {
+		return ctx
+	}
+	key := hc.option.IdempotencyKeyGenerator(ctx, path)
+	if key == "" {
+		return ctx
+	}
+	return common.WithIdempotencyKey(ctx, key)
+}
+
+// withMethodTimeout returns ctx with default timeout of @path in MethodTimeouts of option, or Timeout of option if
+// @path isn't listed, if @ctx has no deadline. The deadline is told to server by grpc-timeout.
+func (hc *TripleController) withMethodTimeout(ctx context.Context, path string) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	timeout, ok := hc.option.GetMethodTimeout(path)
+	if !ok {
+		timeout = time.Duration(hc.option.Timeout) * time.Second
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// clientTimeout returns timeout seconds of client unary rpcs of @path, which is Timeout of option, or the default
+// timeout of @path in MethodTimeouts if it is longer, so that the deadline of the method isn't cut by Timeout
+func (hc *TripleController) clientTimeout(path string) uint32 {
+	timeout, ok := hc.option.GetMethodTimeout(path)
+	if !ok {
+		return hc.option.Timeout
+	}
+	seconds := uint32((timeout + time.Second - 1) / time.Second)
+	if seconds < hc.option.Timeout {
+		return hc.option.Timeout
+	}
+	return seconds
+}
+
+// clientCodec returns codec of client rpcs of @path, which is set by Option.MethodCodecTypes, or the codec of option
+func (hc *TripleController) clientCodec(path string) (constant.CodecType, common.TwoWayCodec) {
+	if twoWayCodec, ok := hc.methodCodecs[path]; ok {
+		return hc.option.MethodCodecTypes[path], twoWayCodec
+	}
+	return hc.option.CodecType, hc.twoWayCodec
+}
+
+// getCompressor returns the compressor of request messages, which is set by common.WithCompression in @ctx,
+// or the compressor of option. It returns nil if messages are not compressed.
+func (hc *TripleController) getCompressor(ctx context.Context) (common.Compressor, error) {
+	name, ok := common.CompressionFromContext(ctx)
+	if !ok || name == hc.option.CompressorType {
+		return hc.compressor, nil
+	}
+	if name == "" || name == constant.IdentityCompressorName {
+		return nil, nil
+	}
+	if compressor, ok := hc.compressors.Load(name); ok {
+		return compressor.(common.Compressor), nil
+	}
+	compressor, err := common.GetCompressor(name, hc.option.CompressionLevel)
+	if err != nil {
+		hc.option.Logger.Errorf("TripleController.getCompressor: find compressor named %s error = %v", name, err)
+		return nil, err
+	}
+	hc.compressors.Store(name, compressor)
+	return compressor, nil
+}
+
+// getAuthority returns :authority of the request, which is set by common.WithAuthority in @ctx, or the one of option.
+// Empty authority means the address of request.
+func (hc *TripleController) getAuthority(ctx context.Context) (string, error) {
+	authority, ok := common.AuthorityFromContext(ctx)
+	if !ok {
+		return hc.option.Authority, nil
+	}
+	if err := common.ValidateAuthority(authority); err != nil {
+		hc.option.Logger.Errorf("TripleController.getAuthority: invalid authority of request, error = %v", err)
+		return "", err
+	}
+	return authority, nil
+}
+
+// marshalRequestAttachment returns ctx with the attachment of @ctx whose values of keys with
+// Option.AttachmentValueCodecs are serialized. It returns error if any attachment can't be serialized or sent as
+// header field, e.g. non-binary value with invalid UTF-8.
+func (hc *TripleController) marshalRequestAttachment(ctx context.Context) (context.Context, error) {
+	attachment, ok := ctx.Value(string(constant.CtxAttachmentKey)).(common.DubboAttachment)
+	if !ok {
+		return ctx, nil
+	}
+	marshaled, err := common.MarshalAttachmentValues(attachment, hc.option.AttachmentValueCodecs)
+	if err == nil {
+		err = common.ValidateAttachment(marshaled)
+	}
+	if err != nil {
+		hc.option.Logger.Errorf("TripleController.marshalRequestAttachment: invalid request attachment, error = %v", err)
+		return nil, err
+	}
+	if len(hc.option.AttachmentValueCodecs) == 0 {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, string(constant.CtxAttachmentKey), common.DubboAttachment(marshaled)), nil
+}
+
+// withDefaultAttachment returns ctx with the attachment of @ctx merged with default attachments of client, which is
+// used to write request header. Keys of @ctx take precedence over default ones, case-insensitively like header fields.
+func (hc *TripleController) withDefaultAttachment(ctx context.Context) context.Context {
+	if len(hc.defaultAttachment) == 0 {
+		return ctx
+	}
+	attachment, _ := ctx.Value(string(constant.CtxAttachmentKey)).(common.DubboAttachment)
+	merged := make(common.DubboAttachment, len(hc.defaultAttachment)+len(attachment))
+	for k, v := range hc.defaultAttachment {
+		merged[k] = v
+	}
+	for k, v := range attachment {
+		delete(merged, strings.ToLower(k))
+		merged[k] = v
+	}
+	return context.WithValue(ctx, string(constant.CtxAttachmentKey), merged)
+}
+
+// checkAvailable returns Canceled error if the controller is destroyed, so that rpc is not started after client is closed
+func (hc *TripleController) checkAvailable() error {
+	if !hc.IsAvailable() {
+		return common.NewTripleErrorWithCause("triple client is closed", int(codes.Canceled), common.ErrClientClosed, nil)
+	}
+	return nil
+}
+
+// allowByCircuitBreaker consults CircuitBreaker of option before sending rpc of @path to @address, if the rpc is
+// rejected, it returns Unavailable error. Otherwise the returned done must be called with the result of rpc, which is
+// also reported to outlier detection.
+func (hc *TripleController) allowByCircuitBreaker(address, path string) (func(err error), error) {
+	report := func(error) {}
+	if hc.outlierDetector != nil {
+		report = func(err error) {
+			hc.outlierDetector.onResult(address, err)
+		}
+	}
+	if hc.option.CircuitBreaker == nil {
+		return report, nil
+	}
+	done, ok := hc.option.CircuitBreaker.Allow(address, path)
+	if !ok {
+		hc.option.Logger.Warnf("TripleController.allowByCircuitBreaker: rpc of path %s to %s is rejected by open circuit breaker", path, address)
+		return nil, common.NewTripleError(fmt.Sprintf("circuit breaker is open for path %s to %s", path, address),
+			int(codes.Unavailable), "", nil)
 	}
-	hc.option.Logger.Debugf("TripleController.UnaryInvoke: triple unary invoke get rsp data = %s, trailerHeader = %+v", string(rspData), rspTrailerHeader)
+	return func(err error) {
+		done(err)
+		report(err)
+	}, nil
+}
+
+// pickAddress returns server address of next rpc, which is picked from endpoints of Resolver by load balance policy if
+// Resolver is set, otherwise it is Location of option
+func (hc *TripleController) pickAddress(ctx context.Context) (string, error) {
+	if hc.option.Resolver == nil {
+		return hc.address, nil
+	}
+	endpoints := hc.option.Resolver.Endpoints()
+	if len(endpoints) == 0 {
+		hc.option.Logger.Errorf("TripleController.pickAddress: no endpoint is resolved")
+		return "", common.NewTripleError("no endpoint is resolved", int(codes.Unavailable), "", nil)
+	}
+	if hc.outlierDetector != nil {
+		endpoints = hc.outlierDetector.filter(endpoints)
+	}
+	return hc.loadBalancer.pick(ctx, endpoints).Address, nil
+}
 
-	for k, v := range rspTrailerHeader {
+// EjectedEndpoints returns sorted addresses of endpoints which are ejected by outlier detection now
+func (hc *TripleController) EjectedEndpoints() []string {
+	if hc.outlierDetector == nil {
+		return []string{}
+	}
+	return hc.outlierDetector.ejected()
+}
+
+// LastGoAwayStreamID returns the last stream id of the latest GOAWAY received from servers, e.g. for a proxy to decide
+// which streams in flight are safe to retry on a new conn, as streams with larger ids are not processed by server.
+// It returns false if no GOAWAY is received, or the conn receiving it is replaced by a new conn to the address.
+func (hc *TripleController) LastGoAwayStreamID() (uint32, bool) {
+	return hc.http2Client.LastGoAwayStreamID()
+}
+
+// SetLogLevel changes the level of logs of controller at runtime, logs below @level are discarded, e.g. debug logs
+// are written after SetLogLevel(logger.DebugLevel) without restart. It is goroutine safe, and affects all clients
+// sharing the controller. It only works if Logger of option is a *logger.LoggerWrapper, as options made by
+// config.NewTripleOption always are, it does nothing for a raw logger.Logger set to Option directly.
+func (hc *TripleController) SetLogLevel(level logger.Level) {
+	if wrapper, ok := hc.option.Logger.(*logger.LoggerWrapper); ok {
+		wrapper.SetLevel(level)
+	}
+}
+
+// trackRPC registers @cancel of running client rpc, which is called by CancelAll, and the returned untrack must be
+// called after the rpc is finished
+func (hc *TripleController) trackRPC(cancel common.CancelCauseFunc) (untrack func()) {
+	key := &cancel
+	hc.runningLock.Lock()
+	hc.running[key] = struct{}{}
+	hc.runningLock.Unlock()
+	return func() {
+		hc.runningLock.Lock()
+		delete(hc.running, key)
+		hc.runningLock.Unlock()
+	}
+}
+
+// CancelAll cancels all running client rpcs with cause common.ErrCanceledAll, their streams are reset by RST_STREAM.
+// Unlike Destroy, the conns are kept and rpcs started after it are not affected.
+func (hc *TripleController) CancelAll() {
+	hc.runningLock.Lock()
+	cancels := make([]common.CancelCauseFunc, 0, len(hc.running))
+	for cancel := range hc.running {
+		cancels = append(cancels, *cancel)
+	}
+	hc.runningLock.Unlock()
+	for _, cancel := range cancels {
+		cancel(common.ErrCanceledAll)
+	}
+}
+
+// addresses returns all server addresses, which are endpoints of Resolver if it is set, otherwise Location of option
+func (hc *TripleController) addresses() []string {
+	if hc.option.Resolver == nil {
+		return []string{hc.address}
+	}
+	endpoints := hc.option.Resolver.Endpoints()
+	addresses := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addresses = append(addresses, endpoint.Address)
+	}
+	return addresses
+}
+
+// onEndpointsUpdate closes conns of endpoints removed from @endpoints of WatchableResolver gracefully
+func (hc *TripleController) onEndpointsUpdate(endpoints []config.Endpoint) {
+	addresses := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addresses = append(addresses, endpoint.Address)
+	}
+	hc.option.Logger.Infof("TripleController.onEndpointsUpdate: endpoints are updated to %v", addresses)
+	hc.http2Client.CloseConnsExcept(addresses)
+}
+
+// startStats starts latency and message stats of client rpc @path, if StatsHandler of option is set. The returned
+// onResponseHeader should be called when response header arrives, onMessage should be called with each message, and
+// end should be called once when the rpc completes with its error.
+func (hc *TripleController) startStats(path string) (onResponseHeader func(header http.Header),
+	onMessage func(outbound, compressed bool, size, wireSize int), end func(err error)) {
+	if hc.option.StatsHandler == nil {
+		return nil, nil, func(error) {}
+	}
+	stats := &config.RPCStats{
+		Method: path,
+		Begin:  time.Now(),
+	}
+	// request and response messages of stream are counted concurrently
+	var lock sync.Mutex
+	onResponseHeader = func(http.Header) {
+		stats.FirstResponseByte = time.Now()
+	}
+	onMessage = func(outbound, compressed bool, size, wireSize int) {
+		lock.Lock()
+		defer lock.Unlock()
+		if outbound {
+			stats.RequestCompressed = stats.RequestCompressed || compressed
+			stats.RequestBytes += int64(size)
+			stats.RequestWireBytes += int64(wireSize)
+		} else {
+			stats.ResponseCompressed = stats.ResponseCompressed || compressed
+			stats.ResponseBytes += int64(size)
+			stats.ResponseWireBytes += int64(wireSize)
+		}
+	}
+	end = func(err error) {
+		lock.Lock()
+		stats.End = time.Now()
+		stats.Error = err
+		lock.Unlock()
+		hc.option.StatsHandler(stats)
+	}
+	return onResponseHeader, onMessage, end
+}
+
+// withHeaderMetadata returns the callback of response header which calls @onResponseHeader, and records metadata in
+// response header if Option.GrpcMetadataCompatible is set. The returned complete adds the recorded metadata to
+// response attachment, before values of the same key in trailer, and then restores the case of
+// Option.PreserveCaseKeys. It must be called after the trailer is received.
+func (hc *TripleController) withHeaderMetadata(onResponseHeader func(header http.Header)) (func(header http.Header), func(attachment common.TripleAttachment)) {
+	preserveCaseKeys := hc.option.PreserveCaseKeys
+	if !hc.option.GrpcMetadataCompatible {
+		return onResponseHeader, func(attachment common.TripleAttachment) {
+			common.RestoreAttachmentKeyCase(attachment, preserveCaseKeys)
+		}
+	}
+	var md common.TripleAttachment
+	record := func(header http.Header) {
+		md = headerMetadata(header, hc.option.AttachmentValueCodecs)
+		if onResponseHeader != nil {
+			onResponseHeader(header)
+		}
+	}
+	complete := func(attachment common.TripleAttachment) {
+		for k, v := range md {
+			attachment[k] = append(append([]string(nil), v...), attachment[k]...)
+		}
+		common.RestoreAttachmentKeyCase(attachment, preserveCaseKeys)
+	}
+	return record, complete
+}
+
+// headerMetadata returns metadata of plain grpc server in response @header decoded with @codecs, header fields of
+// grpc protocol are not metadata. Binary value which fails to decode is kept as it is.
+func headerMetadata(header http.Header, codecs map[string]config.AttachmentValueCodec) common.TripleAttachment {
+	md := make(common.TripleAttachment)
+	for k, v := range header {
+		k = strings.ToLower(k)
+		switch k {
+		case "content-type", constant.GrpcEncoding, constant.GrpcAcceptEncoding:
+			continue
+		}
+		for _, raw := range v {
+			value, err := common.DecodeAttachmentValueWithCodecs(k, raw, codecs)
+			if err != nil {
+				value = raw
+			}
+			md.Add(k, value)
+		}
+	}
+	return md
+}
+
+// parseTrailer gets attachment and triple status from response @trailer, if the status is not OK,
+// it returns common.TripleError with the status and stack traces sent by server. The error unwraps to @cause if
+// it's not nil, which is the cause of trailer made up by client for rpc terminated at client side.
+func (hc *TripleController) parseTrailer(trailer http.Header, cause error) (common.TripleAttachment, error) {
+	var code int
+	var msg string
+	var err error
+	// statusReceived is false if the server ends the stream without grpc-status, or the trailer is truncated by
+	// max header list size of client
+	statusReceived := false
+	attachment := make(common.TripleAttachment)
+
+	for k, v := range trailer {
 		if len(v) == 0 {
 			continue
 		}
@@ -432,50 +1372,253 @@ func (hc *TripleController) UnaryInvoke(ctx context.Context, path string, arg, r
 		case constant.TrailerKeyGrpcStatus:
 			code, err = strconv.Atoi(v[0])
 			if err != nil {
-				hc.option.Logger.Errorf("TripleController.UnaryInvoke: get trailer err = %v", err)
-				return *common.NewErrorWithAttachment(perrors.Errorf("TripleController.UnaryInvoke: get trailer err = %v", err), attachment)
+				hc.option.Logger.Errorf("TripleController.parseTrailer: get trailer err = %v", err)
+				return attachment, perrors.Errorf("TripleController.parseTrailer: get trailer err = %v", err)
 			}
+			statusReceived = true
 		case constant.TrailerKeyGrpcMessage:
 			msg = v[0]
 		default:
-			attachment[strings.ToLower(k)] = v[0]
-		}
-	}
-
-	if codes.Code(code) != codes.OK {
-		hc.option.Logger.Warnf("TripleController.UnaryInvoke: triple status not success, msg = %s, code = %d", msg, code)
-		var stackTracesStr string
-		if len(attachment) > 0 {
-			if attachment[constant.TrailerKeyGrpcDetailsBin] != "" {
-				trailerKeyGrpcDetailsBin := attachment[constant.TrailerKeyGrpcDetailsBin]
-				trailerKeyGrpcDetails, _ := base64.RawStdEncoding.DecodeString(trailerKeyGrpcDetailsBin)
-				if trailerKeyGrpcDetailsBin != "" {
-					//details := &spb.Status{}
-					details, _ := status.NewStatus(codes.Internal, "").WithDetails(&errdetails.DebugInfo{})
-					detailProto := details.Proto()
-					if err := proto.Unmarshal(trailerKeyGrpcDetails, detailProto); err == nil && len(detailProto.Details) > 0 {
-						stackTracesStr = strings.Replace(detailProto.Details[0].String(), `\n`, "\n", -1)
-						stackTracesStr = strings.Replace(stackTracesStr, `\t`, "\t", -1)
-					}
+			// binary value which fails to decode is kept as it is
+			for _, raw := range v {
+				value, err := common.DecodeAttachmentValueWithCodecs(k, raw, hc.option.AttachmentValueCodecs)
+				if err != nil {
+					value = raw
 				}
+				attachment.Add(k, value)
 			}
 		}
-		// Now only error returned by server side rpc function can user level error get attachment of triple
-		// that is because error is nil when rpc success, and user can't get attachment.
-		return *common.NewErrorWithAttachment(common.NewTripleError(msg, code, stackTracesStr, attachment), attachment)
 	}
 
-	// all split data are collected and to unmarshal
-	if err := hc.twoWayCodec.UnmarshalResponse(rspData, reply); err != nil {
-		hc.option.Logger.Errorf("client unmarshal rsp err = %v\n", err)
-		return *common.NewErrorWithAttachment(err, attachment)
+	if !statusReceived {
+		code, msg = int(codes.Internal), "server closed the stream without grpc-status in trailer"
 	}
-	return *common.NewErrorWithAttachment(nil, attachment)
+	if codes.Code(code) == codes.OK {
+		return attachment, nil
+	}
+
+	hc.option.Logger.Warnf("TripleController.parseTrailer: triple status not success, msg = %s, code = %d", msg, code)
+	if cause != nil {
+		return attachment, common.NewTripleErrorWithCause(msg, code, cause, attachment)
+	}
+	var stackTracesStr string
+	// grpc-status-details-bin is already base64 decoded as binary attachment
+	if trailerKeyGrpcDetailsBin := attachment.Get(constant.TrailerKeyGrpcDetailsBin); trailerKeyGrpcDetailsBin != "" {
+		detailProto := &spb.Status{}
+		if err := proto.Unmarshal([]byte(trailerKeyGrpcDetailsBin), detailProto); err == nil {
+			for _, detail := range detailProto.Details {
+				// stack traces are sent as DebugInfo, other details are the ones of error returned by handler
+				if !ptypes.Is(detail, &errdetails.DebugInfo{}) {
+					continue
+				}
+				stackTracesStr = strings.Replace(detail.String(), `\n`, "\n", -1)
+				stackTracesStr = strings.Replace(stackTracesStr, `\t`, "\t", -1)
+				break
+			}
+			// code and msg of trailer take precedence
+			detailProto.Code, detailProto.Message = int32(code), msg
+			return attachment, common.NewTripleErrorWithStatus(detailProto, stackTracesStr, attachment)
+		}
+	}
+	return attachment, common.NewTripleError(msg, code, stackTracesStr, attachment)
 }
 
-// Destroy destroys TripleController and force close all related goroutine
+// UnaryInvokeChunked starts unary invocation with @path and request @arg like UnaryInvoke, but it doesn't buffer
+// the whole response. Each data frame sent by server is exposed as a chunk of the returned reader, see chunkedReader.
+func (hc *TripleController) UnaryInvokeChunked(ctx context.Context, path string, arg interface{}) (io.ReadCloser, error) {
+	hc.option.Logger.Debugf("TripleController.UnaryInvokeChunked: with path = %s, args = %+v", path, arg)
+	if err := hc.checkAvailable(); err != nil {
+		return nil, err
+	}
+	codecType, twoWayCodec := hc.clientCodec(path)
+	sendData, err := common.MarshalRequestContext(ctx, twoWayCodec, arg)
+	if err != nil {
+		hc.option.Logger.Errorf("TripleController.UnaryInvokeChunked: client request marshal error = %v", err)
+		return nil, err
+	}
+	ctx = hc.withIdempotencyKey(ctx, path)
+	ctx, err = hc.marshalRequestAttachment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	compressor, err := hc.getCompressor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	authority, err := hc.getAuthority(ctx)
+	if err != nil {
+		return nil, err
+	}
+	address, err := hc.pickAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done, err := hc.allowByCircuitBreaker(address, path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancelTimeout := hc.withMethodTimeout(ctx, path)
+	headerHandler, _ := common.GetProtocolHeaderHandler(hc.option, hc.withDefaultAttachment(ctx))
+	newHeader := headerHandler.WriteTripleReqHeaderField(http.Header{})
+
+	// the only request message is followed by nil, which ends the request stream
+	sendChan := make(chan *bytes.Buffer, 2)
+	sendChan <- bytes.NewBuffer(sendData)
+	sendChan <- nil
+	onResponseHeader, onMessage, endStats := hc.startStats(path)
+	onResponseHeader, completeAttachment := hc.withHeaderMetadata(onResponseHeader)
+	// the stream is reset if @ctx is done or the reader is closed before the end, and cancel is called after the rpc
+	// is finished, terminateCause is set before the trailer made up by client is received
+	streamCtx, cancel := common.WithCancelCause(ctx)
+	untrack := hc.trackRPC(cancel)
+	var terminateCause error
+	dataChan, rspTrailerChan, err := hc.http2Client.StreamPost(address, path, sendChan, &http2Config.PostConfig{
+		ContentType:      tools.GetContentType(codecType),
+		BufferSize:       hc.option.BufferSize,
+		Timeout:          hc.option.Timeout,
+		HeaderField:      newHeader,
+		Compressor:       compressor,
+		Authority:        authority,
+		OnResponseHeader: onResponseHeader,
+		OnMessage:        onMessage,
+		Context:          streamCtx,
+		OnTerminate: func(cause error) {
+			terminateCause = cause
+		},
+	})
+	if err != nil {
+		hc.option.Logger.Errorf("TripleController.UnaryInvokeChunked: triple unary invoke path %s with addr = %s error = %v", path, address, err)
+		untrack()
+		cancel(nil)
+		cancelTimeout()
+		done(err)
+		endStats(err)
+		return nil, err
+	}
+	return newChunkedReader(dataChan, rspTrailerChan, func(trailer http.Header) (common.TripleAttachment, error) {
+		attachment, err := hc.parseTrailer(trailer, terminateCause)
+		completeAttachment(attachment)
+		untrack()
+		cancel(nil)
+		cancelTimeout()
+		done(err)
+		endStats(err)
+		return attachment, err
+	}, func() {
+		cancel(nil)
+		cancelTimeout()
+	}), nil
+}
+
+// Dial connects to server in advance, it is aborted with error if @ctx is done before the conn is set up.
+// With Resolver, it connects to all endpoints currently resolved, and fails only if none of them is connected.
+func (hc *TripleController) Dial(ctx context.Context) error {
+	dialed := false
+	var lastErr error
+	for _, address := range hc.addresses() {
+		if err := hc.http2Client.Dial(ctx, address); err != nil {
+			hc.option.Logger.Errorf("TripleController.Dial: dial %s error = %v", address, err)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				// the other endpoints are not dialed if @ctx is done
+				return ctxErr
+			}
+			lastErr = err
+			continue
+		}
+		dialed = true
+	}
+	if dialed {
+		return nil
+	}
+	return lastErr
+}
+
+// WarmUp connects to server and finishes http2 handshake in advance, so that the first rpc doesn't pay for it.
+// With Resolver, it warms up all endpoints currently resolved, and fails only if none of them is warmed up.
+func (hc *TripleController) WarmUp(ctx context.Context) error {
+	var lastErr error
+	for _, result := range hc.WarmUpConns(ctx) {
+		if result.Error == nil {
+			return nil
+		}
+		lastErr = result.Error
+	}
+	return lastErr
+}
+
+// WarmUpConns warms up the conns to all endpoints concurrently like WarmUp, and returns the result of each conn in
+// the order of endpoints, after all of them are ready or failed, or @ctx is done.
+func (hc *TripleController) WarmUpConns(ctx context.Context) []config.WarmUpResult {
+	addresses := hc.addresses()
+	results := make([]config.WarmUpResult, len(addresses))
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		wg.Add(1)
+		go func(result *config.WarmUpResult, address string) {
+			defer wg.Done()
+			begin := time.Now()
+			result.Address = address
+			result.Error = hc.http2Client.WarmUp(ctx, address)
+			result.Elapsed = time.Since(begin)
+			if result.Error != nil {
+				hc.option.Logger.Errorf("TripleController.WarmUpConns: warm up %s error = %v", address, result.Error)
+			}
+		}(&results[i], address)
+	}
+	wg.Wait()
+	return results
+}
+
+// SetConcurrencyLimiter sets @limiter shared by server, it must be called before serving
+func (hc *TripleController) SetConcurrencyLimiter(limiter *ConcurrencyLimiter) {
+	hc.concurrencyLimiter = limiter
+}
+
+// Destroy destroys TripleController and force close all related goroutine, the conns are closed after the running
+// unary rpcs are finished. It is safe to call it concurrently with rpcs and repeatedly.
 func (hc *TripleController) Destroy() {
-	close(hc.closeChan)
+	hc.destroyOnce.Do(func() {
+		close(hc.closeChan)
+		if hc.unwatchResolver != nil {
+			hc.unwatchResolver()
+		}
+		hc.http2Client.Close()
+		if hc.shadow != nil {
+			hc.shadow.Destroy()
+		}
+	})
+}
+
+// Retain adds a TripleClient sharing the controller, it returns Canceled error if the controller is destroyed.
+// Each successful Retain must be paired with a Release.
+func (hc *TripleController) Retain() error {
+	hc.refsLock.Lock()
+	defer hc.refsLock.Unlock()
+	if err := hc.checkAvailable(); err != nil {
+		return err
+	}
+	hc.refs++
+	return nil
+}
+
+// Release removes a TripleClient sharing the controller, the controller is destroyed when the last one is released
+func (hc *TripleController) Release() {
+	hc.refsLock.Lock()
+	defer hc.refsLock.Unlock()
+	if hc.refs <= 0 {
+		return
+	}
+	hc.refs--
+	if hc.refs == 0 {
+		hc.Destroy()
+	}
+}
+
+// Option returns the option which controller is created with
+func (hc *TripleController) Option() *config.Option {
+	return hc.option
 }
 
 func (hc *TripleController) IsAvailable() bool {
@@ -19,6 +19,7 @@ package message
 
 import (
 	"bytes"
+	"sync"
 )
 
 import (
@@ -64,20 +65,35 @@ func (bm *Message) GetMsgType() MsgType {
 // MsgQueue contain the chan of Message
 type MsgQueue struct {
 	c chan Message
+
+	// done is closed first by Close, to unblock pending Put, then c is closed after them under lock
+	done      chan struct{}
+	lock      sync.RWMutex
+	closeOnce sync.Once
 }
 
 // NewBufferMsgQueue returns new MsgQueue
 func NewBufferMsgQueue() *MsgQueue {
 	b := &MsgQueue{
-		c: make(chan Message),
+		c:    make(chan Message),
+		done: make(chan struct{}),
 	}
 	return b
 }
 
 // Put if stream close by force, the Put function doesn't send anything.
+// It is safe to call Put concurrently with Close, @r is dropped if the queue is closed before it is got.
 func (b *MsgQueue) Put(r Message) {
-	if b.c != nil {
-		b.c <- r
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	select {
+	case b.c <- r:
+	case <-b.done:
 	}
 }
 
@@ -85,8 +101,14 @@ func (b *MsgQueue) Get() <-chan Message {
 	return b.c
 }
 
+// Close closes the chan returned by Get, it is safe to call it repeatedly
 func (b *MsgQueue) Close() {
-	close(b.c)
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		close(b.c)
+	})
 }
 
 /////////////////////////////////stream state
@@ -30,6 +30,8 @@ import (
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	spb "google.golang.org/genproto/googleapis/rpc/status"
+
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 import (
@@ -79,10 +81,26 @@ func FromError(code codes.Code, err error) *TripleError {
 	}
 }
 
+// FromGRPCStatus returns TripleError with the code, msg and details of @err if it implements GRPCStatus, e.g. the error
+// returned by status.Error of grpc, or the one with details returned by handler of TripleUnaryService
+func FromGRPCStatus(err error) (*TripleError, bool) {
+	se, ok := err.(interface{ GRPCStatus() *grpcstatus.Status })
+	if !ok {
+		return nil, false
+	}
+	st := se.GRPCStatus()
+	if st == nil {
+		return nil, false
+	}
+	return &TripleError{e: FromProto(st.Proto())}, true
+}
+
 // Status represents an RPC status codes, message, and details.  It is immutable
 // and should be created with New, Newf, or FromProto.
 type Status struct {
 	s *spb.Status
+	// cause is the reason of rpc canceled at client side, it is never sent
+	cause error
 }
 
 // NewStatus returns a Status representing c and msg.
@@ -111,6 +129,19 @@ func (s *Status) Message() string {
 	return s.s.Message
 }
 
+// WithCause returns a copy of s with @cause, which is unwrapped from the error of client rpc
+func (s *Status) WithCause(cause error) *Status {
+	return &Status{s: s.s, cause: cause}
+}
+
+// Cause returns the cause of s, it is nil if s is not canceled at client side
+func (s *Status) Cause() error {
+	if s == nil {
+		return nil
+	}
+	return s.cause
+}
+
 // Proto returns s's status as an spb.Status proto message.
 func (s *Status) Proto() *spb.Status {
 	if s == nil {
@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"reflect"
 	"sync"
 )
@@ -34,12 +35,14 @@ import (
 )
 
 import (
+	"github.com/dubbogo/triple/internal/buffer"
 	"github.com/dubbogo/triple/internal/codes"
 	"github.com/dubbogo/triple/internal/message"
 	"github.com/dubbogo/triple/internal/status"
 	"github.com/dubbogo/triple/internal/tools"
 	"github.com/dubbogo/triple/pkg/common"
 	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/common/logger"
 	"github.com/dubbogo/triple/pkg/config"
 )
 
@@ -63,19 +66,80 @@ type baseProcessor struct {
 
 // handleRPCErr writes close message with status of given @err
 func (p *baseProcessor) handleRPCErr(err error) {
+	p.handleRPCErrWithAttachment(err, nil)
+}
+
+// handleRPCErrWithAttachment writes close message with status of given @err and trailing @attachment
+func (p *baseProcessor) handleRPCErrWithAttachment(err error, attachment common.TripleAttachment) {
 	if status.IsTripleError(err) {
-		p.stream.WriteCloseMsgTypeWithStatus(err.(*status.TripleError).Status())
+		p.stream.WriteCloseMsgTypeWithStatusAndAttachment(err.(*status.TripleError).Status(), attachment)
+		return
+	}
+	if statusErr, ok := status.FromGRPCStatus(err); ok {
+		p.stream.WriteCloseMsgTypeWithStatusAndAttachment(statusErr.Status(), attachment)
 		return
 	}
-	p.stream.WriteCloseMsgTypeWithStatus(status.FromError(codes.Unknown, err).Status())
+	p.stream.WriteCloseMsgTypeWithStatusAndAttachment(status.FromError(codes.Unknown, err).Status(), attachment)
 }
 
 // handleRPCSuccess sends data and grpc success code with message
-func (p *baseProcessor) handleRPCSuccess(data []byte, attachment map[string]string) {
+func (p *baseProcessor) handleRPCSuccess(data []byte, attachment common.TripleAttachment) {
 	p.stream.PutSend(data, attachment, message.DataMsgType)
 	p.stream.WriteCloseMsgTypeWithStatus(status.NewStatus(codes.OK, ""))
 }
 
+// handleStreamSuccess sends grpc success code with trailing @attachment after all messages of streaming rpc. Unlike
+// handleRPCSuccess, no data message is sent before, otherwise client receives an empty message before io.EOF, which
+// is decoded as a zero value message sent by handler.
+func (p *baseProcessor) handleStreamSuccess(attachment common.TripleAttachment) {
+	p.stream.WriteCloseMsgTypeWithStatusAndAttachment(status.NewStatus(codes.OK, ""), attachment)
+}
+
+// handleRPCChunkedSuccess sends data read from @reader as sequential data messages, each of them is at most
+// constant.DefaultUnaryChunkSize, and then sends grpc success code. It is used when unary rpc returns a huge
+// response as io.Reader, the chunks are not marshaled by codec. Trailing @attachment is sent once with the close
+// message, rather than with every chunk. Reading stops once @ctx of rpc is done, e.g. client closes the reader early
+// and resets the stream.
+func (p *baseProcessor) handleRPCChunkedSuccess(ctx context.Context, reader io.Reader, attachment common.TripleAttachment) {
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	buf := make([]byte, constant.DefaultUnaryChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			p.opt.Logger.Warnf("baseProcessor.handleRPCChunkedSuccess: ctx done before chunked response is sent, error = %v", err)
+			if err == context.DeadlineExceeded {
+				p.handleRPCErr(status.Errorf(codes.DeadlineExceeded, "deadline exceeded before chunked response is sent"))
+			} else {
+				p.handleRPCErr(status.Errorf(codes.Canceled, "rpc canceled before chunked response is sent"))
+			}
+			return
+		}
+		n, err := reader.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			p.stream.PutSend(chunk, nil, message.DataMsgType)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.opt.Logger.Errorf("baseProcessor.handleRPCChunkedSuccess: read chunked response error = %v", err)
+			p.handleRPCErrWithAttachment(status.Errorf(codes.Internal, "read chunked response error = %v", err), attachment)
+			return
+		}
+	}
+	p.stream.WriteCloseMsgTypeWithStatusAndAttachment(status.NewStatus(codes.OK, ""), attachment)
+}
+
+// releaseRecvBuffer puts received @buf back to buffer pool if it's enabled, it must be called after unmarshal
+func (p *baseProcessor) releaseRecvBuffer(buf *bytes.Buffer) {
+	if p.opt.EnableBufferPool {
+		buffer.PutBuffer(buf)
+	}
+}
+
 // close closes processor once
 func (p *baseProcessor) close() {
 	p.quitOnce.Do(func() {
@@ -106,10 +170,20 @@ func newUnaryProcessor(s *serverStream, desc grpc.MethodDesc, serializer common.
 	}
 }
 
-// processUnaryRPC processes unary rpc
-func (p *unaryProcessor) processUnaryRPC(buf bytes.Buffer, service interface{}, header h2Triple.ProtocolHeader) ([]byte, common.ErrorWithAttachment) {
+// responseMarshalError logs error @err of marshaling response @v of rpc @method, and returns status error with code
+// Option.ResponseMarshalErrorCode, which is sent to client instead of the broken response.
+func responseMarshalError(opt *config.Option, method string, v interface{}, err error) error {
+	opt.Logger.Errorf("response serialization of %T of method %s failed, error = %v", v, method, err)
+	return status.Errorf(codes.Code(opt.ResponseMarshalErrorCode), "response serialization failed: marshal %T of method %s error: %v", v, method, err)
+}
+
+// processUnaryRPC processes unary rpc, if the reply of service is io.Reader, it is returned as @rspReader
+// without marshaling, and should be sent by chunks. @ctx is the ctx of rpc, which is passed to ContextCodec.
+func (p *unaryProcessor) processUnaryRPC(ctx context.Context, buf bytes.Buffer, service interface{}, header h2Triple.ProtocolHeader) ([]byte, io.Reader, common.ErrorWithAttachment) {
 	readBuf := buf.Bytes()
-	p.opt.Logger.Debugf("unaryProcessor.processUnaryRPC: with readBuffer to be unmarshal = %s, header = %+v, server defined serialization type = %s", string(readBuf), header, p.opt.CodecType)
+	if logger.DebugEnabled(p.opt.Logger) {
+		p.opt.Logger.Debugf("unaryProcessor.processUnaryRPC: with readBuffer to be unmarshal = %s, header = %+v", string(readBuf), header)
+	}
 
 	var rawReplyStruct interface{}
 	var reply interface{}
@@ -119,24 +193,28 @@ func (p *unaryProcessor) processUnaryRPC(buf bytes.Buffer, service interface{},
 	_, methodName, e := tools.GetServiceKeyAndUpperCaseMethodNameFromPath(header.GetPath())
 	if e != nil {
 		p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: invalid http2 path = %s, error = %s", header.GetPath(), e.Error())
-		return nil, *common.NewErrorWithAttachment(e, nil)
+		return nil, nil, *common.NewErrorWithAttachment(e, nil)
 	}
 	p.opt.Logger.Debugf("unaryProcessor.processUnaryRPC: get parsed golang methodName = %s", methodName)
-	if p.opt.CodecType == constant.PBCodecName {
+	// rpcCtx is passed to handler, to let it set trailing attachments
+	rpcCtx := common.NewTrailerContext(header.FieldToCtx())
+	// methodDesc is only provided for pb service, codec of request is decided by server per call
+	if p.methodDesc.Handler != nil {
 		descFunc := func(v interface{}) error {
-			if err = p.twoWayCodec.UnmarshalRequest(readBuf, v); err != nil {
-				p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: Unary rpc request unmarshal error: %s", err)
-				return status.Errorf(codes.Internal, "Unary rpc request unmarshal error: %s", err)
+			if err = common.UnmarshalRequestContext(ctx, p.twoWayCodec, readBuf, v); err != nil {
+				msg := tools.UnmarshalErrorMessage(header.GetPath(), v, readBuf, err)
+				p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: Unary rpc request %s", msg)
+				return status.Errorf(codes.InvalidArgument, "Unary rpc request %s", msg)
 			}
 			return nil
 		}
 		p.opt.Logger.Debugf("unaryProcessor.processUnaryRPC: unary invoke pb service method %s with header %+v", methodName, header)
-		reply, err = p.methodDesc.Handler(service, header.FieldToCtx(), descFunc, nil)
+		reply, err = p.methodDesc.Handler(service, rpcCtx, descFunc, nil)
 	} else {
 		unaryService, ok := service.(common.TripleUnaryService)
 		if !ok {
 			p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: msgpack provider service %+v doesn't impl TripleUnaryService", service)
-			return nil, *common.NewErrorWithAttachment(status.Errorf(codes.Internal, "msgpack provider service %+v doesn't impl TripleUnaryService", service), responseAttachment)
+			return nil, nil, *common.NewErrorWithAttachment(status.Errorf(codes.Internal, "msgpack provider service %+v doesn't impl TripleUnaryService", service), responseAttachment)
 		}
 
 		if methodName == "$invoke" {
@@ -144,20 +222,21 @@ func (p *unaryProcessor) processUnaryRPC(buf bytes.Buffer, service interface{},
 			args, err = p.genericCodec.UnmarshalRequest(readBuf)
 			if err != nil {
 				p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: generic invoke with request %s unmarshal error = %s", string(readBuf), err.Error())
-				return nil, *common.NewErrorWithAttachment(status.Errorf(codes.Internal, "generic invoke with request %s unmarshal error = %s", string(readBuf), err.Error()), responseAttachment)
+				return nil, nil, *common.NewErrorWithAttachment(status.Errorf(codes.Internal, "generic invoke with request %s unmarshal error = %s", string(readBuf), err.Error()), responseAttachment)
 			}
 			p.opt.Logger.Debugf("unaryProcessor.processUnaryRPC: generic invoke service with header %+v and args %v", header, args)
-			reply, err = unaryService.InvokeWithArgs(header.FieldToCtx(), methodName, args)
+			reply, err = unaryService.InvokeWithArgs(rpcCtx, methodName, args)
 		} else {
 			reqParam, ok := unaryService.GetReqParamsInterfaces(methodName)
 			if !ok {
 				p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: method name %s is not provided by service, please check if correct", methodName)
-				return nil, *common.NewErrorWithAttachment(status.Errorf(codes.Unimplemented, "method name %s is not provided by service, please check if correct", methodName), responseAttachment)
+				return nil, nil, *common.NewErrorWithAttachment(status.Errorf(codes.Unimplemented, "method name %s is not provided by service, please check if correct", methodName), responseAttachment)
 			}
 			// get args from buf
-			if err = p.twoWayCodec.UnmarshalRequest(readBuf, reqParam); err != nil {
-				p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: Unary rpc request unmarshal error: %s", err)
-				return nil, *common.NewErrorWithAttachment(status.Errorf(codes.Internal, "Unary rpc request unmarshal error: %s", err), responseAttachment)
+			if err = common.UnmarshalRequestContext(ctx, p.twoWayCodec, readBuf, reqParam); err != nil {
+				msg := tools.UnmarshalErrorMessage(header.GetPath(), reqParam, readBuf, err)
+				p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: Unary rpc request %s", msg)
+				return nil, nil, *common.NewErrorWithAttachment(status.Errorf(codes.InvalidArgument, "Unary rpc request %s", msg), responseAttachment)
 			}
 			args := make([]interface{}, 0, len(reqParam))
 			for _, v := range reqParam {
@@ -166,17 +245,27 @@ func (p *unaryProcessor) processUnaryRPC(buf bytes.Buffer, service interface{},
 			}
 			// invoke the service
 			p.opt.Logger.Debugf("unaryProcessor.processUnaryRPC: unary invoke service method %s with header %+v and args %+v", methodName, header, args)
-			reply, err = unaryService.InvokeWithArgs(header.FieldToCtx(), methodName, args)
+			reply, err = unaryService.InvokeWithArgs(rpcCtx, methodName, args)
 		}
 	}
 
+	for k, v := range common.TrailerFromContext(rpcCtx) {
+		responseAttachment.Add(k, v...)
+	}
 	if result, ok := reply.(common.OuterResult); ok {
 		// proceess header trailer
-		outerAttachment := result.Attachments()
+		outerAttachment, marshalErr := common.MarshalAttachmentValues(result.Attachments(), p.opt.AttachmentValueCodecs)
+		if marshalErr != nil {
+			p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: invalid response attachment, error = %v", marshalErr)
+			return nil, nil, *common.NewErrorWithAttachment(status.Errorf(codes.Internal, "invalid response attachment: %v", marshalErr), responseAttachment)
+		}
 		p.opt.Logger.Debugf("unaryProcessor.processUnaryRPC: get outerAttachment = %+v", outerAttachment)
 		for k, v := range outerAttachment {
-			if str, ok := v.(string); ok {
-				responseAttachment[k] = str
+			switch value := v.(type) {
+			case string:
+				responseAttachment.Set(k, value)
+			case []string:
+				responseAttachment.Set(k, value...)
 			}
 		}
 		p.opt.Logger.Debugf("unaryProcessor.processUnaryRPC: get triple attachment = %+v", responseAttachment)
@@ -187,22 +276,41 @@ func (p *unaryProcessor) processUnaryRPC(buf bytes.Buffer, service interface{},
 		rawReplyStruct = reply
 	}
 
+	if rspReader, ok := rawReplyStruct.(io.Reader); ok && err == nil {
+		p.opt.Logger.Debugf("unaryProcessor.processUnaryRPC: get reply io.Reader, send it by chunks")
+		return nil, rspReader, *common.NewErrorWithAttachment(nil, responseAttachment)
+	}
+
 	var replyData []byte
 	if rawReplyStruct != nil {
 		p.opt.Logger.Debugf("get result rawReplyStruct = %+v", rawReplyStruct)
 		var marshalErr error
-		replyData, marshalErr = p.twoWayCodec.MarshalResponse(rawReplyStruct)
+		replyData, marshalErr = common.MarshalResponseContext(ctx, p.twoWayCodec, rawReplyStruct)
 		if marshalErr != nil {
-			p.opt.Logger.Errorf("unaryProcessor.processUnaryRPC: Unary rpc reply marshal error: %s", marshalErr)
-			return nil, *common.NewErrorWithAttachment(status.Errorf(codes.Internal, "Unary rpc reply marshal error: %s", marshalErr), responseAttachment)
+			return nil, nil, *common.NewErrorWithAttachment(responseMarshalError(p.opt, header.GetPath(), rawReplyStruct, marshalErr), responseAttachment)
 		}
 	}
 
 	if err != nil {
-		return replyData, *common.NewErrorWithAttachment(status.FromError(codes.Unknown, err), responseAttachment)
+		if tripleErr, ok := err.(*common.TripleError); ok {
+			// e.g. error returned by common.NewHandlerError
+			for k, v := range tripleErr.Attachment() {
+				responseAttachment.Set(k, v...)
+			}
+			return replyData, nil, *common.NewErrorWithAttachment(status.Errorf(codes.Code(tripleErr.Code()), "%s", tripleErr.Error()), responseAttachment)
+		}
+		// e.g. unmarshal error returned by dec func of pb handler
+		if statusErr, ok := err.(*status.TripleError); ok {
+			return replyData, nil, *common.NewErrorWithAttachment(statusErr, responseAttachment)
+		}
+		// e.g. error with details returned by status.Error of grpc, which are sent in grpc-status-details-bin
+		if statusErr, ok := status.FromGRPCStatus(err); ok {
+			return replyData, nil, *common.NewErrorWithAttachment(statusErr, responseAttachment)
+		}
+		return replyData, nil, *common.NewErrorWithAttachment(status.FromError(codes.Unknown, err), responseAttachment)
 	}
 
-	return replyData, *common.NewErrorWithAttachment(nil, responseAttachment)
+	return replyData, nil, *common.NewErrorWithAttachment(nil, responseAttachment)
 }
 
 // runRPC is called by lower layer's stream
@@ -211,6 +319,13 @@ func (p *unaryProcessor) runRPC(ctx context.Context) *status.TripleError {
 	if perr := p.pool.Submit(func() {
 		select {
 		case <-ctx.Done():
+			// deadline exceeded before receiving request
+			p.opt.Logger.Warnf("unaryProcessor:runRPC: ctx done before receiving request, error = %v", ctx.Err())
+			if ctx.Err() == context.DeadlineExceeded {
+				p.handleRPCErr(status.Errorf(codes.DeadlineExceeded, "deadline exceeded before receiving request"))
+			} else {
+				p.handleRPCErr(status.Errorf(codes.Canceled, "rpc canceled before receiving request"))
+			}
 			return
 		case <-p.done:
 			// in this case, server doesn't receive data but got close signal, it returns canceled code
@@ -230,10 +345,16 @@ func (p *unaryProcessor) runRPC(ctx context.Context) *status.TripleError {
 				p.handleRPCErr(status.Errorf(codes.Internal, "unary processor receive message from http2 error = %s", recvMsg.Err))
 				return
 			}
-			rspData, errWithAttachment := p.processUnaryRPC(*recvMsg.Buffer, p.stream.getService(), p.stream.getHeader())
+			defer p.releaseRecvBuffer(recvMsg.Buffer)
+			rspData, rspReader, errWithAttachment := p.processUnaryRPC(ctx, *recvMsg.Buffer, p.stream.getService(), p.stream.getHeader())
 			if err := errWithAttachment.GetError(); err != nil {
 				p.opt.Logger.Errorf("unaryProcessor:runRPC: process unary rpc with: header = %+v\ndata = %s\n error = %s", p.stream.getHeader(), recvMsg.Buffer.String(), err)
-				p.handleRPCErr(err)
+				p.handleRPCErrWithAttachment(err, errWithAttachment.GetAttachments())
+				return
+			}
+
+			if rspReader != nil {
+				p.handleRPCChunkedSuccess(ctx, rspReader, errWithAttachment.GetAttachments())
 				return
 			}
 
@@ -274,18 +395,39 @@ func newStreamingProcessor(s *serverStream, desc grpc.StreamDesc, serializer com
 
 // runRPC called by stream
 func (sp *streamingProcessor) runRPC(ctx context.Context) *status.TripleError {
-	serverUserStream := newServerUserStream(sp.stream, sp.twoWayCodec, sp.opt)
+	// rpcCtx is returned by Context of stream, to let handler set trailing attachments
+	rpcCtx := common.NewTrailerContext(sp.stream.getHeader().FieldToCtx())
+	serverUserStream := newServerUserStream(rpcCtx, sp.stream.getHeader().GetPath(), sp.stream, sp.twoWayCodec, sp.opt)
+
+	stopHeartbeat := func() {}
+	if heartbeat, ok := sp.opt.MethodStreamHeartbeats[sp.stream.getHeader().GetPath()]; ok && heartbeat.Interval > 0 {
+		stopHeartbeat = serverUserStream.startHeartbeat(heartbeat)
+	}
 
 	if perr := sp.pool.Submit(func() {
-		if err := sp.streamDesc.Handler(sp.stream.getService(), serverUserStream); err != nil {
+		err := sp.streamDesc.Handler(sp.stream.getService(), serverUserStream)
+		// heartbeat is stopped before the close message
+		stopHeartbeat()
+		if err != nil {
 			sp.opt.Logger.Errorf("streamingProcessor.runRPC: stream processor handle streaming request with service %+v with error = %s", sp.stream.getService(), err)
-			sp.handleRPCErr(status.Errorf(codes.Internal, "stream processor handle streaming request with service %+v with error = %s", sp.stream.getService(), err))
+			trailer := common.TrailerFromContext(rpcCtx)
+			// the status of error returned by handler is sent after the messages already sent
+			if tripleErr, ok := err.(*common.TripleError); ok {
+				sp.handleRPCErrWithAttachment(status.Errorf(codes.Code(tripleErr.Code()), "%s", tripleErr.Error()), trailer)
+				return
+			}
+			if status.IsTripleError(err) {
+				sp.handleRPCErrWithAttachment(err, trailer)
+				return
+			}
+			sp.handleRPCErrWithAttachment(status.Errorf(codes.Internal, "stream processor handle streaming request with service %+v with error = %s", sp.stream.getService(), err), trailer)
 			return
 		}
 		// for stream rpc, processor should send CloseMsg to lower stream layer to call close
 		// but unary rpc not, unary rpc processor only send data to stream layer
-		sp.handleRPCSuccess(nil, nil)
+		sp.handleStreamSuccess(common.TrailerFromContext(rpcCtx))
 	}); perr != nil {
+		stopHeartbeat()
 		sp.opt.Logger.Warnf("streamingProcessor.runRPC: go routine pool full with error = %v", perr)
 		return status.Errorf(codes.ResourceExhausted, "go routine pool full with error = %v", perr)
 	}
@@ -44,10 +44,12 @@ import (
 type Stream interface {
 	// channel usage
 	PutRecv(data []byte, msgType message.MsgType)
-	PutSend(data []byte, attachment map[string]string, msgType message.MsgType)
+	PutSend(data []byte, attachment common.TripleAttachment, msgType message.MsgType)
 	GetSend() <-chan message.Message
 	GetRecv() <-chan message.Message
 	PutSplitDataRecv(splitData []byte, msgType message.MsgType, handler common.PackageHandler)
+	// CloseRecv closes recvBuf only, the following PutRecv drops messages without blocking
+	CloseRecv()
 	Close()
 }
 
@@ -76,9 +78,15 @@ type baseStream struct {
 
 // WriteCloseMsgTypeWithStatus put bufferMsg with status:  @st and type: ServerStreamCloseMsgType
 func (s *baseStream) WriteCloseMsgTypeWithStatus(st *status.Status) {
+	s.WriteCloseMsgTypeWithStatusAndAttachment(st, nil)
+}
+
+// WriteCloseMsgTypeWithStatusAndAttachment put bufferMsg with status: @st, trailing @attachment and type: ServerStreamCloseMsgType
+func (s *baseStream) WriteCloseMsgTypeWithStatusAndAttachment(st *status.Status, attachment common.TripleAttachment) {
 	s.sendBuf.Put(message.Message{
-		Status:  st,
-		MsgType: message.ServerStreamCloseMsgType,
+		Status:     st,
+		MsgType:    message.ServerStreamCloseMsgType,
+		Attachment: attachment,
 	})
 }
 
@@ -117,7 +125,7 @@ func (s *baseStream) PutSplitDataRecv(splitData []byte, msgType message.MsgType,
 }
 
 // PutSend put message type and @data to sendBuf
-func (s *baseStream) PutSend(data []byte, attachment map[string]string, msgType message.MsgType) {
+func (s *baseStream) PutSend(data []byte, attachment common.TripleAttachment, msgType message.MsgType) {
 	s.sendBuf.Put(message.Message{
 		Buffer:     bytes.NewBuffer(data),
 		MsgType:    msgType,
@@ -230,3 +238,22 @@ func NewClientStream() *clientStream {
 func (cs *clientStream) Close() {
 	cs.baseStream.Close()
 }
+
+// PutRecvStatus puts the final status and @attachment of rpc from trailer to recvBuf, after all the data messages
+func (cs *clientStream) PutRecvStatus(st *status.Status, attachment common.TripleAttachment) {
+	cs.recvBuf.Put(message.Message{
+		Status:     st,
+		Attachment: attachment,
+		MsgType:    message.ServerStreamCloseMsgType,
+	})
+}
+
+// CloseSend closes sendBuf only, it is called when the send loop exits
+func (cs *clientStream) CloseSend() {
+	cs.sendBuf.Close()
+}
+
+// CloseRecv closes recvBuf only, it is called by client after the final status is received
+func (s *baseStream) CloseRecv() {
+	s.recvBuf.Close()
+}
@@ -19,6 +19,11 @@ package stream
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 import (
@@ -28,7 +33,11 @@ import (
 )
 
 import (
+	"github.com/dubbogo/triple/internal/buffer"
+	"github.com/dubbogo/triple/internal/codes"
 	"github.com/dubbogo/triple/internal/message"
+	"github.com/dubbogo/triple/internal/status"
+	"github.com/dubbogo/triple/internal/tools"
 	"github.com/dubbogo/triple/pkg/common"
 	"github.com/dubbogo/triple/pkg/config"
 )
@@ -39,6 +48,30 @@ type baseUserStream struct {
 	opt         *config.Option
 	stream      Stream
 	twoWayCodec common.TwoWayCodec
+	// releaseRecvBuf is true if received messages are from buffer pool, and should be released after unmarshal
+	releaseRecvBuf bool
+	// sending is 1 when SendMsg is in progress, it is used to detect concurrent SendMsg
+	sending int32
+	// recvErr is the final error of client stream, io.EOF if the rpc succeeds, or the error of exceeding
+	// MaxStreamMessages, it is returned by all following RecvMsg
+	recvErr error
+	// recvMsgs is the number of data messages received, which is limited by Option.MaxStreamMessages
+	recvMsgs int
+	// exceedMaxMsgs terminates stream which receives more than Option.MaxStreamMessages messages, and returns the error
+	exceedMaxMsgs func() error
+	// trailer is the trailer attachment of client stream, it is set with recvErr
+	trailer common.TripleAttachment
+	// lastSend is the time in unix nano when the last message is sent, it is used to decide the idle time of stream
+	lastSend int64
+	// isHeartbeat reports whether received raw message is heartbeat, which is skipped, nil means no heartbeat
+	isHeartbeat func(data []byte) bool
+	// method is the path of stream rpc, it is used to describe unmarshal error
+	method string
+	// unmarshalErrCode is the code of unmarshal error of received messages
+	unmarshalErrCode codes.Code
+	// marshalErr converts marshal error of sent message, it is set only by server to report response serialization
+	// failure, nil means the error is returned as it is
+	marshalErr func(m interface{}, err error) error
 }
 
 // nolint
@@ -60,50 +93,205 @@ func (ss *baseUserStream) Context() context.Context {
 	return nil
 }
 
-// SendMsg sends message `m` to stream
+// SendMsg sends message `m` to stream, the whole message is put to stream as one unit, so messages are sent in order.
+// It is not safe to call SendMsg concurrently on the same stream, and the concurrent call returns error at once
+// without sending anything.
 // nolint
 func (ss *baseUserStream) SendMsg(m interface{}) error {
+	if !atomic.CompareAndSwapInt32(&ss.sending, 0, 1) {
+		ss.opt.Logger.Error("SendMsg is called concurrently on the same stream")
+		return status.Errorf(codes.Internal, "SendMsg is called concurrently on the same stream")
+	}
+	defer atomic.StoreInt32(&ss.sending, 0)
+
 	replyData, err := ss.twoWayCodec.MarshalRequest(m)
 	if err != nil {
+		if ss.marshalErr != nil {
+			return ss.marshalErr(m, err)
+		}
 		ss.opt.Logger.Error("send msg error with msg = ", m)
 		return err
 	}
 	ss.stream.PutSend(replyData, nil, message.DataMsgType)
+	atomic.StoreInt64(&ss.lastSend, time.Now().UnixNano())
 	return nil
 }
 
 // RecvMsg gets message `m` from stream
 // nolint
 func (ss *baseUserStream) RecvMsg(m interface{}) error {
+	return ss.recvMsg(m, nil)
+}
+
+// RecvMsgTimeout gets message `m` from stream like RecvMsg, but it returns DeadlineExceeded error if no message
+// arrives in @timeout. The stream is not closed in this case, and the following messages can still be received.
+func (ss *baseUserStream) RecvMsgTimeout(m interface{}, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	return ss.recvMsg(m, timer.C)
+}
+
+// recvMsg gets message `m` from stream, it gives up when @timeout fires, nil @timeout means waiting forever
+func (ss *baseUserStream) recvMsg(m interface{}, timeout <-chan time.Time) error {
+	if ss.recvErr != nil {
+		return ss.recvErr
+	}
 	recvChan := ss.stream.GetRecv()
-	readBuf, ok := <-recvChan
-	if !ok {
-		return errors.Errorf("user stream closed!")
+	var readBuf message.Message
+	var ok bool
+	for {
+		select {
+		case readBuf, ok = <-recvChan:
+		case <-timeout:
+			return status.Errorf(codes.DeadlineExceeded, "no message received by deadline of RecvMsgTimeout")
+		}
+		if !ok {
+			return errors.Errorf("user stream closed!")
+		}
+		// heartbeats are skipped, they don't count as messages for RecvMsgTimeout either
+		if readBuf.MsgType == message.DataMsgType && ss.isHeartbeat != nil && ss.isHeartbeat(readBuf.Bytes()) {
+			if ss.releaseRecvBuf {
+				buffer.PutBuffer(readBuf.Buffer)
+			}
+			continue
+		}
+		break
 	}
-	if err := ss.twoWayCodec.UnmarshalResponse(readBuf.Bytes(), m); err != nil {
-		return err
+	if readBuf.MsgType == message.DataMsgType && ss.opt.MaxStreamMessages > 0 {
+		if ss.recvMsgs >= ss.opt.MaxStreamMessages {
+			if ss.releaseRecvBuf {
+				buffer.PutBuffer(readBuf.Buffer)
+			}
+			ss.opt.Logger.Warnf("stream %s receives more than %d messages, it is terminated", ss.method, ss.opt.MaxStreamMessages)
+			// messages still sent by peer are dropped, so that the receiving side is not blocked
+			ss.stream.CloseRecv()
+			ss.recvErr = ss.exceedMaxMsgs()
+			return ss.recvErr
+		}
+		ss.recvMsgs++
 	}
-	return nil
+	if readBuf.MsgType == message.ServerStreamCloseMsgType {
+		// the final status is received after all messages
+		ss.recvErr = io.EOF
+		ss.trailer = readBuf.Attachment
+		if readBuf.Status != nil && readBuf.Status.Code() != codes.OK {
+			ss.recvErr = common.NewTripleErrorWithCause(readBuf.Status.Message(), int(readBuf.Status.Code()),
+				readBuf.Status.Cause(), readBuf.Attachment)
+		}
+		return ss.recvErr
+	}
+	err := ss.twoWayCodec.UnmarshalResponse(readBuf.Bytes(), m)
+	if err != nil {
+		msg := tools.UnmarshalErrorMessage(ss.method, m, readBuf.Bytes(), err)
+		ss.opt.Logger.Errorf("recv msg error = %s", msg)
+		err = status.Errorf(ss.unmarshalErrCode, "%s", msg)
+	}
+	if ss.releaseRecvBuf {
+		buffer.PutBuffer(readBuf.Buffer)
+	}
+	return err
 }
 
 // serverUserStream can be thrown to grpc, and let grpc use it
 type serverUserStream struct {
 	baseUserStream
+	// ctx is the ctx of server rpc, which is created by common.NewTrailerContext
+	ctx context.Context
 }
 
-func newServerUserStream(s Stream, serializer common.TwoWayCodec, opt *config.Option) *serverUserStream {
+// newServerUserStream returns serverUserStream of rpc @method path, request messages which can't be unmarshaled are
+// reported as InvalidArgument, response messages which can't be marshaled are reported as Option.ResponseMarshalErrorCode
+func newServerUserStream(ctx context.Context, method string, s Stream, serializer common.TwoWayCodec, opt *config.Option) *serverUserStream {
 	return &serverUserStream{
 		baseUserStream: baseUserStream{
-			twoWayCodec: serializer,
-			stream:      s,
-			opt:         opt,
+			twoWayCodec:      serializer,
+			stream:           s,
+			opt:              opt,
+			releaseRecvBuf:   opt.EnableBufferPool,
+			method:           method,
+			unmarshalErrCode: codes.InvalidArgument,
+			marshalErr: func(m interface{}, err error) error {
+				return responseMarshalError(opt, method, m, err)
+			},
+			// handler returns the error, which is sent to client as the status of stream
+			exceedMaxMsgs: func() error {
+				return status.Errorf(codes.ResourceExhausted, "%s", exceedMaxStreamMessagesMessage(method, opt))
+			},
 		},
+		ctx: ctx,
+	}
+}
+
+// exceedMaxStreamMessagesMessage returns message of error of stream @method receiving more than MaxStreamMessages of @opt
+func exceedMaxStreamMessagesMessage(method string, opt *config.Option) string {
+	return fmt.Sprintf("stream %s receives more than max stream messages %d", method, opt.MaxStreamMessages)
+}
+
+// Context returns ctx of server rpc, with incoming attachments stored in
+func (ss *serverUserStream) Context() context.Context {
+	return ss.ctx
+}
+
+// startHeartbeat sends @heartbeat message on stream each time it is idle for heartbeat interval, until the returned
+// stop is called. stop must be called before the stream is closed.
+func (ss *serverUserStream) startHeartbeat(heartbeat config.StreamHeartbeat) (stop func()) {
+	var data []byte
+	if heartbeat.Message != nil {
+		var err error
+		if data, err = ss.twoWayCodec.MarshalRequest(heartbeat.Message); err != nil {
+			ss.opt.Logger.Errorf("serverUserStream.startHeartbeat: marshal heartbeat message error = %v", err)
+			return func() {}
+		}
+	}
+	atomic.StoreInt64(&ss.lastSend, time.Now().UnixNano())
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		timer := time.NewTimer(heartbeat.Interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&ss.lastSend)))
+			if idle >= heartbeat.Interval {
+				ss.stream.PutSend(data, nil, message.DataMsgType)
+				atomic.StoreInt64(&ss.lastSend, time.Now().UnixNano())
+				idle = 0
+			}
+			timer.Reset(heartbeat.Interval - idle)
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// SetTrailer merges @md to trailing attachments, which are sent when the stream ends, all values of a key are sent
+func (ss *serverUserStream) SetTrailer(md metadata.MD) {
+	for k, v := range md {
+		for _, value := range v {
+			_ = common.AddTrailer(ss.ctx, k, value)
+		}
 	}
 }
 
 // clientUserStream can be thrown to grpc, and let grpc use it
 type clientUserStream struct {
 	baseUserStream
+	// cancel cancels the http2 stream of rpc, it is nil if the stream can't be cancelled
+	cancel func()
+	// closeLock guards closed, closeErr and onClose
+	closeLock sync.Mutex
+	// closed is true after the rpc ends with final error closeErr, which is nil if the status is OK
+	closed   bool
+	closeErr error
+	// onClose are callbacks registered by OnClose before the rpc ends
+	onClose []func(err error)
 }
 
 // nolint
@@ -111,9 +299,16 @@ func (ss *clientUserStream) Header() (metadata.MD, error) {
 	return nil, nil
 }
 
-// nolint
+// Trailer returns trailer attachments of the rpc, it is only available after RecvMsg returns error
 func (ss *clientUserStream) Trailer() metadata.MD {
-	return nil
+	if ss.trailer == nil {
+		return nil
+	}
+	md := make(metadata.MD, len(ss.trailer))
+	for k, v := range ss.trailer {
+		md[k] = append([]string(nil), v...)
+	}
+	return md
 }
 
 // nolint
@@ -122,13 +317,75 @@ func (ss *clientUserStream) CloseSend() error {
 	return nil
 }
 
+// SetHeartbeatPredicate sets @isHeartbeat, received messages reported by it are skipped by RecvMsg
+func (ss *clientUserStream) SetHeartbeatPredicate(isHeartbeat func(data []byte) bool) {
+	ss.isHeartbeat = isHeartbeat
+}
+
+// SetCancel sets @cancel of the http2 stream of rpc, which is called by Stop
+func (ss *clientUserStream) SetCancel(cancel func()) {
+	ss.cancel = cancel
+}
+
+// Stop terminates the stream early, e.g. after the first N results of server streaming rpc are received. RST_STREAM
+// is sent to server, whose handler ctx is canceled, and the following RecvMsg returns Canceled error after the messages
+// already received. It is safe to call it repeatedly, and it is no-op after the rpc is finished.
+func (ss *clientUserStream) Stop() {
+	if ss.cancel != nil {
+		ss.cancel()
+	}
+}
+
+// OnClose registers @callback, which is called once when the rpc ends for any reason, with nil if the final status is
+// OK, otherwise the error of final status. It is called at once if the rpc has already ended.
+func (ss *clientUserStream) OnClose(callback func(err error)) {
+	ss.closeLock.Lock()
+	if !ss.closed {
+		ss.onClose = append(ss.onClose, callback)
+		ss.closeLock.Unlock()
+		return
+	}
+	err := ss.closeErr
+	ss.closeLock.Unlock()
+	callback(err)
+}
+
+// SetClosed marks the rpc ended with final error @err, and calls callbacks registered by OnClose in order. Only the
+// first call takes effect.
+func (ss *clientUserStream) SetClosed(err error) {
+	ss.closeLock.Lock()
+	if ss.closed {
+		ss.closeLock.Unlock()
+		return
+	}
+	ss.closed, ss.closeErr = true, err
+	callbacks := ss.onClose
+	ss.onClose = nil
+	ss.closeLock.Unlock()
+	for _, callback := range callbacks {
+		callback(err)
+	}
+}
+
+// SetMethod sets path of stream rpc @method, which is used to describe unmarshal error of response messages
+func (ss *clientUserStream) SetMethod(method string) {
+	ss.method = method
+}
+
 // nolint
 func NewClientUserStream(s Stream, serializer common.TwoWayCodec, opt *config.Option) *clientUserStream {
-	return &clientUserStream{
+	ss := &clientUserStream{
 		baseUserStream: baseUserStream{
-			twoWayCodec: serializer,
-			stream:      s,
-			opt:         opt,
+			twoWayCodec:      serializer,
+			stream:           s,
+			opt:              opt,
+			unmarshalErrCode: codes.Internal,
 		},
 	}
+	// the rpc is stopped, so that server stops streaming
+	ss.exceedMaxMsgs = func() error {
+		ss.Stop()
+		return common.NewTripleError(exceedMaxStreamMessagesMessage(ss.method, opt), int(codes.ResourceExhausted), "", nil)
+	}
+	return ss
 }
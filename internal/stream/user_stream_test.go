@@ -18,6 +18,7 @@
 package stream
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -26,10 +27,18 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"google.golang.org/grpc"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 import (
+	codecImpl "github.com/dubbogo/triple/internal/codec/twoway_codec_impl"
+	"github.com/dubbogo/triple/internal/codes"
 	"github.com/dubbogo/triple/internal/message"
+	"github.com/dubbogo/triple/internal/status"
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/logger/default_logger"
+	"github.com/dubbogo/triple/pkg/config"
 )
 
 type TestRPCService struct {
@@ -74,7 +83,7 @@ func TestBaseUserStream(t *testing.T) {
 	// put msg
 	for i := 0; i < 500; i++ {
 		baseUserStream.PutRecv(testData, message.ServerStreamCloseMsgType)
-		baseUserStream.PutSend(testData, make(map[string]string), message.DataMsgType)
+		baseUserStream.PutSend(testData, make(common.TripleAttachment), message.DataMsgType)
 	}
 	time.Sleep(time.Second)
 	assert.Equal(t, counter, 1000)
@@ -84,3 +93,152 @@ func TestBaseUserStream(t *testing.T) {
 	//assert.Equal(t, closeMsg.msgType, ServerStreamCloseMsgType)
 	//assert.Equal(t, closeMsg.err, errors.New("close error"))
 }
+
+func TestClientUserStreamRecvMsgTimeout(t *testing.T) {
+	s := newBaseStream(&TestRPCService{})
+	codec := codecImpl.NewPBTwoWayCodec()
+	userStream := NewClientUserStream(s, codec, config.NewTripleOption())
+
+	// producer sends the first message at once, and pauses longer than the per-recv deadline before the second one
+	resume := make(chan struct{})
+	go func() {
+		for _, v := range []string{"first", "second"} {
+			data, err := codec.MarshalResponse(wrapperspb.String(v))
+			assert.Nil(t, err)
+			s.PutRecv(data, message.DataMsgType)
+			<-resume
+		}
+	}()
+
+	msg := &wrapperspb.StringValue{}
+	assert.Nil(t, userStream.RecvMsgTimeout(msg, time.Second))
+	assert.Equal(t, "first", msg.GetValue())
+
+	start := time.Now()
+	err := userStream.RecvMsgTimeout(msg, 50*time.Millisecond)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.True(t, status.IsTripleError(err))
+	assert.Equal(t, codes.DeadlineExceeded, err.(*status.TripleError).Status().Code())
+
+	// the stream is still available after timeout
+	close(resume)
+	assert.Nil(t, userStream.RecvMsgTimeout(msg, time.Second))
+	assert.Equal(t, "second", msg.GetValue())
+}
+
+// blockingCodec blocks in MarshalRequest until unblock is closed
+type blockingCodec struct {
+	marshaling chan struct{}
+	unblock    chan struct{}
+}
+
+func (c *blockingCodec) MarshalRequest(v interface{}) ([]byte, error) {
+	close(c.marshaling)
+	<-c.unblock
+	return []byte("blocked"), nil
+}
+
+func (c *blockingCodec) MarshalResponse(v interface{}) ([]byte, error) {
+	return nil, nil
+}
+
+func (c *blockingCodec) UnmarshalRequest(data []byte, v interface{}) error {
+	return nil
+}
+
+func (c *blockingCodec) UnmarshalResponse(data []byte, v interface{}) error {
+	return nil
+}
+
+func TestClientUserStreamConcurrentSendMsg(t *testing.T) {
+	s := newBaseStream(&TestRPCService{})
+	codec := &blockingCodec{
+		marshaling: make(chan struct{}),
+		unblock:    make(chan struct{}),
+	}
+	userStream := NewClientUserStream(s, codec, config.NewTripleOption(config.WithLogger(default_logger.GetDefaultLogger())))
+
+	firstErr := make(chan error)
+	go func() {
+		firstErr <- userStream.SendMsg("first")
+	}()
+	<-codec.marshaling
+
+	// SendMsg in progress is detected, and the concurrent one returns error without sending
+	err := userStream.SendMsg("second")
+	assert.True(t, status.IsTripleError(err))
+	assert.Equal(t, codes.Internal, err.(*status.TripleError).Status().Code())
+
+	close(codec.unblock)
+	msg := <-s.GetSend()
+	assert.Equal(t, "blocked", string(msg.Bytes()))
+	assert.Nil(t, <-firstErr)
+}
+
+func TestUserStreamMaxStreamMessages(t *testing.T) {
+	codec := codecImpl.NewPBTwoWayCodec()
+	opt := config.NewTripleOption(config.WithMaxStreamMessages(2))
+	opt.Validate()
+	// putMessages puts messages and one heartbeat to @s in background, which exceed the limit, the returned chan is
+	// closed after all of them are put
+	putMessages := func(s Stream) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for _, v := range []string{"0", "heartbeat", "1", "2", "3", "4"} {
+				data, _ := codec.MarshalResponse(wrapperspb.String(v))
+				s.PutRecv(data, message.DataMsgType)
+			}
+		}()
+		return done
+	}
+	// assertDrained asserts that messages beyond the limit are dropped without blocking the producer
+	assertDrained := func(done chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("producer is blocked after the stream is terminated")
+		}
+	}
+	heartbeat, _ := codec.MarshalResponse(wrapperspb.String("heartbeat"))
+
+	s := newBaseStream(&TestRPCService{})
+	clientStream := NewClientUserStream(s, codec, opt)
+	clientStream.SetMethod("/service/Search")
+	clientStream.SetHeartbeatPredicate(func(data []byte) bool {
+		return string(data) == string(heartbeat)
+	})
+	stopped := false
+	clientStream.SetCancel(func() {
+		stopped = true
+	})
+	done := putMessages(s)
+	for _, v := range []string{"0", "1"} {
+		msg := &wrapperspb.StringValue{}
+		assert.Nil(t, clientStream.RecvMsg(msg))
+		assert.Equal(t, v, msg.GetValue())
+	}
+	err := clientStream.RecvMsg(&wrapperspb.StringValue{})
+	tripleErr, ok := err.(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, int(codes.ResourceExhausted), tripleErr.Code())
+	assert.Equal(t, "stream /service/Search receives more than max stream messages 2", tripleErr.Error())
+	// the rpc is stopped, and the error is kept after the close message
+	assert.True(t, stopped)
+	assertDrained(done)
+	s.PutRecv(nil, message.ServerStreamCloseMsgType)
+	assert.Equal(t, err, clientStream.RecvMsg(&wrapperspb.StringValue{}))
+
+	s = newBaseStream(&TestRPCService{})
+	serverStream := newServerUserStream(context.Background(), "/service/Upload", s, codec, opt)
+	done = putMessages(s)
+	// server has no heartbeat predicate, the heartbeat is a message
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, serverStream.RecvMsg(&wrapperspb.StringValue{}))
+	}
+	err = serverStream.RecvMsg(&wrapperspb.StringValue{})
+	assert.True(t, status.IsTripleError(err))
+	assert.Equal(t, codes.ResourceExhausted, err.(*status.TripleError).Status().Code())
+	assertDrained(done)
+	assert.Equal(t, err, serverStream.RecvMsg(&wrapperspb.StringValue{}))
+}
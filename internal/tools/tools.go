@@ -31,6 +31,7 @@ import (
 import (
 	"github.com/dubbogo/triple/internal/codes"
 	"github.com/dubbogo/triple/internal/status"
+	"github.com/dubbogo/triple/pkg/common/constant"
 	"github.com/dubbogo/triple/pkg/config"
 )
 
@@ -44,6 +45,28 @@ func AddDefaultOption(opt *config.Option) *config.Option {
 	return opt
 }
 
+// GetContentType returns content-type of requests marshaled by codec @codecType, e.g. "application/grpc+hessian2"
+func GetContentType(codecType constant.CodecType) string {
+	if codecType == "" || codecType == constant.PBCodecName {
+		return constant.TripleContentType
+	}
+	return constant.TripleContentTypePrefix + "+" + string(codecType)
+}
+
+// GetContentSubType returns sub-type of @contentType, e.g. "proto" of "application/grpc+proto", it returns empty
+// string if there is no sub-type
+func GetContentSubType(contentType string) string {
+	if !strings.HasPrefix(contentType, constant.TripleContentTypePrefix+"+") {
+		return ""
+	}
+	subType := contentType[len(constant.TripleContentTypePrefix)+1:]
+	// remove params, e.g. "application/grpc+proto; charset=utf-8"
+	if idx := strings.Index(subType, ";"); idx >= 0 {
+		subType = subType[:idx]
+	}
+	return strings.TrimSpace(subType)
+}
+
 // GetServiceKeyAndUpperCaseMethodNameFromPath todo call this function time, once to save time
 func GetServiceKeyAndUpperCaseMethodNameFromPath(path string) (string, string, *status.TripleError) {
 	paramList := strings.Split(path, "/")
@@ -61,7 +84,8 @@ func GetServiceKeyAndUpperCaseMethodNameFromPath(path string) (string, string, *
 
 }
 
-// ReflectResponse reflect return value
+// ReflectResponse reflect return value @in to @out, @out must be a non-nil pointer, and nil pointers it points to,
+// e.g. *out of **Msg, are allocated. It returns error if type of @in can't be assigned to @out.
 // TODO response object should not be copied again to another object, it should be the exact type of the object
 func ReflectResponse(in interface{}, out interface{}) error {
 	if in == nil {
@@ -77,25 +101,83 @@ func ReflectResponse(in interface{}, out interface{}) error {
 
 	inValue := hessian.EnsurePackValue(in)
 	outValue := hessian.EnsurePackValue(out)
+	if !inValue.IsValid() || isNilValue(inValue) {
+		return perrors.Errorf("@in is nil")
+	}
+	if outValue.IsNil() {
+		return perrors.Errorf("@out is nil pointer of type %s", outValue.Type())
+	}
 
-	outType := outValue.Type().String()
-	if outType == "interface {}" || outType == "*interface {}" {
-		hessian.SetValue(outValue, inValue)
+	// unpack pointer-to-pointer, e.g. **Msg, to single pointer *Msg, allocating nil ones
+	for outValue.Elem().Kind() == reflect.Ptr {
+		if inValue.Type().AssignableTo(outValue.Elem().Type()) {
+			outValue.Elem().Set(inValue)
+			return nil
+		}
+		if outValue.Elem().IsNil() {
+			outValue.Elem().Set(reflect.New(outValue.Elem().Type().Elem()))
+		}
+		outValue = outValue.Elem()
+	}
+
+	outType := outValue.Type().Elem()
+	if outType.Kind() == reflect.Interface {
+		if !inValue.Type().Implements(outType) {
+			return perrors.Errorf("@in type %s does not implement @out type %s", inValue.Type(), outType)
+		}
+		outValue.Elem().Set(inValue)
 		return nil
 	}
 
 	switch inValue.Type().Kind() {
 	case reflect.Slice, reflect.Array:
+		if outType.Kind() != reflect.Slice {
+			return perrors.Errorf("@in type %s can not assign to @out type %s", inValue.Type(), outValue.Type())
+		}
 		return CopySlice(inValue, outValue)
 	case reflect.Map:
+		if outType.Kind() != reflect.Map {
+			return perrors.Errorf("@in type %s can not assign to @out type %s", inValue.Type(), outValue.Type())
+		}
 		return CopyMap(inValue, outValue)
 	default:
+		if !isAssignable(hessian.UnpackPtrType(inValue.Type()), outType) {
+			return perrors.Errorf("@in type %s can not assign to @out type %s", inValue.Type(), outValue.Type())
+		}
 		hessian.SetValue(outValue, inValue)
 	}
 
 	return nil
 }
 
+// isNilValue returns if @v is nil pointer, map, slice or interface
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isAssignable returns if value of @in type can be set to @out type by hessian.SetValue, which also converts
+// between numeric kinds, e.g. int32 to int64
+func isAssignable(in, out reflect.Type) bool {
+	if in.AssignableTo(out) {
+		return true
+	}
+	return isNumericKind(in.Kind()) && isNumericKind(out.Kind())
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
 // CopySlice copy from inSlice to outSlice
 func CopySlice(inSlice, outSlice reflect.Value) error {
 	if inSlice.IsNil() {
@@ -18,11 +18,21 @@
 package tools
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 )
 
 import (
+	"github.com/golang/protobuf/proto"
+
+	perrors "github.com/pkg/errors"
+
 	"github.com/stretchr/testify/assert"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 import (
@@ -46,3 +56,92 @@ func TestGetServiceKeyAndUpperCaseMethodNameFromPath(t *testing.T) {
 	assert.Equal(t, "GetUser", method)
 	assert.Nil(t, err)
 }
+
+func TestContentType(t *testing.T) {
+	assert.Equal(t, "application/grpc+proto", GetContentType(constant.PBCodecName))
+	assert.Equal(t, "application/grpc+hessian2", GetContentType(constant.HessianCodecName))
+
+	assert.Equal(t, "proto", GetContentSubType("application/grpc+proto"))
+	assert.Equal(t, "hessian2", GetContentSubType("application/grpc+hessian2; charset=utf-8"))
+	assert.Equal(t, "", GetContentSubType("application/grpc"))
+	assert.Equal(t, "", GetContentSubType("application/json"))
+}
+
+type testReply struct {
+	Name string
+}
+
+func TestReflectResponse(t *testing.T) {
+	in := &testReply{Name: "triple"}
+
+	// nil reply
+	assert.NotNil(t, ReflectResponse(in, nil))
+	var nilReply *testReply
+	assert.NotNil(t, ReflectResponse(in, nilReply))
+	assert.NotNil(t, ReflectResponse(nilReply, &testReply{}))
+
+	reply := &testReply{}
+	assert.Nil(t, ReflectResponse(in, reply))
+	assert.Equal(t, "triple", reply.Name)
+
+	// pointer-to-pointer reply
+	var ptrReply *testReply
+	assert.Nil(t, ReflectResponse(in, &ptrReply))
+	assert.Equal(t, "triple", ptrReply.Name)
+	var ptrPtrReply **testReply
+	assert.Nil(t, ReflectResponse(in, &ptrPtrReply))
+	assert.Equal(t, "triple", (*ptrPtrReply).Name)
+
+	var anyReply interface{}
+	assert.Nil(t, ReflectResponse(in, &anyReply))
+	assert.Equal(t, in, anyReply)
+
+	// type mismatch
+	assert.NotNil(t, ReflectResponse(in, new(string)))
+	assert.NotNil(t, ReflectResponse([]string{"a"}, &testReply{}))
+	assert.NotNil(t, ReflectResponse(map[string]string{"a": "b"}, new([]string)))
+	var stringer fmt.Stringer
+	assert.NotNil(t, ReflectResponse(in, &stringer))
+
+	number := int64(0)
+	assert.Nil(t, ReflectResponse(int32(1), &number))
+	assert.Equal(t, int64(1), number)
+}
+
+func TestUnmarshalErrorMessage(t *testing.T) {
+	// json error tells offset and field
+	var v struct {
+		A struct {
+			B int `json:"b"`
+		} `json:"a"`
+	}
+	data := []byte(`{"a": {"b": "x"}}`)
+	err := json.Unmarshal(data, &v)
+	assert.NotNil(t, err)
+	msg := UnmarshalErrorMessage("/svc/Method", &v, data, perrors.WithStack(err))
+	assert.Contains(t, msg, "of method /svc/Method error at offset 15 of field a.b:")
+
+	// location of malformed nested proto field is told by scanning wire format
+	anyData := protowire.AppendTag(nil, 1, protowire.BytesType)
+	anyData = protowire.AppendBytes(anyData, []byte{0xff})
+	data = protowire.AppendTag(nil, 1, protowire.VarintType)
+	data = protowire.AppendVarint(data, 3)
+	data = protowire.AppendTag(data, 3, protowire.BytesType)
+	data = protowire.AppendBytes(data, anyData)
+	st := &spb.Status{}
+	err = proto.Unmarshal(data, st)
+	assert.NotNil(t, err)
+	msg = UnmarshalErrorMessage("/svc/Method", st, data, err)
+	assert.Contains(t, msg, "unmarshal *status.Status of method /svc/Method error at offset 4 of field details.type_url:")
+
+	// truncated field
+	data = protowire.AppendTag(nil, 2, protowire.BytesType)
+	data = append(data, 10, 'a')
+	err = proto.Unmarshal(data, st)
+	assert.NotNil(t, err)
+	assert.Contains(t, UnmarshalErrorMessage("/svc/Method", st, data, err), "at offset 0 of field message:")
+
+	// location is unknown
+	msg = UnmarshalErrorMessage("/svc/Method", []interface{}{}, nil, fmt.Errorf("hessian error"))
+	assert.Equal(t, "unmarshal []interface {} of method /svc/Method error: hessian error", msg)
+}
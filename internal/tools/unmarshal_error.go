@@ -0,0 +1,101 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+import (
+	protoV1 "github.com/golang/protobuf/proto"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// UnmarshalErrorMessage describes codec error @err of unmarshaling @data to @msg for @method path, with the byte
+// offset and field path where decoding failed, if they can be told from the underlying codec, e.g. json errors, or
+// from scanning wire format of proto message @msg.
+func UnmarshalErrorMessage(method string, msg interface{}, data []byte, err error) string {
+	offset, field, ok := unmarshalErrorLocation(msg, data, err)
+	location := ""
+	if ok {
+		location = fmt.Sprintf(" at offset %d", offset)
+		if field != "" {
+			location += " of field " + field
+		}
+	}
+	return fmt.Sprintf("unmarshal %T of method %s error%s: %v", msg, method, location, err)
+}
+
+// unmarshalErrorLocation returns offset and field path of @err, ok is false if they are unknown
+func unmarshalErrorLocation(msg interface{}, data []byte, err error) (int64, string, bool) {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return typeErr.Offset, typeErr.Field, true
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return syntaxErr.Offset, "", true
+	}
+	if pbMsg, ok := msg.(protoV1.Message); ok && pbMsg != nil {
+		offset, path, found := protoErrorLocation(protoV1.MessageV2(pbMsg).ProtoReflect().Descriptor(), data, 0)
+		if found {
+			return int64(offset), strings.Join(path, "."), true
+		}
+	}
+	return 0, "", false
+}
+
+// protoErrorLocation scans wire format @data of message @md, which starts at @base of the whole message, and returns
+// the offset and field path of the first malformed field. Mismatched wire types are not errors of protobuf, the
+// field is kept as unknown field, so they are not reported.
+func protoErrorLocation(md protoreflect.MessageDescriptor, data []byte, base int) (int, []string, bool) {
+	for pos := 0; pos < len(data); {
+		num, typ, n := protowire.ConsumeTag(data[pos:])
+		if n < 0 {
+			return base + pos, nil, true
+		}
+		fd := md.Fields().ByNumber(num)
+		var name []string
+		if fd != nil {
+			name = []string{string(fd.Name())}
+		}
+		m := protowire.ConsumeFieldValue(num, typ, data[pos+n:])
+		if m < 0 {
+			return base + pos, name, true
+		}
+		if fd != nil && typ == protowire.BytesType {
+			value, prefix := protowire.ConsumeBytes(data[pos+n:])
+			switch {
+			case fd.Kind() == protoreflect.MessageKind && !fd.IsMap():
+				if offset, path, found := protoErrorLocation(fd.Message(), value, base+pos+n+prefix-len(value)); found {
+					return offset, append(name, path...), true
+				}
+			case fd.Kind() == protoreflect.StringKind && !utf8.Valid(value):
+				return base + pos, name, true
+			}
+		}
+		pos += n + m
+	}
+	return 0, nil, false
+}
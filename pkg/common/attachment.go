@@ -0,0 +1,311 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+/*
+Attachments are sent as http2 header fields, so the values must be valid header field values. Keys ending with "-bin"
+carry binary values, which are kept as raw bytes in attachment map, and base64 encoded in header field by triple,
+just like grpc binary metadata. Other values must be valid UTF-8 strings.
+*/
+
+// Get returns the first value of @key, it returns empty string if there is none
+func (a TripleAttachment) Get(key string) string {
+	value, _ := a.Lookup(key)
+	return value
+}
+
+// Lookup returns the first value of @key, and whether @key exists
+func (a TripleAttachment) Lookup(key string) (string, bool) {
+	values := a.Values(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// Values returns all values of @key in the order they are received
+func (a TripleAttachment) Values(key string) []string {
+	if values, ok := a[strings.ToLower(key)]; ok {
+		return values
+	}
+	// key restored by RestoreAttachmentKeyCase
+	if restored, ok := restoredKeyCases.Load(strings.ToLower(key)); ok {
+		for _, k := range restored.([]string) {
+			if values, ok := a[k]; ok {
+				return values
+			}
+		}
+	}
+	return nil
+}
+
+// Set sets @values of @key, replacing existing values
+func (a TripleAttachment) Set(key string, values ...string) {
+	if len(values) == 0 {
+		return
+	}
+	a[strings.ToLower(key)] = values
+}
+
+// Add appends @values to existing values of @key
+func (a TripleAttachment) Add(key string, values ...string) {
+	key = strings.ToLower(key)
+	a[key] = append(a[key], values...)
+}
+
+// NewTripleAttachment returns TripleAttachment with single value of each key of @values, it eases migration from
+// map[string]string, the type of TripleAttachment before multiple values are supported
+func NewTripleAttachment(values map[string]string) TripleAttachment {
+	attachment := make(TripleAttachment, len(values))
+	for key, value := range values {
+		attachment.Set(key, value)
+	}
+	return attachment
+}
+
+// SingleValues returns the first value of each key, in the form of map[string]string used by TripleAttachment before
+// multiple values are supported. The other values are dropped, use Values to read them.
+func (a TripleAttachment) SingleValues() map[string]string {
+	values := make(map[string]string, len(a))
+	for key, v := range a {
+		if len(v) > 0 {
+			values[key] = v[0]
+		}
+	}
+	return values
+}
+
+var (
+	// restoredKeyCases is lower case key -> the keys with original case restored by RestoreAttachmentKeyCase, so
+	// that Values looks up restored keys without scanning attachment. It only grows with keys of config.
+	restoredKeyCases     sync.Map
+	restoredKeyCasesLock sync.Mutex
+)
+
+// recordRestoredKeyCase records @key with original case of lower case key @lower for Values
+func recordRestoredKeyCase(lower, key string) {
+	if restored, ok := restoredKeyCases.Load(lower); ok && containsString(restored.([]string), key) {
+		return
+	}
+	restoredKeyCasesLock.Lock()
+	defer restoredKeyCasesLock.Unlock()
+	var keys []string
+	if restored, ok := restoredKeyCases.Load(lower); ok {
+		keys = restored.([]string)
+		if containsString(keys, key) {
+			return
+		}
+	}
+	// the slice stored is never modified, as it is read without lock
+	restoredKeyCases.Store(lower, append(keys[:len(keys):len(keys)], key))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RestoreAttachmentKeyCase renames lower case keys of received @attachment to @keys with their original case, e.g.
+// "x-legacy-key" to "X-Legacy-Key", for legacy consumers ranging over the map. Get, Lookup and Values still work
+// with the restored keys in any case, without scanning the map.
+func RestoreAttachmentKeyCase(attachment TripleAttachment, keys []string) {
+	for _, key := range keys {
+		lower := strings.ToLower(key)
+		if values, ok := attachment[lower]; ok && lower != key {
+			recordRestoredKeyCase(lower, key)
+			delete(attachment, lower)
+			attachment[key] = values
+		}
+	}
+}
+
+// binaryAttachmentKey returns lower case @key with suffix "-bin"
+func binaryAttachmentKey(key string) string {
+	key = strings.ToLower(key)
+	if !IsBinaryAttachmentKey(key) {
+		key += constant.BinaryAttachmentSuffix
+	}
+	return key
+}
+
+// IsBinaryAttachmentKey reports whether the value of attachment @key is binary
+func IsBinaryAttachmentKey(key string) bool {
+	return strings.HasSuffix(strings.ToLower(key), constant.BinaryAttachmentSuffix)
+}
+
+// SetBinaryAttachment sets binary @value of @key to outgoing @attachment, e.g. DubboAttachment of client ctx or
+// attachments of OuterResult. "-bin" suffix is appended to @key if it doesn't have it.
+func SetBinaryAttachment(attachment map[string]interface{}, key string, value []byte) {
+	attachment[binaryAttachmentKey(key)] = string(value)
+}
+
+// GetBinaryAttachment gets binary value of @key from incoming @attachment, e.g. TripleAttachment of server ctx or
+// response attachment of client. "-bin" suffix is appended to @key if it doesn't have it.
+func GetBinaryAttachment(attachment TripleAttachment, key string) ([]byte, bool) {
+	value, ok := attachment.Lookup(binaryAttachmentKey(key))
+	if !ok {
+		return nil, false
+	}
+	return []byte(value), true
+}
+
+// EncodeAttachmentValue returns header field value of attachment @key and @value, binary value is base64 encoded.
+// Non-binary value with invalid UTF-8 returns error.
+func EncodeAttachmentValue(key, value string) (string, error) {
+	if IsBinaryAttachmentKey(key) {
+		return base64.RawStdEncoding.EncodeToString([]byte(value)), nil
+	}
+	if !utf8.ValidString(value) {
+		return "", perrors.Errorf("attachment %s has invalid UTF-8 value, binary value should use key with suffix %s",
+			key, constant.BinaryAttachmentSuffix)
+	}
+	return value, nil
+}
+
+// DecodeAttachmentValue returns attachment value from header field @value of @key, binary value is base64 decoded,
+// both padded and unpadded encodings are accepted.
+func DecodeAttachmentValue(key, value string) (string, error) {
+	if !IsBinaryAttachmentKey(key) {
+		return value, nil
+	}
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return "", perrors.Errorf("attachment %s has invalid base64 value: %v", key, err)
+	}
+	return string(b), nil
+}
+
+// DecodeAttachmentValueWithCodecs returns attachment value from header field @value of @key like
+// DecodeAttachmentValue, the value of key with codec in @codecs is base64 decoded to the serialized bytes
+func DecodeAttachmentValueWithCodecs(key, value string, codecs map[string]config.AttachmentValueCodec) (string, error) {
+	if _, ok := codecs[strings.ToLower(key)]; ok && !IsBinaryAttachmentKey(key) {
+		return DecodeAttachmentValue(key+constant.BinaryAttachmentSuffix, value)
+	}
+	return DecodeAttachmentValue(key, value)
+}
+
+// MarshalAttachmentValues returns header field values of outgoing attachment @values of keys with codec in @codecs,
+// the serialized values are base64 encoded. Values of other keys are kept as they are.
+func MarshalAttachmentValues(attachment map[string]interface{}, codecs map[string]config.AttachmentValueCodec) (map[string]interface{}, error) {
+	if len(codecs) == 0 {
+		return attachment, nil
+	}
+	marshaled := make(map[string]interface{}, len(attachment))
+	for k, v := range attachment {
+		codec, ok := codecs[strings.ToLower(k)]
+		if !ok {
+			marshaled[k] = v
+			continue
+		}
+		data, err := codec.Marshal(v)
+		if err != nil {
+			return nil, perrors.Wrapf(err, "marshal attachment %s", k)
+		}
+		if IsBinaryAttachmentKey(k) {
+			// it is base64 encoded as binary value
+			marshaled[k] = string(data)
+		} else {
+			marshaled[k] = base64.RawStdEncoding.EncodeToString(data)
+		}
+	}
+	return marshaled, nil
+}
+
+// UnmarshalAttachment parses the value of @key in incoming @attachment into @value by @codec, which is the codec of
+// the key in Option.AttachmentValueCodecs. It returns false if @key doesn't exist.
+func UnmarshalAttachment(attachment TripleAttachment, key string, codec config.AttachmentValueCodec, value interface{}) (bool, error) {
+	data, ok := attachment.Lookup(key)
+	if !ok {
+		return false, nil
+	}
+	if err := codec.Unmarshal([]byte(data), value); err != nil {
+		return true, perrors.Wrapf(err, "unmarshal attachment %s", key)
+	}
+	return true, nil
+}
+
+// ValidateAttachment checks that all string values of outgoing @attachment can be encoded to header field,
+// a value of []string is sent as multiple values of the key
+func ValidateAttachment(attachment map[string]interface{}) error {
+	for k, v := range attachment {
+		var values []string
+		switch value := v.(type) {
+		case string:
+			values = []string{value}
+		case []string:
+			values = value
+		}
+		for _, str := range values {
+			if _, err := EncodeAttachmentValue(k, str); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SetPageToken sets @token of next page to response @attachment of list rpc, e.g. attachments of OuterResult,
+// it is sent to client in trailer field tri-next-page-token. Empty @token means the last page.
+func SetPageToken(attachment map[string]interface{}, token string) {
+	attachment[constant.TrailerKeyPageToken] = token
+}
+
+// GetPageToken gets token of next page from response @attachment of client, it returns false if server doesn't set it
+func GetPageToken(attachment TripleAttachment) (string, bool) {
+	return attachment.Lookup(constant.TrailerKeyPageToken)
+}
+
+// SetTotalCount sets @total count of items to response @attachment of list rpc, in trailer field tri-total-count
+func SetTotalCount(attachment map[string]interface{}, total int64) {
+	attachment[constant.TrailerKeyTotalCount] = strconv.FormatInt(total, 10)
+}
+
+// GetTotalCount gets total count of items from response @attachment of client, it returns false if server doesn't
+// set it or the value is malformed
+func GetTotalCount(attachment TripleAttachment) (int64, bool) {
+	v, ok := attachment.Lookup(constant.TrailerKeyTotalCount)
+	if !ok {
+		return 0, false
+	}
+	total, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return total, true
+}
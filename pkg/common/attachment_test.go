@@ -0,0 +1,158 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+import (
+	"gotest.tools/assert"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+func TestBinaryAttachmentRoundTrip(t *testing.T) {
+	values := [][]byte{
+		{},
+		{0},
+		{0xff, 0xfe, 0x00, '\n', '\r'},
+		[]byte("plain text"),
+	}
+	for i := 0; i < 100; i++ {
+		value := make([]byte, rand.Intn(1024))
+		_, _ = rand.Read(value)
+		values = append(values, value)
+	}
+	for _, value := range values {
+		outgoing := make(DubboAttachment)
+		SetBinaryAttachment(outgoing, "Token", value)
+		assert.NilError(t, ValidateAttachment(outgoing))
+
+		// outgoing attachment -> header field -> incoming attachment
+		incoming := make(TripleAttachment)
+		for k, v := range outgoing {
+			encoded, err := EncodeAttachmentValue(k, v.(string))
+			assert.NilError(t, err)
+			for _, c := range []byte(encoded) {
+				assert.Assert(t, c >= 0x20 && c < 0x7f, "header field value %q is not printable", encoded)
+			}
+			decoded, err := DecodeAttachmentValue(k, encoded)
+			assert.NilError(t, err)
+			incoming.Add(k, decoded)
+		}
+		got, ok := GetBinaryAttachment(incoming, "token-bin")
+		assert.Assert(t, ok)
+		assert.Assert(t, bytes.Equal(value, got))
+	}
+}
+
+func TestAttachmentValueCheck(t *testing.T) {
+	// padded base64 sent by other implementations is accepted
+	decoded, err := DecodeAttachmentValue("token-bin", "AQI=")
+	assert.NilError(t, err)
+	assert.Equal(t, "\x01\x02", decoded)
+	_, err = DecodeAttachmentValue("token-bin", "!!")
+	assert.ErrorContains(t, err, "invalid base64")
+
+	_, err = EncodeAttachmentValue("token", "\xff\xfe")
+	assert.ErrorContains(t, err, "invalid UTF-8")
+	assert.ErrorContains(t, ValidateAttachment(DubboAttachment{"token": "\xff\xfe"}), "invalid UTF-8")
+	encoded, err := EncodeAttachmentValue("token", "中文")
+	assert.NilError(t, err)
+	assert.Equal(t, "中文", encoded)
+}
+
+func TestTripleAttachmentValues(t *testing.T) {
+	attachment := make(TripleAttachment)
+	assert.Equal(t, "", attachment.Get("tri-tag"))
+	_, ok := attachment.Lookup("tri-tag")
+	assert.Assert(t, !ok)
+
+	attachment.Add("Tri-Tag", "blue")
+	attachment.Add("tri-tag", "green")
+	assert.Equal(t, "blue", attachment.Get("TRI-TAG"))
+	assert.DeepEqual(t, []string{"blue", "green"}, attachment.Values("tri-tag"))
+
+	attachment.Set("tri-tag", "red")
+	assert.DeepEqual(t, []string{"red"}, attachment.Values("tri-tag"))
+	assert.NilError(t, ValidateAttachment(DubboAttachment{"tri-tag": []string{"blue", "green"}}))
+	assert.ErrorContains(t, ValidateAttachment(DubboAttachment{"tri-tag": []string{"blue", "\xff"}}), "invalid UTF-8")
+}
+
+func TestTripleAttachmentSingleValues(t *testing.T) {
+	attachment := NewTripleAttachment(map[string]string{"Tri-Tag": "blue", "tri-region": "east"})
+	assert.DeepEqual(t, TripleAttachment{"tri-tag": {"blue"}, "tri-region": {"east"}}, attachment)
+
+	attachment.Add("tri-tag", "green")
+	attachment["tri-empty"] = nil
+	assert.DeepEqual(t, map[string]string{"tri-tag": "blue", "tri-region": "east"}, attachment.SingleValues())
+}
+
+func TestRestoreAttachmentKeyCase(t *testing.T) {
+	attachment := TripleAttachment{"x-legacy-key": {"blue"}, "x-other-key": {"green"}}
+	RestoreAttachmentKeyCase(attachment, []string{"X-Legacy-Key", "X-Missing-Key"})
+	assert.DeepEqual(t, TripleAttachment{"X-Legacy-Key": {"blue"}, "x-other-key": {"green"}}, attachment)
+	assert.Equal(t, "blue", attachment.Get("x-legacy-key"))
+	assert.DeepEqual(t, []string{"blue"}, attachment.Values("X-LEGACY-KEY"))
+	assert.Assert(t, attachment.Values("x-missing-key") == nil)
+
+	// the same key restored with another case is found as well
+	another := TripleAttachment{"x-legacy-key": {"red"}}
+	RestoreAttachmentKeyCase(another, []string{"x-LEGACY-key"})
+	assert.DeepEqual(t, []string{"red"}, another.Values("X-Legacy-Key"))
+	assert.DeepEqual(t, []string{"blue"}, attachment.Values("x-legacy-key"))
+}
+
+// upperCodec is config.AttachmentValueCodec impl for test, which serializes string value to upper case
+type upperCodec struct{}
+
+func (upperCodec) Marshal(value interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(value.(string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, value interface{}) error {
+	*value.(*string) = strings.ToLower(string(data))
+	return nil
+}
+
+func TestAttachmentValueCodecs(t *testing.T) {
+	codecs := map[string]config.AttachmentValueCodec{"x-upper": upperCodec{}, "x-upper-bin": upperCodec{}}
+	marshaled, err := MarshalAttachmentValues(map[string]interface{}{"X-Upper": "abc", "x-upper-bin": "def", "x-plain": "ghi"}, codecs)
+	assert.NilError(t, err)
+	// binary value is base64 encoded by EncodeAttachmentValue
+	assert.DeepEqual(t, map[string]interface{}{"X-Upper": "QUJD", "x-upper-bin": "DEF", "x-plain": "ghi"}, marshaled)
+
+	attachment := make(TripleAttachment)
+	for k, v := range map[string]string{"x-upper": "QUJD", "x-upper-bin": "REVG", "x-plain": "ghi"} {
+		value, err := DecodeAttachmentValueWithCodecs(k, v, codecs)
+		assert.NilError(t, err)
+		attachment.Add(k, value)
+	}
+	assert.DeepEqual(t, TripleAttachment{"x-upper": {"ABC"}, "x-upper-bin": {"DEF"}, "x-plain": {"ghi"}}, attachment)
+	var value string
+	ok, err := UnmarshalAttachment(attachment, "X-Upper", upperCodec{}, &value)
+	assert.Assert(t, ok)
+	assert.NilError(t, err)
+	assert.Equal(t, "abc", value)
+}
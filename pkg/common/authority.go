@@ -0,0 +1,64 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+import (
+	"github.com/dubbogo/net/http/httpguts"
+
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/constant"
+)
+
+// WithAuthority returns ctx with :authority @authority of a single call, which overrides Option.Authority of client.
+// The conn is still dialed to the address of client.
+func WithAuthority(ctx context.Context, authority string) context.Context {
+	return context.WithValue(ctx, constant.CtxAuthorityKey, authority)
+}
+
+// AuthorityFromContext returns :authority set by WithAuthority in @ctx
+func AuthorityFromContext(ctx context.Context) (string, bool) {
+	authority, ok := ctx.Value(constant.CtxAuthorityKey).(string)
+	return authority, ok
+}
+
+// ValidateAuthority returns error if @authority is not a legal :authority, which is host with optional port,
+// without userinfo
+func ValidateAuthority(authority string) error {
+	if authority == "" {
+		return perrors.New("authority is empty")
+	}
+	if strings.Contains(authority, "@") || !httpguts.ValidHostHeader(authority) {
+		return perrors.Errorf("authority %q is invalid", authority)
+	}
+	// port must be numeric, and IPv6 address must be in brackets
+	u, err := url.Parse("//" + authority)
+	if err != nil || u.Host != authority || u.Hostname() == "" ||
+		(strings.Contains(u.Hostname(), ":") && !strings.HasPrefix(authority, "[")) {
+		return perrors.Errorf("authority %q is invalid", authority)
+	}
+	return nil
+}
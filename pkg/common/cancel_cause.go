@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+// Causes of client rpcs canceled by triple itself, rpc errors unwrap to them, e.g. errors.Is(err, ErrServerGoAway).
+// Rpcs canceled by client ctx unwrap to Cause of the ctx.
+var (
+	// ErrClientClosed is the cause of rpcs canceled because TripleClient is closed
+	ErrClientClosed = perrors.New("triple client is closed")
+	// ErrStreamStopped is the cause of stream rpc stopped by user
+	ErrStreamStopped = perrors.New("stream is stopped by user")
+	// ErrServerGoAway is the cause of rpcs failed because server sent GOAWAY and closed the conn
+	ErrServerGoAway = perrors.New("server sent GOAWAY")
+	// ErrCanceledAll is the cause of rpcs canceled by CancelAll of TripleClient
+	ErrCanceledAll = perrors.New("rpcs are canceled by CancelAll")
+)
+
+// CancelCauseFunc cancels ctx like context.CancelFunc, and records @cause as the reason, nil cause means
+// context.Canceled. Only the first call takes effect.
+type CancelCauseFunc func(cause error)
+
+type cancelCauseKey struct{}
+
+// cancelCause is the cause recorded by CancelCauseFunc of ctx
+type cancelCause struct {
+	ctx    context.Context
+	parent *cancelCause
+
+	lock     sync.Mutex
+	canceled bool
+	cause    error
+}
+
+/*
+WithCancelCause returns ctx canceled by the returned CancelCauseFunc with a cause, which is returned by Cause of the
+ctx and its children, so that rpc errors tell why they are canceled, e.g. by shutdown of application rather than
+deadline. It's context.WithCancelCause of go 1.20, which can't be used by this module of go 1.15.
+*/
+func WithCancelCause(parent context.Context) (context.Context, CancelCauseFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	c := &cancelCause{ctx: ctx}
+	c.parent, _ = parent.Value(cancelCauseKey{}).(*cancelCause)
+	ctx = context.WithValue(ctx, cancelCauseKey{}, c)
+	return ctx, func(cause error) {
+		c.lock.Lock()
+		if !c.canceled && ctx.Err() == nil {
+			c.canceled = true
+			c.cause = cause
+		}
+		c.lock.Unlock()
+		cancel()
+	}
+}
+
+// Cause returns the cause of done @ctx, which is the cause of CancelCauseFunc canceling @ctx or its parent, or
+// ctx.Err() if it is done otherwise, e.g. by deadline. It returns nil if @ctx is not done.
+func Cause(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	c, _ := ctx.Value(cancelCauseKey{}).(*cancelCause)
+	// ctx done by its own deadline has a different error from the ctx of CancelCauseFunc
+	for ; c != nil && c.ctx.Err() == err; c = c.parent {
+		c.lock.Lock()
+		canceled, cause := c.canceled, c.cause
+		c.lock.Unlock()
+		if canceled {
+			if cause == nil {
+				return context.Canceled
+			}
+			return cause
+		}
+	}
+	return err
+}
+
+// WithoutCancel returns ctx keeping values of @parent, but it is never canceled and has no deadline, and Cause of it
+// and its children doesn't return the cause of @parent. It's context.WithoutCancel of go 1.21.
+func WithoutCancel(parent context.Context) context.Context {
+	return withoutCancelCtx{parent}
+}
+
+type withoutCancelCtx struct {
+	context.Context
+}
+
+func (withoutCancelCtx) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (withoutCancelCtx) Done() <-chan struct{} {
+	return nil
+}
+
+func (withoutCancelCtx) Err() error {
+	return nil
+}
+
+// Value returns value of @key of the parent ctx, except its cancel cause, which doesn't apply to the ctx never canceled
+func (c withoutCancelCtx) Value(key interface{}) interface{} {
+	if _, ok := key.(cancelCauseKey); ok {
+		return nil
+	}
+	return c.Context.Value(key)
+}
@@ -0,0 +1,87 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	"gotest.tools/assert"
+)
+
+func TestCause(t *testing.T) {
+	shutdown := perrors.New("shutdown")
+	ctx, cancel := WithCancelCause(context.Background())
+	child, cancelChild := context.WithCancel(ctx)
+	defer cancelChild()
+	assert.NilError(t, Cause(child))
+
+	// the cause of parent is the cause of its children, and only the first cause is kept
+	cancel(shutdown)
+	cancel(context.DeadlineExceeded)
+	assert.Equal(t, shutdown, Cause(ctx))
+	assert.Equal(t, shutdown, Cause(child))
+
+	// the cause of child is kept if it is canceled before parent
+	ctx, cancel = WithCancelCause(context.Background())
+	child, cancelChildCause := WithCancelCause(ctx)
+	cancelChildCause(nil)
+	cancel(shutdown)
+	assert.Equal(t, context.Canceled, Cause(child))
+
+	// ctx done by its own deadline has no cause, unless its parent is canceled before
+	timeout, cancelTimeout := context.WithTimeout(ctx, time.Nanosecond)
+	defer cancelTimeout()
+	<-timeout.Done()
+	assert.Equal(t, shutdown, Cause(timeout))
+	ctx, cancel = WithCancelCause(context.Background())
+	defer cancel(nil)
+	timeout, cancelTimeout = context.WithTimeout(ctx, time.Nanosecond)
+	defer cancelTimeout()
+	<-timeout.Done()
+	assert.Equal(t, context.DeadlineExceeded, Cause(timeout))
+	assert.NilError(t, Cause(context.Background()))
+}
+
+type testValueKey struct{}
+
+func TestWithoutCancel(t *testing.T) {
+	shutdown := perrors.New("shutdown")
+	parent, cancel := WithCancelCause(context.WithValue(context.Background(), testValueKey{}, "value"))
+	cancel(shutdown)
+	ctx := WithoutCancel(parent)
+	assert.NilError(t, ctx.Err())
+	assert.Assert(t, ctx.Done() == nil)
+	_, ok := ctx.Deadline()
+	assert.Assert(t, !ok)
+	assert.Equal(t, "value", ctx.Value(testValueKey{}))
+
+	// the cause of parent doesn't leak to children canceled otherwise
+	child, cancelChild := context.WithTimeout(ctx, time.Nanosecond)
+	defer cancelChild()
+	<-child.Done()
+	assert.Equal(t, context.DeadlineExceeded, Cause(child))
+	child, cancelChild = context.WithCancel(ctx)
+	cancelChild()
+	assert.Equal(t, context.Canceled, Cause(child))
+}
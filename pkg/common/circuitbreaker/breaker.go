@@ -0,0 +1,300 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+const (
+	defaultWindow           = 10 * time.Second
+	defaultBuckets          = 10
+	defaultOpenTimeout      = 5 * time.Second
+	defaultHalfOpenRequests = 1
+	defaultMinRequests      = 20
+	defaultFailureRatio     = 0.5
+)
+
+// State is the state of a circuit
+type State int
+
+const (
+	// StateClosed lets all rpcs pass, and counts their results in rolling window
+	StateClosed State = iota
+	// StateOpen rejects all rpcs, until OpenTimeout passes
+	StateOpen
+	// StateHalfOpen lets at most HalfOpenRequests rpcs pass to probe whether server recovers
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Counts is the statistics of rpcs in rolling window
+type Counts struct {
+	Requests uint32
+	Failures uint32
+}
+
+// Policy reports whether the circuit should trip open with @counts in rolling window, it is checked after each failure
+type Policy func(counts Counts) bool
+
+// FailureRatePolicy returns Policy which trips if there are at least @minRequests rpcs in rolling window, and
+// the ratio of failures reaches @ratio
+func FailureRatePolicy(minRequests uint32, ratio float64) Policy {
+	return func(counts Counts) bool {
+		return counts.Requests >= minRequests && float64(counts.Failures) >= ratio*float64(counts.Requests)
+	}
+}
+
+// Scope decides which rpcs share one circuit
+type Scope int
+
+const (
+	// ScopeMethod keeps a circuit per target and method
+	ScopeMethod Scope = iota
+	// ScopeTarget keeps a circuit per target, shared by all methods
+	ScopeTarget
+)
+
+// Config is the config of Breaker, zero fields are set to default
+type Config struct {
+	Scope Scope
+	// Window is the length of rolling window of counts, default 10s
+	Window time.Duration
+	// Buckets is the number of buckets which rolling window is divided to, default 10
+	Buckets int
+	// Policy decides when to trip open, default FailureRatePolicy(20, 0.5)
+	Policy Policy
+	// OpenTimeout is the duration of open state, after that the circuit is half-open, default 5s
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the max number of probing rpcs in half-open state, the circuit is closed after all of them
+	// succeed, and is open again if any of them fails. Default 1
+	HalfOpenRequests uint32
+	// IsFailure reports whether the rpc result @err is a failure, default common.IsServerFailure, which counts
+	// transport errors and status Unavailable, Internal and Unknown, but not errors of client ctx
+	IsFailure func(err error) bool
+}
+
+// Breaker is in-memory config.CircuitBreaker impl, which keeps a circuit per Scope
+type Breaker struct {
+	conf     Config
+	lock     sync.Mutex
+	circuits map[string]*circuit
+	// now is used to get current time, replaceable for test
+	now func() time.Time
+}
+
+// NewBreaker returns Breaker with @conf
+func NewBreaker(conf Config) *Breaker {
+	if conf.Window <= 0 {
+		conf.Window = defaultWindow
+	}
+	if conf.Buckets <= 0 {
+		conf.Buckets = defaultBuckets
+	}
+	if conf.Policy == nil {
+		conf.Policy = FailureRatePolicy(defaultMinRequests, defaultFailureRatio)
+	}
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = defaultOpenTimeout
+	}
+	if conf.HalfOpenRequests == 0 {
+		conf.HalfOpenRequests = defaultHalfOpenRequests
+	}
+	if conf.IsFailure == nil {
+		conf.IsFailure = common.IsServerFailure
+	}
+	return &Breaker{
+		conf:     conf,
+		circuits: make(map[string]*circuit),
+		now:      time.Now,
+	}
+}
+
+var _ config.CircuitBreaker = &Breaker{}
+
+// Allow reports whether the rpc of @method to @target can be sent by the state of its circuit
+func (b *Breaker) Allow(target, method string) (func(err error), bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c := b.getCircuit(target, method)
+	generation, ok := c.allow(b.now())
+	if !ok {
+		return nil, false
+	}
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			failure := b.conf.IsFailure(err)
+			b.lock.Lock()
+			defer b.lock.Unlock()
+			c.onResult(generation, failure, b.now())
+		})
+	}, true
+}
+
+// State returns current state of the circuit of @method to @target
+func (b *Breaker) State(target, method string) State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.getCircuit(target, method).currentState(b.now())
+}
+
+func (b *Breaker) getCircuit(target, method string) *circuit {
+	key := target
+	if b.conf.Scope == ScopeMethod {
+		key += "#" + method
+	}
+	c, ok := b.circuits[key]
+	if !ok {
+		c = newCircuit(&b.conf, b.now())
+		b.circuits[key] = c
+	}
+	return c
+}
+
+// circuit is not concurrent safe, it's protected by Breaker.lock
+type circuit struct {
+	conf  *Config
+	state State
+	// generation is increased when state changes, results of rpcs allowed in previous generation are ignored
+	generation uint64
+	openedAt   time.Time
+
+	// buckets of rolling window in closed state, current is the index of bucket starting at bucketStart
+	buckets     []Counts
+	current     int
+	bucketStart time.Time
+
+	halfOpenInFlight uint32
+	halfOpenSuccess  uint32
+}
+
+func newCircuit(conf *Config, now time.Time) *circuit {
+	return &circuit{
+		conf:        conf,
+		buckets:     make([]Counts, conf.Buckets),
+		bucketStart: now,
+	}
+}
+
+// currentState returns state at @now, open circuit becomes half-open after OpenTimeout
+func (c *circuit) currentState(now time.Time) State {
+	if c.state == StateOpen && now.Sub(c.openedAt) >= c.conf.OpenTimeout {
+		c.setState(StateHalfOpen, now)
+	}
+	return c.state
+}
+
+func (c *circuit) allow(now time.Time) (uint64, bool) {
+	switch c.currentState(now) {
+	case StateOpen:
+		return 0, false
+	case StateHalfOpen:
+		if c.halfOpenInFlight >= c.conf.HalfOpenRequests {
+			return 0, false
+		}
+		c.halfOpenInFlight++
+	}
+	return c.generation, true
+}
+
+func (c *circuit) onResult(generation uint64, failure bool, now time.Time) {
+	if generation != c.generation {
+		return
+	}
+	switch c.currentState(now) {
+	case StateClosed:
+		c.roll(now)
+		c.buckets[c.current].Requests++
+		if !failure {
+			return
+		}
+		c.buckets[c.current].Failures++
+		if c.conf.Policy(c.counts()) {
+			c.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		if failure {
+			c.setState(StateOpen, now)
+			return
+		}
+		c.halfOpenSuccess++
+		if c.halfOpenSuccess >= c.conf.HalfOpenRequests {
+			c.setState(StateClosed, now)
+		}
+	}
+}
+
+func (c *circuit) setState(state State, now time.Time) {
+	c.state = state
+	c.generation++
+	c.halfOpenInFlight = 0
+	c.halfOpenSuccess = 0
+	switch state {
+	case StateOpen:
+		c.openedAt = now
+	case StateClosed:
+		for i := range c.buckets {
+			c.buckets[i] = Counts{}
+		}
+		c.current = 0
+		c.bucketStart = now
+	}
+}
+
+// roll moves rolling window to @now, buckets out of window are cleared
+func (c *circuit) roll(now time.Time) {
+	bucketDuration := c.conf.Window / time.Duration(len(c.buckets))
+	passed := now.Sub(c.bucketStart) / bucketDuration
+	if passed <= 0 {
+		return
+	}
+	c.bucketStart = c.bucketStart.Add(passed * bucketDuration)
+	for i := 0; i < int(passed) && i < len(c.buckets); i++ {
+		c.current = (c.current + 1) % len(c.buckets)
+		c.buckets[c.current] = Counts{}
+	}
+}
+
+// counts returns sum of buckets in rolling window
+func (c *circuit) counts() Counts {
+	var counts Counts
+	for _, bucket := range c.buckets {
+		counts.Requests += bucket.Requests
+		counts.Failures += bucket.Failures
+	}
+	return counts
+}
@@ -0,0 +1,166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"google.golang.org/grpc/codes"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common"
+)
+
+const (
+	testTarget = "127.0.0.1:20000"
+	testMethod = "/com.apache.dubbo.sample.basic.IGreeter/SayHello"
+)
+
+var errTest = errors.New("test error")
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestBreaker(conf Config) (*Breaker, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	breaker := NewBreaker(conf)
+	breaker.now = clock.Now
+	return breaker, clock
+}
+
+// call sends an rpc with result @err through @breaker, it returns false if the rpc is rejected
+func call(breaker *Breaker, method string, err error) bool {
+	done, ok := breaker.Allow(testTarget, method)
+	if ok {
+		done(err)
+	}
+	return ok
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	breaker, clock := newTestBreaker(Config{
+		Policy:           FailureRatePolicy(4, 0.5),
+		OpenTimeout:      time.Second,
+		HalfOpenRequests: 2,
+	})
+
+	// closed, failure rate doesn't reach threshold
+	assert.True(t, call(breaker, testMethod, nil))
+	assert.True(t, call(breaker, testMethod, nil))
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.Equal(t, StateClosed, breaker.State(testTarget, testMethod))
+
+	// closed -> open
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.Equal(t, StateOpen, breaker.State(testTarget, testMethod))
+	assert.False(t, call(breaker, testMethod, nil))
+
+	// open -> half-open after OpenTimeout, only HalfOpenRequests probing rpcs are allowed
+	clock.now = clock.now.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, breaker.State(testTarget, testMethod))
+	done1, ok := breaker.Allow(testTarget, testMethod)
+	assert.True(t, ok)
+	done2, ok := breaker.Allow(testTarget, testMethod)
+	assert.True(t, ok)
+	_, ok = breaker.Allow(testTarget, testMethod)
+	assert.False(t, ok)
+
+	// half-open -> open if any probing rpc fails
+	done1(nil)
+	done2(errTest)
+	assert.Equal(t, StateOpen, breaker.State(testTarget, testMethod))
+
+	// half-open -> closed if all probing rpcs succeed
+	clock.now = clock.now.Add(time.Second)
+	assert.True(t, call(breaker, testMethod, nil))
+	assert.Equal(t, StateHalfOpen, breaker.State(testTarget, testMethod))
+	assert.True(t, call(breaker, testMethod, nil))
+	assert.Equal(t, StateClosed, breaker.State(testTarget, testMethod))
+
+	// counts are reset after closed
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.Equal(t, StateClosed, breaker.State(testTarget, testMethod))
+}
+
+func TestBreakerRollingWindow(t *testing.T) {
+	breaker, clock := newTestBreaker(Config{
+		Window:  time.Second,
+		Buckets: 10,
+		Policy:  FailureRatePolicy(3, 1),
+	})
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.True(t, call(breaker, testMethod, errTest))
+	// failures before are out of window
+	clock.now = clock.now.Add(time.Second)
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.Equal(t, StateClosed, breaker.State(testTarget, testMethod))
+
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.Equal(t, StateOpen, breaker.State(testTarget, testMethod))
+}
+
+func TestBreakerScopeAndPolicy(t *testing.T) {
+	// custom policy trips at the first failure
+	tripAtFirstFailure := func(counts Counts) bool {
+		return counts.Failures > 0
+	}
+	otherMethod := "/com.apache.dubbo.sample.basic.IGreeter/SayGoodbye"
+
+	breaker, _ := newTestBreaker(Config{Policy: tripAtFirstFailure})
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.Equal(t, StateOpen, breaker.State(testTarget, testMethod))
+	assert.Equal(t, StateClosed, breaker.State(testTarget, otherMethod))
+
+	breaker, _ = newTestBreaker(Config{Policy: tripAtFirstFailure, Scope: ScopeTarget})
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.Equal(t, StateOpen, breaker.State(testTarget, otherMethod))
+
+	// errors not counted as failure
+	breaker, _ = newTestBreaker(Config{
+		Policy: tripAtFirstFailure,
+		IsFailure: func(err error) bool {
+			return err != errTest
+		},
+	})
+	assert.True(t, call(breaker, testMethod, errTest))
+	assert.Equal(t, StateClosed, breaker.State(testTarget, testMethod))
+
+	// errors of client ctx and status of business errors are not failures by default
+	breaker, _ = newTestBreaker(Config{Policy: tripAtFirstFailure})
+	assert.True(t, call(breaker, testMethod, context.Canceled))
+	assert.True(t, call(breaker, testMethod, common.NewTripleError("not found", int(codes.NotFound), "", nil)))
+	assert.Equal(t, StateClosed, breaker.State(testTarget, testMethod))
+	assert.True(t, call(breaker, testMethod, common.NewTripleError("unavailable", int(codes.Unavailable), "", nil)))
+	assert.Equal(t, StateOpen, breaker.State(testTarget, testMethod))
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -21,6 +22,29 @@ type Codec interface {
 	Unmarshal(data []byte, v interface{}) error
 }
 
+// ContextCodec is optional interface of Codec, which receives ctx of rpc, so that expensive codec can check
+// the remaining deadline of ctx and bail early. If Codec implements it, triple calls it instead of Marshal/Unmarshal.
+type ContextCodec interface {
+	MarshalContext(ctx context.Context, v interface{}) ([]byte, error)
+	UnmarshalContext(ctx context.Context, data []byte, v interface{}) error
+}
+
+// MarshalContext marshals @v with @codec, @ctx is passed to it if it implements ContextCodec
+func MarshalContext(ctx context.Context, codec Codec, v interface{}) ([]byte, error) {
+	if c, ok := codec.(ContextCodec); ok {
+		return c.MarshalContext(ctx, v)
+	}
+	return codec.Marshal(v)
+}
+
+// UnmarshalContext unmarshals @data to @v with @codec, @ctx is passed to it if it implements ContextCodec
+func UnmarshalContext(ctx context.Context, codec Codec, data []byte, v interface{}) error {
+	if c, ok := codec.(ContextCodec); ok {
+		return c.UnmarshalContext(ctx, data, v)
+	}
+	return codec.Unmarshal(data, v)
+}
+
 // CodecFactory is Codec Factory
 type CodecFactory func() Codec
 
@@ -42,6 +66,23 @@ func SetTripleCodec(codecType constant.CodecType, f CodecFactory, opt ...*config
 	codecInWrapperSerializerTypeMap[string(codecType)] = opt[0].SerializerTypeInWrapper
 }
 
+// RegisterCodec register CodecFactory @f and CodecType @codecType like SetTripleCodec,
+// but it returns error if @codecType is already registered, instead of overwriting it
+func RegisterCodec(codecType constant.CodecType, f CodecFactory, opt ...*config.Option) error {
+	if _, ok := codecFactoryMap[string(codecType)]; ok {
+		return perrors.Errorf("Codec %s factory is already registered", codecType)
+	}
+	SetTripleCodec(codecType, f, opt...)
+	return nil
+}
+
+// MustRegisterCodec is RegisterCodec that panics on conflict, it is used in init
+func MustRegisterCodec(codecType constant.CodecType, f CodecFactory, opt ...*config.Option) {
+	if err := RegisterCodec(codecType, f, opt...); err != nil {
+		panic(err)
+	}
+}
+
 // GetTripleCodec get Codec impl by @codecName
 func GetTripleCodec(codecName constant.CodecType) (Codec, error) {
 	if f, ok := codecFactoryMap[string(codecName)]; ok {
@@ -67,6 +108,48 @@ type TwoWayCodec interface {
 	UnmarshalResponse(data []byte, v interface{}) error
 }
 
+// ContextTwoWayCodec is optional interface of TwoWayCodec, which receives ctx of unary rpc, see ContextCodec
+type ContextTwoWayCodec interface {
+	MarshalRequestContext(ctx context.Context, v interface{}) ([]byte, error)
+	MarshalResponseContext(ctx context.Context, v interface{}) ([]byte, error)
+	UnmarshalRequestContext(ctx context.Context, data []byte, v interface{}) error
+	UnmarshalResponseContext(ctx context.Context, data []byte, v interface{}) error
+}
+
+// MarshalRequestContext marshals request @v with @codec, @ctx is passed to it if it implements ContextTwoWayCodec
+func MarshalRequestContext(ctx context.Context, codec TwoWayCodec, v interface{}) ([]byte, error) {
+	if c, ok := codec.(ContextTwoWayCodec); ok {
+		return c.MarshalRequestContext(ctx, v)
+	}
+	return codec.MarshalRequest(v)
+}
+
+// MarshalResponseContext marshals response @v with @codec, @ctx is passed to it if it implements ContextTwoWayCodec
+func MarshalResponseContext(ctx context.Context, codec TwoWayCodec, v interface{}) ([]byte, error) {
+	if c, ok := codec.(ContextTwoWayCodec); ok {
+		return c.MarshalResponseContext(ctx, v)
+	}
+	return codec.MarshalResponse(v)
+}
+
+// UnmarshalRequestContext unmarshals request @data to @v with @codec, @ctx is passed to it if it implements
+// ContextTwoWayCodec
+func UnmarshalRequestContext(ctx context.Context, codec TwoWayCodec, data []byte, v interface{}) error {
+	if c, ok := codec.(ContextTwoWayCodec); ok {
+		return c.UnmarshalRequestContext(ctx, data, v)
+	}
+	return codec.UnmarshalRequest(data, v)
+}
+
+// UnmarshalResponseContext unmarshals response @data to @v with @codec, @ctx is passed to it if it implements
+// ContextTwoWayCodec
+func UnmarshalResponseContext(ctx context.Context, codec TwoWayCodec, data []byte, v interface{}) error {
+	if c, ok := codec.(ContextTwoWayCodec); ok {
+		return c.UnmarshalResponseContext(ctx, data, v)
+	}
+	return codec.UnmarshalResponse(data, v)
+}
+
 type GenericCodec interface {
 	UnmarshalRequest(data []byte) ([]interface{}, error)
 }
@@ -0,0 +1,70 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"fmt"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/constant"
+)
+
+// Compressor compresses and decompresses each message of rpc, it is chosen by grpc-encoding header field
+type Compressor interface {
+	// Name returns the grpc-encoding name of compressor, e.g. "gzip"
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// CompressorFactory creates Compressor with compression @level, the meaning of level is defined by compressor impl
+type CompressorFactory func(level int) (Compressor, error)
+
+// compressorFactoryMap stores grpc-encoding name -> CompressorFactory
+var compressorFactoryMap = make(map[string]CompressorFactory)
+
+// SetCompressor register CompressorFactory @f with grpc-encoding @name
+func SetCompressor(name string, f CompressorFactory) {
+	compressorFactoryMap[name] = f
+}
+
+// GetCompressor get Compressor impl by grpc-encoding @name, with compression @level
+func GetCompressor(name string, level int) (Compressor, error) {
+	if f, ok := compressorFactoryMap[name]; ok {
+		return f(level)
+	}
+	return nil, perrors.New(fmt.Sprintf("Compressor %s factory undefined!", name))
+}
+
+// WithCompression returns ctx with compressor @name of a single call, e.g. "gzip" or "identity", which overrides
+// Option.CompressorType of client. Request messages of the call are compressed by it, and grpc-encoding is set to it.
+func WithCompression(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, constant.CtxCompressionKey, name)
+}
+
+// CompressionFromContext returns compressor name set by WithCompression in @ctx
+func CompressionFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(constant.CtxCompressionKey).(string)
+	return name, ok
+}
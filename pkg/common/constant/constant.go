@@ -30,6 +30,15 @@ const (
 
 	// DefaultListeningAddress is default listening address
 	DefaultListeningAddress = "127.0.0.1:20001"
+
+	// DefaultUnaryChunkSize is max size of each chunk, when server sends unary response from io.Reader
+	DefaultUnaryChunkSize = 64 * 1024
+
+	// DefaultShadowMaxInFlight is default max number of shadow rpcs in flight, see config.Option.ShadowMaxInFlight
+	DefaultShadowMaxInFlight = 100
+
+	// DefaultResponseMarshalErrorCode is default status code of response which server fails to marshal, codes.Internal
+	DefaultResponseMarshalErrorCode = 13
 )
 
 // CodecType is the type of triple serializer
@@ -49,13 +58,57 @@ const (
 	JSONMapStructCodec = CodecType("jsonMapStruct")
 )
 
+// load balance policy of client with Resolver
+const (
+	// RandomLoadBalancePolicy picks endpoint randomly by weight, it is the default policy
+	RandomLoadBalancePolicy = "random"
+
+	// RoundRobinLoadBalancePolicy picks endpoints in turn, weights are ignored
+	RoundRobinLoadBalancePolicy = "round_robin"
+
+	// WeightedRoundRobinLoadBalancePolicy picks endpoints by smooth weighted round-robin, it is plain round-robin if
+	// weights are not provided
+	WeightedRoundRobinLoadBalancePolicy = "weighted_round_robin"
+
+	// ConsistentHashLoadBalancePolicy picks endpoint by consistent hash of the key returned by ConsistentHashKey of
+	// option, so that rpcs with the same key are sent to the same endpoint
+	ConsistentHashLoadBalancePolicy = "consistent_hash"
+)
+
+// compression
+const (
+	// IdentityCompressorName means no compression
+	IdentityCompressorName = "identity"
+
+	// GzipCompressorName is the grpc-encoding name of gzip compressor
+	GzipCompressorName = "gzip"
+
+	// DefaultCompressionLevel is default compression level of compressor, the same as gzip.DefaultCompression
+	DefaultCompressionLevel = -1
+
+	// MaxDecompressedMessageSize is max size of a message after it is decompressed, decompressing a larger one fails
+	// with ResourceExhausted, so that a small compressed message can't exhaust memory of receiver
+	MaxDecompressedMessageSize = 64 * 1024 * 1024
+)
+
 // TripleCtxKey is typ of content key
 type TripleCtxKey string
 
 const (
 	InterfaceKey     = TripleCtxKey("interface")
 	CtxAttachmentKey = TripleCtxKey("attachment")
-	TrailerKey       = "Trailer"
+	// CtxCompressionKey is the ctx key of compressor name of a single call, see common.WithCompression
+	CtxCompressionKey = TripleCtxKey("compression")
+	// CtxAuthorityKey is the ctx key of :authority of a single call, see common.WithAuthority
+	CtxAuthorityKey = TripleCtxKey("authority")
+	// CtxIdempotencyKey is the ctx key of idempotency key of a single call, see common.WithIdempotencyKey
+	CtxIdempotencyKey = TripleCtxKey("idempotency-key")
+	// CtxTrailerKey is the ctx key of trailing attachments set by server handler, see common.SetTrailer
+	CtxTrailerKey = TripleCtxKey("trailer")
+	TrailerKey    = "Trailer"
+
+	// BinaryAttachmentSuffix is the suffix of attachment key with binary value, which is base64 encoded in header
+	BinaryAttachmentSuffix = "-bin"
 )
 
 // triple Header
@@ -72,6 +125,15 @@ const (
 	// TrailerKeyGrpcDetailsBin is a trailer header field to response grpc details bin message encoded by base64
 	TrailerKeyGrpcDetailsBin = "grpc-status-details-bin"
 
+	// TrailerKeyGrpcRetryPushbackMs is a trailer header field to tell client the delay in milliseconds before retry
+	TrailerKeyGrpcRetryPushbackMs = "grpc-retry-pushback-ms"
+
+	// TrailerKeyPageToken is a trailer header field of list rpc to tell client the token of next page
+	TrailerKeyPageToken = "tri-next-page-token"
+
+	// TrailerKeyTotalCount is a trailer header field of list rpc to tell client the total count of items
+	TrailerKeyTotalCount = "tri-total-count"
+
 	// TrailerKeyTraceProtoBin is triple trailer header
 	TrailerKeyTraceProtoBin = "trace-proto-bin"
 
@@ -94,6 +156,34 @@ const (
 	TripleTraceRPCID     = "tri-trace-rpcid"
 	TripleTraceProtoBin  = "tri-trace-proto-bin"
 	TripleUnitInfo       = "tri-unit-info"
+	// TripleIdempotencyKey is header field of idempotency key of call, which is the same in all its attempts
+	TripleIdempotencyKey = "tri-idempotency-key"
+
+	// GrpcEncoding is header field of compressor name of messages
+	GrpcEncoding = "grpc-encoding"
+	// GrpcAcceptEncoding is header field of compressor names supported by client
+	GrpcAcceptEncoding = "grpc-accept-encoding"
+
+	// GrpcTimeout is header field of grpc deadline, e.g. "100m" means 100 milliseconds
+	GrpcTimeout = "grpc-timeout"
+	// LegacyTimeout is dubbo timeout attachment in milliseconds
+	LegacyTimeout = "timeout"
+)
+
+// content-type
+const (
+	// TripleContentTypePrefix is prefix of content-type, followed by "+" and codec sub-type
+	TripleContentTypePrefix = "application/grpc"
+
+	// PBContentSubType is content-type sub-type of protobuf codec
+	PBContentSubType = "proto"
+)
+
+// http2 frame
+const (
+	// MinMaxFrameSize and MaxMaxFrameSize are the allowed range of SETTINGS_MAX_FRAME_SIZE, see RFC 7540 section 6.5.2
+	MinMaxFrameSize = 1 << 14
+	MaxMaxFrameSize = 1<<24 - 1
 )
 
 // gr pool
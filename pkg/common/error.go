@@ -1,13 +1,30 @@
 package common
 
+import (
+	"context"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
 type TripleError struct {
 	msg         string
 	stacksTrace string
-	attachment  map[string]string
+	attachment  TripleAttachment
 	code        int
+	// cause is the reason of rpc canceled at client side, e.g. ErrServerGoAway
+	cause error
+	// status is decoded from grpc-status-details-bin trailer, it carries the details of error sent by server
+	status *spb.Status
 }
 
-func NewTripleError(msg string, code int, stacksTrace string, attachment map[string]string) *TripleError {
+func NewTripleError(msg string, code int, stacksTrace string, attachment TripleAttachment) *TripleError {
 	return &TripleError{
 		msg:         msg,
 		code:        code,
@@ -16,6 +33,37 @@ func NewTripleError(msg string, code int, stacksTrace string, attachment map[str
 	}
 }
 
+// NewTripleErrorWithCause returns TripleError which unwraps to @cause, so that errors.Is tells why rpc is canceled
+func NewTripleErrorWithCause(msg string, code int, cause error, attachment TripleAttachment) *TripleError {
+	err := NewTripleError(msg, code, "", attachment)
+	err.cause = cause
+	return err
+}
+
+// NewTripleErrorWithStatus returns TripleError with code, msg and details of status proto @st, which is decoded from
+// grpc-status-details-bin trailer sent by server
+func NewTripleErrorWithStatus(st *spb.Status, stacksTrace string, attachment TripleAttachment) *TripleError {
+	err := NewTripleError(st.GetMessage(), int(st.GetCode()), stacksTrace, attachment)
+	err.status = st
+	return err
+}
+
+// NewHandlerError returns error with grpc status @code, @msg and @attachments in one shot, which can be returned by
+// handler such as InvokeWithArgs, then server sends both the status and @attachments in trailer, and client gets them
+// by ErrorWithAttachment. Values of @attachments are string or []string, like attachments of OuterResult.
+func NewHandlerError(code int, msg string, attachments DubboAttachment) *TripleError {
+	attachment := make(TripleAttachment, len(attachments))
+	for k, v := range attachments {
+		switch value := v.(type) {
+		case string:
+			attachment.Set(k, value)
+		case []string:
+			attachment.Set(k, value...)
+		}
+	}
+	return NewTripleError(msg, code, "", attachment)
+}
+
 func (e *TripleError) Error() string {
 	return e.msg
 }
@@ -24,10 +72,45 @@ func (e *TripleError) StacksTrace() string {
 	return e.stacksTrace
 }
 
-func (e *TripleError) Attachment() map[string]string {
+func (e *TripleError) Attachment() TripleAttachment {
 	return e.attachment
 }
 
 func (e *TripleError) Code() int {
 	return e.code
 }
+
+// Unwrap returns the cause of error, it is nil for errors of server
+func (e *TripleError) Unwrap() error {
+	return e.cause
+}
+
+// GRPCStatus returns grpc status of error, so that status.FromError of grpc gets the code, msg and details sent
+// by server, e.g. the details of error returned by handler which implements GRPCStatus
+func (e *TripleError) GRPCStatus() *grpcstatus.Status {
+	if e.status != nil {
+		return grpcstatus.FromProto(e.status)
+	}
+	return grpcstatus.New(grpccodes.Code(e.code), e.msg)
+}
+
+// IsServerFailure reports whether the rpc result @err is a failure of server, which counts transport errors and status
+// Unavailable, Internal and Unknown, but not errors of client ctx. It is the default failure predicate of both circuit
+// breaker and outlier detection.
+func IsServerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if cause := perrors.Cause(err); cause == context.Canceled || cause == context.DeadlineExceeded {
+		return false
+	}
+	tripleErr, ok := err.(*TripleError)
+	if !ok {
+		return true
+	}
+	switch grpccodes.Code(tripleErr.Code()) {
+	case grpccodes.Unavailable, grpccodes.Internal, grpccodes.Unknown:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/constant"
+)
+
+// WithIdempotencyKey returns ctx with idempotency key @key of a single call, which overrides
+// Option.IdempotencyKeyGenerator of client. @key is sent as header field tri-idempotency-key in all attempts of the
+// call, it must be a valid header field value.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, constant.CtxIdempotencyKey, key)
+}
+
+// IdempotencyKeyFromContext returns idempotency key set by WithIdempotencyKey in @ctx
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(constant.CtxIdempotencyKey).(string)
+	return key, ok
+}
+
+// NewIdempotencyKey is config.IdempotencyKeyGenerator which generates 128 random bits in hex
+func NewIdempotencyKey(context.Context, string) string {
+	var key [16]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(key[:])
+}
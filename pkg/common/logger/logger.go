@@ -17,6 +17,11 @@
 
 package logger
 
+import (
+	"fmt"
+	"sync/atomic"
+)
+
 // Logger is the interface for Logger types
 type Logger interface {
 	Info(args ...interface{})
@@ -30,9 +35,57 @@ type Logger interface {
 	Debugf(fmt string, args ...interface{})
 }
 
-// LoggerWrapper is used to wrap raw logger to match AddCallerSkip(1) of logger layer skip
+// NopLogger discards all logs, it is the logger of triple if no logger is set
+type NopLogger struct{}
+
+func (NopLogger) Info(args ...interface{})               {}
+func (NopLogger) Warn(args ...interface{})               {}
+func (NopLogger) Error(args ...interface{})              {}
+func (NopLogger) Debug(args ...interface{})              {}
+func (NopLogger) Infof(fmt string, args ...interface{})  {}
+func (NopLogger) Warnf(fmt string, args ...interface{})  {}
+func (NopLogger) Errorf(fmt string, args ...interface{}) {}
+func (NopLogger) Debugf(fmt string, args ...interface{}) {}
+
+// Level is the level of logs, logs below the level of LoggerWrapper are discarded
+type Level int32
+
+const (
+	// DebugLevel enables logs of all levels, it is the default level
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Level(%d)", int32(l))
+}
+
+// DebugEnabled reports whether debug logs of @logger are not discarded, it is used to avoid building costly args of
+// Debugf, e.g. string of payload. It is always true if @logger has no level.
+func DebugEnabled(logger Logger) bool {
+	if l, ok := logger.(interface{ DebugEnabled() bool }); ok {
+		return l.DebugEnabled()
+	}
+	return true
+}
+
+// LoggerWrapper is used to wrap raw logger to match AddCallerSkip(1) of logger layer skip, logs below its level are
+// discarded. Its level can be changed at runtime by SetLevel, which is goroutine safe.
 type LoggerWrapper struct {
 	logger Logger
+	level  int32
 }
 
 // NewLoggerWrapper
@@ -42,28 +95,63 @@ func NewLoggerWrapper(logger Logger) *LoggerWrapper {
 	}
 }
 
+// SetLevel makes logs below @level discarded from now on
+func (w *LoggerWrapper) SetLevel(level Level) {
+	atomic.StoreInt32(&w.level, int32(level))
+}
+
+// Level returns the current level of @w
+func (w *LoggerWrapper) Level() Level {
+	return Level(atomic.LoadInt32(&w.level))
+}
+
+// DebugEnabled reports whether debug logs are written by current level
+func (w *LoggerWrapper) DebugEnabled() bool {
+	return w.enabled(DebugLevel)
+}
+
+func (w *LoggerWrapper) enabled(level Level) bool {
+	return level >= w.Level()
+}
+
 func (w *LoggerWrapper) Info(args ...interface{}) {
-	w.logger.Info(args...)
+	if w.enabled(InfoLevel) {
+		w.logger.Info(args...)
+	}
 }
 func (w *LoggerWrapper) Warn(args ...interface{}) {
-	w.logger.Warn(args...)
+	if w.enabled(WarnLevel) {
+		w.logger.Warn(args...)
+	}
 }
 func (w *LoggerWrapper) Error(args ...interface{}) {
-	w.logger.Error(args...)
+	if w.enabled(ErrorLevel) {
+		w.logger.Error(args...)
+	}
 }
 func (w *LoggerWrapper) Debug(args ...interface{}) {
-	w.logger.Debug(args...)
+	if w.enabled(DebugLevel) {
+		w.logger.Debug(args...)
+	}
 }
 
 func (w *LoggerWrapper) Infof(fmt string, args ...interface{}) {
-	w.logger.Infof(fmt, args...)
+	if w.enabled(InfoLevel) {
+		w.logger.Infof(fmt, args...)
+	}
 }
 func (w *LoggerWrapper) Warnf(fmt string, args ...interface{}) {
-	w.logger.Warnf(fmt, args...)
+	if w.enabled(WarnLevel) {
+		w.logger.Warnf(fmt, args...)
+	}
 }
 func (w *LoggerWrapper) Errorf(fmt string, args ...interface{}) {
-	w.logger.Errorf(fmt, args...)
+	if w.enabled(ErrorLevel) {
+		w.logger.Errorf(fmt, args...)
+	}
 }
 func (w *LoggerWrapper) Debugf(fmt string, args ...interface{}) {
-	w.logger.Debugf(fmt, args...)
+	if w.enabled(DebugLevel) {
+		w.logger.Debugf(fmt, args...)
+	}
 }
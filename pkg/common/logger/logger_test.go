@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+import (
+	"gotest.tools/assert"
+)
+
+// recordLogger records logs of all levels, each of them is prefixed by its level
+type recordLogger struct {
+	lock sync.Mutex
+	logs []string
+}
+
+func (l *recordLogger) record(level Level, msg string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.logs = append(l.logs, level.String()+" "+msg)
+}
+
+func (l *recordLogger) Info(args ...interface{})  { l.record(InfoLevel, fmt.Sprint(args...)) }
+func (l *recordLogger) Warn(args ...interface{})  { l.record(WarnLevel, fmt.Sprint(args...)) }
+func (l *recordLogger) Error(args ...interface{}) { l.record(ErrorLevel, fmt.Sprint(args...)) }
+func (l *recordLogger) Debug(args ...interface{}) { l.record(DebugLevel, fmt.Sprint(args...)) }
+func (l *recordLogger) Infof(format string, args ...interface{}) {
+	l.record(InfoLevel, fmt.Sprintf(format, args...))
+}
+func (l *recordLogger) Warnf(format string, args ...interface{}) {
+	l.record(WarnLevel, fmt.Sprintf(format, args...))
+}
+func (l *recordLogger) Errorf(format string, args ...interface{}) {
+	l.record(ErrorLevel, fmt.Sprintf(format, args...))
+}
+func (l *recordLogger) Debugf(format string, args ...interface{}) {
+	l.record(DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func TestLoggerWrapperSetLevel(t *testing.T) {
+	raw := &recordLogger{}
+	wrapper := NewLoggerWrapper(raw)
+	logAll := func(i int) {
+		wrapper.Debugf("%d", i)
+		wrapper.Info(i)
+		wrapper.Warnf("%d", i)
+		wrapper.Error(i)
+	}
+
+	assert.Equal(t, DebugLevel, wrapper.Level())
+	assert.Assert(t, DebugEnabled(wrapper))
+	logAll(0)
+	wrapper.SetLevel(WarnLevel)
+	assert.Assert(t, !DebugEnabled(wrapper))
+	logAll(1)
+	wrapper.SetLevel(DebugLevel)
+	logAll(2)
+	assert.DeepEqual(t, []string{
+		"debug 0", "info 0", "warn 0", "error 0",
+		"warn 1", "error 1",
+		"debug 2", "info 2", "warn 2", "error 2",
+	}, raw.logs)
+
+	// logger without level writes debug logs
+	assert.Assert(t, DebugEnabled(raw))
+	assert.Equal(t, "Level(7)", Level(7).String())
+
+	// level is changed while logging
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			wrapper.SetLevel(Level(i))
+			logAll(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -125,3 +125,12 @@ func TestGetAndSetSerilizer(t *testing.T) {
 	assert.Equal(t, err, nil)
 	assert.Equal(t, reflect.TypeOf(ser), reflect.TypeOf(oriSerializer))
 }
+
+func TestRegisterCodecConflict(t *testing.T) {
+	assert.NilError(t, RegisterCodec("test-conflict", newTestDubbo3Serializer))
+	assert.ErrorContains(t, RegisterCodec("test-conflict", newTestDubbo3Serializer), "already registered")
+	defer func() {
+		assert.Assert(t, recover() != nil)
+	}()
+	MustRegisterCodec("test-conflict", newTestDubbo3Serializer)
+}
@@ -0,0 +1,56 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimit
+
+import (
+	"context"
+	"net"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/common/peer"
+)
+
+// KeyFunc returns the client identity of incoming rpc @ctx, rpcs with the same key share one bucket per method
+type KeyFunc func(ctx context.Context) string
+
+// KeyByPeerIP is KeyFunc using ip of the remote peer as key
+func KeyByPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// KeyByAttachment returns KeyFunc using value of incoming attachment @key as key, e.g. an auth subject
+func KeyByAttachment(key string) KeyFunc {
+	return func(ctx context.Context) string {
+		attachment, ok := ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment)
+		if !ok {
+			return ""
+		}
+		return attachment.Get(key)
+	}
+}
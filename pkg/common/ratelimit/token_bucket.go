@@ -0,0 +1,153 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+// bucketSweepInterval is the min interval of evicting idle buckets
+const bucketSweepInterval = time.Minute
+
+// Limit is the config of a token bucket
+type Limit struct {
+	// Rate is the number of tokens added to bucket per second, which is steady-state rps
+	Rate float64
+	// Burst is the capacity of bucket, which is max rpc count allowed at one time
+	Burst int
+}
+
+// TokenBucketConfig is the config of TokenBucketLimiter
+type TokenBucketConfig struct {
+	// DefaultLimit is used by methods not in MethodLimits, zero Rate means no limitation
+	DefaultLimit Limit
+	// MethodLimits is method path -> Limit
+	MethodLimits map[string]Limit
+	// KeyFunc get client identity from ctx, if nil, all clients share the bucket of the method
+	KeyFunc KeyFunc
+}
+
+// TokenBucketLimiter is in-memory config.RateLimiter impl, which keeps a token bucket per method and client key.
+// Buckets refilled to burst are the same as new ones, they are evicted at most every bucketSweepInterval, so that
+// buckets of clients gone don't pile up.
+type TokenBucketLimiter struct {
+	conf      TokenBucketConfig
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	// now is used to get current time, replaceable for test
+	now func() time.Time
+}
+
+// NewTokenBucketLimiter returns TokenBucketLimiter with @conf
+func NewTokenBucketLimiter(conf TokenBucketConfig) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		conf:    conf,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+var _ config.RateLimiter = &TokenBucketLimiter{}
+
+// Allow takes a token from the bucket of @method and client key of @ctx
+func (l *TokenBucketLimiter) Allow(ctx context.Context, method string) (bool, time.Duration) {
+	limit, ok := l.conf.MethodLimits[method]
+	if !ok {
+		limit = l.conf.DefaultLimit
+	}
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+
+	bucketKey := method
+	if l.conf.KeyFunc != nil {
+		bucketKey += "#" + l.conf.KeyFunc(ctx)
+	}
+
+	now := l.now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sweep(now)
+	bucket, ok := l.buckets[bucketKey]
+	if !ok {
+		bucket = newTokenBucket(limit, now)
+		l.buckets[bucketKey] = bucket
+	}
+	return bucket.take(now)
+}
+
+// sweep evicts buckets refilled to burst at @now if bucketSweepInterval passes since last sweep, l.lock must be held
+func (l *TokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// tokenBucket is not concurrent safe, it's protected by TokenBucketLimiter.lock
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit Limit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// full reports whether the bucket is refilled to burst at @now
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// take refills the bucket from last time to @now, and takes one token. If bucket is empty, it returns the delay
+// to wait for next token
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
@@ -0,0 +1,173 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/common/peer"
+)
+
+const testMethod = "/com.apache.dubbo.sample.basic.IGreeter/SayHello"
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLimiter(conf TokenBucketConfig) (*TokenBucketLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	limiter := NewTokenBucketLimiter(conf)
+	limiter.now = clock.Now
+	return limiter, clock
+}
+
+func TestTokenBucketLimiterBurst(t *testing.T) {
+	limiter, _ := newTestLimiter(TokenBucketConfig{
+		DefaultLimit: Limit{Rate: 10, Burst: 5},
+	})
+	for i := 0; i < 5; i++ {
+		ok, _ := limiter.Allow(context.Background(), testMethod)
+		assert.True(t, ok)
+	}
+	ok, delay := limiter.Allow(context.Background(), testMethod)
+	assert.False(t, ok)
+	assert.Equal(t, 100*time.Millisecond, delay)
+}
+
+func TestTokenBucketLimiterSteadyState(t *testing.T) {
+	limiter, clock := newTestLimiter(TokenBucketConfig{
+		DefaultLimit: Limit{Rate: 10, Burst: 1},
+	})
+	ok, _ := limiter.Allow(context.Background(), testMethod)
+	assert.True(t, ok)
+
+	// 10 rps, a token is added every 100ms
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		clock.now = clock.now.Add(20 * time.Millisecond)
+		if ok, _ := limiter.Allow(context.Background(), testMethod); ok {
+			allowed++
+		}
+	}
+	assert.Equal(t, 20, allowed)
+
+	// tokens never exceed burst after idle
+	clock.now = clock.now.Add(time.Hour)
+	ok, _ = limiter.Allow(context.Background(), testMethod)
+	assert.True(t, ok)
+	ok, _ = limiter.Allow(context.Background(), testMethod)
+	assert.False(t, ok)
+}
+
+func TestTokenBucketLimiterMethodLimits(t *testing.T) {
+	limiter, _ := newTestLimiter(TokenBucketConfig{
+		MethodLimits: map[string]Limit{
+			testMethod: {Rate: 1, Burst: 1},
+		},
+	})
+	ok, _ := limiter.Allow(context.Background(), testMethod)
+	assert.True(t, ok)
+	ok, delay := limiter.Allow(context.Background(), testMethod)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, delay)
+
+	// no default limit
+	for i := 0; i < 100; i++ {
+		ok, _ = limiter.Allow(context.Background(), "/com.apache.dubbo.sample.basic.IGreeter/SayHello2")
+		assert.True(t, ok)
+	}
+}
+
+func TestTokenBucketLimiterKeyFunc(t *testing.T) {
+	limiter, _ := newTestLimiter(TokenBucketConfig{
+		DefaultLimit: Limit{Rate: 1, Burst: 1},
+		KeyFunc:      KeyByAttachment("subject"),
+	})
+	aliceCtx := context.WithValue(context.Background(), constant.CtxAttachmentKey, common.TripleAttachment{"subject": {"alice"}})
+	bobCtx := context.WithValue(context.Background(), constant.CtxAttachmentKey, common.TripleAttachment{"subject": {"bob"}})
+
+	ok, _ := limiter.Allow(aliceCtx, testMethod)
+	assert.True(t, ok)
+	ok, _ = limiter.Allow(aliceCtx, testMethod)
+	assert.False(t, ok)
+	ok, _ = limiter.Allow(bobCtx, testMethod)
+	assert.True(t, ok)
+}
+
+func TestKeyByPeerIP(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 20000},
+	})
+	assert.Equal(t, "10.0.0.1", KeyByPeerIP(ctx))
+	assert.Equal(t, "", KeyByPeerIP(context.Background()))
+}
+
+func TestTokenBucketLimiterEvictIdleBuckets(t *testing.T) {
+	limiter, clock := newTestLimiter(TokenBucketConfig{
+		// a token is added every 10s
+		DefaultLimit: Limit{Rate: 0.1, Burst: 10},
+		KeyFunc:      KeyByAttachment("subject"),
+	})
+	ctxOf := func(subject string) context.Context {
+		return context.WithValue(context.Background(), constant.CtxAttachmentKey, common.TripleAttachment{"subject": {subject}})
+	}
+	for i := 0; i < 10; i++ {
+		ok, _ := limiter.Allow(ctxOf("alice"), testMethod)
+		assert.True(t, ok)
+	}
+	ok, _ := limiter.Allow(ctxOf("bob"), testMethod)
+	assert.True(t, ok)
+	assert.Len(t, limiter.buckets, 2)
+
+	// buckets are not swept within bucketSweepInterval
+	clock.now = clock.now.Add(bucketSweepInterval / 2)
+	ok, _ = limiter.Allow(ctxOf("carol"), testMethod)
+	assert.True(t, ok)
+	assert.Len(t, limiter.buckets, 3)
+
+	// bob's and carol's buckets are refilled, but alice's empty one needs 100s
+	clock.now = clock.now.Add(bucketSweepInterval)
+	ok, _ = limiter.Allow(ctxOf("dave"), testMethod)
+	assert.True(t, ok)
+	assert.Len(t, limiter.buckets, 2)
+	assert.Contains(t, limiter.buckets, testMethod+"#alice")
+	assert.Contains(t, limiter.buckets, testMethod+"#dave")
+
+	// alice's bucket keeps the tokens taken
+	for i := 0; i < 9; i++ {
+		ok, _ = limiter.Allow(ctxOf("alice"), testMethod)
+		assert.True(t, ok)
+	}
+	ok, _ = limiter.Allow(ctxOf("alice"), testMethod)
+	assert.False(t, ok)
+}
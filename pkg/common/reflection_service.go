@@ -0,0 +1,151 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"reflect"
+	"sort"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// reflectionMethod is an exported method of impl of reflectionUnaryService
+type reflectionMethod struct {
+	method reflect.Value
+	// withContext is true if the first param of method is context.Context, which is not a request param
+	withContext bool
+	paramTypes  []reflect.Type
+	// withError is true if the last result of method is error
+	withError bool
+}
+
+// reflectionUnaryService is TripleUnaryService which dispatches rpcs to exported methods of impl by name
+type reflectionUnaryService struct {
+	methods map[string]*reflectionMethod
+}
+
+// NewReflectionUnaryService returns TripleUnaryService which dispatches rpcs to exported methods of @impl by method
+// name, e.g. non-pb services, instead of implementing InvokeWithArgs and GetReqParamsInterfaces by hand. Request
+// params are the params of method, except the optional first one of context.Context, which gets the ctx of rpc.
+// Method returns the reply, optionally followed by error, methods of other signatures are not provided.
+// It returns error if @impl has no such methods.
+func NewReflectionUnaryService(impl interface{}) (TripleUnaryService, error) {
+	if impl == nil {
+		return nil, perrors.New("reflection unary service impl is nil")
+	}
+	implValue := reflect.ValueOf(impl)
+	implType := implValue.Type()
+	s := &reflectionUnaryService{methods: make(map[string]*reflectionMethod)}
+	for i := 0; i < implType.NumMethod(); i++ {
+		if m := newReflectionMethod(implValue.Method(i)); m != nil {
+			s.methods[implType.Method(i).Name] = m
+		}
+	}
+	if len(s.methods) == 0 {
+		return nil, perrors.Errorf("%v has no exported method returning reply and optional error", implType)
+	}
+	return s, nil
+}
+
+// newReflectionMethod returns reflectionMethod of @method, it returns nil if the signature is not supported
+func newReflectionMethod(method reflect.Value) *reflectionMethod {
+	methodType := method.Type()
+	m := &reflectionMethod{method: method}
+	switch {
+	case methodType.NumOut() == 1 && methodType.Out(0) != errorType:
+	case methodType.NumOut() == 2 && methodType.Out(1) == errorType:
+		m.withError = true
+	default:
+		return nil
+	}
+	for i := 0; i < methodType.NumIn(); i++ {
+		if i == 0 && methodType.In(i) == contextType {
+			m.withContext = true
+			continue
+		}
+		m.paramTypes = append(m.paramTypes, methodType.In(i))
+	}
+	return m
+}
+
+// Methods returns names of provided methods in ascending order
+func (s *reflectionUnaryService) Methods() []string {
+	names := make([]string, 0, len(s.methods))
+	for name := range s.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *reflectionUnaryService) GetReqParamsInterfaces(methodName string) ([]interface{}, bool) {
+	m, ok := s.methods[methodName]
+	if !ok {
+		return nil, false
+	}
+	params := make([]interface{}, 0, len(m.paramTypes))
+	for _, t := range m.paramTypes {
+		params = append(params, reflect.New(t).Interface())
+	}
+	return params, true
+}
+
+func (s *reflectionUnaryService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	m, ok := s.methods[methodName]
+	if !ok {
+		return nil, perrors.Errorf("method %s is not provided by service", methodName)
+	}
+	if len(arguments) != len(m.paramTypes) {
+		return nil, perrors.Errorf("method %s takes %d arguments, got %d", methodName, len(m.paramTypes), len(arguments))
+	}
+	in := make([]reflect.Value, 0, len(arguments)+1)
+	if m.withContext {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	for i, arg := range arguments {
+		t := m.paramTypes[i]
+		if arg == nil {
+			in = append(in, reflect.Zero(t))
+			continue
+		}
+		v := reflect.ValueOf(arg)
+		switch {
+		case v.Type().AssignableTo(t):
+		case v.Type().ConvertibleTo(t):
+			v = v.Convert(t)
+		default:
+			return nil, perrors.Errorf("argument %d of method %s is %v, want %v", i, methodName, v.Type(), t)
+		}
+		in = append(in, v)
+	}
+	out := m.method.Call(in)
+	if m.withError {
+		if err, _ := out[1].Interface().(error); err != nil {
+			return nil, err
+		}
+	}
+	return out[0].Interface(), nil
+}
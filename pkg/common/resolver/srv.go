@@ -0,0 +1,233 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+const (
+	// SRVScheme is the scheme of target resolved by DNS SRV records, e.g. srv:///_grpc._tcp.myservice
+	SRVScheme = "srv"
+
+	defaultRefreshInterval = 30 * time.Second
+	defaultLookupTimeout   = 5 * time.Second
+)
+
+// LookupSRVFunc looks up SRV records of @name, it has the same signature as net.Resolver.LookupSRV
+type LookupSRVFunc func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+// SRVConfig is the config of SRVResolver
+type SRVConfig struct {
+	// LookupSRV is used to query SRV records, if nil, net.DefaultResolver.LookupSRV is used
+	LookupSRV LookupSRVFunc
+	// RefreshInterval is the interval of re-querying records, default is 30s. TTL of records is not exposed by
+	// go resolver, so it should be set to TTL of records.
+	RefreshInterval time.Duration
+	// LookupTimeout is the timeout of each query, default is 5s
+	LookupTimeout time.Duration
+}
+
+// SRVResolver is config.Resolver impl, which discovers endpoints and weights from DNS SRV records of target, e.g.
+// srv:///_grpc._tcp.myservice, like headless service of Kubernetes. Only records of the lowest priority are used.
+// Records are re-queried every RefreshInterval, and the last endpoints are kept if the query fails. Watchers are told
+// after endpoints change.
+type SRVResolver struct {
+	name      string
+	conf      SRVConfig
+	lock      sync.RWMutex
+	endpoints []config.Endpoint
+	// watchers are called after endpoints change, keyed by the id of Watch
+	watchers  map[uint64]func(endpoints []config.Endpoint)
+	watcherID uint64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ config.WatchableResolver = &SRVResolver{}
+
+// NewSRVResolver queries SRV records of @target with @conf, and returns SRVResolver refreshing them in background.
+// It returns error if @target is invalid, or the first query fails or finds no records.
+func NewSRVResolver(target string, conf SRVConfig) (*SRVResolver, error) {
+	name, err := ParseSRVTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if conf.LookupSRV == nil {
+		conf.LookupSRV = net.DefaultResolver.LookupSRV
+	}
+	if conf.RefreshInterval <= 0 {
+		conf.RefreshInterval = defaultRefreshInterval
+	}
+	if conf.LookupTimeout <= 0 {
+		conf.LookupTimeout = defaultLookupTimeout
+	}
+	r := &SRVResolver{
+		name:     name,
+		conf:     conf,
+		watchers: make(map[uint64]func(endpoints []config.Endpoint)),
+		done:     make(chan struct{}),
+	}
+	if err := r.refresh(); err != nil {
+		return nil, err
+	}
+	go r.run()
+	return r, nil
+}
+
+// ParseSRVTarget returns the record name of @target, e.g. "_grpc._tcp.myservice" of "srv:///_grpc._tcp.myservice".
+// Authority of target, i.e. custom dns server, is not supported.
+func ParseSRVTarget(target string) (string, error) {
+	prefix := SRVScheme + "://"
+	if !strings.HasPrefix(target, prefix) {
+		return "", perrors.Errorf("invalid srv target %s, it should be srv:///<name>", target)
+	}
+	rest := target[len(prefix):]
+	idx := strings.Index(rest, "/")
+	if idx < 0 {
+		return "", perrors.Errorf("invalid srv target %s, it should be srv:///<name>", target)
+	}
+	if idx > 0 {
+		return "", perrors.Errorf("invalid srv target %s, dns authority %s is not supported", target, rest[:idx])
+	}
+	name := rest[idx+1:]
+	if name == "" {
+		return "", perrors.Errorf("invalid srv target %s, record name is empty", target)
+	}
+	return name, nil
+}
+
+// Endpoints returns endpoints of the last successful query
+func (r *SRVResolver) Endpoints() []config.Endpoint {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.endpoints
+}
+
+// Watch registers @onUpdate, which is called with the new endpoints after each query finding endpoints changed
+func (r *SRVResolver) Watch(onUpdate func(endpoints []config.Endpoint)) func() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.watcherID++
+	id := r.watcherID
+	r.watchers[id] = onUpdate
+	return func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		delete(r.watchers, id)
+	}
+}
+
+// Close stops refreshing records
+func (r *SRVResolver) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+func (r *SRVResolver) run() {
+	ticker := time.NewTicker(r.conf.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			// the last endpoints are kept if query fails
+			_ = r.refresh()
+		}
+	}
+}
+
+// refresh queries SRV records and replaces endpoints
+func (r *SRVResolver) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.conf.LookupTimeout)
+	defer cancel()
+	_, records, err := r.conf.LookupSRV(ctx, "", "", r.name)
+	if err != nil {
+		return perrors.Wrapf(err, "lookup srv records of %s", r.name)
+	}
+	endpoints := endpointsFromSRV(records)
+	if len(endpoints) == 0 {
+		return perrors.Errorf("no srv records of %s", r.name)
+	}
+	r.lock.Lock()
+	changed := !equalEndpoints(r.endpoints, endpoints)
+	r.endpoints = endpoints
+	var watchers []func(endpoints []config.Endpoint)
+	if changed {
+		for _, watcher := range r.watchers {
+			watchers = append(watchers, watcher)
+		}
+	}
+	r.lock.Unlock()
+	for _, watcher := range watchers {
+		watcher(endpoints)
+	}
+	return nil
+}
+
+// equalEndpoints reports whether @a and @b have the same endpoints in the same order
+func equalEndpoints(a, b []config.Endpoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// endpointsFromSRV converts records of the lowest priority to endpoints
+func endpointsFromSRV(records []*net.SRV) []config.Endpoint {
+	if len(records) == 0 {
+		return nil
+	}
+	sorted := make([]*net.SRV, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+	endpoints := make([]config.Endpoint, 0, len(sorted))
+	for _, record := range sorted {
+		if record.Priority != sorted[0].Priority {
+			break
+		}
+		endpoints = append(endpoints, config.Endpoint{
+			Address: net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))),
+			Weight:  int(record.Weight),
+		})
+	}
+	return endpoints
+}
@@ -0,0 +1,147 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+// fakeSRVLookup returns records set by test, or error if records are nil
+type fakeSRVLookup struct {
+	lock    sync.Mutex
+	name    string
+	records []*net.SRV
+}
+
+func (f *fakeSRVLookup) set(records []*net.SRV) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.records = records
+}
+
+func (f *fakeSRVLookup) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.name = name
+	if f.records == nil {
+		return "", nil, perrors.New("no such host")
+	}
+	return name, f.records, nil
+}
+
+func TestParseSRVTarget(t *testing.T) {
+	name, err := ParseSRVTarget("srv:///_grpc._tcp.myservice")
+	assert.Nil(t, err)
+	assert.Equal(t, "_grpc._tcp.myservice", name)
+
+	for _, target := range []string{"dns:///myservice", "srv://myservice", "srv:///", "srv://8.8.8.8/_grpc._tcp.myservice"} {
+		_, err = ParseSRVTarget(target)
+		assert.NotNil(t, err, target)
+	}
+}
+
+func TestSRVResolver(t *testing.T) {
+	lookup := &fakeSRVLookup{}
+	lookup.set([]*net.SRV{
+		{Target: "pod-0.myservice.default.svc.cluster.local.", Port: 20000, Priority: 10, Weight: 3},
+		{Target: "pod-1.myservice.default.svc.cluster.local.", Port: 20000, Priority: 10, Weight: 1},
+		// backup of higher priority value is not used
+		{Target: "backup.myservice.default.svc.cluster.local.", Port: 20001, Priority: 20, Weight: 100},
+	})
+	r, err := NewSRVResolver("srv:///_grpc._tcp.myservice", SRVConfig{
+		LookupSRV:       lookup.LookupSRV,
+		RefreshInterval: 10 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Equal(t, "_grpc._tcp.myservice", lookup.name)
+	assert.Equal(t, []config.Endpoint{
+		{Address: "pod-0.myservice.default.svc.cluster.local:20000", Weight: 3},
+		{Address: "pod-1.myservice.default.svc.cluster.local:20000", Weight: 1},
+	}, r.Endpoints())
+
+	// records are re-queried
+	lookup.set([]*net.SRV{
+		{Target: "pod-2.myservice.default.svc.cluster.local.", Port: 20000, Priority: 10, Weight: 5},
+	})
+	expected := []config.Endpoint{{Address: "pod-2.myservice.default.svc.cluster.local:20000", Weight: 5}}
+	for i := 0; i < 100 && !assert.ObjectsAreEqual(expected, r.Endpoints()); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, expected, r.Endpoints())
+
+	// the last endpoints are kept if query fails
+	lookup.set(nil)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, expected, r.Endpoints())
+}
+
+func TestSRVResolverWatch(t *testing.T) {
+	lookup := &fakeSRVLookup{}
+	lookup.set([]*net.SRV{{Target: "pod-0.", Port: 20000, Priority: 10, Weight: 1}})
+	r, err := NewSRVResolver("srv:///_grpc._tcp.myservice", SRVConfig{
+		LookupSRV:       lookup.LookupSRV,
+		RefreshInterval: 10 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	defer r.Close()
+	updates := make(chan []config.Endpoint, 10)
+	cancel := r.Watch(func(endpoints []config.Endpoint) {
+		updates <- endpoints
+	})
+
+	// watcher is told only when endpoints change
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, updates, 0)
+	lookup.set([]*net.SRV{{Target: "pod-1.", Port: 20000, Priority: 10, Weight: 1}})
+	select {
+	case endpoints := <-updates:
+		assert.Equal(t, []config.Endpoint{{Address: "pod-1:20000", Weight: 1}}, endpoints)
+	case <-time.After(time.Second):
+		t.Fatal("watcher isn't told")
+	}
+
+	cancel()
+	lookup.set([]*net.SRV{{Target: "pod-2.", Port: 20000, Priority: 10, Weight: 1}})
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, updates, 0)
+}
+
+func TestSRVResolverFirstLookupFailure(t *testing.T) {
+	lookup := &fakeSRVLookup{}
+	_, err := NewSRVResolver("srv:///_grpc._tcp.myservice", SRVConfig{LookupSRV: lookup.LookupSRV})
+	assert.NotNil(t, err)
+
+	lookup.set([]*net.SRV{})
+	_, err = NewSRVResolver("srv:///_grpc._tcp.myservice", SRVConfig{LookupSRV: lookup.LookupSRV})
+	assert.NotNil(t, err)
+}
@@ -36,7 +36,56 @@ type TripleUnaryService interface {
 	GetReqParamsInterfaces(methodName string) ([]interface{}, bool)
 }
 
-type TripleAttachment map[string]string
+// ServiceInfo describes service registered on server, it is used by admin tooling
+type ServiceInfo struct {
+	// InterfaceName is the key of service, e.g. com.apache.dubbo.sample.basic.IGreeter
+	InterfaceName string
+	// Methods are names of methods provided by the service, in ascending order
+	Methods []string
+}
+
+// MethodKind is the cardinality of a method dispatched by server
+type MethodKind int
+
+const (
+	UnaryMethodKind MethodKind = iota
+	ClientStreamMethodKind
+	ServerStreamMethodKind
+	// BidiStreamMethodKind is also the kind of stream desc without ClientStreams and ServerStreams, which is served
+	// as a full stream
+	BidiStreamMethodKind
+)
+
+func (k MethodKind) String() string {
+	switch k {
+	case UnaryMethodKind:
+		return "unary"
+	case ClientStreamMethodKind:
+		return "client_stream"
+	case ServerStreamMethodKind:
+		return "server_stream"
+	case BidiStreamMethodKind:
+		return "bidi_stream"
+	}
+	return "unknown"
+}
+
+// Route describes a path that server dispatches to a method of TripleGrpcService
+type Route struct {
+	// Path is the request path, e.g. /com.apache.dubbo.sample.basic.IGreeter/SayHello
+	Path string
+	// MethodName is the name of method in ServiceDesc
+	MethodName string
+	Kind       MethodKind
+	// MethodDesc is set for unary method, and StreamDesc is set for streaming method, their Handler is what server calls
+	MethodDesc *grpc.MethodDesc
+	StreamDesc *grpc.StreamDesc
+}
+
+// TripleAttachment is incoming attachments of rpc, a key may have multiple values like http.Header, keys are lower case.
+// It was map[string]string, NewTripleAttachment and SingleValues convert from and to that form.
+type TripleAttachment map[string][]string
+
 type DubboAttachment map[string]interface{}
 
 // OuterResult is a dubbo RPC result
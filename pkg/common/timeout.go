@@ -0,0 +1,136 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/constant"
+)
+
+// maxTimeoutValue is max digits count of grpc-timeout value defined by grpc protocol
+const maxTimeoutValue = 8
+
+// maxTimeoutAmount is the max amount of grpc-timeout value with maxTimeoutValue digits
+const maxTimeoutAmount = 1e8 - 1
+
+// timeoutUnits are grpc-timeout units from the largest to the smallest
+var timeoutUnits = []struct {
+	unit   time.Duration
+	suffix string
+}{
+	{time.Hour, "H"},
+	{time.Minute, "M"},
+	{time.Second, "S"},
+	{time.Millisecond, "m"},
+	{time.Microsecond, "u"},
+	{time.Nanosecond, "n"},
+}
+
+// TimeoutFromIncomingContext parse the timeout of the invocation from incoming attachments of server @ctx,
+// grpc-timeout field is used first, and if absent, legacy dubbo timeout attachment in milliseconds is used.
+// It returns false if neither of them exists or the value is malformed.
+func TimeoutFromIncomingContext(ctx context.Context) (time.Duration, bool) {
+	attachment, ok := ctx.Value(constant.CtxAttachmentKey).(TripleAttachment)
+	if !ok {
+		return 0, false
+	}
+	if v, ok := attachment.Lookup(constant.GrpcTimeout); ok {
+		timeout, err := DecodeGrpcTimeout(v)
+		if err != nil {
+			return 0, false
+		}
+		return timeout, true
+	}
+	if v, ok := attachment.Lookup(constant.LegacyTimeout); ok {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ms < 0 {
+			return 0, false
+		}
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	return 0, false
+}
+
+// DecodeGrpcTimeout parse grpc-timeout header value @s, like "10S", to time.Duration
+func DecodeGrpcTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("timeout string is too short: %q", s)
+	}
+	if len(s) > maxTimeoutValue+1 {
+		return 0, fmt.Errorf("timeout string is too long: %q", s)
+	}
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, fmt.Errorf("timeout unit is not recognized: %q", s)
+	}
+	t, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || t < 0 {
+		return 0, fmt.Errorf("timeout value is invalid: %q", s)
+	}
+	if t > int64(math.MaxInt64/unit) {
+		// clamp to the max duration if it overflows
+		return time.Duration(math.MaxInt64), nil
+	}
+	return time.Duration(t) * unit, nil
+}
+
+// EncodeGrpcTimeout returns grpc-timeout header value of @t in the most compact unit, which is the largest unit that @t
+// can be represented exactly with at most 8 digits, e.g. "1S" instead of "1000m". If there is no such unit, the
+// smallest unit that fits 8 digits is used, and the value is rounded up. Non-positive @t is encoded as "0n".
+func EncodeGrpcTimeout(t time.Duration) string {
+	if t <= 0 {
+		return "0n"
+	}
+	for _, u := range timeoutUnits {
+		if t%u.unit == 0 && t/u.unit <= maxTimeoutAmount {
+			return strconv.FormatInt(int64(t/u.unit), 10) + u.suffix
+		}
+	}
+	// max time.Duration is about 2562047 hours, so there is always a unit fits in 8 digits
+	for i := len(timeoutUnits) - 1; ; i-- {
+		u := timeoutUnits[i]
+		amount := t / u.unit
+		if t%u.unit != 0 {
+			// round up, so the deadline is not shortened
+			amount++
+		}
+		if amount <= maxTimeoutAmount || i == 0 {
+			return strconv.FormatInt(int64(amount), 10) + u.suffix
+		}
+	}
+}
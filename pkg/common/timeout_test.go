@@ -0,0 +1,105 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+import (
+	"gotest.tools/assert"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/constant"
+)
+
+func TestTimeoutFromIncomingContext(t *testing.T) {
+	tests := []struct {
+		attachment TripleAttachment
+		timeout    time.Duration
+		ok         bool
+	}{
+		{TripleAttachment{constant.GrpcTimeout: {"2H"}}, 2 * time.Hour, true},
+		{TripleAttachment{constant.GrpcTimeout: {"3M"}}, 3 * time.Minute, true},
+		{TripleAttachment{constant.GrpcTimeout: {"10S"}}, 10 * time.Second, true},
+		{TripleAttachment{constant.GrpcTimeout: {"100m"}}, 100 * time.Millisecond, true},
+		{TripleAttachment{constant.GrpcTimeout: {"5u"}}, 5 * time.Microsecond, true},
+		{TripleAttachment{constant.GrpcTimeout: {"99999999n"}}, 99999999 * time.Nanosecond, true},
+		// overflow of time.Duration is clamped
+		{TripleAttachment{constant.GrpcTimeout: {"99999999H"}}, time.Duration(math.MaxInt64), true},
+		{TripleAttachment{constant.LegacyTimeout: {"3000"}}, 3 * time.Second, true},
+		// grpc-timeout is preferred
+		{TripleAttachment{constant.GrpcTimeout: {"1S"}, constant.LegacyTimeout: {"3000"}}, time.Second, true},
+
+		// malformed
+		{TripleAttachment{constant.GrpcTimeout: {"1"}}, 0, false},
+		{TripleAttachment{constant.GrpcTimeout: {"10s"}}, 0, false},
+		{TripleAttachment{constant.GrpcTimeout: {"aS"}}, 0, false},
+		{TripleAttachment{constant.GrpcTimeout: {"-1S"}}, 0, false},
+		{TripleAttachment{constant.GrpcTimeout: {"123456789S"}}, 0, false},
+		{TripleAttachment{constant.LegacyTimeout: {"3s"}}, 0, false},
+		{TripleAttachment{constant.LegacyTimeout: {"-10"}}, 0, false},
+		{TripleAttachment{}, 0, false},
+	}
+	for _, test := range tests {
+		ctx := context.WithValue(context.Background(), constant.CtxAttachmentKey, test.attachment)
+		timeout, ok := TimeoutFromIncomingContext(ctx)
+		assert.Equal(t, ok, test.ok, test.attachment)
+		assert.Equal(t, timeout, test.timeout, test.attachment)
+	}
+
+	_, ok := TimeoutFromIncomingContext(context.Background())
+	assert.Equal(t, ok, false)
+}
+
+func TestEncodeGrpcTimeout(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		encoded string
+	}{
+		{2 * time.Hour, "2H"},
+		{3 * time.Minute, "3M"},
+		{90 * time.Second, "90S"},
+		{1500 * time.Millisecond, "1500m"},
+		{5 * time.Microsecond, "5u"},
+		{99999999 * time.Nanosecond, "99999999n"},
+		{100000000 * time.Nanosecond, "100m"},
+		{2562047 * time.Hour, "2562047H"},
+	}
+	for _, test := range tests {
+		encoded := EncodeGrpcTimeout(test.timeout)
+		assert.Equal(t, encoded, test.encoded)
+		decoded, err := DecodeGrpcTimeout(encoded)
+		assert.NilError(t, err)
+		assert.Equal(t, decoded, test.timeout)
+	}
+
+	// not exact in 8 digits, rounded up to the smallest unit that fits
+	encoded := EncodeGrpcTimeout(time.Hour + time.Nanosecond)
+	assert.Equal(t, encoded, "3600001m")
+	decoded, err := DecodeGrpcTimeout(encoded)
+	assert.NilError(t, err)
+	assert.Assert(t, decoded >= time.Hour+time.Nanosecond)
+
+	assert.Equal(t, EncodeGrpcTimeout(0), "0n")
+	assert.Equal(t, EncodeGrpcTimeout(-time.Second), "0n")
+}
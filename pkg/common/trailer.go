@@ -0,0 +1,88 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/constant"
+)
+
+// trailer stores trailing attachments set by server handler during rpc
+type trailer struct {
+	lock       sync.Mutex
+	attachment TripleAttachment
+}
+
+// NewTrailerContext returns ctx of server rpc derived from @ctx, in which handler can set trailing attachments by
+// SetTrailer
+func NewTrailerContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, constant.CtxTrailerKey, &trailer{attachment: make(TripleAttachment)})
+}
+
+// SetTrailer sets trailing attachment @key with @value in server rpc @ctx, it is sent in trailers whether the rpc
+// succeeds or fails, e.g. timings or cache hints. Binary value should be set to key with "-bin" suffix.
+// It returns error if @ctx is not ctx of server rpc.
+func SetTrailer(ctx context.Context, key, value string) error {
+	t, ok := ctx.Value(constant.CtxTrailerKey).(*trailer)
+	if !ok {
+		return perrors.New("SetTrailer: ctx is not ctx of server rpc")
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.attachment.Set(key, value)
+	return nil
+}
+
+// AddTrailer appends @value to trailing attachment @key in server rpc @ctx, all values of @key are sent in trailers.
+// It returns error if @ctx is not ctx of server rpc.
+func AddTrailer(ctx context.Context, key, value string) error {
+	t, ok := ctx.Value(constant.CtxTrailerKey).(*trailer)
+	if !ok {
+		return perrors.New("AddTrailer: ctx is not ctx of server rpc")
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.attachment.Add(key, value)
+	return nil
+}
+
+// TrailerFromContext returns the trailing attachments set in @ctx by SetTrailer, it returns nil if there is none
+func TrailerFromContext(ctx context.Context) TripleAttachment {
+	t, ok := ctx.Value(constant.CtxTrailerKey).(*trailer)
+	if !ok {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.attachment) == 0 {
+		return nil
+	}
+	attachment := make(TripleAttachment, len(t.attachment))
+	for k, v := range t.attachment {
+		attachment[k] = append([]string(nil), v...)
+	}
+	return attachment
+}
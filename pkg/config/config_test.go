@@ -19,6 +19,7 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 import (
@@ -27,6 +28,7 @@ import (
 
 import (
 	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/common/logger"
 	"github.com/dubbogo/triple/pkg/common/logger/default_logger"
 )
 
@@ -116,4 +118,70 @@ func TestValidate(t *testing.T) {
 	assert.Equal(t, constant.DefaultListeningAddress, opt.Location)
 	assert.Equal(t, constant.TRIPLE, opt.Protocol)
 	assert.Equal(t, constant.PBCodecName, opt.CodecType)
+	assert.Equal(t, logger.NewLoggerWrapper(logger.NopLogger{}), opt.Logger)
+
+	opt = NewTripleOption(WithVerboseDefaultLogger())
+	opt.Validate()
+	assert.NotEqual(t, logger.NewLoggerWrapper(logger.NopLogger{}), opt.Logger)
+}
+
+func TestWithCompressionLevel(t *testing.T) {
+	opt := NewTripleOption(
+		WithCompressorType(constant.GzipCompressorName),
+		WithCompressionLevel(9),
+	)
+	opt.Validate()
+	assert.Equal(t, constant.GzipCompressorName, opt.CompressorType)
+	assert.Equal(t, 9, opt.CompressionLevel)
+
+	opt = NewTripleOption()
+	opt.Validate()
+	assert.Equal(t, constant.DefaultCompressionLevel, opt.CompressionLevel)
+}
+
+func TestWithMaxFrameSize(t *testing.T) {
+	for size, expected := range map[uint32]uint32{
+		0:       0,
+		1 << 20: 1 << 20,
+		1024:    constant.MinMaxFrameSize,
+		1 << 30: constant.MaxMaxFrameSize,
+	} {
+		opt := NewTripleOption(WithMaxFrameSize(size))
+		opt.Validate()
+		assert.Equal(t, expected, opt.MaxFrameSize)
+	}
+}
+
+func TestServerTimeoutEffective(t *testing.T) {
+	// no deadline from client
+	timeout, ok := ServerTimeout{}.Effective(0, false)
+	assert.False(t, ok)
+	timeout, ok = ServerTimeout{Default: time.Second}.Effective(0, false)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, timeout)
+	timeout, ok = ServerTimeout{Default: time.Minute, Max: time.Second}.Effective(0, false)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, timeout)
+
+	// over max
+	timeout, ok = ServerTimeout{Max: time.Second}.Effective(time.Hour, true)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, timeout)
+
+	// within bounds
+	timeout, ok = ServerTimeout{Default: time.Second, Max: time.Minute}.Effective(3*time.Second, true)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, timeout)
+	timeout, ok = ServerTimeout{}.Effective(time.Hour, true)
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, timeout)
+}
+
+func TestGetServerTimeout(t *testing.T) {
+	opt := NewTripleOption(
+		WithServerTimeout(ServerTimeout{Default: time.Second}),
+		WithMethodServerTimeout("/com.apache.dubbo.sample.basic.IGreeter/BigUnaryTest", ServerTimeout{Max: time.Minute}),
+	)
+	assert.Equal(t, ServerTimeout{Max: time.Minute}, opt.GetServerTimeout("/com.apache.dubbo.sample.basic.IGreeter/BigUnaryTest"))
+	assert.Equal(t, ServerTimeout{Default: time.Second}, opt.GetServerTimeout("/com.apache.dubbo.sample.basic.IGreeter/SayHello"))
 }
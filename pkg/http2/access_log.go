@@ -0,0 +1,116 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/peer"
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+// accessLog collects tconfig.AccessLogRecord of a request, all methods are no-op on nil accessLog, which means access
+// log is disabled
+type accessLog struct {
+	record tconfig.AccessLogRecord
+	// requestBytes, requestPayloadBytes, requestWirePayloadBytes and requestCompressed are updated by the goroutine
+	// reading request body, so they are accessed atomically
+	requestBytes            int64
+	requestPayloadBytes     int64
+	requestWirePayloadBytes int64
+	requestCompressed       int32
+}
+
+// newAccessLog starts access log of request @r
+func newAccessLog(r *http.Request) *accessLog {
+	l := &accessLog{
+		record: tconfig.AccessLogRecord{
+			Method: r.URL.Path,
+			Start:  time.Now(),
+		},
+	}
+	if p, ok := peer.FromContext(r.Context()); ok && p.Addr != nil {
+		l.record.Peer = p.Addr.String()
+	}
+	return l
+}
+
+// countRequest returns @body which counts bytes read from it to RequestBytes
+func (l *accessLog) countRequest(body io.ReadCloser) io.ReadCloser {
+	if l == nil {
+		return body
+	}
+	return &countingBody{ReadCloser: body, n: &l.requestBytes}
+}
+
+// onRequestMessage returns the callback of each request message, which counts its @size before compression and
+// @wireSize on the wire, it returns nil if access log is disabled
+func (l *accessLog) onRequestMessage() func(compressed bool, size, wireSize int) {
+	if l == nil {
+		return nil
+	}
+	return func(compressed bool, size, wireSize int) {
+		if compressed {
+			atomic.StoreInt32(&l.requestCompressed, 1)
+		}
+		atomic.AddInt64(&l.requestPayloadBytes, int64(size))
+		atomic.AddInt64(&l.requestWirePayloadBytes, int64(wireSize))
+	}
+}
+
+// countResponse adds response message of @size bytes before compression, which is written as @n bytes with header
+func (l *accessLog) countResponse(compressed bool, size, n int) {
+	if l == nil {
+		return
+	}
+	l.record.ResponseCompressed = l.record.ResponseCompressed || compressed
+	l.record.ResponseBytes += int64(n)
+	l.record.ResponsePayloadBytes += int64(size)
+	l.record.ResponseWirePayloadBytes += int64(n - messageHeaderLen)
+}
+
+// finish sends the record with final status @code to @sink
+func (l *accessLog) finish(sink tconfig.AccessLogSink, code uint32) {
+	if l == nil {
+		return
+	}
+	l.record.Code = code
+	l.record.Duration = time.Since(l.record.Start)
+	l.record.RequestBytes = atomic.LoadInt64(&l.requestBytes)
+	l.record.RequestCompressed = atomic.LoadInt32(&l.requestCompressed) == 1
+	l.record.RequestPayloadBytes = atomic.LoadInt64(&l.requestPayloadBytes)
+	l.record.RequestWirePayloadBytes = atomic.LoadInt64(&l.requestWirePayloadBytes)
+	sink(&l.record)
+}
+
+// countingBody counts bytes read from request body to @n
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,125 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+// dialBackoff keeps consecutive dial failures of addresses, an address is not redialed until its backoff delay passes
+type dialBackoff struct {
+	backoff tconfig.Backoff
+	// random returns a pseudo-random number in [0.0, 1.0) to randomize delay
+	random func() float64
+	now    func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]*dialFailure
+}
+
+// dialFailure is the consecutive dial failures of an address
+type dialFailure struct {
+	count   int
+	retryAt time.Time
+	err     error
+}
+
+// newDialBackoff returns dialBackoff of @backoff, it returns nil if backoff is disabled by zero BaseDelay
+func newDialBackoff(backoff tconfig.Backoff) *dialBackoff {
+	if backoff.BaseDelay <= 0 {
+		return nil
+	}
+	if backoff.Multiplier == 0 {
+		backoff.Multiplier = tconfig.DefaultBackoff.Multiplier
+	}
+	if backoff.MaxDelay == 0 {
+		backoff.MaxDelay = tconfig.DefaultBackoff.MaxDelay
+	}
+	return &dialBackoff{
+		backoff:  backoff,
+		random:   rand.Float64,
+		now:      time.Now,
+		failures: make(map[string]*dialFailure),
+	}
+}
+
+// delay returns the backoff delay after @count consecutive failures
+func (b *dialBackoff) delay(count int) time.Duration {
+	d := float64(b.backoff.BaseDelay) * math.Pow(math.Max(b.backoff.Multiplier, 1), float64(count-1))
+	if max := float64(b.backoff.MaxDelay); d > max {
+		d = max
+	}
+	d *= 1 + b.backoff.Jitter*(b.random()*2-1)
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// check returns the last dial error of @addr if it is in backoff, which should not be redialed
+func (b *dialBackoff) check(addr string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failure, ok := b.failures[addr]
+	if !ok {
+		return nil
+	}
+	if wait := failure.retryAt.Sub(b.now()); wait > 0 {
+		return perrors.WithMessagef(failure.err, "http2 client: redial of %s is backed off for %v", addr, wait)
+	}
+	return nil
+}
+
+// fail records dial failure @err of @addr, and starts its backoff. Failures of other addresses, which are not
+// redialed for MaxDelay after their backoff, are dropped, so that addresses never dialed again don't stay forever.
+func (b *dialBackoff) fail(addr string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	for a, f := range b.failures {
+		if a != addr && now.Sub(f.retryAt) > b.backoff.MaxDelay {
+			delete(b.failures, a)
+		}
+	}
+	failure, ok := b.failures[addr]
+	if !ok {
+		failure = &dialFailure{}
+		b.failures[addr] = failure
+	}
+	failure.count++
+	failure.retryAt = now.Add(b.delay(failure.count))
+	failure.err = err
+}
+
+// succeed resets the backoff of @addr after it is dialed, its failures are removed
+func (b *dialBackoff) succeed(addr string) {
+	b.mu.Lock()
+	delete(b.failures, addr)
+	b.mu.Unlock()
+}
@@ -0,0 +1,174 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/net/http2"
+
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+func TestDialBackoffDelay(t *testing.T) {
+	assert.Nil(t, newDialBackoff(tconfig.Backoff{}))
+
+	// delay grows by multiplier until max delay
+	b := newDialBackoff(tconfig.Backoff{BaseDelay: 100 * time.Millisecond, Multiplier: 2, MaxDelay: time.Second})
+	var delays []time.Duration
+	for count := 1; count <= 6; count++ {
+		delays = append(delays, b.delay(count))
+	}
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second, time.Second}, delays)
+
+	// delays of the same attempt are spread by jitter, so clients don't redial at once after server restarts
+	b = newDialBackoff(tconfig.DefaultBackoff)
+	for count := 1; count <= 12; count++ {
+		d := math.Min(float64(time.Second)*math.Pow(1.6, float64(count-1)), float64(120*time.Second))
+		distinct := make(map[time.Duration]struct{})
+		for i := 0; i < 20; i++ {
+			delay := b.delay(count)
+			distinct[delay] = struct{}{}
+			assert.True(t, float64(delay) >= d*0.8 && float64(delay) <= d*1.2, "delay %v of attempt %d", delay, count)
+		}
+		assert.True(t, len(distinct) > 1)
+	}
+}
+
+func TestClientConnPoolDialBackoff(t *testing.T) {
+	var dialed int
+	dialErr := errors.New("connection refused")
+	pool := newClientConnPool(&http2.Transport{}, tconfig.Option{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed++
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, dialErr
+		},
+		ConnectParams: tconfig.ConnectParams{
+			Backoff: tconfig.Backoff{BaseDelay: time.Second, Multiplier: 2, MaxDelay: time.Minute},
+		},
+	})
+	now := time.Now()
+	pool.backoff.now = func() time.Time {
+		return now
+	}
+
+	_, err := pool.getClientConn(context.Background(), "127.0.0.1:20000")
+	assert.Equal(t, dialErr, err)
+	assert.Equal(t, 1, dialed)
+	// rpcs fail fast with the last dial error during backoff
+	_, err = pool.getClientConn(context.Background(), "127.0.0.1:20000")
+	assert.True(t, errors.Is(err, dialErr))
+	assert.Contains(t, err.Error(), "backed off")
+	assert.Equal(t, 1, dialed)
+	// other addresses are not affected
+	_, err = pool.getClientConn(context.Background(), "127.0.0.1:20001")
+	assert.Equal(t, dialErr, err)
+	assert.Equal(t, 2, dialed)
+
+	// redialed after the delay, which is doubled after the second failure
+	now = now.Add(time.Second)
+	_, err = pool.getClientConn(context.Background(), "127.0.0.1:20000")
+	assert.Equal(t, dialErr, err)
+	assert.Equal(t, 3, dialed)
+	now = now.Add(time.Second)
+	_, err = pool.getClientConn(context.Background(), "127.0.0.1:20000")
+	assert.True(t, errors.Is(err, dialErr))
+	assert.Equal(t, 3, dialed)
+	now = now.Add(time.Second)
+	_, _ = pool.getClientConn(context.Background(), "127.0.0.1:20000")
+	assert.Equal(t, 4, dialed)
+
+	// dial aborted by ctx of rpc doesn't start backoff
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.getClientConn(ctx, "127.0.0.1:20002")
+	assert.Equal(t, context.Canceled, err)
+	_, err = pool.getClientConn(context.Background(), "127.0.0.1:20002")
+	assert.Equal(t, dialErr, err)
+	assert.Equal(t, 6, dialed)
+
+	// failures of addresses not redialed for MaxDelay after their backoff are dropped
+	now = now.Add(2 * time.Minute)
+	_, _ = pool.getClientConn(context.Background(), "127.0.0.1:20000")
+	pool.backoff.mu.Lock()
+	assert.Equal(t, 1, len(pool.backoff.failures))
+	pool.backoff.mu.Unlock()
+}
+
+func TestClientConnPoolDialBackoffReset(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lst.Close()
+	addr := lst.Addr().String()
+
+	fail := true
+	dialErr := errors.New("connection refused")
+	pool := newClientConnPool(&http2.Transport{}, tconfig.Option{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if fail {
+				return nil, dialErr
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+		ConnectParams: tconfig.ConnectParams{
+			Backoff: tconfig.Backoff{BaseDelay: time.Second, Multiplier: 2, MaxDelay: time.Minute},
+		},
+	})
+	defer pool.close()
+	now := time.Now()
+	pool.backoff.now = func() time.Time {
+		return now
+	}
+
+	for i := 0; i < 3; i++ {
+		_, _ = pool.getClientConn(context.Background(), addr)
+		now = now.Add(time.Minute)
+	}
+	pool.backoff.mu.Lock()
+	assert.Equal(t, 3, pool.backoff.failures[addr].count)
+	pool.backoff.mu.Unlock()
+
+	// the failures are removed after the address is dialed, the next failure starts from BaseDelay
+	fail = false
+	_, err = pool.getClientConn(context.Background(), addr)
+	assert.Nil(t, err)
+	pool.backoff.mu.Lock()
+	assert.Equal(t, 0, len(pool.backoff.failures))
+	pool.backoff.mu.Unlock()
+	fail = true
+	pool.backoff.fail(addr, dialErr)
+	assert.NotNil(t, pool.backoff.check(addr))
+	now = now.Add(time.Second)
+	assert.Nil(t, pool.backoff.check(addr))
+}
@@ -3,9 +3,12 @@ package http2
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
-	"net"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -18,6 +21,7 @@ import (
 
 import (
 	_ "github.com/dubbogo/triple/internal/codec"
+	"github.com/dubbogo/triple/internal/codes"
 	"github.com/dubbogo/triple/internal/message"
 	"github.com/dubbogo/triple/pkg/common"
 	"github.com/dubbogo/triple/pkg/common/constant"
@@ -26,48 +30,151 @@ import (
 	"github.com/dubbogo/triple/pkg/http2/config"
 )
 
+// streamTrailerDrainTimeout is the max time to wait for trailer of stream terminated by client, see terminatedTrailer
+const streamTrailerDrainTimeout = 5 * time.Second
+
 func NewClient(option tconfig.Option) *Client {
 	headerHandler, err := common.GetPackagerHandler(tconfig.NewTripleOption(tconfig.WithProtocol(constant.TRIPLE)))
 	if err != nil {
 		panic(err)
 	}
+	if option.Logger == nil {
+		option.Logger = logger.NopLogger{}
+	}
+	transport := &h2.Transport{
+		// zero means the default of http2
+		MaxHeaderListSize: option.MaxHeaderListSize,
+	}
+	pool := newClientConnPool(transport, option)
+	transport.ConnPool = pool
 	client := http.Client{
-		Transport: &h2.Transport{
-			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-				return net.Dial(network, addr)
-			},
-		},
+		Transport: transport,
 	}
 	return &Client{
 		frameHandler: headerHandler,
 		logger:       option.Logger,
 		client:       client,
+		pool:         pool,
 	}
 }
 
 type Client struct {
 	client       http.Client
+	pool         *clientConnPool
 	frameHandler common.PackageHandler
 	logger       logger.Logger
+	compressors  compressorCache
+}
+
+// Dial establishes http2 conn to @addr in advance, it returns error if @ctx is done before the conn is set up.
+// The conn is reused by following requests to @addr.
+func (h *Client) Dial(ctx context.Context, addr string) error {
+	_, err := h.pool.getClientConn(ctx, addr)
+	return err
+}
+
+// WarmUp dials http2 conn to @addr like Dial, and waits for a PING round trip on it, so that the http2 handshake
+// with server is finished. It is safe to call it concurrently and repeatedly, the conn is dialed only once.
+func (h *Client) WarmUp(ctx context.Context, addr string) error {
+	cc, err := h.pool.getClientConn(ctx, addr)
+	if err != nil {
+		return err
+	}
+	return cc.Ping(ctx)
+}
+
+// LastGoAwayStreamID returns the last stream id of the latest GOAWAY received from servers, streams with larger ids on
+// the conn are not processed by server, so they are safe to retry on a new conn. It returns false if no GOAWAY is
+// received. GOAWAY of an address is forgotten once its conn is replaced by a new conn to the address.
+func (h *Client) LastGoAwayStreamID() (uint32, bool) {
+	return h.pool.lastGoAway()
+}
+
+// CloseConnsExcept closes conns of addresses not in @addresses gracefully, each conn is closed after the requests on
+// it are finished, and new requests to the addresses dial new conns
+func (h *Client) CloseConnsExcept(addresses []string) {
+	h.pool.closeExcept(addresses)
+}
+
+// Close closes conns of the client gracefully, each conn is closed after the requests on it are finished, and new
+// requests fail at once. It is safe to call it repeatedly.
+func (h *Client) Close() {
+	h.pool.close()
 }
 
 func (h *Client) StreamPost(addr, path string, sendChan chan *bytes.Buffer, opts *config.PostConfig) (chan *bytes.Buffer, chan http.Header, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// the stream is opened on a ready conn, so that StreamPost is bounded by @ctx like Post, instead of failing later
+	if err := h.Dial(ctx, addr); err != nil {
+		h.logger.Errorf("http2.Client.StreamPost: dial %s for stream %s error = %v", addr, path, err)
+		return nil, nil, streamOpenError(ctx, path, err)
+	}
+	// reqCtx is canceled to reset the stream if a request message can't be sent, the error is set before that
+	reqCtx, cancelReq := context.WithCancel(ctx)
+	var sendErrLock sync.Mutex
+	var sendErr error
+	failSend := func(err error) {
+		sendErrLock.Lock()
+		if sendErr == nil {
+			sendErr = err
+		}
+		sendErrLock.Unlock()
+		cancelReq()
+	}
+	getSendErr := func() error {
+		sendErrLock.Lock()
+		defer sendErrLock.Unlock()
+		return sendErr
+	}
 	sendStreamChan := make(chan h2Triple.BufferMsg)
 	closeChan := make(chan struct{})
 	recvChan := make(chan *bytes.Buffer)
 	trailerChan := make(chan http.Header)
+	terminate := func(cause error) {
+		if opts.OnTerminate != nil {
+			opts.OnTerminate(cause)
+		}
+	}
 	// receive message from sendChan
 	go func() {
 		for {
 			select {
 			case <-closeChan:
 				return
+			case <-reqCtx.Done():
+				// the request stream is reset by transport, unblock its body writer which waits for messages
+				select {
+				case sendStreamChan <- h2Triple.BufferMsg{
+					Buffer:  bytes.NewBuffer([]byte{}),
+					MsgType: h2Triple.MsgType(message.ServerStreamCloseMsgType),
+				}:
+				case <-closeChan:
+				}
+				return
 			case sendMsg := <-sendChan:
 				if sendMsg == nil {
+					// nil message means the caller has nothing more to send, end the request stream
+					sendStreamChan <- h2Triple.BufferMsg{
+						Buffer:  bytes.NewBuffer([]byte{}),
+						MsgType: h2Triple.MsgType(message.ServerStreamCloseMsgType),
+					}
 					return
 				}
+				sendData, err := frameData(h.frameHandler, sendMsg.Bytes(), opts.Compressor)
+				if err != nil {
+					// the message can't be skipped silently, the stream fails with Internal and is reset
+					h.logger.Errorf("http2 request compress error = %s", err)
+					failSend(err)
+					continue
+				}
+				if opts.OnMessage != nil {
+					opts.OnMessage(true, opts.Compressor != nil, sendMsg.Len(), len(sendData)-messageHeaderLen)
+				}
 				sendStreamChan <- h2Triple.BufferMsg{
-					Buffer:  bytes.NewBuffer(h.frameHandler.Pkg2FrameData(sendMsg.Bytes())),
+					Buffer:  bytes.NewBuffer(sendData),
 					MsgType: h2Triple.DataMsgType,
 				}
 			}
@@ -77,45 +184,259 @@ func (h *Client) StreamPost(addr, path string, sendChan chan *bytes.Buffer, opts
 		SendChan: sendStreamChan,
 		Handler:  NewProtocolHeaderHandlerImpl(opts.HeaderField),
 	}
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, "https://"+addr+path, &streamReq)
+	if err != nil {
+		cancelReq()
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", opts.ContentType)
+	if opts.Authority != "" {
+		req.Host = opts.Authority
+	}
 	go func() {
-		rsp, err := h.client.Post("https://"+addr+path, opts.ContentType, &streamReq)
+		defer cancelReq()
+		rsp, err := h.client.Do(req)
 		if err != nil {
 			h.logger.Errorf("http2 request error = %s", err)
-			// close send stream and return
+			// close send stream and return, with the error told by trailer
 			close(closeChan)
+			close(recvChan)
+			code, cause, msg := codes.Unavailable, transportErrCause(err), err.Error()
+			if reqCtx.Err() != nil {
+				code, cause = contextErrCode(reqCtx.Err()), common.Cause(reqCtx)
+				if sendErr := getSendErr(); sendErr != nil {
+					code, cause, msg = codes.Internal, sendErr, sendErrorMessage(sendErr)
+				}
+			}
+			terminate(cause)
+			trailerChan <- newStatusTrailer(code, msg)
 			return
 		}
-		ch := readSplitData(context.Background(), rsp.Body)
+		if rsp.StatusCode != http.StatusOK {
+			// the request is rejected before reaching the service, e.g. by header list size of server, no trailer comes
+			_ = rsp.Body.Close()
+			close(closeChan)
+			close(recvChan)
+			msg := httpStatusErrMsg(rsp.StatusCode)
+			terminate(errors.New(msg))
+			trailerChan <- newStatusTrailer(httpStatusErrCode(rsp.StatusCode), msg)
+			return
+		}
+		// terminated is called after reqCtx is done
+		terminated := func() http.Header {
+			if sendErr := getSendErr(); sendErr != nil {
+				terminate(sendErr)
+				drainTrailer(rsp)
+				return newStatusTrailer(codes.Internal, sendErrorMessage(sendErr))
+			}
+			terminate(common.Cause(reqCtx))
+			return terminatedTrailer(reqCtx, rsp)
+		}
+		if opts.OnResponseHeader != nil {
+			opts.OnResponseHeader(rsp.Header)
+		}
+		decompressor, err := h.compressors.getCompressor(rsp.Header.Get(constant.GrpcEncoding), constant.DefaultCompressionLevel)
+		if err != nil {
+			h.logger.Errorf("http2 response decompressor error = %s", err)
+		}
+		body := &readErrorBody{ReadCloser: rsp.Body}
+		// decompressErr is set before ch is closed
+		var decompressErr error
+		ch := readSplitData(context.Background(), body, false, decompressor, func(err error) {
+			h.logger.Errorf("http2 decompress response message of path %s error = %v", path, err)
+			decompressErr = err
+		}, func(compressed bool, size, wireSize int) {
+			if opts.OnMessage != nil {
+				opts.OnMessage(false, compressed, size, wireSize)
+			}
+		})
 	Loop:
 		for {
 			select {
 			case <-closeChan:
 				close(recvChan)
 				break Loop
+			case <-reqCtx.Done():
+				close(recvChan)
+				trailerChan <- terminated()
+				return
 			case data := <-ch:
 				if data == nil {
 					close(recvChan)
+					if decompressErr != nil {
+						// the rest of response is discarded, the stream is reset
+						_ = rsp.Body.Close()
+						drainTrailer(rsp)
+						terminate(decompressErr)
+						trailerChan <- newStatusTrailer(decompressErrorStatus(decompressErr))
+						return
+					}
+					if err := body.err; err != nil && err != io.EOF && reqCtx.Err() == nil {
+						// the conn is lost, trailer never comes
+						terminate(transportErrCause(err))
+						trailerChan <- newStatusTrailer(codes.Unavailable, "stream is terminated by transport error: "+err.Error())
+						return
+					}
 					break Loop
 				}
-				recvChan <- bytes.NewBuffer(data.Bytes())
+				select {
+				case recvChan <- bytes.NewBuffer(data.Bytes()):
+				case <-reqCtx.Done():
+					close(recvChan)
+					trailerChan <- terminated()
+					return
+				}
 			}
 		}
-		trailer := rsp.Body.(*h2Triple.ResponseBody).GetTrailer()
 		// todo streaming error
 		//if status, err := strconv.Atoi(trailer.Get(constant.TrailerKeyHttp2Status)); err != nil ||status != 0 {
 		//
 		//}
-		trailerChan <- trailer
+		select {
+		case trailer := <-rsp.Body.(*h2Triple.ResponseBody).GetTrailerChan():
+			trailerChan <- trailer
+		case <-reqCtx.Done():
+			trailerChan <- terminated()
+		}
 	}()
 	return recvChan, trailerChan, nil
 }
 
+// drainTrailer drains trailer of stream @rsp reset by client in background. Its trailer never comes, except the one
+// being delivered by transport before reset, otherwise the read loop of conn is blocked by it.
+func drainTrailer(rsp *http.Response) {
+	go func() {
+		timer := time.NewTimer(streamTrailerDrainTimeout)
+		defer timer.Stop()
+		select {
+		case <-rsp.Body.(*h2Triple.ResponseBody).GetTrailerChan():
+		case <-timer.C:
+		}
+	}()
+}
+
+// sendErrorMessage returns grpc message of stream failed because a request message can't be sent with @err
+func sendErrorMessage(err error) string {
+	return "grpc: failed to compress the request message: " + err.Error()
+}
+
+// newStatusTrailer returns trailer of rpc failed at client side with grpc status @code and @msg
+func newStatusTrailer(code codes.Code, msg string) http.Header {
+	trailer := make(http.Header)
+	trailer.Set(constant.TrailerKeyGrpcStatus, strconv.Itoa(int(code)))
+	trailer.Set(constant.TrailerKeyGrpcMessage, msg)
+	return trailer
+}
+
+// terminatedTrailer returns trailer of stream @rsp terminated by client with @ctx
+func terminatedTrailer(ctx context.Context, rsp *http.Response) http.Header {
+	drainTrailer(rsp)
+	return newStatusTrailer(contextErrCode(ctx.Err()), "stream is terminated by client: "+ctx.Err().Error())
+}
+
+// unaryTimeoutError returns error of unary rpc @path exceeding Option.Timeout, which is the deadline of client
+func unaryTimeoutError(path string) error {
+	return common.NewTripleErrorWithCause("http2.Client.Post: http2 unary call "+path+" timeout",
+		int(codes.DeadlineExceeded), context.DeadlineExceeded, nil)
+}
+
+// unaryTerminatedError returns error of unary rpc @path terminated because @ctx is done, which unwraps to the cause of
+// @ctx
+func unaryTerminatedError(ctx context.Context, path string) error {
+	return common.NewTripleErrorWithCause("http2.Client.Post: http2 unary call "+path+" is terminated by client: "+ctx.Err().Error(),
+		int(contextErrCode(ctx.Err())), common.Cause(ctx), nil)
+}
+
+// streamOpenError returns error of stream @path which fails to be opened with dial error @err
+func streamOpenError(ctx context.Context, path string, err error) error {
+	if ctx.Err() != nil {
+		return common.NewTripleErrorWithCause("http2.Client.StreamPost: stream "+path+" is not opened before ctx is done: "+ctx.Err().Error(),
+			int(contextErrCode(ctx.Err())), common.Cause(ctx), nil)
+	}
+	return common.NewTripleErrorWithCause("http2.Client.StreamPost: stream "+path+" is not opened: "+err.Error(),
+		int(codes.Unavailable), transportErrCause(err), nil)
+}
+
+// drainSplitData discards split data from @ch until it is closed, so that the read go routine is not blocked
+func drainSplitData(ch chan message.Message) {
+	for range ch {
+	}
+}
+
+// readErrorBody records the error of reading body, which is the transport error if the conn is lost
+type readErrorBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *readErrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// transportErrCause returns the cause of rpc failed by transport error @err, which is common.ErrServerGoAway if the
+// conn is closed after GOAWAY
+func transportErrCause(err error) error {
+	var goAway h2.GoAwayError
+	if errors.As(err, &goAway) {
+		return common.ErrServerGoAway
+	}
+	return err
+}
+
+// httpStatusErrCode returns grpc status code of response with non-200 http status @status, the same as grpc-go
+func httpStatusErrCode(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	return codes.Unknown
+}
+
+// httpStatusErrMsg returns grpc message of response with non-200 http status @status
+func httpStatusErrMsg(status int) string {
+	return fmt.Sprintf("unexpected HTTP status code received from server: %d (%s)", status, http.StatusText(status))
+}
+
+// contextErrCode returns grpc status code of ctx error @err
+func contextErrCode(err error) codes.Code {
+	if err == context.DeadlineExceeded {
+		return codes.DeadlineExceeded
+	}
+	return codes.Canceled
+}
+
 func (h *Client) Post(addr, path string, data []byte, opts *config.PostConfig) ([]byte, http.Header, error) {
-	h.logger.Debugf("http2.Client.Post: with addr = %s, path = %s, data = %s, opts = %+v", addr, path, string(data), opts)
+	if logger.DebugEnabled(h.logger) {
+		h.logger.Debugf("http2.Client.Post: with addr = %s, path = %s, data = %s, opts = %+v", addr, path, string(data), opts)
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	sendStreamChan := make(chan h2Triple.BufferMsg, 2)
 
+	sendData, err := frameData(h.frameHandler, data, opts.Compressor)
+	if err != nil {
+		h.logger.Errorf("http2.Client.Post: compress request error = %v", err)
+		return nil, nil, err
+	}
+	if opts.OnMessage != nil {
+		opts.OnMessage(true, opts.Compressor != nil, len(data), len(sendData)-messageHeaderLen)
+	}
 	sendStreamChan <- h2Triple.BufferMsg{
-		Buffer:  bytes.NewBuffer(h.frameHandler.Pkg2FrameData(data)),
+		Buffer:  bytes.NewBuffer(sendData),
 		MsgType: h2Triple.MsgType(message.DataMsgType),
 	}
 
@@ -130,9 +451,42 @@ func (h *Client) Post(addr, path string, data []byte, opts *config.PostConfig) (
 		Handler:  NewProtocolHeaderHandlerImpl(opts.HeaderField),
 	}
 
-	rsp, err := h.client.Post("https://"+addr+path, opts.ContentType, &stremaReq)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+addr+path, &stremaReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", opts.ContentType)
+	if opts.Authority != "" {
+		req.Host = opts.Authority
+	}
+	if opts.SetContentLength {
+		// the only message is fully buffered, so its length is known before sending
+		req.ContentLength = int64(len(sendData))
+	}
+	rsp, err := h.client.Do(req)
 	if err != nil {
 		h.logger.Errorf("http2.Client.Post: dubbo3 http2 post err = %v\n", err)
+		if ctx.Err() != nil {
+			return nil, nil, unaryTerminatedError(ctx, path)
+		}
+		if cause := transportErrCause(err); cause != err {
+			return nil, nil, common.NewTripleErrorWithCause(err.Error(), int(codes.Unavailable), cause, nil)
+		}
+		return nil, nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		// the request is rejected before reaching the service, e.g. by header list size of server, no trailer comes
+		_ = rsp.Body.Close()
+		h.logger.Errorf("http2.Client.Post: http2 unary call %s with addr = %s got http status %d", path, addr, rsp.StatusCode)
+		return nil, nil, common.NewTripleError(httpStatusErrMsg(rsp.StatusCode), int(httpStatusErrCode(rsp.StatusCode)), "", nil)
+	}
+	if opts.OnResponseHeader != nil {
+		opts.OnResponseHeader(rsp.Header)
+	}
+
+	decompressor, err := h.compressors.getCompressor(rsp.Header.Get(constant.GrpcEncoding), constant.DefaultCompressionLevel)
+	if err != nil {
+		h.logger.Errorf("http2.Client.Post: response decompressor error = %v", err)
 		return nil, nil, err
 	}
 
@@ -148,8 +502,13 @@ func (h *Client) Post(addr, path string, data []byte, opts *config.PostConfig) (
 	readDone := make(chan struct{})
 
 	fromFrameHeaderDataSize := uint32(0)
+	compressed := false
+	// received is true if header of response message is received
+	received := false
 
 	splitedDataChan := make(chan message.Message)
+	// readErr is the error of reading body other than EOF, it is set before splitedDataChan is closed
+	var readErr error
 
 	go func() {
 		defer close(splitedDataChan)
@@ -165,6 +524,7 @@ func (h *Client) Post(addr, path string, data []byte, opts *config.PostConfig) (
 			if err != nil {
 				if err.Error() != "EOF" {
 					h.logger.Errorf("http2.Client.Post: dubbo3 unary invoke read error = %v\n", err)
+					readErr = err
 				}
 				// [normal close], read finished or no read body, return
 				return
@@ -185,6 +545,15 @@ Loop:
 		select {
 		case dataMsg := <-splitedDataChan:
 			if dataMsg.Buffer == nil {
+				if readErr != nil && ctx.Err() != nil {
+					// the stream is reset because @ctx is done
+					return nil, nil, unaryTerminatedError(ctx, path)
+				}
+				if readErr != nil {
+					// the conn is lost, trailer never comes
+					return nil, nil, common.NewTripleErrorWithCause("http2.Client.Post: http2 unary call "+path+" read error = "+readErr.Error(),
+						int(codes.Unavailable), transportErrCause(readErr), nil)
+				}
 				// read finished with empty body, maybe error status
 				// [normal close]
 				break Loop
@@ -193,6 +562,8 @@ Loop:
 			if fromFrameHeaderDataSize == 0 {
 				// should parse data frame header first
 				var totalSize uint32
+				compressed = isCompressed(splitedData)
+				received = true
 				if splitedData, totalSize = h.frameHandler.Frame2PkgData(splitedData); totalSize == 0 {
 					// [normal close]
 					break Loop
@@ -226,12 +597,18 @@ Loop:
 			// 3. set timeout flag
 			timeoutFlag = true
 			break Loop
+		case <-ctx.Done():
+			// the stream is reset by transport, read go routine exits after the body read fails
+			close(readDone)
+			go drainSplitData(splitedDataChan)
+			h.logger.Errorf("http2.Client.Post: http2 unary call %s with addr = %s is terminated by client: %v", path, addr, ctx.Err())
+			return nil, nil, unaryTerminatedError(ctx, path)
 		}
 	}
 
 	if timeoutFlag {
 		h.logger.Error("http2.Client.Post: http2 unary call" + path + " with addr = " + addr + " timeout")
-		return nil, nil, perrors.Errorf("http2.Client.Post: http2 unary call %s timeout", path)
+		return nil, nil, unaryTimeoutError(path)
 	}
 
 	select {
@@ -239,12 +616,29 @@ Loop:
 		break
 	case <-timeoutTicker:
 		timeoutFlag = true
+	case <-ctx.Done():
+		h.logger.Errorf("http2.Client.Post: http2 unary call %s with addr = %s is terminated by client: %v", path, addr, ctx.Err())
+		return nil, nil, unaryTerminatedError(ctx, path)
 	}
 
 	if timeoutFlag {
 		h.logger.Error("http2.Client.Post: http2 unary call" + path + " with addr = " + addr + " timeout")
-		return nil, nil, perrors.Errorf("http2.Client.Post: http2 unary call %s timeout", path)
+		return nil, nil, unaryTimeoutError(path)
 	}
 
+	if compressed {
+		rspData, err := decompress(decompressor, splitBuffer.Bytes())
+		if err != nil {
+			h.logger.Errorf("http2.Client.Post: decompress response error = %v", err)
+			return nil, nil, err
+		}
+		if opts.OnMessage != nil {
+			opts.OnMessage(false, true, len(rspData), splitBuffer.Len())
+		}
+		return rspData, trailer, nil
+	}
+	if received && opts.OnMessage != nil {
+		opts.OnMessage(false, false, splitBuffer.Len(), splitBuffer.Len())
+	}
 	return splitBuffer.Bytes(), trailer, nil
 }
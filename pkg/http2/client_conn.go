@@ -0,0 +1,178 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/logger"
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+/*
+clientConn sniffs http2 frames from bytes of both directions of client conn once, and handles them by callbacks:
+
+  - frames of both directions are told to FrameObserver if it is set.
+  - the last stream id of each GOAWAY received is told to onGoAway, streams with larger ids are not processed by
+    server, which are safe to retry on a new conn.
+  - PUSH_PROMISE is disallowed by SETTINGS_ENABLE_PUSH=0 of client. The transport already treats it as connection
+    error and closes the conn, but its GOAWAY is never flushed, so GOAWAY of PROTOCOL_ERROR is written when the conn
+    is closed. Frames of unknown types are ignored by the transport as http2 requires.
+  - with StrayFrameReject, frames received after END_STREAM of stream are recognized by strayFrameTracker. The
+    transport reads the bytes before the stray frame, and then the conn is closed with GOAWAY of STREAM_CLOSED.
+
+GOAWAY is written between frames of client, it is skipped if client is writing a frame halfway, and conn is closed
+anyway.
+*/
+type clientConn struct {
+	net.Conn
+	addr   string
+	logger logger.Logger
+
+	readSniffer  *frameSniffer
+	writeSniffer *frameSniffer
+	// writeLock makes frame writes of client and GOAWAY not interleaved
+	writeLock sync.Mutex
+	observer  tconfig.FrameObserver
+	// stray is nil unless the strategy is StrayFrameReject
+	stray      *strayFrameTracker
+	rejectOnce sync.Once
+
+	lock     sync.Mutex
+	pushed   bool
+	goAwayed bool
+}
+
+// clientConnOption is the way clientConn handles frames sniffed
+type clientConnOption struct {
+	observer           tconfig.FrameObserver
+	onGoAway           func(lastStreamID uint32)
+	strayFrameStrategy tconfig.StrayFrameStrategy
+	logger             logger.Logger
+}
+
+func newClientConn(conn net.Conn, addr string, option clientConnOption) net.Conn {
+	c := &clientConn{
+		Conn:     conn,
+		addr:     addr,
+		logger:   option.logger,
+		observer: option.observer,
+	}
+	c.readSniffer = &frameSniffer{observer: c.onFrame, onGoAway: option.onGoAway}
+	c.writeSniffer = &frameSniffer{observer: option.observer, outbound: true, prefaceLeft: len(h2.ClientPreface)}
+	if option.strayFrameStrategy == tconfig.StrayFrameReject {
+		c.stray = newStrayFrameTracker(addr)
+	}
+	return c
+}
+
+func (c *clientConn) Read(b []byte) (int, error) {
+	if c.stray == nil {
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			c.readSniffer.feed(b[:n])
+		}
+		return n, err
+	}
+	if c.stray.rejectErr != nil {
+		c.reject()
+		return 0, c.stray.rejectErr
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if fed := c.readSniffer.feedUntil(b[:n], c.stray.isStray); fed < n {
+			// the stray frame is read by transport in next Read, which fails
+			if fed == 0 {
+				c.reject()
+				return 0, c.stray.rejectErr
+			}
+			return fed, nil
+		}
+	}
+	return n, err
+}
+
+func (c *clientConn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.writeSniffer.feed(b[:n])
+	}
+	return n, err
+}
+
+func (c *clientConn) onFrame(info tconfig.FrameInfo) {
+	if info.Type == h2.FramePushPromise {
+		c.lock.Lock()
+		c.pushed = true
+		c.lock.Unlock()
+	}
+	if c.observer != nil {
+		c.observer(info)
+	}
+}
+
+// Close sends GOAWAY with PROTOCOL_ERROR to server before closing conn if server has sent PUSH_PROMISE, writing is
+// best effort
+func (c *clientConn) Close() error {
+	c.lock.Lock()
+	pushed := c.pushed
+	c.lock.Unlock()
+	if pushed {
+		c.writeGoAway(h2.ErrCodeProtocol, "push_promise_disallowed")
+	}
+	return c.Conn.Close()
+}
+
+// reject writes GOAWAY of STREAM_CLOSED to server for the stray frame received and closes the conn
+func (c *clientConn) reject() {
+	c.rejectOnce.Do(func() {
+		if c.logger != nil {
+			c.logger.Warnf("http2 client: close conn to %s, error = %v", c.addr, c.stray.rejectErr)
+		}
+		c.writeGoAway(h2.ErrCodeStreamClosed, "frame_after_end_stream")
+		_ = c.Conn.Close()
+	})
+}
+
+// writeGoAway writes GOAWAY with @code and @debug to server if it isn't written yet, writing is best effort
+func (c *clientConn) writeGoAway(code h2.ErrCode, debug string) {
+	c.lock.Lock()
+	goAwayed := c.goAwayed
+	c.goAwayed = true
+	c.lock.Unlock()
+	if goAwayed {
+		return
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.writeSniffer.atFrameBoundary() {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(refuseConnWriteTimeout))
+		// client accepts no stream of server, so the last stream id is 0
+		_ = h2.NewFramer(c.Conn, nil).WriteGoAway(0, code, []byte(debug))
+	}
+}
@@ -1,12 +1,35 @@
 package config
 
 import (
+	"context"
 	"net/http"
 )
 
+import (
+	"github.com/dubbogo/triple/pkg/common"
+)
+
 type PostConfig struct {
 	ContentType string
 	BufferSize  uint32
 	Timeout     uint32
 	HeaderField http.Header
+	// Compressor compresses request messages, if nil, messages are not compressed
+	Compressor common.Compressor
+	// OnResponseHeader is called with response @header when it arrives, if it's not nil
+	OnResponseHeader func(header http.Header)
+	// OnMessage is called with each request message sent and response message received, if it's not nil. @size is
+	// the length of message before compression, and @wireSize is the one on the wire, without header of message.
+	// It may be called concurrently for request and response messages of stream.
+	OnMessage func(outbound, compressed bool, size, wireSize int)
+	// Authority is the :authority of request, if empty, the address is used
+	Authority string
+	// SetContentLength makes Post send content-length of the framed request message
+	SetContentLength bool
+	// Context cancels the request of Post or StreamPost by RST_STREAM when it is done, if it's nil, the request is not
+	// cancelled
+	Context context.Context
+	// OnTerminate is called with the cause before StreamPost makes up the status trailer of stream terminated at
+	// client side, e.g. by Context or conn lost after GOAWAY, if it's not nil
+	OnTerminate func(cause error)
 }
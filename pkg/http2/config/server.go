@@ -1,12 +1,25 @@
 package config
 
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
 import (
 	"github.com/dubbogo/triple/pkg/common"
 	"github.com/dubbogo/triple/pkg/common/logger"
+	"github.com/dubbogo/triple/pkg/common/peer"
+	tconfig "github.com/dubbogo/triple/pkg/config"
 )
 
 type ServerConfig struct {
-	// Logger is User defined logger, if empty, use default.
+	// Listener accepts conns instead of listening on the address of server, if empty, listen on tcp address.
+	// It is closed when server is stopped.
+	Listener net.Listener
+
+	// Logger is User defined logger, if empty, logs are discarded.
 	Logger logger.Logger
 
 	// PathExtractor extracts interface name from path, if empty, use default
@@ -42,7 +55,7 @@ type ServerConfig struct {
 			```
 		Another example is with gr pool limitation scene:
 			```go
-			func(path string, header http.Header, recvChan chan *bytes.Buffer,
+			func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
 				sendChan chan *bytes.Buffer, ctrlch chan http.Header,
 				errCh chan interface{}) {
 				var (
@@ -69,4 +82,52 @@ type ServerConfig struct {
 			```
 	*/
 	HandlerGRManagedByUser bool
+
+	// OnConnect is called when a new conn is accepted, the returned ctx is the parent of all requests' ctx on this conn
+	OnConnect func(ctx context.Context, p *peer.Peer) context.Context
+
+	// OnDisconnect is called exactly once when the conn is closed
+	OnDisconnect func(p *peer.Peer)
+
+	// EnableBufferPool makes received messages' buffer got from pool, Handler should release them by buffer.PutBuffer
+	EnableBufferPool bool
+
+	// CompressionLevel is the level of compressor to compress response messages
+	CompressionLevel int
+
+	// FrameObserver observes http2 frames of accepted conns, it is only for protocol debugging
+	FrameObserver tconfig.FrameObserver
+
+	// MaxConnections is the max number of simultaneous conns, zero means no limitation
+	MaxConnections int
+
+	// MinPingInterval and PermitWithoutStream are the keepalive enforcement policy, see tconfig.Option
+	MinPingInterval     time.Duration
+	PermitWithoutStream bool
+	// HeaderReadTimeout is the max time of receiving a header block, zero means no limitation, see tconfig.Option
+	HeaderReadTimeout time.Duration
+	// StreamWriteTimeout is the max time of writing a response message, zero means no limitation, see tconfig.Option
+	StreamWriteTimeout time.Duration
+
+	// TCPKeepalive is applied to accepted conns
+	TCPKeepalive tconfig.TCPKeepalive
+
+	// MaxFrameSize is SETTINGS_MAX_FRAME_SIZE advertised to client, zero means the default of http2
+	MaxFrameSize uint32
+
+	// StreamWindowSize and ConnWindowSize are initial flow control windows of receiving requests, zero means the
+	// default of http2
+	StreamWindowSize int32
+	ConnWindowSize   int32
+	// MaxHeaderListSize is the max size of request header list, zero means the default of http2
+	MaxHeaderListSize uint32
+	// MaxRequestBytes is the max combined size of header list and body of request, non-positive means no limitation
+	MaxRequestBytes int
+
+	// TLSConfig makes server accept http2 over TLS with ALPN protocols NextProtos, if nil, server accepts h2c
+	TLSConfig  *tls.Config
+	NextProtos []string
+
+	// AccessLogSink receives access log of each request, if nil, access logs are not recorded
+	AccessLogSink tconfig.AccessLogSink
 }
@@ -0,0 +1,297 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+)
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/logger"
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+var errClientConnPoolClosed = perrors.New("http2 client is closed")
+
+func defaultDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// clientConnPool keeps one http2 client conn for each address, which is dialed by Client.Dial,
+// or by the first request to the address.
+type clientConnPool struct {
+	t         *h2.Transport
+	dial      func(ctx context.Context, network, addr string) (net.Conn, error)
+	observer  tconfig.FrameObserver
+	keepalive tconfig.TCPKeepalive
+	logger    logger.Logger
+	// backoff is nil if addresses are redialed without backoff
+	backoff *dialBackoff
+	// tlsConfig is nil if conns speak h2c
+	tlsConfig *tls.Config
+	// strayFrameStrategy decides how frames received after END_STREAM of stream are handled
+	strayFrameStrategy tconfig.StrayFrameStrategy
+
+	mu    sync.Mutex
+	conns map[string]*h2.ClientConn
+	// goAways are the GOAWAYs received by the latest conn stored of each address, the one of an address is forgotten
+	// once its conn is replaced by a new one
+	goAways map[string]*goAwayRecord
+	// goAwaySeq orders GOAWAYs received by all conns
+	goAwaySeq uint64
+	// dialing is the dial in progress of each address, concurrent requests to the address wait for it
+	dialing map[string]*dialCall
+	closed  bool
+}
+
+// goAwayRecord is the latest GOAWAY received by a conn, seq is zero if none
+type goAwayRecord struct {
+	lastStreamID uint32
+	seq          uint64
+}
+
+// dialCall is the dial of an address shared by concurrent requests
+type dialCall struct {
+	done chan struct{}
+	// cc and err are set before done is closed
+	cc  *h2.ClientConn
+	err error
+	// aborted is true if the dial is aborted by ctx of the request dialing it
+	aborted bool
+}
+
+func newClientConnPool(t *h2.Transport, option tconfig.Option) *clientConnPool {
+	dial := option.DialContext
+	if dial == nil {
+		dial = defaultDialContext
+	}
+	if option.ProxyURL != nil || option.ProxyFromEnvironment {
+		dial = newProxyDialContext(dial, option.ProxyURL, option.ProxyFromEnvironment)
+	}
+	return &clientConnPool{
+		t:         t,
+		dial:      dial,
+		observer:  option.FrameObserver,
+		keepalive: option.TCPKeepalive,
+		logger:    option.Logger,
+		backoff:   newDialBackoff(option.ConnectParams.Backoff),
+		tlsConfig: newTLSConfig(option.TLSConfig, option.NextProtos),
+		conns:     make(map[string]*h2.ClientConn),
+		dialing:   make(map[string]*dialCall),
+		goAways:   make(map[string]*goAwayRecord),
+
+		strayFrameStrategy: option.StrayFrameStrategy,
+	}
+}
+
+// lastGoAway returns the last stream id of the latest GOAWAY received by the latest conns of addresses, it returns
+// false if none
+func (p *clientConnPool) lastGoAway() (uint32, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var latest *goAwayRecord
+	for _, record := range p.goAways {
+		if record.seq > 0 && (latest == nil || record.seq > latest.seq) {
+			latest = record
+		}
+	}
+	if latest == nil {
+		return 0, false
+	}
+	return latest.lastStreamID, true
+}
+
+// onGoAway records GOAWAY with @lastStreamID received by the conn of @record
+func (p *clientConnPool) onGoAway(record *goAwayRecord, lastStreamID uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.goAwaySeq++
+	record.lastStreamID = lastStreamID
+	record.seq = p.goAwaySeq
+}
+
+// GetClientConn implements h2.ClientConnPool
+func (p *clientConnPool) GetClientConn(req *http.Request, addr string) (*h2.ClientConn, error) {
+	return p.getClientConn(req.Context(), addr)
+}
+
+// getClientConn returns conn of @addr, the conn is dialed if there isn't one. Concurrent requests to the same address
+// share a single dial, a request waiting for it returns once its own @ctx is done, and dials again if the dial is
+// aborted by ctx of the request dialing it.
+func (p *clientConnPool) getClientConn(ctx context.Context, addr string) (*h2.ClientConn, error) {
+	for {
+		p.mu.Lock()
+		if cc, err := p.getLocked(addr); cc != nil || err != nil {
+			p.mu.Unlock()
+			return cc, err
+		}
+		if call, ok := p.dialing[addr]; ok {
+			p.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if call.aborted {
+				continue
+			}
+			return call.cc, call.err
+		}
+		call := &dialCall{done: make(chan struct{})}
+		p.dialing[addr] = call
+		p.mu.Unlock()
+
+		call.cc, call.err = p.dialClientConn(ctx, addr)
+		call.aborted = call.err != nil && ctx.Err() != nil
+		p.mu.Lock()
+		delete(p.dialing, addr)
+		p.mu.Unlock()
+		close(call.done)
+		return call.cc, call.err
+	}
+}
+
+// dialClientConn dials a new conn to @addr, which is stored in pool
+func (p *clientConnPool) dialClientConn(ctx context.Context, addr string) (*h2.ClientConn, error) {
+	if p.backoff != nil {
+		if err := p.backoff.check(addr); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := p.dial(ctx, "tcp", addr)
+	if err == nil {
+		if err := setTCPKeepalive(conn, p.keepalive); err != nil && p.logger != nil {
+			p.logger.Warnf("http2 client: set tcp keepalive of conn to %s error = %v", addr, err)
+		}
+		if p.tlsConfig != nil {
+			rawConn := conn
+			if conn, err = tlsClientHandshake(ctx, rawConn, addr, p.tlsConfig); err != nil {
+				rawConn.Close()
+			}
+		}
+	}
+	if err != nil {
+		// dial aborted by ctx of rpc is not a failure of address
+		if p.backoff != nil && ctx.Err() == nil {
+			p.backoff.fail(addr, err)
+		}
+		return nil, err
+	}
+	if p.backoff != nil {
+		p.backoff.succeed(addr)
+	}
+	record := &goAwayRecord{}
+	conn = newClientConn(conn, addr, clientConnOption{
+		observer: p.observer,
+		onGoAway: func(lastStreamID uint32) {
+			p.onGoAway(record, lastStreamID)
+		},
+		strayFrameStrategy: p.strayFrameStrategy,
+		logger:             p.logger,
+	})
+	cc, err := p.t.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		cc.Close()
+		return nil, errClientConnPoolClosed
+	}
+	// conn with the same address may be dialed concurrently, keep the one stored first
+	if old, ok := p.conns[addr]; ok && old.CanTakeNewRequest() {
+		cc.Close()
+		return old, nil
+	}
+	p.conns[addr] = cc
+	p.goAways[addr] = record
+	return cc, nil
+}
+
+// getLocked returns conn of @addr which can take new requests, or nil if there isn't one. p.mu must be held.
+func (p *clientConnPool) getLocked(addr string) (*h2.ClientConn, error) {
+	if p.closed {
+		return nil, errClientConnPoolClosed
+	}
+	if cc, ok := p.conns[addr]; ok && cc.CanTakeNewRequest() {
+		return cc, nil
+	}
+	return nil, nil
+}
+
+// close shuts down all conns in background, each of them is closed after its streams are finished
+func (p *clientConnPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for addr, cc := range p.conns {
+		go func(cc *h2.ClientConn) {
+			_ = cc.Shutdown(context.Background())
+		}(cc)
+		delete(p.conns, addr)
+	}
+}
+
+// closeExcept shuts down conns of addresses not in @addresses in background like close, e.g. conns of endpoints
+// removed by resolver, and GOAWAYs received by them are forgotten
+func (p *clientConnPool) closeExcept(addresses []string) {
+	kept := make(map[string]struct{}, len(addresses))
+	for _, addr := range addresses {
+		kept[addr] = struct{}{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, cc := range p.conns {
+		if _, ok := kept[addr]; ok {
+			continue
+		}
+		go func(cc *h2.ClientConn) {
+			_ = cc.Shutdown(context.Background())
+		}(cc)
+		delete(p.conns, addr)
+		delete(p.goAways, addr)
+	}
+}
+
+// MarkDead implements h2.ClientConnPool
+func (p *clientConnPool) MarkDead(cc *h2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, v := range p.conns {
+		if v == cc {
+			delete(p.conns, addr)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/net/http2"
+
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+// startSilentListener returns address of a listener which accepts conns and never reads from them
+func startSilentListener(t *testing.T) string {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	var (
+		lock  sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		lst.Close()
+		lock.Lock()
+		defer lock.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			conns = append(conns, conn)
+			lock.Unlock()
+		}
+	}()
+	return lst.Addr().String()
+}
+
+func TestClientConnPoolSingleFlightDial(t *testing.T) {
+	addr := startSilentListener(t)
+	var dialed int32
+	release := make(chan struct{})
+	pool := newClientConnPool(&http2.Transport{}, tconfig.Option{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dialed, 1)
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	})
+	defer pool.close()
+
+	// concurrent requests wait for the same dial
+	var wg sync.WaitGroup
+	ccs := make([]*http2.ClientConn, 10)
+	for i := range ccs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cc, err := pool.getClientConn(context.Background(), addr)
+			assert.Nil(t, err)
+			ccs[i] = cc
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dialed))
+	for _, cc := range ccs {
+		assert.True(t, cc == ccs[0])
+	}
+}
+
+func TestClientConnPoolDialAborted(t *testing.T) {
+	addr := startSilentListener(t)
+	var dialed int32
+	pool := newClientConnPool(&http2.Transport{}, tconfig.Option{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if atomic.AddInt32(&dialed, 1) == 1 {
+				// the first dial blocks until its request gives up
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	})
+	defer pool.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	abortedErr := make(chan error, 1)
+	go func() {
+		_, err := pool.getClientConn(ctx, addr)
+		abortedErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the waiting request doesn't fail with ctx of another request, it dials again
+	cc, err := pool.getClientConn(context.Background(), addr)
+	assert.Nil(t, err)
+	assert.NotNil(t, cc)
+	assert.Equal(t, context.DeadlineExceeded, <-abortedErr)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dialed))
+
+	// a waiting request returns once its own ctx is done
+	blocked := make(chan struct{})
+	defer close(blocked)
+	slowPool := newClientConnPool(&http2.Transport{}, tconfig.Option{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-blocked
+			return nil, context.Canceled
+		},
+	})
+	defer slowPool.close()
+	go func() {
+		_, _ = slowPool.getClientConn(context.Background(), addr)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer waitCancel()
+	_, err = slowPool.getClientConn(waitCtx, addr)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+// drainPollInterval is the interval to check if all requests are completed during draining
+const drainPollInterval = 10 * time.Millisecond
+
+// DrainAndClose stops accepting conns and sends GOAWAY to all conns, then waits until all requests are completed or
+// @ctx is done, the conns are closed by force at last. It is safe to call it repeatedly and concurrently, the
+// summary of the first call is returned.
+func (s *Server) DrainAndClose(ctx context.Context) tconfig.DrainSummary {
+	s.drainOnce.Do(func() {
+		finished := atomic.LoadInt64(&s.finishedRPCs)
+		s.Stop()
+		// there is no conn tracked by hs, so Shutdown only sends GOAWAY to conns served by h2Server, and returns
+		_ = s.hs.Shutdown(context.Background())
+
+		ticker := time.NewTicker(drainPollInterval)
+		defer ticker.Stop()
+	Loop:
+		for atomic.LoadInt32(&s.runningRPCs) > 0 {
+			select {
+			case <-ctx.Done():
+				break Loop
+			case <-ticker.C:
+			}
+		}
+
+		// requests finished after closing conns are not completed
+		s.drainSummary.Forced = int(atomic.LoadInt32(&s.runningRPCs))
+		s.drainSummary.Completed = int(atomic.LoadInt64(&s.finishedRPCs) - finished)
+		s.closeConns()
+	})
+	return s.drainSummary
+}
+
+// trackConn adds @c to conns of server, it returns false if conns are closed
+func (s *Server) trackConn(c net.Conn) bool {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+	if s.connsClosed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+// untrackConn removes @c from conns of server
+func (s *Server) untrackConn(c net.Conn) {
+	s.connsLock.Lock()
+	delete(s.conns, c)
+	s.connsLock.Unlock()
+}
+
+// closeConns closes all conns of server by force
+func (s *Server) closeConns() {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+	s.connsClosed = true
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
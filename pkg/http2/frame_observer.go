@@ -0,0 +1,140 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+// frameHeaderLen is the length of http2 frame header
+const frameHeaderLen = 9
+
+// frameSniffer parses http2 frame headers from bytes of one direction of conn, and tells them to observer.
+// Payloads are skipped without being copied, except the last stream id of GOAWAY, which is told to onGoAway.
+type frameSniffer struct {
+	mu          sync.Mutex
+	outbound    bool
+	observer    tconfig.FrameObserver
+	onGoAway    func(lastStreamID uint32)
+	prefaceLeft int
+	header      [frameHeaderLen]byte
+	headerLen   int
+	payloadLeft uint32
+	// goAway buffers the last stream id at the beginning of GOAWAY payload being fed, while inGoAway is true
+	goAway    [4]byte
+	goAwayLen int
+	inGoAway  bool
+}
+
+func (s *frameSniffer) feed(b []byte) {
+	s.feedUntil(b, nil)
+}
+
+// feedUntil feeds @b like feed, but it stops at the frame for which @stop returns true, and returns the number of
+// bytes before its header in @b, which is zero if the header started in former bytes. The observer isn't told about
+// the stopped frame, and the sniffer must not be fed any more after it stops.
+func (s *frameSniffer) feedUntil(b []byte, stop func(info tconfig.FrameInfo) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := len(b)
+	for len(b) > 0 {
+		if s.prefaceLeft > 0 {
+			n := min(s.prefaceLeft, len(b))
+			s.prefaceLeft -= n
+			b = b[n:]
+			continue
+		}
+		if s.payloadLeft > 0 {
+			n := min(int(s.payloadLeft), len(b))
+			if s.inGoAway {
+				s.feedGoAway(b[:n])
+			}
+			s.payloadLeft -= uint32(n)
+			b = b[n:]
+			continue
+		}
+		headerStart := total - len(b) - s.headerLen
+		n := copy(s.header[s.headerLen:], b)
+		s.headerLen += n
+		b = b[n:]
+		if s.headerLen < frameHeaderLen {
+			return total
+		}
+		s.headerLen = 0
+		info := tconfig.FrameInfo{
+			Outbound: s.outbound,
+			Length:   uint32(s.header[0])<<16 | uint32(s.header[1])<<8 | uint32(s.header[2]),
+			Type:     h2.FrameType(s.header[3]),
+			Flags:    h2.Flags(s.header[4]),
+			StreamID: binary.BigEndian.Uint32(s.header[5:]) & (1<<31 - 1),
+		}
+		if stop != nil && stop(info) {
+			if headerStart < 0 {
+				return 0
+			}
+			return headerStart
+		}
+		s.payloadLeft = info.Length
+		// GOAWAY payload has last stream id and error code at least
+		s.inGoAway = s.onGoAway != nil && info.Type == h2.FrameGoAway && info.Length >= 8
+		s.goAwayLen = 0
+		if s.observer != nil {
+			s.observer(info)
+		}
+	}
+	return total
+}
+
+// feedGoAway buffers payload @b of GOAWAY until the last stream id is complete, and tells it to onGoAway
+func (s *frameSniffer) feedGoAway(b []byte) {
+	s.goAwayLen += copy(s.goAway[s.goAwayLen:], b)
+	if s.goAwayLen == len(s.goAway) {
+		s.inGoAway = false
+		s.onGoAway(binary.BigEndian.Uint32(s.goAway[:]) & (1<<31 - 1))
+	}
+}
+
+// state returns whether a frame header is fed halfway, and the length of payload of the current frame not fed yet
+func (s *frameSniffer) state() (inHeader bool, payloadLeft uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.headerLen > 0, s.payloadLeft
+}
+
+// atFrameBoundary reports whether all the bytes fed are whole frames, so that another frame can be inserted
+func (s *frameSniffer) atFrameBoundary() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prefaceLeft == 0 && s.headerLen == 0 && s.payloadLeft == 0
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
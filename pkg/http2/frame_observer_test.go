@@ -0,0 +1,68 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"bytes"
+	"testing"
+)
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+func TestFrameSniffer(t *testing.T) {
+	var data bytes.Buffer
+	data.WriteString(h2.ClientPreface)
+	framer := h2.NewFramer(&data, nil)
+	assert.Nil(t, framer.WriteSettings())
+	assert.Nil(t, framer.WriteData(1, false, []byte("first")))
+	assert.Nil(t, framer.WriteData(1, true, make([]byte, 1000)))
+	assert.Nil(t, framer.WriteRSTStream(3, h2.ErrCodeCancel))
+	assert.Nil(t, framer.WriteGoAway(5, h2.ErrCodeNo, []byte("shutdown")))
+
+	var infos []tconfig.FrameInfo
+	var lastStreamIDs []uint32
+	sniffer := &frameSniffer{
+		prefaceLeft: len(h2.ClientPreface),
+		observer: func(info tconfig.FrameInfo) {
+			infos = append(infos, info)
+		},
+		onGoAway: func(lastStreamID uint32) {
+			lastStreamIDs = append(lastStreamIDs, lastStreamID)
+		},
+	}
+	// frames are split at any position by conn read
+	for _, b := range data.Bytes() {
+		sniffer.feed([]byte{b})
+	}
+	assert.Equal(t, []tconfig.FrameInfo{
+		{Type: h2.FrameSettings, Length: 0},
+		{Type: h2.FrameData, StreamID: 1, Length: 5},
+		{Type: h2.FrameData, Flags: h2.FlagDataEndStream, StreamID: 1, Length: 1000},
+		{Type: h2.FrameRSTStream, StreamID: 3, Length: 4},
+		{Type: h2.FrameGoAway, Length: 16},
+	}, infos)
+	assert.Equal(t, []uint32{5}, lastStreamIDs)
+}
@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/net/http2"
+
+	perrors "github.com/pkg/errors"
+)
+
+const (
+	// h2cPrefaceTimeout is the timeout of reading client preface of accepted conn
+	h2cPrefaceTimeout = 10 * time.Second
+	// http1VersionNotSupported is the response to clients speaking HTTP/1.x, e.g. with "Upgrade: h2c"
+	http1VersionNotSupported = "HTTP/1.1 505 HTTP Version Not Supported\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Connection: close\r\n" +
+		"Content-Length: 52\r\n" +
+		"\r\n" +
+		"triple server only accepts h2c with prior knowledge\n"
+)
+
+/*
+Triple speaks HTTP/2 over cleartext with prior knowledge (h2c), without TLS, ALPN or HTTP/1.1 Upgrade, client sends
+client preface "PRI * HTTP/2.0" right after the tcp handshake. readClientPreface checks the preface of accepted conn,
+so that clients speaking HTTP/1.x got a readable 505 response instead of a broken http2 conn.
+*/
+
+// prefaceConn replays the client preface read by readClientPreface
+type prefaceConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefaceConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// readClientPreface reads client preface from @conn, and returns the conn which replays it to http2 server.
+// If @conn doesn't start with client preface, it responds 505 to HTTP/1.x client and returns error.
+func readClientPreface(conn net.Conn) (net.Conn, error) {
+	preface := make([]byte, len(http2.ClientPreface))
+	_ = conn.SetReadDeadline(time.Now().Add(h2cPrefaceTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	// the preface is checked as it arrives, as a short HTTP/1.x request waits for response before sending more
+	n := 0
+	for n < len(preface) {
+		m, err := conn.Read(preface[n:])
+		n += m
+		if string(preface[:n]) != http2.ClientPreface[:n] {
+			// HTTP/1.x request line starts with method and a space
+			if bytes.IndexByte(preface[:n], ' ') > 0 {
+				_ = conn.SetWriteDeadline(time.Now().Add(refuseConnWriteTimeout))
+				_, _ = io.WriteString(conn, http1VersionNotSupported)
+			}
+			return nil, perrors.Errorf("conn from %v doesn't speak h2c with prior knowledge, got %q", conn.RemoteAddr(), preface[:n])
+		}
+		if err == io.EOF && n == 0 {
+			// conn closed without sending anything, e.g. tcp health check
+			return nil, err
+		}
+		if err != nil {
+			return nil, perrors.Errorf("read client preface from %v error = %v", conn.RemoteAddr(), err)
+		}
+	}
+	return &prefaceConn{Conn: conn, r: io.MultiReader(bytes.NewReader(preface), conn)}, nil
+}
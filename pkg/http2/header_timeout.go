@@ -0,0 +1,97 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"sync"
+	"time"
+)
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+/*
+headerReadTimer defends server against slow-loris attack: if a header block of client, i.e. HEADERS and the
+CONTINUATION frames following it, or even a frame header, isn't received in timeout after its first byte, serverConn
+sends GOAWAY with ENHANCE_YOUR_CALM and closes conn. The stream alone can't be reset, because no other frame is allowed
+in the middle of header block, and header compression state of the conn is broken by the partial block.
+*/
+type headerReadTimer struct {
+	timeout time.Duration
+	// onTimeout is called with the last stream id of client once the timer fires
+	onTimeout func(lastStreamID uint32)
+	// current is the latest frame whose header is received, it is only accessed by read loop
+	current tconfig.FrameInfo
+
+	lock sync.Mutex
+	// lastStreamID is the latest stream whose header block is received
+	lastStreamID uint32
+	// timer is started when the first byte of frame header or header block is received, and stopped after it is
+	// received, it is nil if nothing is pending. timerID tells the timer fired from the ones stopped too late.
+	timer   *time.Timer
+	timerID uint64
+	closing bool
+}
+
+// check starts timer once frame header or header block is pending after bytes are read, and stops it after they are
+// received. @inHeader and @payloadLeft are the state of the sniffer of bytes read. A partial frame header arms the
+// timer whatever type the frame is, because the type isn't known before the whole frame header is received, so the
+// timer bounds frame headers of all types, but a conn without pending bytes, e.g. an idle one, is never closed by it.
+func (t *headerReadTimer) check(inHeader bool, payloadLeft uint32) {
+	// END_HEADERS flag of CONTINUATION is the same as HEADERS
+	inHeaderBlock := t.current.Type == h2.FrameHeaders || t.current.Type == h2.FrameContinuation
+	blockPending := inHeaderBlock && (payloadLeft > 0 || !t.current.Flags.Has(h2.FlagHeadersEndHeaders))
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if inHeaderBlock && !blockPending && t.current.StreamID > t.lastStreamID {
+		t.lastStreamID = t.current.StreamID
+	}
+	if !inHeader && !blockPending {
+		if t.timer != nil {
+			t.timer.Stop()
+			t.timer = nil
+		}
+		return
+	}
+	if t.timer == nil && !t.closing {
+		t.timerID++
+		timerID := t.timerID
+		t.timer = time.AfterFunc(t.timeout, func() {
+			t.fire(timerID)
+		})
+	}
+}
+
+// fire calls onTimeout if timer of @timerID is still pending, i.e. the header isn't received in time
+func (t *headerReadTimer) fire(timerID uint64) {
+	t.lock.Lock()
+	if t.timer == nil || t.timerID != timerID || t.closing {
+		t.lock.Unlock()
+		return
+	}
+	t.closing = true
+	lastStreamID := t.lastStreamID
+	t.lock.Unlock()
+	t.onTimeout(lastStreamID)
+}
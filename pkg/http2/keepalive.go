@@ -0,0 +1,116 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"sync"
+	"time"
+)
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+const (
+	// maxPingStrikes is the number of pings violating policy allowed before the conn is closed, the same as grpc
+	maxPingStrikes = 2
+	// pingWithoutStreamMinInterval is the min interval of pings without active stream if they are not permitted,
+	// the same as grpc
+	pingWithoutStreamMinInterval = 2 * time.Hour
+)
+
+/*
+keepaliveEnforcer enforces keepalive policy of server on an accepted conn, like grpc: each ping sent by client sooner
+than min interval after the last one is a strike, and when strikes are more than maxPingStrikes, serverConn sends
+GOAWAY with ENHANCE_YOUR_CALM and closes conn. Strikes are cleared when server sends HEADERS or DATA, because pings
+are expected while client is waiting for response.
+
+Active streams are told by HEADERS from client and the END_STREAM or RST_STREAM of them.
+*/
+type keepaliveEnforcer struct {
+	minPingInterval     time.Duration
+	permitWithoutStream bool
+
+	lock          sync.Mutex
+	activeStreams map[uint32]struct{}
+	lastStreamID  uint32
+	lastPing      time.Time
+	strikes       int
+	closing       bool
+}
+
+func newKeepaliveEnforcer(minPingInterval time.Duration, permitWithoutStream bool) *keepaliveEnforcer {
+	return &keepaliveEnforcer{
+		minPingInterval:     minPingInterval,
+		permitWithoutStream: permitWithoutStream,
+		activeStreams:       make(map[uint32]struct{}),
+	}
+}
+
+// onFrame tracks active streams and pings of @info, it returns true with the last stream id of client if too many
+// pings violate policy, which is returned only once
+func (k *keepaliveEnforcer) onFrame(info tconfig.FrameInfo) (uint32, bool) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	switch {
+	case info.Type == h2.FrameRSTStream || (info.Outbound && isEndStream(info)):
+		// server ends stream after client
+		delete(k.activeStreams, info.StreamID)
+	case !info.Outbound && info.Type == h2.FrameHeaders:
+		k.activeStreams[info.StreamID] = struct{}{}
+		if info.StreamID > k.lastStreamID {
+			k.lastStreamID = info.StreamID
+		}
+	}
+	if info.Outbound && (info.Type == h2.FrameHeaders || info.Type == h2.FrameData) {
+		k.strikes = 0
+		k.lastPing = time.Time{}
+	}
+	if info.Outbound || info.Type != h2.FramePing || info.Flags.Has(h2.FlagPingAck) || !k.strike() {
+		return 0, false
+	}
+	k.closing = true
+	return k.lastStreamID, true
+}
+
+// strike records a ping from client, it returns true if strikes exceed maxPingStrikes, @k.lock must be held
+func (k *keepaliveEnforcer) strike() bool {
+	if k.closing {
+		return false
+	}
+	now := time.Now()
+	minInterval := k.minPingInterval
+	if len(k.activeStreams) == 0 && !k.permitWithoutStream && minInterval < pingWithoutStreamMinInterval {
+		minInterval = pingWithoutStreamMinInterval
+	}
+	if !k.lastPing.IsZero() && now.Sub(k.lastPing) < minInterval {
+		k.strikes++
+	}
+	k.lastPing = now
+	return k.strikes > maxPingStrikes
+}
+
+// isEndStream reports whether frame of @info ends its stream
+func isEndStream(info tconfig.FrameInfo) bool {
+	return (info.Type == h2.FrameHeaders && info.Flags.Has(h2.FlagHeadersEndStream)) ||
+		(info.Type == h2.FrameData && info.Flags.Has(h2.FlagDataEndStream))
+}
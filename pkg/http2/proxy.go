@@ -0,0 +1,145 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// newProxyDialContext returns dial function which tunnels conns dialed by @dial through HTTP CONNECT proxy
+// @proxyURL, or the proxy of env vars if @fromEnvironment is true. Addresses without proxy are dialed directly.
+func newProxyDialContext(dial dialContextFunc, proxyURL *url.URL, fromEnvironment bool) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		proxy := proxyURL
+		if proxy == nil && fromEnvironment {
+			var err error
+			// h2c is cleartext, so HTTP_PROXY is used rather than HTTPS_PROXY
+			if proxy, err = http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "http", Host: addr}}); err != nil {
+				return nil, perrors.Wrapf(err, "invalid proxy of env vars")
+			}
+		}
+		if proxy == nil {
+			return dial(ctx, network, addr)
+		}
+		return dialThroughProxy(ctx, dial, network, proxy, addr)
+	}
+}
+
+// dialThroughProxy dials conn to @proxy by @dial, and sends CONNECT of @addr on it. The returned conn is the tunnel
+// to @addr. The handshake with proxy is aborted if @ctx is done.
+func dialThroughProxy(ctx context.Context, dial dialContextFunc, network string, proxy *url.URL, addr string) (net.Conn, error) {
+	if proxy.Scheme != "" && proxy.Scheme != "http" {
+		return nil, perrors.Errorf("proxy scheme %s of %s is not supported, only http CONNECT proxy is supported", proxy.Scheme, proxy.Redacted())
+	}
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), "80")
+	}
+	conn, err := dial(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, perrors.Wrapf(err, "dial proxy %s", proxyAddr)
+	}
+
+	// the blocking handshake is interrupted by deadline in the past when @ctx is done
+	handshakeDone := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-handshakeDone:
+		}
+	}()
+	br, err := proxyHandshake(conn, proxy, proxyAddr, addr)
+	// the watcher exits before the deadline is cleared, so that it never sets deadline of the returned tunnel
+	close(handshakeDone)
+	<-watcherDone
+	if err != nil {
+		_ = conn.Close()
+		return nil, proxyHandshakeError(ctx, err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if br.Buffered() > 0 {
+		// bytes of server sent right after the response of proxy
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// proxyHandshake sends CONNECT of @addr to @proxy on @conn, and reads the response, the returned reader may buffer
+// bytes after the response
+func proxyHandshake(conn net.Conn, proxy *url.URL, proxyAddr, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, perrors.Wrapf(err, "send CONNECT %s to proxy %s", addr, proxyAddr)
+	}
+	br := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, perrors.Wrapf(err, "read response of CONNECT %s from proxy %s", addr, proxyAddr)
+	}
+	_ = rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, perrors.Errorf("proxy %s refused CONNECT %s with status %s", proxyAddr, addr, rsp.Status)
+	}
+	return br, nil
+}
+
+// proxyHandshakeError returns error of @ctx if the handshake with proxy fails because @ctx is done, otherwise @err
+func proxyHandshakeError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// bufferedConn reads the bytes buffered by @r first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
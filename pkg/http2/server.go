@@ -3,13 +3,15 @@ package http2
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,10 +22,12 @@ import (
 )
 
 import (
+	"github.com/dubbogo/triple/internal/buffer"
+	"github.com/dubbogo/triple/internal/codes"
 	"github.com/dubbogo/triple/pkg/common"
 	"github.com/dubbogo/triple/pkg/common/constant"
 	"github.com/dubbogo/triple/pkg/common/logger"
-	"github.com/dubbogo/triple/pkg/common/logger/default_logger"
+	"github.com/dubbogo/triple/pkg/common/peer"
 	tconfig "github.com/dubbogo/triple/pkg/config"
 	tConfig "github.com/dubbogo/triple/pkg/http2/config"
 )
@@ -33,6 +37,8 @@ const (
 	DefaultMaxSleepTime = 1 * time.Second
 	// DefaultListenerTimeout tcp listener timeout
 	DefaultListenerTimeout = 1.5e9
+	// refuseConnWriteTimeout is the timeout of writing GOAWAY to refused conn
+	refuseConnWriteTimeout = time.Second
 )
 
 // Handler relays data to upper layer and receives data from upper layer as well.
@@ -44,17 +50,58 @@ type Handler func(path string, header http.Header, recvChan chan *bytes.Buffer,
 	sendChan chan *bytes.Buffer, ctrlChan chan http.Header,
 	errChan chan interface{})
 
+// HandlerContext is Handler with @ctx, the context of the http2 request, whose parent is the context of the connection
+type HandlerContext func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+	sendChan chan *bytes.Buffer, ctrlChan chan http.Header,
+	errChan chan interface{})
+
 // Server is the object that can be started and listening remote request
 type Server struct {
 	lst                  net.Listener
 	lock                 sync.Mutex
-	httpHandlerMap       map[string]Handler
+	httpHandlerMap       map[string]HandlerContext
+	defaultHandler       HandlerContext
 	done                 chan struct{}
 	address              string
 	logger               logger.Logger
 	frameHandler         common.PackageHandler
 	pathExtractor        common.PathExtractor
 	handleGRMangedByUser bool
+	onConnect            func(ctx context.Context, p *peer.Peer) context.Context
+	onDisconnect         func(p *peer.Peer)
+	enableBufferPool     bool
+	compressionLevel     int
+	frameObserver        tconfig.FrameObserver
+	maxConnections       int32
+	minPingInterval      time.Duration
+	permitWithoutStream  bool
+	headerReadTimeout    time.Duration
+	streamWriteTimeout   time.Duration
+	tcpKeepalive         tconfig.TCPKeepalive
+	accessLogSink        tconfig.AccessLogSink
+	maxRequestBytes      int
+	compressors          compressorCache
+	// tlsConfig is nil if conns speak h2c
+	tlsConfig *tls.Config
+	// connCount is the number of conns being served
+	connCount int32
+	stopOnce  sync.Once
+
+	// h2Server serves all conns, hs is only used to start graceful shutdown of them, which is registered by
+	// http2.ConfigureServer
+	h2Server *http2.Server
+	hs       *http.Server
+	// connConfig is the base config of conns served by h2Server
+	connConfig *http.Server
+	// conns are the accepted conns, which are closed by force at the end of DrainAndClose
+	conns       map[net.Conn]struct{}
+	connsClosed bool
+	connsLock   sync.Mutex
+	// runningRPCs and finishedRPCs are the numbers of requests being handled and handled
+	runningRPCs  int32
+	finishedRPCs int64
+	drainOnce    sync.Once
+	drainSummary tconfig.DrainSummary
 }
 
 // NewServer returns a server instance
@@ -69,50 +116,111 @@ func NewServer(address string, conf tConfig.ServerConfig) *Server {
 	}
 
 	if conf.Logger == nil {
-		conf.Logger = default_logger.GetDefaultLogger()
+		conf.Logger = logger.NopLogger{}
 	}
 
 	if conf.HandlerGRManagedByUser {
 		conf.Logger.Debug("use http2 handleGRMangedByUser mod, pls ensure your http2 handler could start new gr.")
 	}
 
+	h2Server := &http2.Server{
+		// it is ignored by http2 if it is out of range
+		MaxReadFrameSize: conf.MaxFrameSize,
+		// they are ignored by http2 if they are out of range
+		MaxUploadBufferPerStream:     conf.StreamWindowSize,
+		MaxUploadBufferPerConnection: conf.ConnWindowSize,
+	}
+	if conf.StreamWriteTimeout > 0 {
+		// the stream reset on write timeout must not wait for its pending DATA frames
+		h2Server.NewWriteScheduler = newStreamResetWriteScheduler
+	}
+	hs := &http.Server{}
+	if err := http2.ConfigureServer(hs, h2Server); err != nil {
+		panic(err)
+	}
+
+	// http2 takes MaxHeaderBytes as max header list size of conns, zero means the default
+	connConfig := &http.Server{MaxHeaderBytes: int(conf.MaxHeaderListSize)}
+
 	return &Server{
+		h2Server:             h2Server,
+		hs:                   hs,
+		connConfig:           connConfig,
+		conns:                make(map[net.Conn]struct{}),
+		lst:                  conf.Listener,
 		frameHandler:         headerHandler,
 		address:              address,
 		logger:               conf.Logger,
 		done:                 make(chan struct{}),
-		httpHandlerMap:       make(map[string]Handler),
+		httpHandlerMap:       make(map[string]HandlerContext),
 		pathExtractor:        conf.PathExtractor,
 		handleGRMangedByUser: conf.HandlerGRManagedByUser,
+		onConnect:            conf.OnConnect,
+		onDisconnect:         conf.OnDisconnect,
+		enableBufferPool:     conf.EnableBufferPool,
+		compressionLevel:     conf.CompressionLevel,
+		frameObserver:        conf.FrameObserver,
+		maxConnections:       int32(conf.MaxConnections),
+		minPingInterval:      conf.MinPingInterval,
+		permitWithoutStream:  conf.PermitWithoutStream,
+		headerReadTimeout:    conf.HeaderReadTimeout,
+		streamWriteTimeout:   conf.StreamWriteTimeout,
+		tcpKeepalive:         conf.TCPKeepalive,
+		accessLogSink:        conf.AccessLogSink,
+		maxRequestBytes:      conf.MaxRequestBytes,
+		tlsConfig:            newTLSConfig(conf.TLSConfig, conf.NextProtos),
 		lock:                 sync.Mutex{},
 	}
 }
 
 func (s *Server) RegisterHandler(path string, handler Handler) {
+	s.RegisterHandlerContext(path, func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlChan chan http.Header, errChan chan interface{}) {
+		handler(path, header, recvChan, sendChan, ctrlChan, errChan)
+	})
+}
+
+// RegisterHandlerContext registers @handler of @path like RegisterHandler, and @handler gets the context of request
+func (s *Server) RegisterHandlerContext(path string, handler HandlerContext) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.httpHandlerMap[path] = handler
 }
 
-// Stop
+// RegisterDefaultHandler registers @handler to serve requests whose path matches no registered handler,
+// if it's not registered, these requests are responded with http status 400.
+func (s *Server) RegisterDefaultHandler(handler HandlerContext) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.defaultHandler = handler
+}
+
+// Stop stops accepting conns, the conns being served are not closed. It is safe to call it repeatedly.
 func (s *Server) Stop() {
 	//if s.h2Controller != nil {
 	//	s.h2Controller.Destroy()
 	//}
-	close(s.done)
+	s.stopOnce.Do(func() {
+		close(s.done)
+		if s.lst != nil {
+			// unblock Accept of listener without deadline
+			_ = s.lst.Close()
+		}
+	})
 }
 
 // Start can start a triple server
 func (s *Server) Start() {
 	s.logger.Debug("tripleServer Start at ", s.address)
 
-	lst, err := net.Listen("tcp", s.address)
-	if err != nil {
-		panic(err)
+	if s.lst == nil {
+		lst, err := net.Listen("tcp", s.address)
+		if err != nil {
+			panic(err)
+		}
+		s.lst = lst
 	}
 
-	s.lst = lst
-
 	go s.run()
 }
 
@@ -124,7 +232,8 @@ func (s *Server) run() {
 		tmpDelay time.Duration
 	)
 
-	tl := s.lst.(*net.TCPListener)
+	// listener of ServerConfig may not be tcp listener, which is unblocked by Close in Stop
+	tl, _ := s.lst.(*net.TCPListener)
 	for {
 		select {
 		case <-s.done:
@@ -152,8 +261,28 @@ func (s *Server) run() {
 			return
 		}
 
+		if count := atomic.AddInt32(&s.connCount, 1); s.maxConnections > 0 && count > s.maxConnections {
+			atomic.AddInt32(&s.connCount, -1)
+			s.logger.Warnf("http2 server: conn from %v is refused, max connections %d is reached", c.RemoteAddr(), s.maxConnections)
+			go refuseConn(c)
+			continue
+		}
+
+		if err := setTCPKeepalive(c, s.tcpKeepalive); err != nil {
+			s.logger.Warnf("http2 server: set tcp keepalive of conn from %v error = %v", c.RemoteAddr(), err)
+		}
+
+		if !s.trackConn(c) {
+			// server is closed by DrainAndClose
+			atomic.AddInt32(&s.connCount, -1)
+			_ = c.Close()
+			return
+		}
+
 		// handle the connection
 		go func() {
+			defer atomic.AddInt32(&s.connCount, -1)
+			defer s.untrackConn(c)
 			defer func() {
 				if r := recover(); r != nil {
 					const size = 64 << 10
@@ -171,83 +300,145 @@ func (s *Server) run() {
 	}
 }
 
+// ConnectionCount returns the number of conns being served
+func (s *Server) ConnectionCount() int {
+	return int(atomic.LoadInt32(&s.connCount))
+}
+
+// refuseConn sends SETTINGS and GOAWAY to the refused conn @c, to tell client not to retry on it, and closes it.
+// Writing is best effort, and it doesn't wait for client preface.
+func refuseConn(c net.Conn) {
+	defer c.Close()
+	_ = c.SetWriteDeadline(time.Now().Add(refuseConnWriteTimeout))
+	framer := http2.NewFramer(c, nil)
+	if err := framer.WriteSettings(); err != nil {
+		return
+	}
+	_ = framer.WriteGoAway(0, http2.ErrCodeRefusedStream, []byte("max connections reached"))
+}
+
 // handleRawConn create a H2 Controller to deal with new conn
 func (s *Server) handleRawConn(conn net.Conn) error {
 	s.logger.Debugf("Triple Server get new tcp conn")
-	srv := &http2.Server{}
-	opts := &http2.ServeConnOpts{Handler: http.HandlerFunc(s.http2HandleFunction)}
-	srv.ServeConn(conn, opts)
-	return nil
-}
+	p := &peer.Peer{
+		Addr:      conn.RemoteAddr(),
+		LocalAddr: conn.LocalAddr(),
+	}
+	// connCtx is the parent of all requests' ctx on this conn
+	connCtx, cancel := context.WithCancel(peer.NewContext(context.Background(), p))
+	defer cancel()
+	if s.onConnect != nil {
+		if ctx := s.onConnect(connCtx, p); ctx != nil {
+			connCtx = ctx
+		}
+	}
+	if s.onDisconnect != nil {
+		// ServeConn returns only once when conn is closed, no matter closed gracefully or reset by peer
+		defer s.onDisconnect(p)
+	}
 
-// skipHeader is to read first 5 bytes of data frame, which indicates length of http data frame.
-// The first return([]byte) is frameData with 5 offset.
-// The second one is the length of http data frame.
-func skipHeader(frameData []byte) ([]byte, uint32) {
-	if len(frameData) < 5 {
-		return []byte{}, 0
+	if s.tlsConfig != nil {
+		tlsConn, err := tlsServerHandshake(conn, s.tlsConfig)
+		if err != nil {
+			_ = conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+	h2cConn, err := readClientPreface(conn)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	conn = h2cConn
+	connOption := serverConnOption{
+		observer:            s.frameObserver,
+		minPingInterval:     s.minPingInterval,
+		permitWithoutStream: s.permitWithoutStream,
+		headerReadTimeout:   s.headerReadTimeout,
+		logger:              s.logger,
+	}
+	if connOption.enabled() {
+		conn = newServerConn(conn, connOption)
+	}
+
+	opts := &http2.ServeConnOpts{
+		Context:    connCtx,
+		BaseConfig: s.connConfig,
+		Handler:    http.HandlerFunc(s.http2HandleFunction),
 	}
-	lineHeader := frameData[:5]
-	length := binary.BigEndian.Uint32(lineHeader[1:])
-	return frameData[5:], length
+	s.h2Server.ServeConn(conn, opts)
+	return nil
 }
 
-func readSplitData(ctx context.Context, rBody io.ReadCloser) chan *bytes.Buffer {
+// readSplitData reads http2 body @rBody, and sends each whole message to returned chan in order.
+// The body may be split at any position by http2 flow control, so bytes of a message header or body are buffered
+// until the message is complete, and a zero-length message is sent as an empty buffer.
+// if @usePool is true, the message buffer is got from buffer pool, receiver should release it after using.
+// messages with compressed flag are decompressed by @decompressor, if it fails, @onDecompressErr is called with the
+// error before the chan is closed, nil @onDecompressErr means ignoring it.
+func readSplitData(ctx context.Context, rBody io.ReadCloser, usePool bool, decompressor common.Compressor,
+	onDecompressErr func(err error), onMessage func(compressed bool, size, wireSize int)) chan *bytes.Buffer {
 	cbm := make(chan *bytes.Buffer)
 	go func() {
 		buf := make([]byte, 4098) // todo configurable
-		for {
-			splitBuffer := bytes.NewBuffer(make([]byte, 0))
-
-			// fromFrameHeaderDataSize is wanting data size now
-			fromFrameHeaderDataSize := uint32(0)
-			for {
-				var n int
-				var err error
-				if splitBuffer.Len() < int(fromFrameHeaderDataSize) || splitBuffer.Len() == 0 {
-					n, err = rBody.Read(buf)
+		splitBuffer := bytes.NewBuffer(make([]byte, 0))
+		var readErr error
+		// fill reads body until splitBuffer contains at least @size bytes, it returns false if body ends before that
+		fill := func(size int) bool {
+			for splitBuffer.Len() < size {
+				if readErr != nil {
+					// todo deal with error
+					return false
 				}
+				var n int
+				n, readErr = rBody.Read(buf)
+				splitBuffer.Write(buf[:n])
+			}
+			return true
+		}
+		for {
+			// [:5] is compressed flag and length of message
+			if !fill(5) {
+				close(cbm)
+				return
+			}
+			header := splitBuffer.Next(5)
+			compressed := isCompressed(header)
+			length := int(binary.BigEndian.Uint32(header[1:]))
+			if !fill(length) {
+				close(cbm)
+				return
+			}
 
+			var allDataBody *bytes.Buffer
+			if compressed {
+				data, err := decompress(decompressor, splitBuffer.Next(length))
 				if err != nil {
-					// todo deal with error
-					//cbm <- message.Message{
-					//	MsgType: message.ServerStreamCloseMsgType,
-					//}
+					if onDecompressErr != nil {
+						onDecompressErr(err)
+					}
 					close(cbm)
 					return
 				}
-				splitData := buf[:n]
-				splitBuffer.Write(splitData)
-				if fromFrameHeaderDataSize == 0 {
-					// should parse data frame header first
-					data := splitBuffer.Bytes()
-					var totalSize uint32
-					if data, totalSize = skipHeader(data); totalSize == 0 {
-						break
-					} else {
-						// get wanting data size from header
-						fromFrameHeaderDataSize = totalSize
-					}
-					splitBuffer.Reset()
-					splitBuffer.Write(data)
-				}
-				if splitBuffer.Len() >= int(fromFrameHeaderDataSize) {
-					allDataBody := make([]byte, fromFrameHeaderDataSize)
-					_, err := splitBuffer.Read(allDataBody)
-					if err != nil {
-						fmt.Printf("read SplitedDatas error = %v\n", err)
-					}
-					select {
-					case <-ctx.Done():
-						close(cbm)
-						return
-					default:
-						cbm <- bytes.NewBuffer(allDataBody)
-					}
-
-					// temp data is sent, and reset wanting data size
-					fromFrameHeaderDataSize = 0
-				}
+				allDataBody = bytes.NewBuffer(data)
+			} else if usePool {
+				allDataBody = buffer.GetBuffer()
+				allDataBody.Write(splitBuffer.Next(length))
+			} else {
+				data := make([]byte, length)
+				copy(data, splitBuffer.Next(length))
+				allDataBody = bytes.NewBuffer(data)
+			}
+			if onMessage != nil {
+				onMessage(compressed, allDataBody.Len(), length)
+			}
+			select {
+			case <-ctx.Done():
+				close(cbm)
+				return
+			default:
+				cbm <- allDataBody
 			}
 		}
 	}()
@@ -255,9 +446,51 @@ func readSplitData(ctx context.Context, rBody io.ReadCloser) chan *bytes.Buffer
 }
 
 func (s *Server) http2HandleFunction(wi http.ResponseWriter, r *http.Request) {
-	// body data from http
+	atomic.AddInt32(&s.runningRPCs, 1)
+	defer func() {
+		atomic.AddInt64(&s.finishedRPCs, 1)
+		atomic.AddInt32(&s.runningRPCs, -1)
+	}()
+	w := wi.(*http2.Http2ResponseWriter)
+	// accessLog is nil if access log is disabled
+	var accessLog *accessLog
+	if s.accessLogSink != nil {
+		accessLog = newAccessLog(r)
+		r.Body = accessLog.countRequest(r.Body)
+	}
+
+	// tooLargeCh is closed once the request exceeds max request bytes, by header list or by body
+	tooLargeCh := make(chan struct{})
+	if s.maxRequestBytes > 0 {
+		headerSize := headerListSize(r)
+		if headerSize > s.maxRequestBytes {
+			s.logger.Warnf("[HTTP2 ERROR] header list of %d bytes of path %s exceeds max request bytes %d", headerSize, r.URL.Path, s.maxRequestBytes)
+			writeErrorResponse(w, false, codes.ResourceExhausted, requestTooLargeMessage(s.maxRequestBytes))
+			accessLog.finish(s.accessLogSink, uint32(codes.ResourceExhausted))
+			return
+		}
+		r.Body = &limitedBody{ReadCloser: r.Body, remaining: s.maxRequestBytes - headerSize, onExceed: func() {
+			s.logger.Warnf("[HTTP2 ERROR] request of path %s exceeds max request bytes %d", r.URL.Path, s.maxRequestBytes)
+			close(tooLargeCh)
+		}}
+	}
+
+	// compressor of request messages, and response messages are compressed with the same one
+	compressor, err := s.compressors.getCompressor(r.Header.Get(constant.GrpcEncoding), s.compressionLevel)
+	if err != nil {
+		s.logger.Warnf("[HTTP2 ERROR] unsupported grpc-encoding of path %s: %v", r.URL.Path, err)
+		writeUnsupportedEncodingResponse(w, r.Header.Get(constant.GrpcEncoding))
+		accessLog.finish(s.accessLogSink, uint32(codes.Unimplemented))
+		return
+	}
+
+	// body data from http, the rpc fails if a request message can't be decompressed
+	decompressErrCh := make(chan error, 1)
 	ctx, cancel := context.WithCancel(context.Background())
-	bodyCh := readSplitData(ctx, r.Body)
+	bodyCh := readSplitData(ctx, r.Body, s.enableBufferPool, compressor, func(err error) {
+		s.logger.Errorf("[HTTP2 ERROR] decompress request message of path %s error = %v", r.URL.Path, err)
+		decompressErrCh <- err
+	}, accessLog.onRequestMessage())
 	defer func() {
 		cancel()
 		select {
@@ -269,11 +502,9 @@ func (s *Server) http2HandleFunction(wi http.ResponseWriter, r *http.Request) {
 	ctrlChan := make(chan http.Header)
 	errChan := make(chan interface{})
 
-	w := wi.(*http2.Http2ResponseWriter)
-
 	path := r.URL.Path
 	headerField := r.Header
-	var handler Handler
+	var handler HandlerContext
 
 	// select a http handler according to the path
 	if handlerName, err := s.pathExtractor.HttpHandlerKey(path); err == nil {
@@ -281,38 +512,73 @@ func (s *Server) http2HandleFunction(wi http.ResponseWriter, r *http.Request) {
 			handler = v
 		}
 	}
+	if handler == nil {
+		handler = s.defaultHandler
+	}
 
 	if handler == nil {
 		//todo add error handler interface, let user define their handler
 		err := perrors.Errorf("no handler was found for path: %s", path)
 		s.logger.Warn("[HTTP2 ERROR] no handler was found for path: %s", path)
 		writeResponse(w, s.logger, 400, err.Error())
+		accessLog.finish(s.accessLogSink, uint32(codes.Unimplemented))
 		return
 	}
 
 	if s.handleGRMangedByUser {
-		handler(path, headerField, bodyCh, sendChan, ctrlChan, errChan)
+		handler(r.Context(), path, headerField, bodyCh, sendChan, ctrlChan, errChan)
 	} else {
 		go func() {
-			handler(path, headerField, bodyCh, sendChan, ctrlChan, errChan)
+			handler(r.Context(), path, headerField, bodyCh, sendChan, ctrlChan, errChan)
 		}()
 	}
 
 	// first response
-	firstRspHeaderMap := <-ctrlChan
+	var firstRspHeaderMap http.Header
+	select {
+	case firstRspHeaderMap = <-ctrlChan:
+	case err := <-decompressErrCh:
+		drainHandler(false, sendChan, ctrlChan, errChan)
+		writeDecompressErrorResponse(w, false, err)
+		code, _ := decompressErrorStatus(err)
+		accessLog.finish(s.accessLogSink, uint32(code))
+		return
+	case <-tooLargeCh:
+		drainHandler(false, sendChan, ctrlChan, errChan)
+		writeErrorResponse(w, false, codes.ResourceExhausted, requestTooLargeMessage(s.maxRequestBytes))
+		accessLog.finish(s.accessLogSink, uint32(codes.ResourceExhausted))
+		return
+	}
 	for k, v := range firstRspHeaderMap {
 		for _, vi := range v {
 			w.Header().Add(k, vi)
 		}
 	}
+	if compressor != nil {
+		w.Header().Set(constant.GrpcEncoding, compressor.Name())
+	}
 	w.WriteHeader(http.StatusOK)
 	w.FlushHeader()
 	success := true
 	errorMsg := ""
+	mw := s.newMessageWriter(w)
+	defer mw.close()
 
 Loop:
 	for {
 		select {
+		case err := <-decompressErrCh:
+			// the handler is not waited, because it may be waiting for request messages
+			drainHandler(true, sendChan, ctrlChan, errChan)
+			writeDecompressErrorResponse(w, true, err)
+			code, _ := decompressErrorStatus(err)
+			accessLog.finish(s.accessLogSink, uint32(code))
+			return
+		case <-tooLargeCh:
+			drainHandler(true, sendChan, ctrlChan, errChan)
+			writeErrorResponse(w, true, codes.ResourceExhausted, requestTooLargeMessage(s.maxRequestBytes))
+			accessLog.finish(s.accessLogSink, uint32(codes.ResourceExhausted))
+			return
 		// TODO: close
 		case err := <-errChan:
 			success = false
@@ -322,11 +588,24 @@ Loop:
 			if !ok { // sendChanClose
 				break Loop
 			}
-			sendData := s.frameHandler.Pkg2FrameData(sendMsg.Bytes())
-			if _, err := w.Write(sendData); err != nil {
+			sendData, err := frameData(s.frameHandler, sendMsg.Bytes(), compressor)
+			if err != nil {
+				s.logger.Errorf(" compress response error = %v", err)
+				success = false
+				errorMsg = err.Error()
+				break Loop
+			}
+			if err := mw.write(sendData); err == errStreamWriteTimeout {
+				s.logger.Warnf("[HTTP2 ERROR] write response message of path %s timeout after %v, the stream is reset", path, s.streamWriteTimeout)
+				drainHandler(true, sendChan, ctrlChan, errChan)
+				accessLog.finish(s.accessLogSink, uint32(codes.Internal))
+				// http2 resets the stream of aborted handler by RST_STREAM INTERNAL_ERROR, which unblocks the pending
+				// write. Trailers can't be sent, as the response writer is still used by the pending write.
+				panic(http.ErrAbortHandler)
+			} else if err != nil {
 				s.logger.Errorf(" receiving response from upper proxy invoker error = %v", err)
 			}
-			w.Flush()
+			accessLog.countResponse(compressor != nil, sendMsg.Len(), len(sendData))
 		}
 	}
 
@@ -339,12 +618,43 @@ Loop:
 		trailerMap[constant.TrailerKeyHttp2Message] = []string{errorMsg}
 	}
 	writeTripleFinalRspHeaderField(w, trailerMap)
+	if accessLog != nil {
+		var code int
+		if values := trailerMap[constant.TrailerKeyGrpcStatus]; len(values) > 0 {
+			code, _ = strconv.Atoi(values[0])
+		}
+		accessLog.finish(s.accessLogSink, uint32(code))
+	}
+}
+
+// drainHandler discards responses of handler in background after the rpc is failed by server, so that handler is not
+// blocked. @headerSent tells whether the first response header of handler is received.
+func drainHandler(headerSent bool, sendChan chan *bytes.Buffer, ctrlChan chan http.Header, errChan chan interface{}) {
+	go func() {
+		if !headerSent {
+			<-ctrlChan
+		}
+	Loop:
+		for {
+			select {
+			case <-errChan:
+				break Loop
+			case _, ok := <-sendChan:
+				if !ok {
+					break Loop
+				}
+			}
+		}
+		<-ctrlChan
+	}()
 }
 
 // writeTripleFinalRspHeaderField returns trailers header fields that triple and grpc defined
 func writeTripleFinalRspHeaderField(w *http2.Http2ResponseWriter, trailer http.Header) {
 	for k, v := range trailer {
-		w.Header().Add(http2.TrailerPrefix+k, v[0])
+		for _, vi := range v {
+			w.Header().Add(http2.TrailerPrefix+k, vi)
+		}
 	}
 	w.FlushTrailer()
 }
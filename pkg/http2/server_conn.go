@@ -0,0 +1,172 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/logger"
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+/*
+serverConn sniffs http2 frames from bytes of both directions of an accepted conn once, and handles them by callbacks:
+
+  - frames of both directions are told to FrameObserver if it is set.
+  - keepaliveEnforcer enforces keepalive policy of server if MinPingInterval is set.
+  - headerReadTimer bounds the time of receiving header blocks of client if HeaderReadTimeout is set.
+
+Both of the latter send GOAWAY with ENHANCE_YOUR_CALM and close the conn, which is done once. GOAWAY is written between
+frames of server: if server is writing a frame halfway, it is written right after the rest of the frame, and conn is
+closed anyway if the frame isn't finished in refuseConnWriteTimeout.
+*/
+type serverConn struct {
+	net.Conn
+	logger logger.Logger
+
+	readSniffer  *frameSniffer
+	writeSniffer *frameSniffer
+	// writeLock makes frame writes of server and GOAWAY not interleaved
+	writeLock  sync.Mutex
+	goAwayOnce sync.Once
+	// pendingGoAway is the GOAWAY waiting for the frame being written to finish, guarded by writeLock
+	pendingGoAway *pendingGoAway
+	observer      tconfig.FrameObserver
+	// keepalive is nil if keepalive policy isn't enforced
+	keepalive *keepaliveEnforcer
+	// headerTimer is nil if time of receiving header blocks isn't limited
+	headerTimer *headerReadTimer
+}
+
+// pendingGoAway is the GOAWAY to be sent by serverConn
+type pendingGoAway struct {
+	lastStreamID uint32
+	debug        string
+}
+
+// serverConnOption is the way serverConn handles frames sniffed
+type serverConnOption struct {
+	observer            tconfig.FrameObserver
+	minPingInterval     time.Duration
+	permitWithoutStream bool
+	headerReadTimeout   time.Duration
+	logger              logger.Logger
+}
+
+// enabled reports whether frames need to be sniffed
+func (o serverConnOption) enabled() bool {
+	return o.observer != nil || o.minPingInterval > 0 || o.headerReadTimeout > 0
+}
+
+func newServerConn(conn net.Conn, option serverConnOption) net.Conn {
+	c := &serverConn{
+		Conn:     conn,
+		logger:   option.logger,
+		observer: option.observer,
+	}
+	if option.minPingInterval > 0 {
+		c.keepalive = newKeepaliveEnforcer(option.minPingInterval, option.permitWithoutStream)
+	}
+	if option.headerReadTimeout > 0 {
+		c.headerTimer = &headerReadTimer{timeout: option.headerReadTimeout, onTimeout: c.onHeaderTimeout}
+	}
+	c.readSniffer = &frameSniffer{observer: c.onFrame, prefaceLeft: len(h2.ClientPreface)}
+	c.writeSniffer = &frameSniffer{observer: c.onFrame, outbound: true}
+	return c
+}
+
+func (c *serverConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.readSniffer.feed(b[:n])
+		if c.headerTimer != nil {
+			c.headerTimer.check(c.readSniffer.state())
+		}
+	}
+	return n, err
+}
+
+func (c *serverConn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.writeSniffer.feed(b[:n])
+	}
+	if c.pendingGoAway != nil && c.writeSniffer.atFrameBoundary() {
+		c.writeGoAway(c.pendingGoAway)
+		c.pendingGoAway = nil
+		_ = c.Conn.Close()
+	}
+	return n, err
+}
+
+func (c *serverConn) onFrame(info tconfig.FrameInfo) {
+	if c.observer != nil {
+		c.observer(info)
+	}
+	if c.headerTimer != nil && !info.Outbound {
+		c.headerTimer.current = info
+	}
+	if c.keepalive == nil {
+		return
+	}
+	if lastStreamID, tooManyPings := c.keepalive.onFrame(info); tooManyPings {
+		c.logger.Warnf("http2 server: conn from %v is closed, it sends too many pings", c.RemoteAddr())
+		// frames written are told with writeLock held
+		go c.goAway(lastStreamID, "too_many_pings")
+	}
+}
+
+func (c *serverConn) onHeaderTimeout(lastStreamID uint32) {
+	c.logger.Warnf("http2 server: conn from %v is closed, it doesn't send header in %s", c.RemoteAddr(), c.headerTimer.timeout)
+	c.goAway(lastStreamID, "header_read_timeout")
+}
+
+// goAway sends GOAWAY with ENHANCE_YOUR_CALM and @debug to client and closes conn once, writing is best effort.
+// If server is writing a frame halfway, GOAWAY is left to Write of the rest of the frame.
+func (c *serverConn) goAway(lastStreamID uint32, debug string) {
+	c.goAwayOnce.Do(func() {
+		frame := &pendingGoAway{lastStreamID: lastStreamID, debug: debug}
+		c.writeLock.Lock()
+		defer c.writeLock.Unlock()
+		if !c.writeSniffer.atFrameBoundary() {
+			c.pendingGoAway = frame
+			time.AfterFunc(refuseConnWriteTimeout, func() {
+				_ = c.Conn.Close()
+			})
+			return
+		}
+		c.writeGoAway(frame)
+		_ = c.Conn.Close()
+	})
+}
+
+// writeGoAway writes GOAWAY @frame to conn, it must be called with writeLock held, between frames of server
+func (c *serverConn) writeGoAway(frame *pendingGoAway) {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(refuseConnWriteTimeout))
+	_ = h2.NewFramer(c.Conn, nil).WriteGoAway(frame.lastStreamID, h2.ErrCodeEnhanceYourCalm, []byte(frame.debug))
+}
@@ -0,0 +1,1166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/net/http2"
+	"github.com/dubbogo/net/http2/hpack"
+
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	"github.com/dubbogo/triple/internal/buffer"
+	"github.com/dubbogo/triple/internal/codes"
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/common/logger/default_logger"
+	"github.com/dubbogo/triple/pkg/common/peer"
+	tconfig "github.com/dubbogo/triple/pkg/config"
+	"github.com/dubbogo/triple/pkg/http2/config"
+)
+
+type connStateKey struct{}
+
+func getFreeAddress(t testing.TB) string {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lst.Close()
+	return lst.Addr().String()
+}
+
+func TestServerConnCallbacks(t *testing.T) {
+	addr := getFreeAddress(t)
+	var connectCount, disconnectCount int32
+	disconnected := make(chan *peer.Peer, 2)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger: default_logger.GetDefaultLogger(),
+		OnConnect: func(ctx context.Context, p *peer.Peer) context.Context {
+			atomic.AddInt32(&connectCount, 1)
+			return context.WithValue(ctx, connStateKey{}, "session")
+		},
+		OnDisconnect: func(p *peer.Peer) {
+			atomic.AddInt32(&disconnectCount, 1)
+			disconnected <- p
+		},
+	})
+	handlerCtx := make(chan context.Context, 1)
+	svr.RegisterHandlerContext("/test", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		handlerCtx <- ctx
+		ctrlCh <- make(http.Header)
+		sendChan <- <-recvChan
+		close(sendChan)
+		ctrlCh <- make(http.Header)
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	// per-connection ctx is parent of request ctx
+	client := NewClient(tconfig.Option{Logger: default_logger.GetDefaultLogger()})
+	_, _, err := client.Post(addr, "/test", []byte("hello"), &config.PostConfig{
+		ContentType: "application/grpc+proto",
+		BufferSize:  1024,
+		Timeout:     3,
+	})
+	assert.Nil(t, err)
+	ctx := <-handlerCtx
+	assert.Equal(t, "session", ctx.Value(connStateKey{}))
+	p, ok := peer.FromContext(ctx)
+	assert.True(t, ok)
+	assert.NotNil(t, p.Addr)
+
+	// abrupt reset
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	_ = conn.(*net.TCPConn).SetLinger(0)
+	_ = conn.Close()
+	select {
+	case p := <-disconnected:
+		assert.Equal(t, conn.LocalAddr().String(), p.Addr.String())
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnDisconnect is not called after conn reset")
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&connectCount))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&disconnectCount))
+}
+
+// countingCompressor records the count of compress and decompress, it is named @name if @name is not empty
+type countingCompressor struct {
+	common.Compressor
+	name            string
+	compressCount   int32
+	decompressCount int32
+}
+
+func (c *countingCompressor) Name() string {
+	if c.name != "" {
+		return c.name
+	}
+	return c.Compressor.Name()
+}
+
+func (c *countingCompressor) Compress(data []byte) ([]byte, error) {
+	atomic.AddInt32(&c.compressCount, 1)
+	return c.Compressor.Compress(data)
+}
+
+func (c *countingCompressor) Decompress(data []byte) ([]byte, error) {
+	atomic.AddInt32(&c.decompressCount, 1)
+	return c.Compressor.Decompress(data)
+}
+
+func TestServerCompressedMessage(t *testing.T) {
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:           default_logger.GetDefaultLogger(),
+		CompressionLevel: constant.DefaultCompressionLevel,
+	})
+	// handler without ctx is still supported
+	svr.RegisterHandler("/test", func(path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		ctrlCh <- make(http.Header)
+		sendChan <- <-recvChan
+		close(sendChan)
+		ctrlCh <- make(http.Header)
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	gzipCompressor, err := common.GetCompressor(constant.GzipCompressorName, constant.DefaultCompressionLevel)
+	assert.Nil(t, err)
+	compressor := &countingCompressor{Compressor: gzipCompressor}
+	client := NewClient(tconfig.Option{Logger: default_logger.GetDefaultLogger()})
+	reqData := bytes.Repeat([]byte("hello"), 10000)
+	rspData, _, err := client.Post(addr, "/test", reqData, &config.PostConfig{
+		ContentType: constant.TripleContentType,
+		BufferSize:  1024,
+		Timeout:     3,
+		HeaderField: http.Header{constant.GrpcEncoding: []string{constant.GzipCompressorName}},
+		Compressor:  compressor,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, reqData, rspData)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&compressor.compressCount))
+
+	// unsupported grpc-encoding
+	_, trailer, err := client.Post(addr, "/test", reqData, &config.PostConfig{
+		ContentType: constant.TripleContentType,
+		BufferSize:  1024,
+		Timeout:     3,
+		HeaderField: http.Header{constant.GrpcEncoding: []string{"unknown"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "12", trailer.Get(constant.TrailerKeyGrpcStatus))
+}
+
+func TestClientUnaryContentLength(t *testing.T) {
+	addr := getFreeAddress(t)
+	contentLengths := make(chan string, 1)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger: default_logger.GetDefaultLogger(),
+	})
+	svr.RegisterHandlerContext("/test", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		contentLengths <- header.Get("Content-Length")
+		ctrlCh <- make(http.Header)
+		sendChan <- <-recvChan
+		close(sendChan)
+		ctrlCh <- make(http.Header)
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	client := NewClient(tconfig.Option{Logger: default_logger.GetDefaultLogger()})
+	// the message is larger than a data frame
+	for _, reqData := range [][]byte{[]byte("hello"), bytes.Repeat([]byte("hello"), 100000)} {
+		rspData, _, err := client.Post(addr, "/test", reqData, &config.PostConfig{
+			ContentType:      constant.TripleContentType,
+			BufferSize:       1024,
+			Timeout:          3,
+			HeaderField:      http.Header{},
+			SetContentLength: true,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, reqData, rspData)
+		// content-length is the length of framed message, with 5 bytes of compressed flag and message length
+		assert.Equal(t, strconv.Itoa(len(reqData)+5), <-contentLengths)
+	}
+
+	// it is not sent by default
+	_, _, err := client.Post(addr, "/test", []byte("hello"), &config.PostConfig{
+		ContentType: constant.TripleContentType,
+		BufferSize:  1024,
+		Timeout:     3,
+		HeaderField: http.Header{},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "", <-contentLengths)
+}
+
+func TestServerMaxFrameSize(t *testing.T) {
+	const maxFrameSize = 1 << 20
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:       default_logger.GetDefaultLogger(),
+		MaxFrameSize: maxFrameSize,
+	})
+	svr.RegisterHandlerContext("/test", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		ctrlCh <- make(http.Header)
+		sendChan <- <-recvChan
+		close(sendChan)
+		ctrlCh <- make(http.Header)
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	// SETTINGS_MAX_FRAME_SIZE is advertised in the first SETTINGS of server
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	assert.Nil(t, err)
+	framer := http2.NewFramer(conn, conn)
+	assert.Nil(t, framer.WriteSettings())
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	frame, err := framer.ReadFrame()
+	assert.Nil(t, err)
+	settings, ok := frame.(*http2.SettingsFrame)
+	if assert.True(t, ok) {
+		size, ok := settings.Value(http2.SettingMaxFrameSize)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(maxFrameSize), size)
+	}
+
+	// client sends large message in DATA frames larger than the default 16KB, and up to the advertised max
+	var maxDataLength uint32
+	client := NewClient(tconfig.Option{
+		Logger: default_logger.GetDefaultLogger(),
+		FrameObserver: func(info tconfig.FrameInfo) {
+			if info.Outbound && info.Type == http2.FrameData && info.Length > atomic.LoadUint32(&maxDataLength) {
+				atomic.StoreUint32(&maxDataLength, info.Length)
+			}
+		},
+	})
+	// SETTINGS of server is applied before PING ack, the first request of a new conn may be sent before it
+	assert.Nil(t, client.WarmUp(context.Background(), addr))
+	reqData := bytes.Repeat([]byte("hello"), 100000)
+	rspData, _, err := client.Post(addr, "/test", reqData, &config.PostConfig{
+		ContentType: constant.TripleContentType,
+		BufferSize:  1024,
+		Timeout:     3,
+		HeaderField: http.Header{},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, reqData, rspData)
+	assert.True(t, atomic.LoadUint32(&maxDataLength) > constant.MinMaxFrameSize, "max DATA frame length = %d", maxDataLength)
+	assert.True(t, atomic.LoadUint32(&maxDataLength) <= maxFrameSize)
+}
+
+func TestServerWindowSize(t *testing.T) {
+	const streamWindow, connWindow = 8 << 20, 16 << 20
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:           default_logger.GetDefaultLogger(),
+		StreamWindowSize: streamWindow,
+		ConnWindowSize:   connWindow,
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	// stream window is advertised in the first SETTINGS of server, and conn window is grown by WINDOW_UPDATE of
+	// stream 0 from the default 64KB of http2
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	assert.Nil(t, err)
+	framer := http2.NewFramer(conn, conn)
+	assert.Nil(t, framer.WriteSettings())
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	frame, err := framer.ReadFrame()
+	assert.Nil(t, err)
+	settings, ok := frame.(*http2.SettingsFrame)
+	if assert.True(t, ok) {
+		size, ok := settings.Value(http2.SettingInitialWindowSize)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(streamWindow), size)
+	}
+	for {
+		frame, err = framer.ReadFrame()
+		if !assert.Nil(t, err) {
+			return
+		}
+		// SETTINGS ack may come first
+		if windowUpdate, ok := frame.(*http2.WindowUpdateFrame); ok {
+			assert.Equal(t, uint32(0), windowUpdate.StreamID)
+			assert.Equal(t, uint32(connWindow-65535), windowUpdate.Increment)
+			return
+		}
+	}
+}
+
+func TestServerStreamCompressedMessages(t *testing.T) {
+	gzipCompressor, err := common.GetCompressor(constant.GzipCompressorName, constant.DefaultCompressionLevel)
+	assert.Nil(t, err)
+	// both server and client get this compressor by grpc-encoding
+	compressor := &countingCompressor{Compressor: gzipCompressor, name: "counting-gzip"}
+	common.SetCompressor(compressor.name, func(level int) (common.Compressor, error) {
+		return compressor, nil
+	})
+
+	rspMessages := [][]byte{
+		bytes.Repeat([]byte("hello"), 10000),
+		{},
+		bytes.Repeat([]byte("triple"), 10000),
+	}
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:           default_logger.GetDefaultLogger(),
+		CompressionLevel: constant.DefaultCompressionLevel,
+	})
+	svr.RegisterHandlerContext("/stream", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		ctrlCh <- make(http.Header)
+		for _, msg := range rspMessages {
+			sendChan <- bytes.NewBuffer(msg)
+		}
+		close(sendChan)
+		ctrlCh <- make(http.Header)
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	client := NewClient(tconfig.Option{Logger: default_logger.GetDefaultLogger()})
+	sendChan := make(chan *bytes.Buffer, 2)
+	sendChan <- bytes.NewBuffer([]byte("request"))
+	sendChan <- nil
+	recvChan, trailerChan, err := client.StreamPost(addr, "/stream", sendChan, &config.PostConfig{
+		ContentType: constant.TripleContentType,
+		BufferSize:  1024,
+		Timeout:     3,
+		HeaderField: http.Header{constant.GrpcEncoding: []string{compressor.name}},
+	})
+	assert.Nil(t, err)
+	received := make([][]byte, 0)
+	for msg := range recvChan {
+		received = append(received, msg.Bytes())
+	}
+	<-trailerChan
+	assert.Equal(t, rspMessages, received)
+	// each message is compressed and decompressed on its own frame
+	assert.Equal(t, int32(len(rspMessages)), atomic.LoadInt32(&compressor.compressCount))
+	assert.Equal(t, int32(len(rspMessages)), atomic.LoadInt32(&compressor.decompressCount))
+}
+
+// corruptCompressor compresses messages into bytes which can't be decompressed
+type corruptCompressor struct {
+	common.Compressor
+}
+
+func (c *corruptCompressor) Name() string {
+	return "corrupt-gzip"
+}
+
+func (c *corruptCompressor) Compress(data []byte) ([]byte, error) {
+	return []byte("bad!"), nil
+}
+
+func TestDecompressError(t *testing.T) {
+	gzipCompressor, err := common.GetCompressor(constant.GzipCompressorName, constant.DefaultCompressionLevel)
+	assert.Nil(t, err)
+	compressor := &corruptCompressor{Compressor: gzipCompressor}
+	common.SetCompressor(compressor.Name(), func(level int) (common.Compressor, error) {
+		return compressor, nil
+	})
+
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:           default_logger.GetDefaultLogger(),
+		CompressionLevel: constant.DefaultCompressionLevel,
+	})
+	svr.RegisterHandlerContext("/test", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		ctrlCh <- make(http.Header)
+		if msg := <-recvChan; msg != nil {
+			sendChan <- msg
+		} else {
+			// the request stream is broken, server fails the rpc without waiting for handler
+			<-ctx.Done()
+		}
+		close(sendChan)
+		ctrlCh <- make(http.Header)
+	})
+	svr.Start()
+	defer svr.Stop()
+	client := NewClient(tconfig.Option{Logger: default_logger.GetDefaultLogger()})
+
+	t.Run("server", func(t *testing.T) {
+		// the request message can't be decompressed by gzip of server
+		_, trailer, err := client.Post(addr, "/test", []byte("hello"), &config.PostConfig{
+			ContentType: constant.TripleContentType,
+			BufferSize:  1024,
+			Timeout:     3,
+			HeaderField: http.Header{constant.GrpcEncoding: []string{constant.GzipCompressorName}},
+			Compressor:  compressor,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, strconv.Itoa(int(codes.Internal)), trailer.Get(constant.TrailerKeyGrpcStatus))
+		assert.Equal(t, "grpc: failed to decompress the received message", trailer.Get(constant.TrailerKeyGrpcMessage))
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		// the request message exceeds max size after it is decompressed by server
+		gzipCompressor, err := common.GetCompressor(constant.GzipCompressorName, gzip.BestSpeed)
+		assert.Nil(t, err)
+		_, trailer, err := client.Post(addr, "/test", make([]byte, constant.MaxDecompressedMessageSize+1), &config.PostConfig{
+			ContentType: constant.TripleContentType,
+			BufferSize:  1024,
+			Timeout:     10,
+			HeaderField: http.Header{constant.GrpcEncoding: []string{constant.GzipCompressorName}},
+			Compressor:  gzipCompressor,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, strconv.Itoa(int(codes.ResourceExhausted)), trailer.Get(constant.TrailerKeyGrpcStatus))
+	})
+
+	t.Run("client", func(t *testing.T) {
+		// the request message is not compressed, and the response message can't be decompressed by client
+		sendChan := make(chan *bytes.Buffer, 2)
+		sendChan <- bytes.NewBuffer([]byte("hello"))
+		sendChan <- nil
+		recvChan, trailerChan, err := client.StreamPost(addr, "/test", sendChan, &config.PostConfig{
+			ContentType: constant.TripleContentType,
+			BufferSize:  1024,
+			Timeout:     3,
+			HeaderField: http.Header{constant.GrpcEncoding: []string{compressor.Name()}},
+		})
+		assert.Nil(t, err)
+		for range recvChan {
+			t.Error("message which can't be decompressed is received")
+		}
+		trailer := <-trailerChan
+		assert.Equal(t, strconv.Itoa(int(codes.Internal)), trailer.Get(constant.TrailerKeyGrpcStatus))
+		assert.Equal(t, "grpc: failed to decompress the received message", trailer.Get(constant.TrailerKeyGrpcMessage))
+	})
+}
+
+// failingCompressor fails to compress any message
+type failingCompressor struct {
+	common.Compressor
+}
+
+func (c *failingCompressor) Compress(data []byte) ([]byte, error) {
+	return nil, errors.New("compress failed")
+}
+
+func TestStreamCompressError(t *testing.T) {
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:           default_logger.GetDefaultLogger(),
+		CompressionLevel: constant.DefaultCompressionLevel,
+	})
+	svr.RegisterHandlerContext("/stream", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		ctrlCh <- make(http.Header)
+		for msg := range recvChan {
+			if msg == nil {
+				break
+			}
+			sendChan <- msg
+		}
+		<-ctx.Done()
+		close(sendChan)
+		ctrlCh <- make(http.Header)
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	gzipCompressor, err := common.GetCompressor(constant.GzipCompressorName, constant.DefaultCompressionLevel)
+	assert.Nil(t, err)
+	var terminateCause error
+	client := NewClient(tconfig.Option{Logger: default_logger.GetDefaultLogger()})
+	sendChan := make(chan *bytes.Buffer, 1)
+	sendChan <- bytes.NewBuffer([]byte("hello"))
+	recvChan, trailerChan, err := client.StreamPost(addr, "/stream", sendChan, &config.PostConfig{
+		ContentType: constant.TripleContentType,
+		BufferSize:  1024,
+		Timeout:     3,
+		HeaderField: http.Header{constant.GrpcEncoding: []string{constant.GzipCompressorName}},
+		Compressor:  &failingCompressor{Compressor: gzipCompressor},
+		OnTerminate: func(cause error) {
+			terminateCause = cause
+		},
+	})
+	assert.Nil(t, err)
+	for range recvChan {
+		t.Error("message which can't be compressed is echoed")
+	}
+	// the message is not skipped, the stream fails instead
+	trailer := <-trailerChan
+	assert.Equal(t, strconv.Itoa(int(codes.Internal)), trailer.Get(constant.TrailerKeyGrpcStatus))
+	assert.Equal(t, "grpc: failed to compress the request message: compress failed", trailer.Get(constant.TrailerKeyGrpcMessage))
+	assert.EqualError(t, terminateCause, "compress failed")
+}
+
+func TestCompressorCache(t *testing.T) {
+	var created int32
+	common.SetCompressor("cached-gzip", func(level int) (common.Compressor, error) {
+		atomic.AddInt32(&created, 1)
+		return common.GetCompressor(constant.GzipCompressorName, level)
+	})
+	cache := &compressorCache{}
+
+	c1, err := cache.getCompressor("cached-gzip", gzip.BestSpeed)
+	assert.Nil(t, err)
+	c2, err := cache.getCompressor("cached-gzip", gzip.BestSpeed)
+	assert.Nil(t, err)
+	assert.True(t, c1 == c2)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&created))
+
+	// another level gets another compressor
+	c3, err := cache.getCompressor("cached-gzip", gzip.BestCompression)
+	assert.Nil(t, err)
+	assert.False(t, c1 == c3)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&created))
+
+	// the failed one is not cached
+	for i := 0; i < 2; i++ {
+		_, err = cache.getCompressor("cached-gzip", gzip.BestCompression+1)
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&created))
+
+	c, err := cache.getCompressor(constant.IdentityCompressorName, gzip.BestSpeed)
+	assert.Nil(t, err)
+	assert.Nil(t, c)
+}
+
+func benchmarkReadSplitData(b *testing.B, usePool bool) {
+	pkgHandler, err := common.GetPackagerHandler(tconfig.NewTripleOption(tconfig.WithProtocol(constant.TRIPLE)))
+	assert.Nil(b, err)
+	body := bytes.NewBuffer(nil)
+	for i := 0; i < 100; i++ {
+		body.Write(pkgHandler.Pkg2FrameData(bytes.Repeat([]byte{'a'}, 1024)))
+	}
+	data := body.Bytes()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for msg := range readSplitData(context.Background(), ioutil.NopCloser(bytes.NewReader(data)), usePool, nil, nil, nil) {
+			if usePool {
+				buffer.PutBuffer(msg)
+			}
+		}
+	}
+}
+
+func BenchmarkReadSplitData(b *testing.B) {
+	b.Run("WithoutBufferPool", func(b *testing.B) {
+		benchmarkReadSplitData(b, false)
+	})
+	b.Run("WithBufferPool", func(b *testing.B) {
+		benchmarkReadSplitData(b, true)
+	})
+}
+
+// startLatencyRelay relays conns to @addr, and delivers bytes of each direction after @delay, like a link of RTT
+// 2 * @delay without bandwidth limitation. It returns the address of relay.
+func startLatencyRelay(b *testing.B, addr string, delay time.Duration) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(b, err)
+	b.Cleanup(func() {
+		_ = lis.Close()
+	})
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			go delayCopy(upstream, conn, delay)
+			go delayCopy(conn, upstream, delay)
+		}
+	}()
+	return lis.Addr().String()
+}
+
+// delayCopy copies bytes from @src to @dst, each read is written after @delay, and both conns are closed at the end
+func delayCopy(dst, src net.Conn, delay time.Duration) {
+	type chunk struct {
+		data []byte
+		at   time.Time
+	}
+	chunks := make(chan chunk, 4096)
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				chunks <- chunk{data: append([]byte(nil), buf[:n]...), at: time.Now().Add(delay)}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for c := range chunks {
+		time.Sleep(time.Until(c.at))
+		if _, err := dst.Write(c.data); err != nil {
+			break
+		}
+	}
+	_ = dst.Close()
+	_ = src.Close()
+	for range chunks {
+	}
+}
+
+func benchmarkUploadHighLatency(b *testing.B, streamWindow, connWindow int32) {
+	const uploadSize = 8 << 20
+	addr := getFreeAddress(b)
+	svr := NewServer(addr, config.ServerConfig{
+		StreamWindowSize: streamWindow,
+		ConnWindowSize:   connWindow,
+	})
+	svr.RegisterHandlerContext("/upload", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		ctrlCh <- make(http.Header)
+		for msg := range recvChan {
+			if msg == nil {
+				break
+			}
+		}
+		close(sendChan)
+		ctrlCh <- make(http.Header)
+	})
+	svr.Start()
+	defer svr.Stop()
+	// RTT of 50ms
+	relay := startLatencyRelay(b, addr, 25*time.Millisecond)
+
+	client := NewClient(tconfig.Option{})
+	defer client.Close()
+	message := make([]byte, 64*1024)
+	b.SetBytes(uploadSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sendChan := make(chan *bytes.Buffer)
+		go func() {
+			for sent := 0; sent < uploadSize; sent += len(message) {
+				sendChan <- bytes.NewBuffer(message)
+			}
+			sendChan <- nil
+		}()
+		recvChan, trailerChan, err := client.StreamPost(relay, "/upload", sendChan, &config.PostConfig{
+			ContentType: constant.TripleContentType,
+			BufferSize:  1024,
+			Timeout:     30,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for range recvChan {
+		}
+		<-trailerChan
+	}
+}
+
+// BenchmarkServerWindowSizeHighLatency uploads 8MB by client streaming over a link of 50ms RTT, whose throughput is
+// bounded by the flow control window of server per RTT
+func BenchmarkServerWindowSizeHighLatency(b *testing.B) {
+	b.Run("DefaultWindow", func(b *testing.B) {
+		benchmarkUploadHighLatency(b, 0, 0)
+	})
+	b.Run("16MBWindow", func(b *testing.B) {
+		benchmarkUploadHighLatency(b, 16<<20, 16<<20)
+	})
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:         default_logger.GetDefaultLogger(),
+		MaxConnections: 2,
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		_, err = conn.Write([]byte(http2.ClientPreface))
+		assert.Nil(t, err)
+		return conn
+	}
+	waitConnCount := func(expected int) {
+		for i := 0; i < 100 && svr.ConnectionCount() != expected; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, expected, svr.ConnectionCount())
+	}
+
+	conns := []net.Conn{dial(), dial()}
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	waitConnCount(2)
+
+	// the conn beyond max is refused with GOAWAY and closed
+	refused := dial()
+	defer refused.Close()
+	_ = refused.SetReadDeadline(time.Now().Add(3 * time.Second))
+	framer := http2.NewFramer(nil, refused)
+	frame, err := framer.ReadFrame()
+	assert.Nil(t, err)
+	assert.Equal(t, http2.FrameSettings, frame.Header().Type)
+	frame, err = framer.ReadFrame()
+	assert.Nil(t, err)
+	goAway, ok := frame.(*http2.GoAwayFrame)
+	assert.True(t, ok)
+	assert.Equal(t, http2.ErrCodeRefusedStream, goAway.ErrCode)
+	_, err = framer.ReadFrame()
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, svr.ConnectionCount())
+
+	// new conn is accepted after a served one is closed
+	_ = conns[0].Close()
+	waitConnCount(1)
+	conns[0] = dial()
+	waitConnCount(2)
+}
+
+func TestServerKeepaliveEnforcement(t *testing.T) {
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:              default_logger.GetDefaultLogger(),
+		MinPingInterval:     200 * time.Millisecond,
+		PermitWithoutStream: true,
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	dial := func() (net.Conn, *http2.Framer) {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		_, err = conn.Write([]byte(http2.ClientPreface))
+		assert.Nil(t, err)
+		framer := http2.NewFramer(conn, conn)
+		assert.Nil(t, framer.WriteSettings())
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		return conn, framer
+	}
+	// readPingAcks reads frames until @count ping acks are received, it returns GOAWAY frame if it is received
+	readPingAcks := func(framer *http2.Framer, count int) *http2.GoAwayFrame {
+		for count > 0 {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				return nil
+			}
+			switch f := frame.(type) {
+			case *http2.GoAwayFrame:
+				return f
+			case *http2.PingFrame:
+				if f.IsAck() {
+					count--
+				}
+			}
+		}
+		return nil
+	}
+
+	// pings at allowed interval are acked
+	conn, framer := dial()
+	defer conn.Close()
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, framer.WritePing(false, [8]byte{byte(i)}))
+		assert.Nil(t, readPingAcks(framer, 1))
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	// the conn pinging too often is GOAWAY'd with ENHANCE_YOUR_CALM and closed
+	aggressive, framer := dial()
+	defer aggressive.Close()
+	for i := 0; i < maxPingStrikes+2; i++ {
+		assert.Nil(t, framer.WritePing(false, [8]byte{byte(i)}))
+	}
+	goAway := readPingAcks(framer, maxPingStrikes+3)
+	if assert.NotNil(t, goAway) {
+		assert.Equal(t, http2.ErrCodeEnhanceYourCalm, goAway.ErrCode)
+		assert.Equal(t, "too_many_pings", string(goAway.DebugData()))
+	}
+	_, err := framer.ReadFrame()
+	for err == nil {
+		_, err = framer.ReadFrame()
+	}
+	// conn is closed by server rather than read timeout
+	netErr, ok := err.(net.Error)
+	assert.False(t, ok && netErr.Timeout(), "err = %v", err)
+}
+
+func TestServerConnGoAwayAtFrameBoundary(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := newServerConn(local, serverConnOption{
+		minPingInterval: time.Minute,
+		logger:          default_logger.GetDefaultLogger(),
+	}).(*serverConn)
+
+	var data bytes.Buffer
+	assert.Nil(t, http2.NewFramer(&data, nil).WriteData(1, true, []byte("response")))
+	// frames read are described as strings, because framer reuses them
+	frames := make(chan string, 2)
+	go func() {
+		framer := http2.NewFramer(nil, remote)
+		for {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				close(frames)
+				return
+			}
+			switch f := frame.(type) {
+			case *http2.DataFrame:
+				frames <- "DATA " + string(f.Data())
+			case *http2.GoAwayFrame:
+				frames <- fmt.Sprintf("GOAWAY %d %s", f.LastStreamID, f.ErrCode)
+			default:
+				frames <- f.Header().Type.String()
+			}
+		}
+	}()
+
+	// GOAWAY isn't inserted into the DATA frame written halfway, it follows the frame
+	_, err := conn.Write(data.Bytes()[:5])
+	assert.Nil(t, err)
+	conn.goAway(1, "too_many_pings")
+	_, err = conn.Write(data.Bytes()[5:])
+	assert.Nil(t, err)
+
+	assert.Equal(t, "DATA response", <-frames)
+	assert.Equal(t, "GOAWAY 1 ENHANCE_YOUR_CALM", <-frames)
+	// conn is closed after GOAWAY
+	_, ok := <-frames
+	assert.False(t, ok)
+}
+
+func TestServerMaxRequestBytes(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	startServer := func(addr string, maxRequestBytes int) *Server {
+		svr := NewServer(addr, config.ServerConfig{
+			Logger:          default_logger.GetDefaultLogger(),
+			MaxRequestBytes: maxRequestBytes,
+		})
+		svr.RegisterHandlerContext("/test", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+			sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+			select {
+			case headers <- header:
+			default:
+			}
+			ctrlCh <- make(http.Header)
+			// recvChan is closed without message if the request is too large
+			if msg := <-recvChan; msg != nil {
+				sendChan <- msg
+			}
+			close(sendChan)
+			ctrlCh <- make(http.Header)
+		})
+		svr.Start()
+		return svr
+	}
+	client := NewClient(tconfig.Option{Logger: default_logger.GetDefaultLogger()})
+	reqData := bytes.Repeat([]byte("hello"), 10000)
+	post := func(addr string) (http.Header, error) {
+		_, trailer, err := client.Post(addr, "/test", reqData, &config.PostConfig{
+			ContentType: constant.TripleContentType,
+			BufferSize:  1024,
+			Timeout:     3,
+			HeaderField: http.Header{"tri-attachment": []string{string(bytes.Repeat([]byte("a"), 1000))}},
+		})
+		return trailer, err
+	}
+
+	// header list of the request is got by server without limitation
+	addr := getFreeAddress(t)
+	svr := startServer(addr, 0)
+	_, err := post(addr)
+	assert.Nil(t, err)
+	svr.Stop()
+	header := <-headers
+	// body is the framed message, with 5 bytes of compressed flag and message length
+	bodySize := len(reqData) + 5
+
+	tests := []struct {
+		name string
+		// overflow is the size of request beyond max request bytes
+		overflow     int
+		headerOnly   bool
+		expectedCode string
+	}{
+		// handler sends no grpc-status in trailer
+		{name: "at boundary", overflow: 0, expectedCode: ""},
+		{name: "body beyond boundary", overflow: 1, expectedCode: "8"},
+		{name: "header list beyond boundary", overflow: 1, headerOnly: true, expectedCode: "8"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := getFreeAddress(t)
+			maxRequestBytes := headerListSize(&http.Request{Method: http.MethodPost, Host: addr, RequestURI: "/test", Header: header})
+			if !test.headerOnly {
+				maxRequestBytes += bodySize
+			}
+			maxRequestBytes -= test.overflow
+			svr := startServer(addr, maxRequestBytes)
+			defer svr.Stop()
+
+			trailer, err := post(addr)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectedCode, trailer.Get(constant.TrailerKeyGrpcStatus))
+			if test.expectedCode != "" {
+				assert.Contains(t, trailer.Get(constant.TrailerKeyGrpcMessage), "exceeds max size of "+strconv.Itoa(maxRequestBytes))
+			}
+		})
+	}
+}
+
+func TestServerHeaderReadTimeout(t *testing.T) {
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:            default_logger.GetDefaultLogger(),
+		HeaderReadTimeout: 200 * time.Millisecond,
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	// headerFrame returns HEADERS frame of request of @streamID with whole header block
+	headerFrame := func(streamID uint32) []byte {
+		var block bytes.Buffer
+		encoder := hpack.NewEncoder(&block)
+		for _, field := range [][2]string{{":method", "POST"}, {":scheme", "http"}, {":authority", addr},
+			{":path", "/com.dubbogo.Greeter/SayHello"}, {"content-type", constant.TripleContentType}} {
+			assert.Nil(t, encoder.WriteField(hpack.HeaderField{Name: field[0], Value: field[1]}))
+		}
+		var frame bytes.Buffer
+		assert.Nil(t, http2.NewFramer(&frame, nil).WriteHeaders(http2.HeadersFrameParam{
+			StreamID: streamID, BlockFragment: block.Bytes(), EndStream: true, EndHeaders: true,
+		}))
+		return frame.Bytes()
+	}
+	dial := func() (net.Conn, *http2.Framer) {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		_, err = conn.Write([]byte(http2.ClientPreface))
+		assert.Nil(t, err)
+		framer := http2.NewFramer(conn, conn)
+		assert.Nil(t, framer.WriteSettings())
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		return conn, framer
+	}
+	// readResponse reads frames until response header of @streamID, it returns GOAWAY frame if it is received
+	readResponse := func(framer *http2.Framer, streamID uint32) *http2.GoAwayFrame {
+		for {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				return nil
+			}
+			switch f := frame.(type) {
+			case *http2.GoAwayFrame:
+				return f
+			case *http2.HeadersFrame:
+				if f.StreamID == streamID {
+					return nil
+				}
+			}
+		}
+	}
+
+	// header split in time and idle conn are allowed
+	conn, framer := dial()
+	defer conn.Close()
+	frame := headerFrame(1)
+	_, err := conn.Write(frame[:10])
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = conn.Write(frame[10:])
+	assert.Nil(t, err)
+	assert.Nil(t, readResponse(framer, 1))
+	time.Sleep(300 * time.Millisecond)
+	_, err = conn.Write(headerFrame(3))
+	assert.Nil(t, err)
+	assert.Nil(t, readResponse(framer, 3))
+
+	// the conn drip-feeding header block is GOAWAY'd with ENHANCE_YOUR_CALM and closed
+	slow, framer := dial()
+	defer slow.Close()
+	_, err = slow.Write(headerFrame(1))
+	assert.Nil(t, err)
+	assert.Nil(t, readResponse(framer, 1))
+	go func() {
+		for _, b := range headerFrame(3) {
+			if _, err := slow.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	goAway := readResponse(framer, 3)
+	if assert.NotNil(t, goAway) {
+		assert.Equal(t, http2.ErrCodeEnhanceYourCalm, goAway.ErrCode)
+		assert.Equal(t, "header_read_timeout", string(goAway.DebugData()))
+		assert.Equal(t, uint32(1), goAway.LastStreamID)
+	}
+	_, err = framer.ReadFrame()
+	for err == nil {
+		_, err = framer.ReadFrame()
+	}
+	// conn is closed by server rather than read timeout
+	netErr, ok := err.(net.Error)
+	assert.False(t, ok && netErr.Timeout(), "err = %v", err)
+}
+
+func TestServerStreamWriteTimeout(t *testing.T) {
+	handlerDone := make(chan struct{})
+	addr := getFreeAddress(t)
+	svr := NewServer(addr, config.ServerConfig{
+		Logger:             default_logger.GetDefaultLogger(),
+		StreamWriteTimeout: 200 * time.Millisecond,
+	})
+	svr.RegisterHandlerContext("/stream", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		defer close(handlerDone)
+		ctrlCh <- make(http.Header)
+		for {
+			select {
+			case sendChan <- bytes.NewBuffer([]byte("message")):
+			case <-ctx.Done():
+				close(sendChan)
+				ctrlCh <- make(http.Header)
+				return
+			}
+		}
+	})
+	const total = 100
+	svr.RegisterHandlerContext("/finite", func(ctx context.Context, path string, header http.Header, recvChan chan *bytes.Buffer,
+		sendChan chan *bytes.Buffer, ctrlCh chan http.Header, errCh chan interface{}) {
+		ctrlCh <- make(http.Header)
+		for i := 0; i < total; i++ {
+			sendChan <- bytes.NewBuffer([]byte(strconv.Itoa(i)))
+		}
+		close(sendChan)
+		ctrlCh <- make(http.Header)
+	})
+	svr.Start()
+	defer svr.Stop()
+
+	// messages read in time are all written by the writer of stream
+	client := NewClient(tconfig.Option{Logger: default_logger.GetDefaultLogger()})
+	reqChan := make(chan *bytes.Buffer, 1)
+	reqChan <- nil
+	recvChan, trailerChan, err := client.StreamPost(addr, "/finite", reqChan, &config.PostConfig{
+		ContentType: constant.TripleContentType,
+		BufferSize:  1024,
+		Timeout:     3,
+	})
+	assert.Nil(t, err)
+	received := 0
+	for msg := range recvChan {
+		assert.Equal(t, strconv.Itoa(received), msg.String())
+		received++
+	}
+	assert.Equal(t, total, received)
+	assert.Equal(t, "0", (<-trailerChan).Get(constant.TrailerKeyHttp2Status))
+
+	// client never reads, flow control window of its streams is zero
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	assert.Nil(t, err)
+	framer := http2.NewFramer(conn, conn)
+	assert.Nil(t, framer.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 0}))
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	for _, field := range [][2]string{{":method", "POST"}, {":scheme", "http"}, {":authority", addr},
+		{":path", "/stream"}, {"content-type", constant.TripleContentType}} {
+		assert.Nil(t, encoder.WriteField(hpack.HeaderField{Name: field[0], Value: field[1]}))
+	}
+	assert.Nil(t, framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID: 1, BlockFragment: block.Bytes(), EndStream: true, EndHeaders: true,
+	}))
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+	// the stream is reset once the write of message times out, before its pending DATA frame
+	var rst *http2.RSTStreamFrame
+	for rst == nil {
+		frame, err := framer.ReadFrame()
+		if !assert.Nil(t, err) {
+			return
+		}
+		switch f := frame.(type) {
+		case *http2.DataFrame:
+			assert.Equal(t, 0, len(f.Data()))
+		case *http2.RSTStreamFrame:
+			rst = f
+		}
+	}
+	assert.Equal(t, uint32(1), rst.StreamID)
+	assert.Equal(t, http2.ErrCodeInternal, rst.ErrCode)
+	select {
+	case <-handlerDone:
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler isn't freed after stream write timeout")
+	}
+
+	// the conn is still served
+	assert.Nil(t, framer.WritePing(false, [8]byte{1}))
+	for {
+		frame, err := framer.ReadFrame()
+		if !assert.Nil(t, err) {
+			return
+		}
+		if ping, ok := frame.(*http2.PingFrame); ok {
+			assert.True(t, ping.IsAck())
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+// strayFrameWindow is the number of the latest streams ended by server, whose stray frames are recognized
+const strayFrameWindow = 128
+
+/*
+strayFrameTracker tracks the streams which server ends by END_STREAM, and recognizes HEADERS, CONTINUATION and DATA
+frames received on them after that, e.g. duplicate trailers, as stray frames to be rejected with StrayFrameReject.
+The transport forgets the stream once it is ended, and ignores frames of forgotten streams as the ones of streams
+reset by client, which is what StrayFrameIgnore needs, so the tracker is only used with StrayFrameReject.
+
+Trailers without END_STREAM or with pseudo header fields, and HEADERS after them, are connection errors of
+PROTOCOL_ERROR of transport, whatever the strategy is. Only the latest strayFrameWindow streams ended by server are
+remembered, frames of older ones are ignored by transport.
+*/
+type strayFrameTracker struct {
+	addr string
+
+	// ended are the streams ended by server, in the order of ending in ring, they are only accessed by read loop
+	ended map[uint32]struct{}
+	ring  []uint32
+	next  int
+	// ending is the stream whose header block with END_STREAM isn't ended by END_HEADERS yet, zero if none
+	ending uint32
+
+	// rejectErr is set once a stray frame is received
+	rejectErr error
+}
+
+func newStrayFrameTracker(addr string) *strayFrameTracker {
+	return &strayFrameTracker{
+		addr:  addr,
+		ended: make(map[uint32]struct{}, strayFrameWindow),
+		ring:  make([]uint32, strayFrameWindow),
+	}
+}
+
+// isStray tracks streams ended by server with received frame @info, it returns true if @info is a stray frame
+func (t *strayFrameTracker) isStray(info tconfig.FrameInfo) bool {
+	switch info.Type {
+	case h2.FrameHeaders, h2.FrameData:
+	case h2.FrameContinuation:
+		if info.StreamID == t.ending {
+			if info.Flags.Has(h2.FlagContinuationEndHeaders) {
+				t.ending = 0
+				t.end(info.StreamID)
+			}
+			return false
+		}
+	default:
+		return false
+	}
+	if _, ok := t.ended[info.StreamID]; ok {
+		t.rejectErr = perrors.Errorf("http2 client: received %s frame of stream %d from %s after END_STREAM",
+			info.Type, info.StreamID, t.addr)
+		return true
+	}
+	// END_STREAM flag of HEADERS is the same as DATA
+	if info.Type != h2.FrameContinuation && info.Flags.Has(h2.FlagDataEndStream) {
+		if info.Type == h2.FrameHeaders && !info.Flags.Has(h2.FlagHeadersEndHeaders) {
+			t.ending = info.StreamID
+		} else {
+			t.end(info.StreamID)
+		}
+	}
+	return false
+}
+
+// end remembers stream @streamID ended by server, the oldest one is forgotten if the window is full
+func (t *strayFrameTracker) end(streamID uint32) {
+	delete(t.ended, t.ring[t.next])
+	t.ring[t.next] = streamID
+	t.next = (t.next + 1) % len(t.ring)
+	t.ended[streamID] = struct{}{}
+}
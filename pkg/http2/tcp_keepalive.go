@@ -0,0 +1,49 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"net"
+)
+
+import (
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+// setTCPKeepalive applies OS-level @keepalive to @conn, it does nothing if @conn is not tcp conn, e.g. conn of
+// in-memory transport, or @keepalive is zero. The knobs not settable on the platform are skipped with error returned,
+// after the settable ones are applied.
+func setTCPKeepalive(conn net.Conn, keepalive tconfig.TCPKeepalive) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || keepalive == (tconfig.TCPKeepalive{}) {
+		return nil
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	if keepalive.Idle > 0 {
+		// older go sets the interval of probes as well on linux, which is overridden by Interval if it is set
+		if err := tcpConn.SetKeepAlivePeriod(keepalive.Idle); err != nil {
+			return err
+		}
+	}
+	if keepalive.Interval <= 0 && keepalive.Count <= 0 {
+		return nil
+	}
+	return setKeepaliveProbes(tcpConn, keepalive.Interval, keepalive.Count)
+}
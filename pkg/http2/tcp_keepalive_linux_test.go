@@ -0,0 +1,105 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common/logger/default_logger"
+	tconfig "github.com/dubbogo/triple/pkg/config"
+)
+
+// getsockoptInts returns int socket options of @conn, each of which is level and name
+func getsockoptInts(t *testing.T, conn net.Conn, opts ...[2]int) []int {
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	assert.Nil(t, err)
+	values := make([]int, len(opts))
+	assert.Nil(t, rawConn.Control(func(fd uintptr) {
+		for i, opt := range opts {
+			values[i], err = syscall.GetsockoptInt(int(fd), opt[0], opt[1])
+			assert.Nil(t, err)
+		}
+	}))
+	return values
+}
+
+func TestClientTCPKeepalive(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lst.Close()
+
+	// the conn dialed by client is captured, the dial of http2 conn is not finished without server
+	dialed := make(chan net.Conn, 1)
+	client := NewClient(tconfig.Option{
+		Logger: default_logger.GetDefaultLogger(),
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := defaultDialContext(ctx, network, addr)
+			if err == nil {
+				dialed <- conn
+			}
+			return conn, err
+		},
+		TCPKeepalive: tconfig.TCPKeepalive{Idle: 30 * time.Second, Interval: 5 * time.Second, Count: 3},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = client.Dial(ctx, lst.Addr().String())
+
+	conn := <-dialed
+	defer conn.Close()
+	values := getsockoptInts(t, conn,
+		[2]int{syscall.SOL_SOCKET, syscall.SO_KEEPALIVE},
+		[2]int{syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE},
+		[2]int{syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL},
+		[2]int{syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT},
+	)
+	assert.Equal(t, []int{1, 30, 5, 3}, values)
+}
+
+func TestSetTCPKeepalive(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lst.Close()
+	conn, err := net.Dial("tcp", lst.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// idle is rounded up to seconds
+	assert.Nil(t, setTCPKeepalive(conn, tconfig.TCPKeepalive{Idle: 1500 * time.Millisecond}))
+	values := getsockoptInts(t, conn,
+		[2]int{syscall.SOL_SOCKET, syscall.SO_KEEPALIVE},
+		[2]int{syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE},
+	)
+	assert.Equal(t, []int{1, 2}, values)
+
+	// non tcp conn is skipped
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	assert.Nil(t, setTCPKeepalive(client, tconfig.TCPKeepalive{Idle: time.Second}))
+}
@@ -0,0 +1,105 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+// tlsHandshakeTimeout is the max time server waits for TLS handshake of accepted conn
+const tlsHandshakeTimeout = 10 * time.Second
+
+// http2ALPN is the ALPN protocol id of http2 over TLS
+const http2ALPN = "h2"
+
+// NextProtos returns ALPN protocols @protos offered by client and supported by server over TLS, which is ["h2"] if
+// @protos is empty. Triple always speaks h2 over the conn, so @protos must contain "h2", other ids are only for peers
+// like proxies expecting them.
+func NextProtos(protos []string) ([]string, error) {
+	if len(protos) == 0 {
+		return []string{http2ALPN}, nil
+	}
+	for _, p := range protos {
+		if p == http2ALPN {
+			return protos, nil
+		}
+	}
+	return nil, perrors.Errorf("ALPN protocols %q don't contain %q", protos, http2ALPN)
+}
+
+// newTLSConfig returns the copy of @conf with ALPN protocols @protos, it returns nil if @conf is nil
+func newTLSConfig(conf *tls.Config, protos []string) *tls.Config {
+	if conf == nil {
+		return nil
+	}
+	conf = conf.Clone()
+	// invalid protos are rejected by triple controller
+	conf.NextProtos, _ = NextProtos(protos)
+	return conf
+}
+
+// tlsClientHandshake does TLS handshake of conn @conn dialed to @addr with @conf, it is aborted once @ctx is done
+func tlsClientHandshake(ctx context.Context, conn net.Conn, addr string, conf *tls.Config) (net.Conn, error) {
+	if conf.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		conf = conf.Clone()
+		conf.ServerName = host
+	}
+	tlsConn := tls.Client(conn, conf)
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- tlsConn.Handshake()
+	}()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			return nil, perrors.WithMessagef(err, "TLS handshake with %s", addr)
+		}
+		return tlsConn, nil
+	case <-ctx.Done():
+		// closing conn aborts the handshake
+		_ = conn.Close()
+		<-errChan
+		return nil, ctx.Err()
+	}
+}
+
+// tlsServerHandshake does TLS handshake of accepted conn @conn with @conf
+func tlsServerHandshake(conn net.Conn, conf *tls.Config) (net.Conn, error) {
+	tlsConn := tls.Server(conn, conf)
+	_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, perrors.WithMessagef(err, "TLS handshake with %v", conn.RemoteAddr())
+	}
+	return tlsConn, nil
+}
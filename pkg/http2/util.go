@@ -1,14 +1,189 @@
 package http2
 
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
 import (
 	gxlog "github.com/dubbogo/gost/log"
 
 	"github.com/dubbogo/net/http2"
+
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	_ "github.com/dubbogo/triple/internal/codec/compressor_impl"
+	"github.com/dubbogo/triple/internal/codes"
+	"github.com/dubbogo/triple/internal/status"
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
 )
 
+// compressedFlag is the first byte of frame whose message is compressed
+const compressedFlag = byte(1)
+
+// messageHeaderLen is the length of compressed flag and message length before each message
+const messageHeaderLen = 5
+
 func writeResponse(w *http2.Http2ResponseWriter, logger gxlog.Logger, code int, message string) {
 	w.WriteHeader(code)
 	if _, err := w.Write([]byte(message)); err != nil {
 		logger.Errorf("write response failed, message: %s, err: %v\n", message, err)
 	}
 }
+
+// writeUnsupportedEncodingResponse returns Unimplemented status, with compressors supported by server
+func writeUnsupportedEncodingResponse(w *http2.Http2ResponseWriter, encoding string) {
+	w.Header().Set("content-type", constant.TripleContentType)
+	w.Header().Set(constant.GrpcAcceptEncoding, strings.Join([]string{constant.IdentityCompressorName, constant.GzipCompressorName}, ","))
+	w.WriteHeader(http.StatusOK)
+	w.FlushHeader()
+	writeTripleFinalRspHeaderField(w, http.Header{
+		constant.TrailerKeyGrpcStatus:  []string{strconv.Itoa(int(codes.Unimplemented))},
+		constant.TrailerKeyGrpcMessage: []string{"grpc: Decompressor is not installed for grpc-encoding " + encoding},
+	})
+}
+
+// writeDecompressErrorResponse writes response of rpc failed because request message can't be decompressed with
+// @err, the header is written only if @headerSent is false
+func writeDecompressErrorResponse(w *http2.Http2ResponseWriter, headerSent bool, err error) {
+	code, msg := decompressErrorStatus(err)
+	writeErrorResponse(w, headerSent, code, msg)
+}
+
+// decompressErrorStatus returns grpc status of rpc failed because a message can't be decompressed with @err, it is
+// ResourceExhausted if the decompressed message is too large, otherwise Internal
+func decompressErrorStatus(err error) (codes.Code, string) {
+	if tErr, ok := err.(*status.TripleError); ok && tErr.Status().Code() == codes.ResourceExhausted {
+		return codes.ResourceExhausted, tErr.Status().Message()
+	}
+	return codes.Internal, "grpc: failed to decompress the received message"
+}
+
+// writeErrorResponse writes response of rpc failed by server with status @code and @msg, before the rpc is handled
+// or in the middle of it, the header is written only if @headerSent is false
+func writeErrorResponse(w *http2.Http2ResponseWriter, headerSent bool, code codes.Code, msg string) {
+	if !headerSent {
+		w.Header().Set("content-type", constant.TripleContentType)
+		w.WriteHeader(http.StatusOK)
+		w.FlushHeader()
+	}
+	writeTripleFinalRspHeaderField(w, http.Header{
+		constant.TrailerKeyGrpcStatus:  []string{strconv.Itoa(int(code))},
+		constant.TrailerKeyGrpcMessage: []string{msg},
+	})
+}
+
+// headerListSize returns the size of header list of request @r, including pseudo header fields, which is counted
+// like SETTINGS_MAX_HEADER_LIST_SIZE: the length of name and value plus 32 bytes overhead per field
+func headerListSize(r *http.Request) int {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	size := 0
+	for _, field := range [][2]string{{":method", r.Method}, {":scheme", scheme}, {":authority", r.Host}, {":path", r.RequestURI}} {
+		size += len(field[0]) + len(field[1]) + 32
+	}
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + 32
+		}
+	}
+	return size
+}
+
+// requestTooLargeMessage returns grpc-message of request exceeding max request bytes @max
+func requestTooLargeMessage(max int) string {
+	return fmt.Sprintf("grpc: request exceeds max size of %d bytes of header list and body", max)
+}
+
+// errRequestTooLarge is returned by limitedBody once the request exceeds max request bytes
+var errRequestTooLarge = perrors.New("request exceeds max request bytes")
+
+// limitedBody is request body, which fails with errRequestTooLarge once more than @remaining bytes are read from it.
+// @onExceed is called when it fails the first time.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int
+	onExceed  func()
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errRequestTooLarge
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= n
+	if b.remaining < 0 {
+		b.onExceed()
+		return 0, errRequestTooLarge
+	}
+	return n, err
+}
+
+// compressorKey is the key of compressor in compressorCache
+type compressorKey struct {
+	name  string
+	level int
+}
+
+// compressorCache caches one compressor for each grpc-encoding name and level, so that a compressor, and writers
+// pooled by it, is shared by all rpcs, instead of being created for each of them
+type compressorCache struct {
+	// compressors is map of compressorKey to common.Compressor
+	compressors sync.Map
+}
+
+// getCompressor returns compressor of grpc-encoding @name, it returns nil if @name is empty or identity. Only the
+// compressor created successfully is cached.
+func (c *compressorCache) getCompressor(name string, level int) (common.Compressor, error) {
+	if name == "" || name == constant.IdentityCompressorName {
+		return nil, nil
+	}
+	key := compressorKey{name: name, level: level}
+	if compressor, ok := c.compressors.Load(key); ok {
+		return compressor.(common.Compressor), nil
+	}
+	compressor, err := common.GetCompressor(name, level)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := c.compressors.LoadOrStore(key, compressor)
+	return actual.(common.Compressor), nil
+}
+
+// frameData returns @data with length header, if @compressor is not nil, data is compressed and the compressed flag
+// in header is set. Each message of unary and streaming rpc is compressed on its own, rather than as part of a
+// single compressed stream, so that receiver can decompress every message independently.
+func frameData(frameHandler common.PackageHandler, data []byte, compressor common.Compressor) ([]byte, error) {
+	if compressor == nil {
+		return frameHandler.Pkg2FrameData(data), nil
+	}
+	compressedData, err := compressor.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	frame := frameHandler.Pkg2FrameData(compressedData)
+	frame[0] = compressedFlag
+	return frame, nil
+}
+
+// isCompressed returns if the message of @frameData with header is compressed
+func isCompressed(frameData []byte) bool {
+	return len(frameData) > 0 && frameData[0] == compressedFlag
+}
+
+// decompress decompresses message @data with compressed flag by @decompressor
+func decompress(decompressor common.Compressor, data []byte) ([]byte, error) {
+	if decompressor == nil {
+		return nil, perrors.New("receive compressed message without grpc-encoding")
+	}
+	return decompressor.Decompress(data)
+}
@@ -0,0 +1,159 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"errors"
+	"time"
+)
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+)
+
+// errStreamWriteTimeout is returned by messageWriter if the message isn't written in streamWriteTimeout of server
+var errStreamWriteTimeout = errors.New("stream write timeout")
+
+/*
+messageWriter writes framed response messages of a stream. If streamWriteTimeout of server is set, messages are
+written by a single writer goroutine of the stream, and the handler waits for each write with a timer re-armed for
+it. If the write doesn't finish in time, e.g. flow control window of stream stays closed as client stops reading,
+errStreamWriteTimeout is returned with the write pending, which is unblocked once the stream is reset.
+*/
+type messageWriter struct {
+	w       *h2.Http2ResponseWriter
+	timeout time.Duration
+	// messages are sent to writer goroutine, and results of writes are sent back by done
+	messages chan []byte
+	done     chan error
+	// timer is created by the first write, and re-armed by the following ones
+	timer *time.Timer
+}
+
+// newMessageWriter returns messageWriter of response writer @w, close must be called after the stream is finished
+func (s *Server) newMessageWriter(w *h2.Http2ResponseWriter) *messageWriter {
+	mw := &messageWriter{
+		w:       w,
+		timeout: s.streamWriteTimeout,
+	}
+	if mw.timeout <= 0 {
+		return mw
+	}
+	mw.messages = make(chan []byte)
+	// a write given up by timeout sends its result without waiting
+	mw.done = make(chan error, 1)
+	go func() {
+		for data := range mw.messages {
+			mw.done <- mw.writeAndFlush(data)
+		}
+	}()
+	return mw
+}
+
+// write writes framed message @data and flushes it, it returns errStreamWriteTimeout if it times out
+func (mw *messageWriter) write(data []byte) error {
+	if mw.timeout <= 0 {
+		return mw.writeAndFlush(data)
+	}
+	mw.messages <- data
+	if mw.timer == nil {
+		mw.timer = time.NewTimer(mw.timeout)
+	} else {
+		mw.timer.Reset(mw.timeout)
+	}
+	select {
+	case err := <-mw.done:
+		if !mw.timer.Stop() {
+			<-mw.timer.C
+		}
+		return err
+	case <-mw.timer.C:
+		return errStreamWriteTimeout
+	}
+}
+
+func (mw *messageWriter) writeAndFlush(data []byte) error {
+	_, err := mw.w.Write(data)
+	mw.w.Flush()
+	return err
+}
+
+// close stops writer goroutine, which exits after the pending write if any
+func (mw *messageWriter) close() {
+	if mw.messages == nil {
+		return
+	}
+	close(mw.messages)
+	if mw.timer != nil {
+		mw.timer.Stop()
+	}
+}
+
+/*
+streamResetWriteScheduler is the write scheduler of conns of server with stream write timeout. Frames of stream
+without data, e.g. RST_STREAM of the handler aborted by write timeout, are written before the DATA frames pending in
+random write scheduler of http2, which may never be written as flow control window of the stream stays closed.
+
+Handler writes HEADERS and DATA frames of its stream one by one, each waits for the previous one to be written, so
+the order of them is kept.
+*/
+type streamResetWriteScheduler struct {
+	h2.WriteScheduler
+	// urgent are the frames without data of streams, in the order pushed
+	urgent []h2.FrameWriteRequest
+}
+
+// newStreamResetWriteScheduler returns streamResetWriteScheduler of a conn
+func newStreamResetWriteScheduler() h2.WriteScheduler {
+	return &streamResetWriteScheduler{WriteScheduler: h2.NewRandomWriteScheduler()}
+}
+
+// Push queues frames without data of stream to urgent, and the others to random write scheduler
+func (ws *streamResetWriteScheduler) Push(wr h2.FrameWriteRequest) {
+	if wr.StreamID() != 0 && wr.DataSize() == 0 {
+		ws.urgent = append(ws.urgent, wr)
+		return
+	}
+	ws.WriteScheduler.Push(wr)
+}
+
+// Pop returns the first urgent frame if any, before the ones of random write scheduler
+func (ws *streamResetWriteScheduler) Pop() (h2.FrameWriteRequest, bool) {
+	if len(ws.urgent) > 0 {
+		wr := ws.urgent[0]
+		ws.urgent[0] = h2.FrameWriteRequest{}
+		ws.urgent = ws.urgent[1:]
+		return wr, true
+	}
+	return ws.WriteScheduler.Pop()
+}
+
+// CloseStream drops urgent frames of stream @streamID, like random write scheduler drops the pending ones of it
+func (ws *streamResetWriteScheduler) CloseStream(streamID uint32) {
+	kept := ws.urgent[:0]
+	for _, wr := range ws.urgent {
+		if wr.StreamID() != streamID {
+			kept = append(kept, wr)
+		}
+	}
+	for i := len(kept); i < len(ws.urgent); i++ {
+		ws.urgent[i] = h2.FrameWriteRequest{}
+	}
+	ws.urgent = kept
+	ws.WriteScheduler.CloseStream(streamID)
+}
@@ -0,0 +1,109 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package triple
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+import (
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+// greeterClient is typed client of service com.apache.dubbo.sample.basic.IGreeter written like generated code, which
+// calls TypedClientConn with constant paths
+type greeterClient struct {
+	cc TypedClientConn
+}
+
+// newGreeterClient returns typed client over @cc, e.g. TripleClient
+func newGreeterClient(cc TypedClientConn) *greeterClient {
+	return &greeterClient{cc: cc}
+}
+
+// SayHello is unary method, the response attachment is returned with reply
+func (c *greeterClient) SayHello(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, common.TripleAttachment, error) {
+	reply := &wrapperspb.StringValue{}
+	res := c.cc.Request(ctx, "/com.apache.dubbo.sample.basic.IGreeter/SayHello", req, reply)
+	if err := res.GetError(); err != nil {
+		return nil, res.GetAttachments(), err
+	}
+	return reply, res.GetAttachments(), nil
+}
+
+// Subscribe is server-streaming method, which returns typed stream
+func (c *greeterClient) Subscribe(ctx context.Context, req *wrapperspb.StringValue) (*greeterSubscribeStream, error) {
+	stream, err := c.cc.StreamRequest(ctx, "/com.apache.dubbo.sample.basic.IGreeter/Subscribe")
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	return &greeterSubscribeStream{ClientStream: NewClientStream(stream)}, nil
+}
+
+// greeterSubscribeStream is typed stream of Subscribe, which receives messages of the reply type
+type greeterSubscribeStream struct {
+	*ClientStream
+}
+
+// Recv receives the next event, it returns io.EOF at the end of stream
+func (s *greeterSubscribeStream) Recv() (*wrapperspb.StringValue, error) {
+	event := &wrapperspb.StringValue{}
+	if err := s.RecvMsg(event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func Example_typedClient() {
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation("127.0.0.1:20001")))
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	greeter := newGreeterClient(client)
+	reply, _, err := greeter.SayHello(context.Background(), wrapperspb.String("dubbo"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(reply.GetValue())
+
+	events, err := greeter.Subscribe(context.Background(), wrapperspb.String("news"))
+	if err != nil {
+		panic(err)
+	}
+	for {
+		event, err := events.Recv()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			panic(err)
+		}
+		fmt.Println(event.GetValue())
+	}
+}
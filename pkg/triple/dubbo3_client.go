@@ -19,8 +19,11 @@ package triple
 
 import (
 	"context"
+	"io"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 import (
@@ -34,14 +37,45 @@ import (
 	"github.com/dubbogo/triple/internal/tools"
 	"github.com/dubbogo/triple/pkg/common"
 	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/common/logger"
 	"github.com/dubbogo/triple/pkg/config"
 )
 
+// MethodInvoker calls method of @stub returned by GetDubboStub directly, without reflection. @in are the arguments
+// passed to TripleClient.Invoke, e.g. ctx and request, and the reply of stub method should be written to @reply.
+// It returns the response attachment of the stub method, which can be nil, and a nil error on success.
+type MethodInvoker func(stub interface{}, in []reflect.Value, reply interface{}) (common.TripleAttachment, error)
+
+// TypedClientConn is the contract of typed clients generated by code generators, e.g. protoc plugin of dubbogo. Methods
+// of generated stub call it with constant method path /interfaceKey/functionName and messages of the method types, the
+// reply is allocated by the stub and returned after the call, so no stub method is looked up by name or called by
+// reflection. Messages are marshaled by codec of the client option, i.e. MethodCodecTypes of the path or CodecType,
+// so the stub doesn't pass codec per call. Streaming methods of stub wrap the returned grpc.ClientStream with typed
+// Send and Recv. TripleClient implements it.
+type TypedClientConn interface {
+	// Request sends unary rpc of @path with request @arg, and unmarshals the response to @reply
+	Request(ctx context.Context, path string, arg, reply interface{}) common.ErrorWithAttachment
+	// StreamRequest starts streaming rpc of @path
+	StreamRequest(ctx context.Context, path string) (grpc.ClientStream, error)
+}
+
+var _ TypedClientConn = &TripleClient{}
+
 // TripleClient client endpoint that using triple protocol
+// It is safe for concurrent Invoke, Request and StreamRequest across goroutines, and Close can be called concurrently
+// with them: rpcs started after Close fail with Canceled error, running unary rpcs are finished, and running streams
+// are closed. If the controller is shared by other clients, running streams are closed when the last client is closed.
 type TripleClient struct {
 	h2Controller *http2.TripleController
+	// closed is 1 after Close, the shared controller may still be used by other clients
+	closed int32
 
 	stubInvoker reflect.Value
+	// stubMethods stores method name -> method of stubInvoker, it is populated once when client is created, so that
+	// Invoke doesn't resolve MethodByName per call. It is read only.
+	stubMethods map[string]reflect.Value
+	// methodInvokers stores method name -> MethodInvoker, which bypasses reflection dispatch of stubInvoker
+	methodInvokers sync.Map
 
 	//once is used when destroy
 	once sync.Once
@@ -57,24 +91,70 @@ type TripleClient struct {
 // it returns tripleClient, which contains invoker and triple connection.
 // @impl must have method: GetDubboStub(cc *dubbo3.TripleConn) interface{}, to be capable with grpc
 // @opt is used to init http2 controller, if it's nil, use the default config
+// The conn to server is not dialed until the first rpc, see NewTripleClientContext to dial it in advance.
 func NewTripleClient(impl interface{}, opt *config.Option) (*TripleClient, error) {
+	h2Controller, err := newRetainedController(opt)
+	if err != nil {
+		return nil, err
+	}
+	return newTripleClient(impl, h2Controller), nil
+}
+
+// NewTripleClientContext creates triple client like NewTripleClient, but the conn to server is dialed with @ctx
+// during construction, if @ctx is cancelled or its deadline exceeds before the conn is set up, the dial is aborted
+// and error is returned.
+func NewTripleClientContext(ctx context.Context, impl interface{}, opt *config.Option) (*TripleClient, error) {
+	h2Controller, err := newRetainedController(opt)
+	if err != nil {
+		return nil, err
+	}
+	if err := h2Controller.Dial(ctx); err != nil {
+		h2Controller.Release()
+		return nil, err
+	}
+	return newTripleClient(impl, h2Controller), nil
+}
+
+// newRetainedController creates controller of @opt, which is retained for the client to create
+func newRetainedController(opt *config.Option) (*http2.TripleController, error) {
 	opt = tools.AddDefaultOption(opt)
 	h2Controller, err := http2.NewTripleController(opt)
 	if err != nil {
 		opt.Logger.Errorf("NewTripleController err = %v", err)
 		return nil, err
 	}
+	// the new controller is not destroyed yet
+	_ = h2Controller.Retain()
+	return h2Controller, nil
+}
+
+// NewTripleClientWithController creates triple client like NewTripleClient, but rpcs are sent by the shared
+// @controller, e.g. Controller() of another client, so that clients of different stubs to the same server multiplex
+// over the same conns. The option of @controller is used, and the controller is destroyed when the last client
+// sharing it is closed.
+func NewTripleClientWithController(impl interface{}, controller *http2.TripleController) (*TripleClient, error) {
+	if err := controller.Retain(); err != nil {
+		return nil, err
+	}
+	return newTripleClient(impl, controller), nil
+}
+
+// newTripleClient creates triple client of @impl with @h2Controller retained for it
+func newTripleClient(impl interface{}, h2Controller *http2.TripleController) *TripleClient {
+	opt := h2Controller.Option()
 	tripleClient := &TripleClient{
 		opt:          opt,
 		h2Controller: h2Controller,
 	}
 
-	// put dubbo3 network logic to tripleConn, creat pb stub invoker
-	if opt.CodecType == constant.PBCodecName {
+	// put dubbo3 network logic to tripleConn, creat pb stub invoker, nil @impl means the client is used without stub,
+	// e.g. by Request or RequestRaw
+	if opt.CodecType == constant.PBCodecName && impl != nil {
 		tripleClient.stubInvoker = reflect.ValueOf(getInvoker(impl, newTripleConn(tripleClient)))
+		tripleClient.stubMethods = cacheStubMethods(tripleClient.stubInvoker)
 	}
 
-	return tripleClient, nil
+	return tripleClient
 }
 
 // Invoke call remote using stub
@@ -83,8 +163,11 @@ func (t *TripleClient) Invoke(methodName string, in []reflect.Value, reply inter
 		methodName, in, reply, t.opt.CodecType)
 	attachment := make(common.TripleAttachment)
 	if t.opt.CodecType == constant.PBCodecName {
-		method := t.stubInvoker.MethodByName(methodName)
-		if method.IsZero() {
+		if invoker, ok := t.methodInvokers.Load(methodName); ok {
+			return t.invokeDirectly(invoker.(MethodInvoker), in, reply)
+		}
+		method := t.stubMethod(methodName)
+		if !method.IsValid() {
 			t.opt.Logger.Errorf("TripleClient.Invoke: methodName %s not impl in triple client api.", methodName)
 			return *common.NewErrorWithAttachment(status.Errorf(codes.Unimplemented, "TripleClient.Invoke: methodName %s not impl in triple client api.", methodName), attachment)
 		}
@@ -104,7 +187,15 @@ func (t *TripleClient) Invoke(methodName string, in []reflect.Value, reply inter
 			return *common.NewErrorWithAttachment(res[1].Interface().(error), attachment)
 		}
 		t.opt.Logger.Debugf("TripleClient.Invoke: get reply = %+v", res[0])
-		_ = tools.ReflectResponse(res[0], reply)
+		// reply of stub is copied to @reply, which must be compatible, otherwise the call fails instead of
+		// leaving a zero reply silently
+		if err := tools.ReflectResponse(res[0], reply); err != nil {
+			t.opt.Logger.Debugf("TripleClient.Invoke: reply of method %s is %s, which can't be copied to expected reply %T",
+				methodName, res[0].Type(), reply)
+			t.opt.Logger.Errorf("TripleClient.Invoke: copy reply of method %s failed, error = %v", methodName, err)
+			return *common.NewErrorWithAttachment(status.Errorf(codes.Internal,
+				"TripleClient.Invoke: copy reply %s of method %s to %T failed: %v", res[0].Type(), methodName, reply, err), attachment)
+		}
 	} else {
 		ctx := in[0].Interface().(context.Context)
 		interfaceKey := ctx.Value(constant.InterfaceKey).(string)
@@ -120,26 +211,196 @@ func (t *TripleClient) Invoke(methodName string, in []reflect.Value, reply inter
 	return *common.NewErrorWithAttachment(nil, attachment)
 }
 
+// cacheStubMethods returns exported methods of @stub by name
+func cacheStubMethods(stub reflect.Value) map[string]reflect.Value {
+	if !stub.IsValid() {
+		return nil
+	}
+	typ := stub.Type()
+	methods := make(map[string]reflect.Value, typ.NumMethod())
+	for i := 0; i < typ.NumMethod(); i++ {
+		methods[typ.Method(i).Name] = stub.Method(i)
+	}
+	return methods
+}
+
+// stubMethod returns method @methodName of stub, which is invalid reflect.Value if stub doesn't have it
+func (t *TripleClient) stubMethod(methodName string) reflect.Value {
+	if t.stubMethods != nil {
+		return t.stubMethods[methodName]
+	}
+	if !t.stubInvoker.IsValid() {
+		return reflect.Value{}
+	}
+	// stub methods are not cached
+	return t.stubInvoker.MethodByName(methodName)
+}
+
+// WarmUp sets up the conn to server, including TCP, TLS and http2 handshake, and waits for a PING round trip on it,
+// so that the first real rpc doesn't pay handshake cost. It is safe to call concurrently and it is idempotent,
+// the conn is re-dialed only if the former one is broken.
+func (t *TripleClient) WarmUp(ctx context.Context) error {
+	return t.h2Controller.WarmUp(ctx)
+}
+
+// WarmUpConns warms up the conns to all endpoints concurrently, and returns the result of each conn in the order of
+// endpoints. It returns after all conns are ready or failed, or @ctx is done.
+func (t *TripleClient) WarmUpConns(ctx context.Context) []config.WarmUpResult {
+	return t.h2Controller.WarmUpConns(ctx)
+}
+
+// EjectedEndpoints returns sorted addresses of endpoints of Resolver which are ejected by outlier detection now
+func (t *TripleClient) EjectedEndpoints() []string {
+	return t.h2Controller.EjectedEndpoints()
+}
+
+// SetLogLevel changes the level of logs of client at runtime, see TripleController.SetLogLevel
+func (t *TripleClient) SetLogLevel(level logger.Level) {
+	t.h2Controller.SetLogLevel(level)
+}
+
+// CancelAll cancels all running rpcs of the client with cause common.ErrCanceledAll, see TripleController.CancelAll.
+// Rpcs of other clients sharing the controller are canceled too.
+func (t *TripleClient) CancelAll() {
+	t.h2Controller.CancelAll()
+}
+
+// SetMethodInvoker registers @invoker of stub method @methodName, then Invoke calls it directly instead of reflection
+// dispatch by MethodByName and Call, which is still the fallback of methods without invoker
+func (t *TripleClient) SetMethodInvoker(methodName string, invoker MethodInvoker) {
+	t.methodInvokers.Store(methodName, invoker)
+}
+
+// invokeDirectly calls stub method by @invoker, the result is handled like reflection dispatch
+func (t *TripleClient) invokeDirectly(invoker MethodInvoker, in []reflect.Value, reply interface{}) common.ErrorWithAttachment {
+	attachment, err := invoker(t.stubInvoker.Interface(), in, reply)
+	if attachment == nil {
+		attachment = make(common.TripleAttachment)
+	}
+	return *common.NewErrorWithAttachment(err, attachment)
+}
+
 // Request call h2Controller to send unary rpc req to server
 // @path is /interfaceKey/functionName e.g. /com.apache.dubbo.sample.basic.IGreeter/BigUnaryTest
 // @arg is request body
 func (t *TripleClient) Request(ctx context.Context, path string, arg, reply interface{}) common.ErrorWithAttachment {
-	return t.h2Controller.UnaryInvoke(ctx, path, arg, reply)
+	if err := t.checkAvailable(); err != nil {
+		return *common.NewErrorWithAttachment(err, make(common.TripleAttachment))
+	}
+	ctx = t.withBaseContext(ctx)
+	return t.h2Controller.UnaryInvoke(ctx, t.rewritePath(ctx, path), arg, reply)
+}
+
+// RequestRaw call h2Controller to send unary rpc req to server, with codec bypassed for both request and response.
+// It is used for passthrough proxy, which forwards messages marshaled by codec of the same type as client option.
+// @path is /interfaceKey/functionName e.g. /com.apache.dubbo.sample.basic.IGreeter/BigUnaryTest
+// @argBytes is the raw request message
+func (t *TripleClient) RequestRaw(ctx context.Context, path string, argBytes []byte) ([]byte, common.TripleAttachment, error) {
+	if err := t.checkAvailable(); err != nil {
+		return nil, make(common.TripleAttachment), err
+	}
+	ctx = t.withBaseContext(ctx)
+	return t.h2Controller.UnaryInvokeRaw(ctx, t.rewritePath(ctx, path), argBytes)
+}
+
+// RequestChunked call h2Controller to send unary rpc req to server, but the response is not unmarshaled to reply,
+// it returns an io.ReadCloser over the raw chunks sent by server, which returns a common.TripleError instead of
+// io.EOF at the end, if the rpc fails. The reader must be closed after use.
+// It is used for huge response, server provides it by returning an io.Reader as the result of TripleUnaryService.
+// @path is /interfaceKey/functionName e.g. /com.apache.dubbo.sample.basic.IGreeter/BigUnaryTest
+// @arg is request body
+func (t *TripleClient) RequestChunked(ctx context.Context, path string, arg interface{}) (io.ReadCloser, error) {
+	if err := t.checkAvailable(); err != nil {
+		return nil, err
+	}
+	ctx = t.withBaseContext(ctx)
+	return t.h2Controller.UnaryInvokeChunked(ctx, t.rewritePath(ctx, path), arg)
 }
 
 // StreamRequest call h2Controller to send streaming request to sever, to start link.
 // @path is /interfaceKey/functionName e.g. /com.apache.dubbo.sample.basic.IGreeter/BigStreamTest
 func (t *TripleClient) StreamRequest(ctx context.Context, path string) (grpc.ClientStream, error) {
-	return t.h2Controller.StreamInvoke(ctx, path)
+	if err := t.checkAvailable(); err != nil {
+		return nil, err
+	}
+	ctx = t.withBaseContext(ctx)
+	return t.h2Controller.StreamInvoke(ctx, t.rewritePath(ctx, path))
+}
+
+// rewritePath applies RewritePath of option to outgoing @path
+func (t *TripleClient) rewritePath(ctx context.Context, path string) string {
+	if t.opt.RewritePath == nil {
+		return path
+	}
+	return t.opt.RewritePath(ctx, path)
+}
+
+// withBaseContext merges values of BaseContext of option into @ctx, values of @ctx take precedence, and so do keys of
+// its attachment case-insensitively
+func (t *TripleClient) withBaseContext(ctx context.Context) context.Context {
+	if t.opt.BaseContext == nil {
+		return ctx
+	}
+	base := t.opt.BaseContext()
+	if base == nil {
+		return ctx
+	}
+	attachment, _ := ctx.Value(string(constant.CtxAttachmentKey)).(common.DubboAttachment)
+	merged := &baseValueContext{Context: ctx, base: base}
+	baseAttachment, _ := base.Value(string(constant.CtxAttachmentKey)).(common.DubboAttachment)
+	if len(baseAttachment) == 0 || len(attachment) == 0 {
+		return merged
+	}
+	mergedAttachment := make(common.DubboAttachment, len(baseAttachment)+len(attachment))
+	for k, v := range baseAttachment {
+		mergedAttachment[strings.ToLower(k)] = v
+	}
+	for k, v := range attachment {
+		delete(mergedAttachment, strings.ToLower(k))
+		mergedAttachment[k] = v
+	}
+	return context.WithValue(merged, string(constant.CtxAttachmentKey), mergedAttachment)
+}
+
+// baseValueContext is ctx of call whose values fall back to the ones of @base, deadline and cancellation are of the
+// call only
+type baseValueContext struct {
+	context.Context
+	base context.Context
+}
+
+// Value returns value of @key in ctx of call, or in base ctx if absent
+func (c *baseValueContext) Value(key interface{}) interface{} {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.base.Value(key)
+}
+
+// checkAvailable returns Canceled error if the client is closed, even if its shared controller is still in use
+func (t *TripleClient) checkAvailable() error {
+	if atomic.LoadInt32(&t.closed) == 1 {
+		return common.NewTripleErrorWithCause("triple client is closed", int(codes.Canceled), common.ErrClientClosed, nil)
+	}
+	return nil
+}
+
+// Controller returns http2 controller of the client, which can be shared by NewTripleClientWithController
+func (t *TripleClient) Controller() *http2.TripleController {
+	return t.h2Controller
 }
 
-// Close destroy http controller and return
+// Close releases http controller and return, the controller is destroyed if no other client shares it.
+// It is safe to call it repeatedly
 func (t *TripleClient) Close() {
-	t.opt.Logger.Debug("Triple Client Is closing")
-	t.h2Controller.Destroy()
+	t.once.Do(func() {
+		t.opt.Logger.Debug("Triple Client Is closing")
+		atomic.StoreInt32(&t.closed, 1)
+		t.h2Controller.Release()
+	})
 }
 
 // IsAvailable returns if triple client is available
 func (t *TripleClient) IsAvailable() bool {
-	return t.h2Controller.IsAvailable()
+	return atomic.LoadInt32(&t.closed) == 0 && t.h2Controller.IsAvailable()
 }
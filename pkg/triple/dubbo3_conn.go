@@ -28,6 +28,7 @@ import (
 
 import (
 	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
 )
 
 // TripleConn is the struct that called in pb.go file
@@ -40,13 +41,30 @@ type TripleConn struct {
 // @method is /interfaceKey/functionName e.g. /com.apache.dubbo.sample.basic.IGreeter/BigUnaryTest
 // @arg is request body, must be proto.Message type
 func (t *TripleConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) common.ErrorWithAttachment {
-	return t.client.Request(ctx, method, args, reply)
+	return t.client.Request(applyCallOptions(ctx, opts), method, args, reply)
 }
 
 // NewStream called when streaming rpc 's pb.go file
 // @method is /interfaceKey/functionName e.g. /com.apache.dubbo.sample.basic.IGreeter/BigStreamTest
 func (t *TripleConn) NewStream(ctx context.Context, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-	return t.client.StreamRequest(ctx, method)
+	return t.client.StreamRequest(applyCallOptions(ctx, opts), method)
+}
+
+// WithNoCompression returns grpc.CallOption which disables compression of request messages of a single call, e.g.
+// for already-compressed payloads, grpc-encoding of the call is "identity". Other calls are not affected.
+func WithNoCompression() grpc.CallOption {
+	return grpc.UseCompressor(constant.IdentityCompressorName)
+}
+
+// applyCallOptions returns ctx of the call with @opts applied, only grpc.CompressorCallOption is supported now,
+// others are ignored
+func applyCallOptions(ctx context.Context, opts []grpc.CallOption) context.Context {
+	for _, opt := range opts {
+		if o, ok := opt.(grpc.CompressorCallOption); ok {
+			ctx = common.WithCompression(ctx, o.CompressorType)
+		}
+	}
+	return ctx
 }
 
 // newTripleConn new a triple conn with given @tripleclient, which contains all net logic
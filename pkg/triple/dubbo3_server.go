@@ -18,6 +18,8 @@
 package triple
 
 import (
+	"context"
+	"sort"
 	"sync"
 )
 
@@ -25,6 +27,8 @@ import (
 	"github.com/dubbogo/triple/internal/http2"
 	"github.com/dubbogo/triple/internal/path"
 	"github.com/dubbogo/triple/internal/tools"
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/logger"
 	"github.com/dubbogo/triple/pkg/config"
 	triHttp2 "github.com/dubbogo/triple/pkg/http2"
 	triHttp2Conf "github.com/dubbogo/triple/pkg/http2/config"
@@ -35,6 +39,9 @@ type TripleServer struct {
 	http2Server   *triHttp2.Server
 	rpcServiceMap *sync.Map
 
+	// concurrencyLimiter is shared by controllers, so that it is kept after refresh
+	concurrencyLimiter *http2.ConcurrencyLimiter
+
 	// config
 	opt *config.Option
 }
@@ -44,8 +51,31 @@ type TripleServer struct {
 func NewTripleServer(serviceMap *sync.Map, opt *config.Option) *TripleServer {
 	opt = tools.AddDefaultOption(opt)
 	return &TripleServer{
-		rpcServiceMap: serviceMap,
-		opt:           opt,
+		rpcServiceMap:      serviceMap,
+		concurrencyLimiter: http2.NewConcurrencyLimiter(opt.MethodConcurrencyLimits),
+		opt:                opt,
+	}
+}
+
+// MethodConcurrency returns the number of running rpcs of @method path, which is limited by
+// config.WithMethodConcurrencyLimit. It is always zero for methods without limit.
+func (t *TripleServer) MethodConcurrency(method string) int {
+	return t.concurrencyLimiter.Concurrency(method)
+}
+
+// ConnectionCount returns the number of client conns being served, it is zero before Start
+func (t *TripleServer) ConnectionCount() int {
+	if t.http2Server == nil {
+		return 0
+	}
+	return t.http2Server.ConnectionCount()
+}
+
+// SetLogLevel changes the level of logs of server at runtime, logs below @level are discarded. It is goroutine safe.
+// It only works if Logger of option is a *logger.LoggerWrapper, see TripleController.SetLogLevel.
+func (t *TripleServer) SetLogLevel(level logger.Level) {
+	if wrapper, ok := t.opt.Logger.(*logger.LoggerWrapper); ok {
+		wrapper.SetLevel(level)
 	}
 }
 
@@ -54,26 +84,97 @@ func (t *TripleServer) Stop() {
 	t.http2Server.Stop()
 }
 
+// DrainAndClose shuts down the server gracefully: it stops accepting conns and sends GOAWAY to clients, then waits
+// until all requests are completed or @ctx is done, the conns are closed by force at last. Unlike Stop, which only
+// stops accepting conns, or Destroy of client controller, which closes conns at once, requests being handled can
+// finish in time. It is safe to call it repeatedly and concurrently, the summary of the first call is returned.
+func (t *TripleServer) DrainAndClose(ctx context.Context) config.DrainSummary {
+	if t.http2Server == nil {
+		return config.DrainSummary{}
+	}
+	return t.http2Server.DrainAndClose(ctx)
+}
+
+// ListServices returns info of all registered services and their methods, in ascending order of interface name
+func (t *TripleServer) ListServices() []common.ServiceInfo {
+	services := make([]common.ServiceInfo, 0)
+	t.rpcServiceMap.Range(func(key, value interface{}) bool {
+		services = append(services, http2.GetServiceInfo(key.(string), value))
+		return true
+	})
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].InterfaceName < services[j].InterfaceName
+	})
+	return services
+}
+
+// Routes returns routes of all registered TripleGrpcService, in ascending order of path, see RouteTable
+func (t *TripleServer) Routes() []common.Route {
+	routes := make([]common.Route, 0)
+	t.rpcServiceMap.Range(func(key, value interface{}) bool {
+		if service, ok := value.(common.TripleGrpcService); ok {
+			routes = append(routes, RouteTable(key.(string), service)...)
+		}
+		return true
+	})
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	})
+	return routes
+}
+
+// RouteTable returns routes of @service registered with @interfaceKey, normalized from its ServiceDesc, in ascending
+// order of path. It reflects exactly what server dispatches to with pb codec, e.g. to validate routes or to build
+// middleware by the kind of methods.
+func RouteTable(interfaceKey string, service common.TripleGrpcService) []common.Route {
+	return http2.GetRoutes(interfaceKey, service)
+}
+
 // Start can start a triple server
 func (t *TripleServer) Start() {
 	t.opt.Logger.Debug("TripleServer.Start: tripleServer Start at location = ", t.opt.Location)
 
 	t.http2Server = triHttp2.NewServer(t.opt.Location, triHttp2Conf.ServerConfig{
+		Listener:               t.opt.Listener,
 		Logger:                 t.opt.Logger,
 		PathExtractor:          path.NewDefaultExtractor(),
 		HandlerGRManagedByUser: true,
+		OnConnect:              t.opt.OnConnect,
+		OnDisconnect:           t.opt.OnDisconnect,
+		EnableBufferPool:       t.opt.EnableBufferPool,
+		CompressionLevel:       t.opt.CompressionLevel,
+		FrameObserver:          t.opt.FrameObserver,
+		MaxConnections:         t.opt.MaxConnections,
+		MinPingInterval:        t.opt.MinPingInterval,
+		PermitWithoutStream:    t.opt.PermitWithoutStream,
+		HeaderReadTimeout:      t.opt.HeaderReadTimeout,
+		StreamWriteTimeout:     t.opt.StreamWriteTimeout,
+		TCPKeepalive:           t.opt.TCPKeepalive,
+		MaxFrameSize:           t.opt.MaxFrameSize,
+		StreamWindowSize:       t.opt.ServerStreamWindowSize,
+		ConnWindowSize:         t.opt.ServerConnWindowSize,
+		MaxHeaderListSize:      t.opt.MaxHeaderListSize,
+		MaxRequestBytes:        t.opt.MaxRequestBytes,
+		TLSConfig:              t.opt.TLSConfig,
+		NextProtos:             t.opt.NextProtos,
+		AccessLogSink:          t.opt.AccessLogSink,
 	})
 	tripleCtl, err := http2.NewTripleController(t.opt)
 	if err != nil {
 		t.opt.Logger.Error("TripleServer.Start: new http2 controller failed with error = %v", err)
 		return
 	}
+	tripleCtl.SetConcurrencyLimiter(t.concurrencyLimiter)
 
 	t.rpcServiceMap.Range(func(key, value interface{}) bool {
 		t.opt.Logger.Debugf("TripleServer.Start: http2 register path = %s, with service = %+v", key.(string), value)
-		t.http2Server.RegisterHandler(key.(string), tripleCtl.GetHandler(value))
+		t.http2Server.RegisterHandlerContext(key.(string), tripleCtl.GetHandler(value))
 		return true
 	})
+	if t.opt.UnknownMethodStrategy != config.UnknownMethodUnimplemented {
+		// rpc of unknown service is handled by the same strategy as unknown method
+		t.http2Server.RegisterDefaultHandler(tripleCtl.GetHandler(nil))
+	}
 
 	t.http2Server.Start()
 }
@@ -85,10 +186,11 @@ func (t *TripleServer) RefreshService() {
 		t.opt.Logger.Errorf("TripleServer.Refresh: new http2 controller failed with error = %v", err)
 		return
 	}
+	tripleCtl.SetConcurrencyLimiter(t.concurrencyLimiter)
 
 	t.rpcServiceMap.Range(func(key, value interface{}) bool {
 		t.opt.Logger.Debugf("TripleServer.Refresh: http2 register path = %s, with service = %+v", key.(string), value)
-		t.http2Server.RegisterHandler(key.(string), tripleCtl.GetHandler(value))
+		t.http2Server.RegisterHandlerContext(key.(string), tripleCtl.GetHandler(value))
 		return true
 	})
 }
@@ -0,0 +1,4481 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package triple
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	h2 "github.com/dubbogo/net/http2"
+	"github.com/dubbogo/net/http2/hpack"
+
+	perrors "github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"google.golang.org/protobuf/proto"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+import (
+	codecImpl "github.com/dubbogo/triple/internal/codec/twoway_codec_impl"
+	"github.com/dubbogo/triple/internal/codes"
+	"github.com/dubbogo/triple/internal/status"
+	"github.com/dubbogo/triple/pkg/common"
+	"github.com/dubbogo/triple/pkg/common/constant"
+	"github.com/dubbogo/triple/pkg/common/logger"
+	"github.com/dubbogo/triple/pkg/common/logger/default_logger"
+	"github.com/dubbogo/triple/pkg/common/ratelimit"
+	"github.com/dubbogo/triple/pkg/common/resolver"
+	"github.com/dubbogo/triple/pkg/config"
+	triHttp2 "github.com/dubbogo/triple/pkg/http2"
+	triHttp2Conf "github.com/dubbogo/triple/pkg/http2/config"
+)
+
+const testInterfaceKey = "com.dubbogo.triple.TestService"
+
+// testUnaryService is TripleUnaryService impl for test, method SayHello returns "hello " + name
+type testUnaryService struct{}
+
+func (s *testUnaryService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	return s.SayHello(arguments[0].(string)), nil
+}
+
+func (s *testUnaryService) SayHello(name string) string {
+	return "hello " + name
+}
+
+func (s *testUnaryService) GetReqParamsInterfaces(methodName string) ([]interface{}, bool) {
+	if methodName != "SayHello" {
+		return nil, false
+	}
+	var name string
+	return []interface{}{&name}, true
+}
+
+// testEchoStreamService is TripleGrpcService impl for test, bidi-streaming method Echo replies each message as it is
+type testEchoStreamService struct{}
+
+func (s *testEchoStreamService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: testInterfaceKey,
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Echo",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					for {
+						msg := &wrapperspb.BytesValue{}
+						if err := stream.RecvMsg(msg); err != nil {
+							return nil
+						}
+						if err := stream.SendMsg(msg); err != nil {
+							return err
+						}
+					}
+				},
+				ServerStreams: true,
+				ClientStreams: true,
+			},
+		},
+	}
+}
+
+// testStubImpl is client impl for test, the stub is TripleConn itself
+type testStubImpl struct{}
+
+func (i *testStubImpl) GetDubboStub(cc *TripleConn) interface{} {
+	return cc
+}
+
+// startTestServer starts triple server on free port with @service, and returns the address
+func startTestServer(t *testing.T, service interface{}, fs ...config.OptionFunction) (*TripleServer, string) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := lst.Addr().String()
+	assert.Nil(t, lst.Close())
+
+	serviceMap := &sync.Map{}
+	serviceMap.Store(testInterfaceKey, service)
+	server := NewTripleServer(serviceMap, config.NewTripleOption(append(fs, config.WithLocation(addr))...))
+	server.Start()
+	// wait for listening
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return server, addr
+}
+
+func TestServerMultipleCodecs(t *testing.T) {
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	for _, codecType := range []constant.CodecType{constant.HessianCodecName, constant.MsgPackCodecName} {
+		client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(codecType)))
+		assert.Nil(t, err)
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError(), codecType)
+		assert.Equal(t, "hello triple", reply, codecType)
+		client.Close()
+	}
+
+	// unknown content-type sub-type
+	client := triHttp2.NewClient(config.Option{Logger: default_logger.GetDefaultLogger()})
+	_, trailer, err := client.Post(addr, "/"+testInterfaceKey+"/SayHello", []byte{}, &triHttp2Conf.PostConfig{
+		ContentType: "application/grpc+unknown",
+		BufferSize:  constant.DefaultHttp2ControllerReadBufferSize,
+		Timeout:     constant.DefaultTimeout,
+		HeaderField: http.Header{},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, strconv.Itoa(int(codes.Unimplemented)), trailer.Get(constant.TrailerKeyGrpcStatus))
+}
+
+func TestServerUnknownMethod(t *testing.T) {
+	hessianCodec, err := codecImpl.NewTwoWayCodec(constant.HessianCodecName)
+	assert.Nil(t, err)
+	// echoFallback replies the path it receives, and fails rpc of method Fail
+	echoFallback := func(ctx context.Context, path string, req []byte) ([]byte, error) {
+		if path == "/"+testInterfaceKey+"/Fail" {
+			return nil, common.NewTripleError("fallback failed", int(codes.PermissionDenied), "", nil)
+		}
+		return hessianCodec.MarshalResponse("fallback " + path)
+	}
+
+	tests := []struct {
+		name         string
+		opt          config.OptionFunction
+		path         string
+		expectedCode codes.Code
+		expectedMsg  string
+		expectedRsp  string
+	}{
+		{
+			name:         "default",
+			opt:          config.WithCodecType(constant.HessianCodecName),
+			path:         "/" + testInterfaceKey + "/UnknownMethod",
+			expectedCode: codes.Unimplemented,
+		},
+		{
+			name:         "custom status",
+			opt:          config.WithUnknownMethodStatus(uint32(codes.Unavailable), "no such method"),
+			path:         "/" + testInterfaceKey + "/UnknownMethod",
+			expectedCode: codes.Unavailable,
+			expectedMsg:  "no such method",
+		},
+		{
+			name:         "custom status of unknown service",
+			opt:          config.WithUnknownMethodStatus(uint32(codes.Unavailable), "no such method"),
+			path:         "/com.dubbogo.triple.UnknownService/SayHello",
+			expectedCode: codes.Unavailable,
+			expectedMsg:  "no such method",
+		},
+		{
+			name:        "fallback",
+			opt:         config.WithUnknownMethodFallback(echoFallback),
+			path:        "/" + testInterfaceKey + "/UnknownMethod",
+			expectedRsp: "fallback /" + testInterfaceKey + "/UnknownMethod",
+		},
+		{
+			name:        "fallback of unknown service",
+			opt:         config.WithUnknownMethodFallback(echoFallback),
+			path:        "/com.dubbogo.triple.UnknownService/SayHello",
+			expectedRsp: "fallback /com.dubbogo.triple.UnknownService/SayHello",
+		},
+		{
+			name:         "fallback error",
+			opt:          config.WithUnknownMethodFallback(echoFallback),
+			path:         "/" + testInterfaceKey + "/Fail",
+			expectedCode: codes.PermissionDenied,
+			expectedMsg:  "fallback failed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName), test.opt)
+			defer server.Stop()
+
+			client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+			assert.Nil(t, err)
+			defer client.Close()
+
+			var reply string
+			rsp := client.Request(context.Background(), test.path, []interface{}{"triple"}, &reply)
+			if test.expectedCode == codes.OK {
+				assert.Nil(t, rsp.GetError())
+				assert.Equal(t, test.expectedRsp, reply)
+				return
+			}
+			tripleErr, ok := rsp.GetError().(*common.TripleError)
+			assert.True(t, ok)
+			assert.Equal(t, int(test.expectedCode), tripleErr.Code())
+			if test.expectedMsg != "" {
+				assert.Equal(t, test.expectedMsg, tripleErr.Error())
+			}
+
+			// the known method is not affected
+			rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+			assert.Nil(t, rsp.GetError())
+			assert.Equal(t, "hello triple", reply)
+		})
+	}
+}
+
+func TestNewTripleClientContextCancelDial(t *testing.T) {
+	dialStarted := make(chan struct{})
+	// slowDial blocks until ctx is done, like dialing to black hole address
+	slowDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		close(dialStarted)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-dialStarted
+		cancel()
+	}()
+	start := time.Now()
+	client, err := NewTripleClientContext(ctx, nil, config.NewTripleOption(
+		config.WithLocation("127.0.0.1:20000"), config.WithCodecType(constant.HessianCodecName), config.WithDialContext(slowDial)))
+	assert.Nil(t, client)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// the deadline bounds the dial of all resolved endpoints
+	var dialCount int32
+	slowDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dialCount, 1)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	endpoints := testResolver{{Address: "127.0.0.1:20000", Weight: 1}, {Address: "127.0.0.1:20001", Weight: 1}}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	client, err = NewTripleClientContext(ctx, nil, config.NewTripleOption(config.WithResolver(endpoints),
+		config.WithCodecType(constant.HessianCodecName), config.WithDialContext(slowDial)))
+	assert.Nil(t, client)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dialCount))
+
+	// NewTripleClient doesn't dial, the conn is dialed by the first rpc
+	lazyClient, err := NewTripleClient(nil, config.NewTripleOption(config.WithResolver(endpoints),
+		config.WithCodecType(constant.HessianCodecName), config.WithDialContext(slowDial)))
+	assert.Nil(t, err)
+	defer lazyClient.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dialCount))
+}
+
+func TestStreamRequestDeadline(t *testing.T) {
+	server, addr := startTestServer(t, &testEchoStreamService{})
+	defer server.Stop()
+
+	// the first conn is broken after the client is created, and redial blocks until ctx is done, like the conn is
+	// mid-reconnect to a black hole address
+	var firstConn net.Conn
+	var dialed int32
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if atomic.AddInt32(&dialed, 1) == 1 {
+			conn, err := net.Dial(network, addr)
+			firstConn = conn
+			return conn, err
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	// the first conn is dialed during construction
+	client, err := NewTripleClientContext(context.Background(), nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithDialContext(dial)))
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Nil(t, firstConn.Close())
+	// wait for the read loop of conn to fail
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	stream, err := client.StreamRequest(ctx, "/"+testInterfaceKey+"/Echo")
+	assert.Nil(t, stream)
+	tripleErr, ok := err.(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, codes.DeadlineExceeded, codes.Code(tripleErr.Code()))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dialed))
+}
+
+// testResolver is config.Resolver impl for test, which returns fixed endpoints
+type testResolver []config.Endpoint
+
+func (r testResolver) Endpoints() []config.Endpoint {
+	return r
+}
+
+func TestStreamMessageOrder(t *testing.T) {
+	server, addr := startTestServer(t, &testEchoStreamService{})
+	defer server.Stop()
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr), config.WithCompressorType(constant.GzipCompressorName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Echo")
+	assert.Nil(t, err)
+
+	const total = 1000
+	// random payload is not shrunk by compressor, and messages of it are split into frames by http2 flow control
+	payload := make([]byte, 32*1024)
+	_, _ = rand.Read(payload)
+	sendErr := make(chan error, 1)
+	go func() {
+		for i := uint32(0); i < total; i++ {
+			data := make([]byte, len(payload))
+			copy(data, payload)
+			binary.BigEndian.PutUint32(data, i)
+			if err := stream.SendMsg(wrapperspb.Bytes(data)); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- nil
+	}()
+	for i := uint32(0); i < total; i++ {
+		msg := &wrapperspb.BytesValue{}
+		assert.Nil(t, stream.RecvMsg(msg))
+		if !assert.Equal(t, i, binary.BigEndian.Uint32(msg.GetValue())) {
+			return
+		}
+		assert.Equal(t, payload[4:], msg.GetValue()[4:])
+	}
+	assert.Nil(t, <-sendErr)
+}
+
+func TestTripleClientWarmUp(t *testing.T) {
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	var (
+		lock     sync.Mutex
+		dials    int
+		settings int
+	)
+	countingDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		lock.Lock()
+		dials++
+		lock.Unlock()
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	// settingsObserver counts SETTINGS frames, which are exchanged in http2 handshake
+	settingsObserver := func(info config.FrameInfo) {
+		if info.Type == h2.FrameSettings {
+			lock.Lock()
+			settings++
+			lock.Unlock()
+		}
+	}
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName),
+		config.WithDialContext(countingDial), config.WithFrameObserver(settingsObserver)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, client.WarmUp(context.Background()))
+		}()
+	}
+	wg.Wait()
+
+	lock.Lock()
+	assert.Equal(t, 1, dials)
+	// both sides' SETTINGS and their ACKs
+	assert.Equal(t, 4, settings)
+	lock.Unlock()
+
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello triple", reply)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 1, dials)
+	assert.Equal(t, 4, settings)
+}
+
+func TestTripleClientWarmUpConns(t *testing.T) {
+	serverA, addrA := startTestServer(t, &testNamedService{name: "a"}, config.WithCodecType(constant.HessianCodecName))
+	defer serverA.Stop()
+	serverB, addrB := startTestServer(t, &testNamedService{name: "b"}, config.WithCodecType(constant.HessianCodecName))
+	defer serverB.Stop()
+
+	var (
+		lock  sync.Mutex
+		dials = make(map[string]int)
+	)
+	countingDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		lock.Lock()
+		dials[addr]++
+		lock.Unlock()
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithResolver(testResolver{{Address: addrA}, {Address: addrB}}),
+		config.WithLoadBalancePolicy(constant.RoundRobinLoadBalancePolicy), config.WithCodecType(constant.HessianCodecName),
+		config.WithDialContext(countingDial)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	results := client.WarmUpConns(context.Background())
+	assert.Equal(t, 2, len(results))
+	for i, addr := range []string{addrA, addrB} {
+		assert.Equal(t, addr, results[i].Address)
+		assert.Nil(t, results[i].Error)
+		assert.True(t, results[i].Elapsed > 0)
+	}
+
+	// rpcs to both endpoints use the warmed conns
+	for i := 0; i < 4; i++ {
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+	}
+	lock.Lock()
+	assert.Equal(t, map[string]int{addrA: 1, addrB: 1}, dials)
+	lock.Unlock()
+
+	// the result of unreachable endpoint is reported alone
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	deadAddr := lst.Addr().String()
+	assert.Nil(t, lst.Close())
+	partialClient, err := NewTripleClient(nil, config.NewTripleOption(config.WithResolver(testResolver{{Address: addrA}, {Address: deadAddr}}),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer partialClient.Close()
+	results = partialClient.WarmUpConns(context.Background())
+	assert.Equal(t, 2, len(results))
+	assert.Nil(t, results[0].Error)
+	assert.Equal(t, deadAddr, results[1].Address)
+	assert.NotNil(t, results[1].Error)
+	assert.Nil(t, partialClient.WarmUp(context.Background()))
+
+	// done ctx fails all conns not dialed yet
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceledClient, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addrB),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer canceledClient.Close()
+	results = canceledClient.WarmUpConns(ctx)
+	assert.Equal(t, 1, len(results))
+	assert.NotNil(t, results[0].Error)
+}
+
+func TestTripleClientDialBackoff(t *testing.T) {
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	var (
+		lock  sync.Mutex
+		dials int
+		fail  = true
+	)
+	dialErr := errors.New("connection refused")
+	flakyDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		lock.Lock()
+		dials++
+		failed := fail
+		lock.Unlock()
+		if failed {
+			return nil, dialErr
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName),
+		config.WithDialContext(flakyDial), config.WithConnectParams(config.ConnectParams{
+			Backoff: config.Backoff{BaseDelay: 300 * time.Millisecond, Multiplier: 2, MaxDelay: time.Second},
+		})))
+	assert.Nil(t, err)
+	defer client.Close()
+	sayHello := func() error {
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		return rsp.GetError()
+	}
+	getDials := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return dials
+	}
+
+	assert.NotNil(t, sayHello())
+	assert.Equal(t, 1, getDials())
+	// rpc during backoff fails fast without dialing
+	err = sayHello()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "backed off")
+	assert.Equal(t, 1, getDials())
+
+	// the address is redialed after the delay
+	lock.Lock()
+	fail = false
+	lock.Unlock()
+	time.Sleep(400 * time.Millisecond)
+	assert.Nil(t, sayHello())
+	assert.Equal(t, 2, getDials())
+	assert.Nil(t, sayHello())
+	assert.Equal(t, 2, getDials())
+}
+
+// testBlockingService is TripleUnaryService impl for test, method SayHello blocks until unblock is closed
+type testBlockingService struct {
+	testUnaryService
+	unblock chan struct{}
+}
+
+func (s *testBlockingService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	<-s.unblock
+	return s.testUnaryService.InvokeWithArgs(ctx, methodName, arguments)
+}
+
+func TestServerMethodConcurrencyLimit(t *testing.T) {
+	const path = "/" + testInterfaceKey + "/SayHello"
+	tests := []struct {
+		name  string
+		limit config.ConcurrencyLimit
+		// ctxTimeout is the deadline of rpc beyond the limit
+		ctxTimeout   time.Duration
+		expectedCode codes.Code
+	}{
+		{
+			name:         "reject",
+			limit:        config.ConcurrencyLimit{Max: 2},
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			name:         "queue timeout",
+			limit:        config.ConcurrencyLimit{Max: 2, Overflow: config.ConcurrencyOverflowQueue, QueueTimeout: 50 * time.Millisecond},
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			name:         "queue until deadline",
+			limit:        config.ConcurrencyLimit{Max: 2, Overflow: config.ConcurrencyOverflowQueue},
+			ctxTimeout:   50 * time.Millisecond,
+			expectedCode: codes.DeadlineExceeded,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &testBlockingService{unblock: make(chan struct{})}
+			server, addr := startTestServer(t, service, config.WithCodecType(constant.HessianCodecName),
+				config.WithMethodConcurrencyLimit(path, test.limit))
+			defer server.Stop()
+
+			client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+			assert.Nil(t, err)
+			defer client.Close()
+
+			// occupy all the executions
+			var wg sync.WaitGroup
+			for i := 0; i < test.limit.Max; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var reply string
+					rsp := client.Request(context.Background(), path, []interface{}{"triple"}, &reply)
+					assert.Nil(t, rsp.GetError())
+				}()
+			}
+			for i := 0; i < 100 && server.MethodConcurrency(path) < test.limit.Max; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.Equal(t, test.limit.Max, server.MethodConcurrency(path))
+
+			ctx := context.Background()
+			if test.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.ctxTimeout)
+				defer cancel()
+			}
+			var reply string
+			rsp := client.Request(ctx, path, []interface{}{"triple"}, &reply)
+			tripleErr, ok := rsp.GetError().(*common.TripleError)
+			assert.True(t, ok)
+			assert.Equal(t, int(test.expectedCode), tripleErr.Code())
+
+			if test.limit.Overflow == config.ConcurrencyOverflowQueue {
+				// queued rpc runs after the running ones finish
+				queued := make(chan error, 1)
+				go func() {
+					var reply string
+					rsp := client.Request(context.Background(), path, []interface{}{"triple"}, &reply)
+					queued <- rsp.GetError()
+				}()
+				time.Sleep(20 * time.Millisecond)
+				close(service.unblock)
+				assert.Nil(t, <-queued)
+			} else {
+				close(service.unblock)
+			}
+			wg.Wait()
+			// execution is released after response is sent
+			for i := 0; i < 100 && server.MethodConcurrency(path) > 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.Equal(t, 0, server.MethodConcurrency(path))
+		})
+	}
+}
+
+// testResult is common.OuterResult impl for test
+type testResult struct {
+	result      interface{}
+	attachments map[string]interface{}
+}
+
+func (r *testResult) Result() interface{} {
+	return r.result
+}
+
+func (r *testResult) Attachments() map[string]interface{} {
+	return r.attachments
+}
+
+// testAttachmentService is TripleUnaryService impl for test, method SayHello replies binary attachment "token-bin"
+// of request in response attachment "echo-bin"
+type testAttachmentService struct {
+	testUnaryService
+}
+
+func (s *testAttachmentService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	attachments := make(map[string]interface{})
+	if token, ok := common.GetBinaryAttachment(ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment), "token"); ok {
+		common.SetBinaryAttachment(attachments, "echo", token)
+	}
+	return &testResult{result: "hello " + arguments[0].(string), attachments: attachments}, nil
+}
+
+func TestBinaryAttachment(t *testing.T) {
+	server, addr := startTestServer(t, &testAttachmentService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	for i := 0; i < 20; i++ {
+		token := make([]byte, rand.Intn(256))
+		_, _ = rand.Read(token)
+		attachment := make(common.DubboAttachment)
+		common.SetBinaryAttachment(attachment, "token", token)
+		ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), attachment)
+
+		var reply string
+		rsp := client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		assert.Equal(t, "hello triple", reply)
+		echo, ok := common.GetBinaryAttachment(rsp.GetAttachments(), "echo")
+		assert.True(t, ok)
+		assert.Equal(t, token, echo)
+	}
+
+	// non-binary attachment with invalid UTF-8 is rejected before sending
+	ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), common.DubboAttachment{"token": "\xff\xfe"})
+	var reply string
+	rsp := client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.NotNil(t, rsp.GetError())
+	assert.Contains(t, rsp.GetError().Error(), "invalid UTF-8")
+}
+
+// testEchoAttachmentService is TripleUnaryService impl for test, method SayHello replies request attachments
+// "tri-region" and "tri-app" joined by ","
+type testEchoAttachmentService struct {
+	testUnaryService
+}
+
+func (s *testEchoAttachmentService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	attachment := ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment)
+	return strings.Join(attachment.Values("tri-region"), "|") + "," + attachment.Get("tri-app"), nil
+}
+
+func TestDefaultAttachments(t *testing.T) {
+	server, addr := startTestServer(t, &testEchoAttachmentService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	opt := config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName),
+		config.WithDefaultAttachment("tri-region", "us-east"), config.WithDefaultAttachment("tri-app", "shop"))
+	client, err := NewTripleClient(nil, opt)
+	assert.Nil(t, err)
+	defer client.Close()
+	// defaults are copied when client is created
+	opt.DefaultAttachments["tri-app"] = []string{"changed"}
+
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "us-east,shop", reply)
+
+	// attachment of rpc overrides the default one with the same key, case-insensitively
+	ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), common.DubboAttachment{"Tri-Region": []string{"eu-west", "eu-north"}})
+	rsp = client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "eu-west|eu-north,shop", reply)
+
+	// invalid default attachment fails client creation
+	_, err = NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName),
+		config.WithDefaultAttachment("tri-region", "\xff")))
+	assert.NotNil(t, err)
+}
+
+type testBaseContextKey struct{}
+
+func TestBaseContext(t *testing.T) {
+	server, addr := startTestServer(t, &testEchoAttachmentService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	base := context.WithValue(context.Background(), testBaseContextKey{}, "base")
+	base = context.WithValue(base, string(constant.CtxAttachmentKey), common.DubboAttachment{
+		"tri-region": []string{"us-east"}, "Tri-App": []string{"base"}})
+	var rewriteValue interface{}
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName),
+		config.WithBaseContext(func() context.Context { return base }),
+		config.WithRewritePath(func(ctx context.Context, path string) string {
+			rewriteValue = ctx.Value(testBaseContextKey{})
+			return path
+		})))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "us-east,base", reply)
+	assert.Equal(t, "base", rewriteValue)
+
+	// values and attachment keys of call ctx take precedence, cancellation of base ctx is ignored
+	canceledBase, cancel := context.WithCancel(base)
+	cancel()
+	base = canceledBase
+	ctx := context.WithValue(context.Background(), testBaseContextKey{}, "call")
+	ctx = context.WithValue(ctx, string(constant.CtxAttachmentKey), common.DubboAttachment{"tri-app": []string{"call"}})
+	rsp = client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "us-east,call", reply)
+	assert.Equal(t, "call", rewriteValue)
+}
+
+// testMultiValueAttachmentService is TripleUnaryService impl for test, method SayHello replies all values of request
+// attachment "tri-tag" in response attachment "tri-echo-tag", and adds two values of trailer "tri-cookie"
+type testMultiValueAttachmentService struct {
+	testUnaryService
+}
+
+func (s *testMultiValueAttachmentService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	for _, cookie := range []string{"a=1", "b=2"} {
+		if err := common.AddTrailer(ctx, "tri-cookie", cookie); err != nil {
+			return nil, err
+		}
+	}
+	tags := ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment).Values("tri-tag")
+	attachments := map[string]interface{}{"tri-echo-tag": tags}
+	return &testResult{result: "hello " + arguments[0].(string), attachments: attachments}, nil
+}
+
+func TestMultiValueAttachment(t *testing.T) {
+	server, addr := startTestServer(t, &testMultiValueAttachmentService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey),
+		common.DubboAttachment{"tri-tag": []string{"blue", "green"}})
+	var reply string
+	rsp := client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello triple", reply)
+	assert.Equal(t, []string{"blue", "green"}, rsp.GetAttachments().Values("tri-echo-tag"))
+	assert.Equal(t, "blue", rsp.GetAttachments().Get("tri-echo-tag"))
+	assert.Equal(t, []string{"a=1", "b=2"}, rsp.GetAttachments().Values("tri-cookie"))
+}
+
+// testHandlerErrorService is TripleUnaryService impl for test, method SayHello fails with error detail attachments
+type testHandlerErrorService struct {
+	testUnaryService
+}
+
+func (s *testHandlerErrorService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	return nil, common.NewHandlerError(int(codes.PermissionDenied), "quota of "+arguments[0].(string)+" exceeded",
+		common.DubboAttachment{"tri-error-reason": "QUOTA_EXCEEDED", "tri-error-field": []string{"user", "quota"}})
+}
+
+func TestHandlerError(t *testing.T) {
+	server, addr := startTestServer(t, &testHandlerErrorService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	tripleErr, ok := rsp.GetError().(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, int(codes.PermissionDenied), tripleErr.Code())
+	assert.Equal(t, "quota of triple exceeded", tripleErr.Error())
+	for _, attachment := range []common.TripleAttachment{rsp.GetAttachments(), tripleErr.Attachment()} {
+		assert.Equal(t, "QUOTA_EXCEEDED", attachment.Get("tri-error-reason"))
+		assert.Equal(t, []string{"user", "quota"}, attachment.Values("tri-error-field"))
+	}
+}
+
+// testChunkedService is TripleUnaryService impl for test, method Download returns payload as io.Reader, which is sent
+// to client by chunks, with trailer "tri-chunked". Download of "fail" fails with error, and of "endless" never ends.
+type testChunkedService struct {
+	payload []byte
+	// read is the number of bytes read from endless response
+	read int64
+}
+
+func (s *testChunkedService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	switch arguments[0].(string) {
+	case "fail":
+		return nil, common.NewHandlerError(int(codes.PermissionDenied), "download of fail is denied", nil)
+	case "endless":
+		return &endlessReader{read: &s.read}, nil
+	}
+	if err := common.SetTrailer(ctx, "tri-chunked", "true"); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(s.payload), nil
+}
+
+func (s *testChunkedService) GetReqParamsInterfaces(methodName string) ([]interface{}, bool) {
+	if methodName != "Download" {
+		return nil, false
+	}
+	var name string
+	return []interface{}{&name}, true
+}
+
+// endlessReader is io.Reader which never ends, and counts the bytes read
+type endlessReader struct {
+	read *int64
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	atomic.AddInt64(r.read, int64(len(p)))
+	return len(p), nil
+}
+
+// attachmentReader is the reader returned by RequestChunked, which tells trailer attachment after the last chunk
+type attachmentReader interface {
+	Attachment() common.TripleAttachment
+}
+
+func TestRequestChunked(t *testing.T) {
+	service := &testChunkedService{payload: bytes.Repeat([]byte("triple"), constant.DefaultUnaryChunkSize/2)}
+	server, addr := startTestServer(t, service, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+	const downloadPath = "/" + testInterfaceKey + "/Download"
+
+	t.Run("read to EOF", func(t *testing.T) {
+		reader, err := client.RequestChunked(context.Background(), downloadPath, []interface{}{"triple"})
+		assert.Nil(t, err)
+		defer reader.Close()
+		// the payload is sent by 3 chunks
+		data, err := ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Equal(t, service.payload, data)
+		attachment := reader.(attachmentReader).Attachment()
+		assert.Equal(t, []string{"true"}, attachment.Values("tri-chunked"))
+	})
+
+	t.Run("close early", func(t *testing.T) {
+		reader, err := client.RequestChunked(context.Background(), downloadPath, []interface{}{"endless"})
+		assert.Nil(t, err)
+		_, err = io.ReadFull(reader, make([]byte, 3*constant.DefaultUnaryChunkSize))
+		assert.Nil(t, err)
+		assert.Nil(t, reader.Close())
+		// server stops sending after the stream is reset
+		var read int64
+		assert.Eventually(t, func() bool {
+			last := read
+			read = atomic.LoadInt64(&service.read)
+			return read == last
+		}, 5*time.Second, 200*time.Millisecond)
+		// the conn is kept for other rpcs
+		reader, err = client.RequestChunked(context.Background(), downloadPath, []interface{}{"triple"})
+		assert.Nil(t, err)
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Equal(t, service.payload, data)
+	})
+
+	t.Run("handler error", func(t *testing.T) {
+		reader, err := client.RequestChunked(context.Background(), downloadPath, []interface{}{"fail"})
+		assert.Nil(t, err)
+		defer reader.Close()
+		_, err = reader.Read(make([]byte, 1))
+		tripleErr, ok := err.(*common.TripleError)
+		if assert.True(t, ok, "error = %v", err) {
+			assert.Equal(t, int(codes.PermissionDenied), tripleErr.Code())
+			assert.Equal(t, "download of fail is denied", tripleErr.Error())
+		}
+	})
+}
+
+// captureOutput returns bytes written to stdout and stderr while @f runs
+func captureOutput(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, w
+	output := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		output <- data
+	}()
+
+	f()
+
+	os.Stdout, os.Stderr = stdout, stderr
+	assert.Nil(t, w.Close())
+	return string(<-output)
+}
+
+func TestNilLoggerWritesNothing(t *testing.T) {
+	output := captureOutput(t, func() {
+		opt := config.WithCodecType(constant.HessianCodecName)
+		server, addr := startTestServer(t, &testHandlerErrorService{}, opt)
+		client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), opt))
+		assert.Nil(t, err)
+
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.NotNil(t, rsp.GetError())
+		rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/Unknown", []interface{}{"triple"}, &reply)
+		assert.NotNil(t, rsp.GetError())
+		client.Close()
+		server.Stop()
+	})
+	assert.Empty(t, output)
+}
+
+func TestSetLogLevel(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	opt := config.WithLogger(zap.New(core).Sugar())
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName), opt)
+	defer server.Stop()
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName), opt))
+	assert.Nil(t, err)
+	defer client.Close()
+	// debugLogs returns the number of debug logs written by a rpc
+	debugLogs := func() int {
+		logs.TakeAll()
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		count := 0
+		for _, entry := range logs.All() {
+			if entry.Level == zapcore.DebugLevel {
+				count++
+			}
+		}
+		return count
+	}
+
+	assert.NotZero(t, debugLogs())
+	client.SetLogLevel(logger.InfoLevel)
+	server.SetLogLevel(logger.InfoLevel)
+	assert.Zero(t, debugLogs())
+	client.SetLogLevel(logger.DebugLevel)
+	server.SetLogLevel(logger.DebugLevel)
+	assert.NotZero(t, debugLogs())
+}
+
+func TestAccessLogSink(t *testing.T) {
+	records := make(chan *config.AccessLogRecord, 4)
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName),
+		config.WithAccessLogSink(func(record *config.AccessLogRecord) {
+			records <- record
+		}))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	for _, c := range []struct {
+		method string
+		code   codes.Code
+	}{
+		{method: "SayHello", code: codes.OK},
+		{method: "SayGoodbye", code: codes.Unimplemented},
+	} {
+		var reply string
+		client.Request(context.Background(), "/"+testInterfaceKey+"/"+c.method, []interface{}{"triple"}, &reply)
+		select {
+		case record := <-records:
+			assert.Equal(t, "/"+testInterfaceKey+"/"+c.method, record.Method)
+			assert.Equal(t, uint32(c.code), record.Code)
+			assert.True(t, record.Duration > 0)
+			assert.False(t, record.Start.IsZero())
+			assert.True(t, record.RequestBytes > 5)
+			host, _, err := net.SplitHostPort(record.Peer)
+			assert.Nil(t, err)
+			assert.Equal(t, "127.0.0.1", host)
+			if c.code == codes.OK {
+				// "hello triple" with 5 bytes header
+				assert.True(t, record.ResponseBytes > 5+int64(len("hello triple")))
+			} else {
+				assert.Equal(t, int64(0), record.ResponseBytes)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no access log of %s", c.method)
+		}
+	}
+	// one record per rpc
+	select {
+	case record := <-records:
+		t.Fatalf("unexpected access log %+v", record)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// testEchoCountService is TripleGrpcService impl for test, bidi-streaming method Echo replies the first count messages
+// as they are, and then ends the stream
+type testEchoCountService struct {
+	count int
+}
+
+func (s *testEchoCountService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: testInterfaceKey,
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Echo",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					for i := 0; i < s.count; i++ {
+						msg := &wrapperspb.BytesValue{}
+						if err := stream.RecvMsg(msg); err != nil {
+							return err
+						}
+						if err := stream.SendMsg(msg); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+				ServerStreams: true,
+				ClientStreams: true,
+			},
+		},
+	}
+}
+
+func TestAccessLogPayloadSizes(t *testing.T) {
+	// compressible and incompressible messages are both split into frames
+	messages := []*wrapperspb.BytesValue{wrapperspb.Bytes(make([]byte, 64*1024)), wrapperspb.Bytes(make([]byte, 64*1024))}
+	_, _ = rand.Read(messages[1].Value)
+	payloadBytes := int64(proto.Size(messages[0]) + proto.Size(messages[1]))
+
+	for _, compressorType := range []string{"", constant.GzipCompressorName} {
+		records := make(chan *config.AccessLogRecord, 1)
+		server, addr := startTestServer(t, &testEchoCountService{count: len(messages)}, config.WithAccessLogSink(func(record *config.AccessLogRecord) {
+			records <- record
+		}))
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr),
+			config.WithCompressorType(compressorType)))
+		assert.Nil(t, err)
+
+		stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Echo")
+		assert.Nil(t, err)
+		for _, msg := range messages {
+			assert.Nil(t, stream.SendMsg(msg))
+			assert.Nil(t, stream.RecvMsg(&wrapperspb.BytesValue{}))
+		}
+		assert.Equal(t, io.EOF, stream.RecvMsg(&wrapperspb.BytesValue{}))
+		record := <-records
+		assert.Equal(t, uint32(codes.OK), record.Code)
+		compressed := compressorType != ""
+		assert.Equal(t, compressed, record.RequestCompressed, compressorType)
+		assert.Equal(t, compressed, record.ResponseCompressed, compressorType)
+		assert.Equal(t, payloadBytes, record.RequestPayloadBytes, compressorType)
+		assert.Equal(t, payloadBytes, record.ResponsePayloadBytes, compressorType)
+		// each message has 5 bytes header on the wire
+		assert.Equal(t, record.RequestBytes-2*5, record.RequestWirePayloadBytes, compressorType)
+		assert.Equal(t, record.ResponseBytes-2*5, record.ResponseWirePayloadBytes, compressorType)
+		if compressed {
+			assert.True(t, record.RequestWirePayloadBytes < payloadBytes)
+			assert.True(t, record.ResponseWirePayloadBytes < payloadBytes)
+		} else {
+			assert.Equal(t, payloadBytes, record.RequestWirePayloadBytes)
+			assert.Equal(t, payloadBytes, record.ResponseWirePayloadBytes)
+		}
+		client.Close()
+		server.Stop()
+	}
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName), config.WithH2CPriorKnowledge()))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"h2c"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello h2c", reply)
+
+	// HTTP/1.1 client asking for upgrade gets a readable response
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/"+testInterfaceKey+"/SayHello", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+	req.Header.Set("Upgrade", "h2c")
+	req.Header.Set("HTTP2-Settings", "")
+	httpRsp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	body, err := ioutil.ReadAll(httpRsp.Body)
+	assert.Nil(t, err)
+	_ = httpRsp.Body.Close()
+	assert.Equal(t, http.StatusHTTPVersionNotSupported, httpRsp.StatusCode)
+	assert.Contains(t, string(body), "prior knowledge")
+
+	// prior knowledge client is not affected
+	rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"again"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello again", reply)
+}
+
+func TestFrameObserverUnary(t *testing.T) {
+	var (
+		lock   sync.Mutex
+		frames = make(map[bool]map[h2.FrameType]int)
+	)
+	observer := func(info config.FrameInfo) {
+		lock.Lock()
+		defer lock.Unlock()
+		if frames[info.Outbound] == nil {
+			frames[info.Outbound] = make(map[h2.FrameType]int)
+		}
+		frames[info.Outbound][info.Type]++
+	}
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName),
+		config.WithFrameObserver(observer)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+
+	lock.Lock()
+	defer lock.Unlock()
+	// request headers and message
+	assert.Equal(t, 1, frames[true][h2.FrameHeaders])
+	assert.True(t, frames[true][h2.FrameData] > 0)
+	// response headers, message and trailers
+	assert.Equal(t, 2, frames[false][h2.FrameHeaders])
+	assert.True(t, frames[false][h2.FrameData] > 0)
+}
+
+func TestServerListServices(t *testing.T) {
+	serviceMap := &sync.Map{}
+	serviceMap.Store("com.dubbogo.triple.UnaryService", &testUnaryService{})
+	serviceMap.Store("com.dubbogo.triple.EchoService", &testEchoStreamService{})
+	server := NewTripleServer(serviceMap, nil)
+
+	assert.Equal(t, []common.ServiceInfo{
+		{InterfaceName: "com.dubbogo.triple.EchoService", Methods: []string{"Echo"}},
+		{InterfaceName: "com.dubbogo.triple.UnaryService", Methods: []string{"SayHello"}},
+	}, server.ListServices())
+}
+
+// testRouteService is TripleGrpcService for test, its desc has mixed unary and streaming methods
+type testRouteService struct{}
+
+func (s *testRouteService) ServiceDesc() *grpc.ServiceDesc {
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}
+	return &grpc.ServiceDesc{
+		ServiceName: "com.dubbogo.triple.RouteService",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "SayHello"},
+			// unary method wins
+			{MethodName: "Echo"},
+			// never dispatched, as method of path is upper cased
+			{MethodName: "lowerCase"},
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "Echo", Handler: handler, ServerStreams: true, ClientStreams: true},
+			{StreamName: "Upload", Handler: handler, ClientStreams: true},
+			{StreamName: "Subscribe", Handler: handler, ServerStreams: true},
+			{StreamName: "Chat", Handler: handler, ServerStreams: true, ClientStreams: true},
+		},
+	}
+}
+
+func TestRouteTable(t *testing.T) {
+	routes := RouteTable(testInterfaceKey, &testRouteService{})
+	paths := make([]string, 0, len(routes))
+	kinds := make(map[string]common.MethodKind, len(routes))
+	for _, route := range routes {
+		paths = append(paths, route.Path)
+		kinds[route.MethodName] = route.Kind
+		if route.Kind == common.UnaryMethodKind {
+			assert.NotNil(t, route.MethodDesc)
+			assert.Nil(t, route.StreamDesc)
+		} else {
+			assert.Nil(t, route.MethodDesc)
+			assert.NotNil(t, route.StreamDesc)
+			assert.NotNil(t, route.StreamDesc.Handler)
+		}
+	}
+	prefix := "/" + testInterfaceKey + "/"
+	assert.Equal(t, []string{prefix + "Chat", prefix + "Echo", prefix + "SayHello", prefix + "Subscribe", prefix + "Upload"}, paths)
+	assert.Equal(t, map[string]common.MethodKind{
+		"Chat":      common.BidiStreamMethodKind,
+		"Echo":      common.UnaryMethodKind,
+		"SayHello":  common.UnaryMethodKind,
+		"Subscribe": common.ServerStreamMethodKind,
+		"Upload":    common.ClientStreamMethodKind,
+	}, kinds)
+	assert.Equal(t, "client_stream", common.ClientStreamMethodKind.String())
+
+	// routes of server are the ones of grpc services
+	serviceMap := &sync.Map{}
+	serviceMap.Store(testInterfaceKey, &testEchoStreamService{})
+	serviceMap.Store("com.dubbogo.triple.UnaryService", &testUnaryService{})
+	routes = NewTripleServer(serviceMap, nil).Routes()
+	assert.Equal(t, 1, len(routes))
+	assert.Equal(t, prefix+"Echo", routes[0].Path)
+	assert.Equal(t, common.BidiStreamMethodKind, routes[0].Kind)
+}
+
+// testDeadlineCodec is an example of common.ContextCodec, it wraps hessian codec, and fails fast before marshaling
+// if the remaining deadline of rpc is shorter than minDeadline, which is supposed to be the cost of a big marshal
+type testDeadlineCodec struct {
+	common.Codec
+	minDeadline time.Duration
+}
+
+func (c *testDeadlineCodec) MarshalContext(ctx context.Context, v interface{}) ([]byte, error) {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < c.minDeadline {
+		return nil, perrors.Errorf("remaining deadline %s is too short to marshal", time.Until(deadline))
+	}
+	return c.Marshal(v)
+}
+
+func (c *testDeadlineCodec) UnmarshalContext(ctx context.Context, data []byte, v interface{}) error {
+	return c.Unmarshal(data, v)
+}
+
+func TestContextCodec(t *testing.T) {
+	const codecName = constant.CodecType("test-deadline")
+	common.SetTripleCodec(codecName, func() common.Codec {
+		hessianCodec, _ := common.GetTripleCodec(constant.HessianCodecName)
+		return &testDeadlineCodec{Codec: hessianCodec, minDeadline: time.Second}
+	})
+
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(codecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rsp := client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello triple", reply)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rsp = client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.NotNil(t, rsp.GetError())
+	assert.Contains(t, rsp.GetError().Error(), "too short to marshal")
+}
+
+// testPoisonCodec wraps hessian codec, and fails to marshal reply "hello poison", like a proto with invalid field
+type testPoisonCodec struct {
+	common.Codec
+}
+
+func (c *testPoisonCodec) Marshal(v interface{}) ([]byte, error) {
+	if v == "hello poison" {
+		return nil, perrors.New("invalid field of poison reply")
+	}
+	return c.Codec.Marshal(v)
+}
+
+func TestResponseMarshalError(t *testing.T) {
+	const codecName = constant.CodecType("test-poison")
+	common.SetTripleCodec(codecName, func() common.Codec {
+		hessianCodec, _ := common.GetTripleCodec(constant.HessianCodecName)
+		return &testPoisonCodec{Codec: hessianCodec}
+	})
+
+	for _, c := range []struct {
+		fs   []config.OptionFunction
+		code codes.Code
+	}{
+		{fs: []config.OptionFunction{config.WithCodecType(codecName)}, code: codes.Internal},
+		{fs: []config.OptionFunction{config.WithCodecType(codecName), config.WithResponseMarshalErrorCode(uint32(codes.Unavailable))}, code: codes.Unavailable},
+	} {
+		server, addr := startTestServer(t, &testUnaryService{}, c.fs...)
+		client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(codecName)))
+		assert.Nil(t, err)
+
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		assert.Equal(t, "hello triple", reply)
+
+		rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"poison"}, &reply)
+		tripleErr, ok := rsp.GetError().(*common.TripleError)
+		assert.True(t, ok)
+		assert.Equal(t, int(c.code), tripleErr.Code())
+		assert.Contains(t, tripleErr.Error(), "response serialization failed: marshal string of method /"+testInterfaceKey+"/SayHello")
+		assert.Contains(t, tripleErr.Error(), "invalid field of poison reply")
+
+		client.Close()
+		server.Stop()
+	}
+}
+
+// testPartialStreamService is TripleGrpcService impl for test, server-streaming method Items sends 3 messages and
+// then fails with DeadlineExceeded, with trailer "tri-item-count"
+type testPartialStreamService struct{}
+
+func (s *testPartialStreamService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: testInterfaceKey,
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Items",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					for i := 0; i < 3; i++ {
+						if err := stream.SendMsg(wrapperspb.String("item " + strconv.Itoa(i))); err != nil {
+							return err
+						}
+					}
+					stream.SetTrailer(metadata.Pairs("tri-item-count", "3"))
+					return common.NewTripleError("partial items", int(codes.DeadlineExceeded), "", nil)
+				},
+				ServerStreams: true,
+			},
+		},
+	}
+}
+
+// testFiniteStreamService is TripleGrpcService impl for test, server-streaming method Items sends 2 messages and
+// then succeeds, with trailer "tri-item-count"
+type testFiniteStreamService struct{}
+
+func (s *testFiniteStreamService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: testInterfaceKey,
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Items",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					for i := 0; i < 2; i++ {
+						if err := stream.SendMsg(wrapperspb.String("item " + strconv.Itoa(i))); err != nil {
+							return err
+						}
+					}
+					stream.SetTrailer(metadata.Pairs("tri-item-count", "2"))
+					return nil
+				},
+				ServerStreams: true,
+			},
+		},
+	}
+}
+
+func TestStreamSuccessWithoutEmptyMessage(t *testing.T) {
+	server, addr := startTestServer(t, &testFiniteStreamService{})
+	defer server.Stop()
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Items")
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		msg := &wrapperspb.StringValue{}
+		assert.Nil(t, stream.RecvMsg(msg))
+		assert.Equal(t, "item "+strconv.Itoa(i), msg.GetValue())
+	}
+	// the messages sent by handler are followed by io.EOF at once, rather than an empty message
+	assert.Equal(t, io.EOF, stream.RecvMsg(&wrapperspb.StringValue{}))
+	assert.Equal(t, []string{"2"}, stream.Trailer().Get("tri-item-count"))
+}
+
+func TestStreamPartialResultsWithError(t *testing.T) {
+	server, addr := startTestServer(t, &testPartialStreamService{})
+	defer server.Stop()
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Items")
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		msg := &wrapperspb.StringValue{}
+		assert.Nil(t, stream.RecvMsg(msg))
+		assert.Equal(t, "item "+strconv.Itoa(i), msg.GetValue())
+	}
+	// the error is received after the messages, and it is kept for following RecvMsg
+	for i := 0; i < 2; i++ {
+		err = stream.RecvMsg(&wrapperspb.StringValue{})
+		tripleErr, ok := err.(*common.TripleError)
+		assert.True(t, ok)
+		assert.Equal(t, int(codes.DeadlineExceeded), tripleErr.Code())
+		assert.Equal(t, "partial items", tripleErr.Error())
+		assert.Equal(t, "3", tripleErr.Attachment().Get("tri-item-count"))
+	}
+	assert.Equal(t, []string{"3"}, stream.Trailer().Get("tri-item-count"))
+
+	// trailers carry the final status after the data
+	h2Client := triHttp2.NewClient(config.Option{Logger: default_logger.GetDefaultLogger()})
+	dataChan, trailerChan, err := h2Client.StreamPost(addr, "/"+testInterfaceKey+"/Items", make(chan *bytes.Buffer), &triHttp2Conf.PostConfig{
+		ContentType: constant.TripleContentType,
+		BufferSize:  constant.DefaultHttp2ControllerReadBufferSize,
+		Timeout:     constant.DefaultTimeout,
+		HeaderField: http.Header{},
+	})
+	assert.Nil(t, err)
+	dataLen := 0
+	for data := range dataChan {
+		if data == nil {
+			break
+		}
+		dataLen += data.Len()
+	}
+	assert.True(t, dataLen > 0)
+	trailer := <-trailerChan
+	assert.Equal(t, strconv.Itoa(int(codes.DeadlineExceeded)), trailer.Get(constant.TrailerKeyGrpcStatus))
+	assert.Equal(t, "partial items", trailer.Get(constant.TrailerKeyGrpcMessage))
+}
+
+func TestStreamCompression(t *testing.T) {
+	server, addr := startTestServer(t, &testPartialStreamService{})
+	defer server.Stop()
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCompressorType(constant.GzipCompressorName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// each message of the gzip stream is decompressed on its own, the error after them is not affected
+	stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Items")
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		msg := &wrapperspb.StringValue{}
+		assert.Nil(t, stream.RecvMsg(msg))
+		assert.Equal(t, "item "+strconv.Itoa(i), msg.GetValue())
+	}
+	tripleErr, ok := stream.RecvMsg(&wrapperspb.StringValue{}).(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, int(codes.DeadlineExceeded), tripleErr.Code())
+}
+
+func TestWithCompression(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		dataSent int
+	)
+	// dataObserver counts payload length of DATA frames sent by client
+	dataObserver := func(info config.FrameInfo) {
+		if info.Outbound && info.Type == h2.FrameData {
+			lock.Lock()
+			dataSent += int(info.Length)
+			lock.Unlock()
+		}
+	}
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName),
+		config.WithCompressorType(constant.GzipCompressorName), config.WithFrameObserver(dataObserver)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	name := strings.Repeat("triple", 10000)
+	request := func(ctx context.Context) int {
+		lock.Lock()
+		dataSent = 0
+		lock.Unlock()
+		var reply string
+		rsp := client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{name}, &reply)
+		assert.Nil(t, rsp.GetError())
+		assert.Equal(t, "hello "+name, reply)
+		lock.Lock()
+		defer lock.Unlock()
+		return dataSent
+	}
+
+	assert.True(t, request(common.WithCompression(context.Background(), constant.IdentityCompressorName)) > len(name))
+	assert.True(t, request(common.WithCompression(context.Background(), constant.GzipCompressorName)) < len(name)/10)
+	// compressor of option is used by default
+	assert.True(t, request(context.Background()) < len(name)/10)
+
+	var reply string
+	rsp := client.Request(common.WithCompression(context.Background(), "unknown"), "/"+testInterfaceKey+"/SayHello", []interface{}{name}, &reply)
+	assert.NotNil(t, rsp.GetError())
+}
+
+// testEncodingService is TripleUnaryService impl for test, method SayHello replies grpc-encoding of request
+type testEncodingService struct {
+	testUnaryService
+}
+
+func (s *testEncodingService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	return ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment).Get(constant.GrpcEncoding), nil
+}
+
+func TestWithNoCompression(t *testing.T) {
+	server, addr := startTestServer(t, &testEncodingService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName),
+		config.WithCompressorType(constant.GzipCompressorName)))
+	assert.Nil(t, err)
+	defer client.Close()
+	conn := newTripleConn(client)
+
+	var reply string
+	rsp := conn.Invoke(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply, WithNoCompression())
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, constant.IdentityCompressorName, reply)
+
+	// compressor of option is still used by other calls
+	rsp = conn.Invoke(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, constant.GzipCompressorName, reply)
+}
+
+// testListService is an example list endpoint with pagination in trailers, method List replies a page of at most
+// 2 items joined by ",", which starts from the page token in request
+type testListService struct {
+	items []string
+}
+
+func (s *testListService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	start := 0
+	if token := arguments[0].(string); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := start + 2
+	if end > len(s.items) {
+		end = len(s.items)
+	}
+
+	attachments := make(map[string]interface{})
+	nextToken := ""
+	if end < len(s.items) {
+		nextToken = strconv.Itoa(end)
+	}
+	common.SetPageToken(attachments, nextToken)
+	common.SetTotalCount(attachments, int64(len(s.items)))
+	return &testResult{result: strings.Join(s.items[start:end], ","), attachments: attachments}, nil
+}
+
+func (s *testListService) GetReqParamsInterfaces(methodName string) ([]interface{}, bool) {
+	if methodName != "List" {
+		return nil, false
+	}
+	var token string
+	return []interface{}{&token}, true
+}
+
+func TestPagination(t *testing.T) {
+	service := &testListService{items: []string{"a", "b", "c", "d", "e"}}
+	server, addr := startTestServer(t, service, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var (
+		pages []string
+		token string
+	)
+	for {
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/List", []interface{}{token}, &reply)
+		assert.Nil(t, rsp.GetError())
+		pages = append(pages, reply)
+
+		total, ok := common.GetTotalCount(rsp.GetAttachments())
+		assert.True(t, ok)
+		assert.Equal(t, int64(5), total)
+		token, ok = common.GetPageToken(rsp.GetAttachments())
+		assert.True(t, ok)
+		if token == "" || len(pages) > 5 {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a,b", "c,d", "e"}, pages)
+}
+
+// testGreeterStub is a stub returned by GetDubboStub, to test dispatch of TripleClient.Invoke without network
+type testGreeterStub struct{}
+
+func (s *testGreeterStub) SayHello(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, common.ErrorWithAttachment) {
+	return wrapperspb.String("hello " + in.Value), *common.NewErrorWithAttachment(nil, common.TripleAttachment{"k": {"v"}})
+}
+
+func newTestInvokeClient() *TripleClient {
+	return &TripleClient{
+		stubInvoker: reflect.ValueOf(&testGreeterStub{}),
+		opt: config.NewTripleOption(
+			config.WithCodecType(constant.PBCodecName),
+			config.WithLogger(zap.NewNop().Sugar()),
+		),
+	}
+}
+
+func testSayHelloInvoker(stub interface{}, in []reflect.Value, reply interface{}) (common.TripleAttachment, error) {
+	rsp, errWithAtta := stub.(*testGreeterStub).SayHello(in[0].Interface().(context.Context), in[1].Interface().(*wrapperspb.StringValue))
+	if err := errWithAtta.GetError(); err != nil {
+		return nil, err
+	}
+	reply.(*wrapperspb.StringValue).Value = rsp.Value
+	return errWithAtta.GetAttachments(), nil
+}
+
+func TestTripleClientMethodInvoker(t *testing.T) {
+	client := newTestInvokeClient()
+	in := []reflect.Value{reflect.ValueOf(context.Background()), reflect.ValueOf(wrapperspb.String("triple"))}
+
+	reply := &wrapperspb.StringValue{}
+	res := client.Invoke("SayHello", in, reply)
+	assert.Nil(t, res.GetError())
+	assert.Equal(t, "hello triple", reply.Value)
+
+	client.SetMethodInvoker("SayHello", testSayHelloInvoker)
+	directReply := &wrapperspb.StringValue{}
+	directRes := client.Invoke("SayHello", in, directReply)
+	assert.Nil(t, directRes.GetError())
+	assert.Equal(t, reply.Value, directReply.Value)
+	assert.Equal(t, res.GetAttachments(), directRes.GetAttachments())
+
+	// reply of incompatible type fails with Internal instead of being discarded
+	res = newTestInvokeClient().Invoke("SayHello", in, &wrapperspb.Int32Value{})
+	assert.NotNil(t, res.GetError())
+	assert.Contains(t, res.GetError().Error(), "copy reply *wrapperspb.StringValue of method SayHello to *wrapperspb.Int32Value failed")
+	tripleErr, ok := res.GetError().(*status.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Internal, tripleErr.Status().Code())
+	res = newTestInvokeClient().Invoke("SayHello", in, new(string))
+	assert.Contains(t, res.GetError().Error(), "copy reply *wrapperspb.StringValue of method SayHello to *string failed")
+
+	// methods without invoker still go through reflection
+	res = client.Invoke("SayHi", in, reply)
+	assert.Contains(t, res.GetError().Error(), "not impl")
+
+	// methods cached at client creation are dispatched in the same way
+	cachedClient := newTestInvokeClient()
+	cachedClient.stubMethods = cacheStubMethods(cachedClient.stubInvoker)
+	cachedReply := &wrapperspb.StringValue{}
+	cachedRes := cachedClient.Invoke("SayHello", in, cachedReply)
+	assert.Nil(t, cachedRes.GetError())
+	assert.Equal(t, reply.Value, cachedReply.Value)
+	assert.Equal(t, directRes.GetAttachments(), cachedRes.GetAttachments())
+	res = cachedClient.Invoke("SayHi", in, reply)
+	assert.Contains(t, res.GetError().Error(), "not impl")
+}
+
+func BenchmarkTripleClientInvokeReflection(b *testing.B) {
+	client := newTestInvokeClient()
+	in := []reflect.Value{reflect.ValueOf(context.Background()), reflect.ValueOf(wrapperspb.String("triple"))}
+	reply := &wrapperspb.StringValue{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		client.Invoke("SayHello", in, reply)
+	}
+}
+
+func BenchmarkTripleClientInvokeCachedMethod(b *testing.B) {
+	client := newTestInvokeClient()
+	client.stubMethods = cacheStubMethods(client.stubInvoker)
+	in := []reflect.Value{reflect.ValueOf(context.Background()), reflect.ValueOf(wrapperspb.String("triple"))}
+	reply := &wrapperspb.StringValue{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		client.Invoke("SayHello", in, reply)
+	}
+}
+
+func BenchmarkTripleClientInvokeDirect(b *testing.B) {
+	client := newTestInvokeClient()
+	client.SetMethodInvoker("SayHello", testSayHelloInvoker)
+	in := []reflect.Value{reflect.ValueOf(context.Background()), reflect.ValueOf(wrapperspb.String("triple"))}
+	reply := &wrapperspb.StringValue{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		client.Invoke("SayHello", in, reply)
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	limiter := ratelimit.NewTokenBucketLimiter(ratelimit.TokenBucketConfig{
+		MethodLimits: map[string]ratelimit.Limit{
+			"/" + testInterfaceKey + "/SayHello": {Rate: 0.01, Burst: 2},
+		},
+	})
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName),
+		config.WithRateLimiter(limiter))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	rejected := 0
+	for i := 0; i < 5; i++ {
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		if rsp.GetError() == nil {
+			assert.Equal(t, "hello triple", reply)
+			continue
+		}
+		rejected++
+		tripleErr, ok := rsp.GetError().(*common.TripleError)
+		assert.True(t, ok)
+		assert.Equal(t, int(codes.ResourceExhausted), tripleErr.Code())
+		retryAfter, err := strconv.Atoi(rsp.GetAttachments().Get(constant.TrailerKeyGrpcRetryPushbackMs))
+		assert.Nil(t, err)
+		assert.True(t, retryAfter > 0)
+	}
+	// only the burst is allowed
+	assert.Equal(t, 3, rejected)
+}
+
+func TestTripleClientStatsHandler(t *testing.T) {
+	statsChan := make(chan *config.RPCStats, 2)
+	statsHandler := config.WithStatsHandler(func(stats *config.RPCStats) {
+		statsChan <- stats
+	})
+
+	t.Run("unary", func(t *testing.T) {
+		server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+		defer server.Stop()
+		client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+			config.WithCodecType(constant.HessianCodecName), statsHandler))
+		assert.Nil(t, err)
+		defer client.Close()
+
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		stats := <-statsChan
+		assert.Equal(t, "/"+testInterfaceKey+"/SayHello", stats.Method)
+		assert.Nil(t, stats.Error)
+		assert.False(t, stats.FirstResponseByte.Before(stats.Begin))
+		assert.False(t, stats.End.Before(stats.FirstResponseByte))
+		assert.True(t, stats.TimeToFirstByte() > 0)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		server, addr := startTestServer(t, &testPartialStreamService{})
+		defer server.Stop()
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr), statsHandler))
+		assert.Nil(t, err)
+		defer client.Close()
+
+		stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Items")
+		assert.Nil(t, err)
+		for stream.RecvMsg(&wrapperspb.StringValue{}) == nil {
+		}
+
+		stats := <-statsChan
+		assert.Equal(t, "/"+testInterfaceKey+"/Items", stats.Method)
+		tripleErr, ok := stats.Error.(*common.TripleError)
+		assert.True(t, ok)
+		assert.Equal(t, int(codes.DeadlineExceeded), tripleErr.Code())
+		assert.True(t, stats.TimeToFirstByte() > 0)
+		assert.True(t, stats.Duration() >= stats.TimeToFirstByte())
+	})
+
+	t.Run("compression", func(t *testing.T) {
+		server, addr := startTestServer(t, &testUpperService{})
+		defer server.Stop()
+		payload := strings.Repeat("triple", 10000)
+		// proto StringValue has 4 bytes of tag and length before the string
+		size := int64(len(payload) + 4)
+
+		for _, compressorType := range []string{constant.GzipCompressorName, ""} {
+			client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr),
+				config.WithCompressorType(compressorType), statsHandler))
+			assert.Nil(t, err)
+			reply := &wrapperspb.StringValue{}
+			rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/Upper", wrapperspb.String(payload), reply)
+			assert.Nil(t, rsp.GetError())
+			client.Close()
+
+			stats := <-statsChan
+			assert.Equal(t, size, stats.RequestBytes)
+			assert.Equal(t, size, stats.ResponseBytes)
+			if compressorType == "" {
+				assert.False(t, stats.RequestCompressed)
+				assert.False(t, stats.ResponseCompressed)
+				assert.Equal(t, size, stats.RequestWireBytes)
+				assert.Equal(t, size, stats.ResponseWireBytes)
+				continue
+			}
+			// server compresses response with the compressor of request
+			assert.True(t, stats.RequestCompressed)
+			assert.True(t, stats.ResponseCompressed)
+			assert.True(t, stats.RequestWireBytes > 0 && stats.RequestWireBytes < size/10, stats.RequestWireBytes)
+			assert.True(t, stats.ResponseWireBytes > 0 && stats.ResponseWireBytes < size/10, stats.ResponseWireBytes)
+		}
+
+		// messages of stream are summed up
+		server, addr = startTestServer(t, &testEchoStreamService{})
+		defer server.Stop()
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr),
+			config.WithCompressorType(constant.GzipCompressorName), statsHandler))
+		assert.Nil(t, err)
+		defer client.Close()
+		stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Echo")
+		assert.Nil(t, err)
+		for i := 0; i < 3; i++ {
+			assert.Nil(t, stream.SendMsg(wrapperspb.Bytes([]byte(payload))))
+			assert.Nil(t, stream.RecvMsg(&wrapperspb.BytesValue{}))
+		}
+		assert.Nil(t, NewClientStream(stream).Stop())
+		stats := <-statsChan
+		assert.True(t, stats.RequestCompressed)
+		assert.True(t, stats.ResponseCompressed)
+		assert.Equal(t, 3*size, stats.RequestBytes)
+		assert.Equal(t, 3*size, stats.ResponseBytes)
+		assert.True(t, stats.RequestWireBytes < size/10, stats.RequestWireBytes)
+		assert.True(t, stats.ResponseWireBytes < size/10, stats.ResponseWireBytes)
+	})
+}
+
+func TestTripleClientRewritePath(t *testing.T) {
+	hessianCodec, err := codecImpl.NewTwoWayCodec(constant.HessianCodecName)
+	assert.Nil(t, err)
+	// server replies the path it receives
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName),
+		config.WithUnknownMethodFallback(func(ctx context.Context, path string, req []byte) ([]byte, error) {
+			return hessianCodec.MarshalResponse(path)
+		}))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName),
+		config.WithRewritePath(func(ctx context.Context, path string) string {
+			if strings.HasPrefix(path, "/v1/") {
+				return "/v2/" + strings.TrimPrefix(path, "/v1/")
+			}
+			return path
+		})))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	rsp := client.Request(context.Background(), "/v1/Greeter/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "/v2/Greeter/SayHello", reply)
+
+	// paths not matched by rewriter are sent as is
+	rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello triple", reply)
+}
+
+// testUpperService is TripleGrpcService impl for test, unary method Upper replies the upper case of request string
+type testUpperService struct{}
+
+func (s *testUpperService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: testInterfaceKey,
+		Methods: []grpc.MethodDesc{
+			{
+				MethodName: "Upper",
+				Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+					in := &wrapperspb.StringValue{}
+					if err := dec(in); err != nil {
+						return nil, err
+					}
+					return wrapperspb.String(strings.ToUpper(in.Value)), nil
+				},
+			},
+		},
+	}
+}
+
+func TestServerUnmarshalMismatchedRequest(t *testing.T) {
+	server, addr := startTestServer(t, &testUpperService{})
+	defer server.Stop()
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &wrapperspb.StringValue{}
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/Upper", wrapperspb.String("triple"), reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "TRIPLE", reply.Value)
+
+	// bytes field of the same number is sent, which is not valid utf-8 of string field
+	rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/Upper", wrapperspb.Bytes([]byte{0xff, 0xfe}), reply)
+	tripleErr, ok := rsp.GetError().(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, int(codes.InvalidArgument), tripleErr.Code())
+	assert.Contains(t, tripleErr.Error(), "*wrapperspb.StringValue of method /"+testInterfaceKey+"/Upper")
+	assert.Contains(t, tripleErr.Error(), "at offset 0 of field value")
+}
+
+// testUpperEchoService is TripleGrpcService for test, with unary method Upper of testUpperService and stream Echo of
+// testEchoStreamService
+type testUpperEchoService struct{}
+
+func (s *testUpperEchoService) ServiceDesc() *grpc.ServiceDesc {
+	desc := (&testUpperService{}).ServiceDesc()
+	desc.Streams = (&testEchoStreamService{}).ServiceDesc().Streams
+	return desc
+}
+
+// testMixedCodecService serves pb method Upper of testUpperService and hessian method SayHello of testUnaryService
+type testMixedCodecService struct {
+	testUpperService
+	testUnaryService
+}
+
+func TestTripleClientMethodCodecType(t *testing.T) {
+	server, addr := startTestServer(t, &testMixedCodecService{})
+	defer server.Stop()
+
+	const sayHelloPath = "/" + testInterfaceKey + "/SayHello"
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithMethodCodecType(sayHelloPath, constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// server decides codec by content-type of each request
+	reply := &wrapperspb.StringValue{}
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/Upper", wrapperspb.String("triple"), reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "TRIPLE", reply.Value)
+	var hello string
+	rsp = client.Request(context.Background(), sayHelloPath, []interface{}{"triple"}, &hello)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello triple", hello)
+
+	_, err = NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithMethodCodecType(sayHelloPath, "unknown")))
+	assert.NotNil(t, err)
+}
+
+// startTestProxy starts HTTP CONNECT proxy which requires basic auth of @user and @password
+func startTestProxy(t *testing.T, user, password string) (string, *int32) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { lis.Close() })
+	var tunnels int32
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != auth {
+					_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				atomic.AddInt32(&tunnels, 1)
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go func() {
+					_, _ = io.Copy(target, br)
+					target.Close()
+				}()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+	return lis.Addr().String(), &tunnels
+}
+
+func TestProxyTunnel(t *testing.T) {
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+	proxyAddr, tunnels := startTestProxy(t, "user", "secret")
+
+	// ProxyFromEnvironment is not tested, because env vars are cached by net/http and localhost is never proxied
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName),
+		config.WithProxyURL(&url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword("user", "secret")})))
+	assert.Nil(t, err)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		assert.Equal(t, "hello triple", reply)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(tunnels))
+
+	// the status of proxy is in the error of dialing
+	_, err = NewTripleClientContext(context.Background(), nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName),
+		config.WithProxyURL(&url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword("user", "wrong")})))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "407")
+}
+
+// TestTripleClientConcurrentClose is meant to be run with -race
+func TestTripleClientConcurrentClose(t *testing.T) {
+	server, addr := startTestServer(t, &testUpperEchoService{})
+	defer server.Stop()
+
+	// the conn is dialed in advance, so that rpcs before Close succeed
+	client, err := NewTripleClientContext(context.Background(), &testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	conn := newTripleConn(client)
+
+	const total = 100
+	var (
+		wg        sync.WaitGroup
+		succeeded int32
+	)
+	call := func(i int) error {
+		ctx := context.Background()
+		switch i % 3 {
+		case 0:
+			reply := &wrapperspb.StringValue{}
+			rsp := client.Request(ctx, "/"+testInterfaceKey+"/Upper", wrapperspb.String("triple"), reply)
+			if err := rsp.GetError(); err != nil {
+				return err
+			}
+			assert.Equal(t, "TRIPLE", reply.Value)
+		case 1:
+			reply := &wrapperspb.StringValue{}
+			rsp := conn.Invoke(ctx, "/"+testInterfaceKey+"/Upper", wrapperspb.String("triple"), reply)
+			if err := rsp.GetError(); err != nil {
+				return err
+			}
+			assert.Equal(t, "TRIPLE", reply.Value)
+		default:
+			stream, err := client.StreamRequest(ctx, "/"+testInterfaceKey+"/Echo")
+			if err != nil {
+				return err
+			}
+			if err := stream.SendMsg(wrapperspb.Bytes([]byte("triple"))); err != nil {
+				return err
+			}
+			msg := &wrapperspb.BytesValue{}
+			if err := stream.RecvMsg(msg); err != nil {
+				return err
+			}
+			assert.Equal(t, "triple", string(msg.Value))
+			_ = stream.CloseSend()
+		}
+		return nil
+	}
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if call(i) == nil {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}(i)
+		if i == total/2 {
+			wg.Add(2)
+			for j := 0; j < 2; j++ {
+				go func() {
+					defer wg.Done()
+					client.Close()
+				}()
+			}
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("rpcs are blocked by Close")
+	}
+	assert.True(t, atomic.LoadInt32(&succeeded) > 0)
+
+	// rpcs after Close fail at once
+	for i := 0; i < 3; i++ {
+		tripleErr, ok := call(i).(*common.TripleError)
+		assert.True(t, ok)
+		assert.Equal(t, int(codes.Canceled), tripleErr.Code())
+	}
+	assert.False(t, client.IsAvailable())
+	client.Close()
+}
+
+func TestTripleClientSharedController(t *testing.T) {
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	var dials int32
+	countingDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	first, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName), config.WithDialContext(countingDial)))
+	assert.Nil(t, err)
+	defer first.Close()
+	second, err := NewTripleClientWithController(nil, first.Controller())
+	assert.Nil(t, err)
+	defer second.Close()
+
+	sayHello := func(client *TripleClient) error {
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		return rsp.GetError()
+	}
+	// rpcs of both clients are multiplexed over the same conn
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, sayHello(first))
+		assert.Nil(t, sayHello(second))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials))
+
+	// the controller is kept until the last client is closed
+	first.Close()
+	assert.False(t, first.IsAvailable())
+	tripleErr, ok := sayHello(first).(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, int(codes.Canceled), tripleErr.Code())
+	assert.True(t, second.IsAvailable())
+	assert.Nil(t, sayHello(second))
+
+	second.Close()
+	assert.False(t, second.Controller().IsAvailable())
+	_, err = NewTripleClientWithController(nil, second.Controller())
+	assert.NotNil(t, err)
+}
+
+func TestTripleClientRequestRaw(t *testing.T) {
+	backend, backendAddr := startTestServer(t, &testUpperService{})
+	defer backend.Stop()
+	backendClient, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(backendAddr)))
+	assert.Nil(t, err)
+	defer backendClient.Close()
+
+	// proxy forwards raw messages of unknown methods to backend verbatim
+	forwarded := make(chan []byte, 1)
+	proxy, proxyAddr := startTestServer(t, &testEchoStreamService{},
+		config.WithUnknownMethodFallback(func(ctx context.Context, path string, req []byte) ([]byte, error) {
+			forwarded <- req
+			rsp, _, err := backendClient.RequestRaw(ctx, path, req)
+			return rsp, err
+		}))
+	defer proxy.Stop()
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(proxyAddr)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &wrapperspb.StringValue{}
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/Upper", wrapperspb.String("triple"), reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "TRIPLE", reply.Value)
+	reqData, err := proto.Marshal(wrapperspb.String("triple"))
+	assert.Nil(t, err)
+	assert.Equal(t, reqData, <-forwarded)
+
+	// the raw reply is the marshaled response message
+	rspData, _, err := backendClient.RequestRaw(context.Background(), "/"+testInterfaceKey+"/Upper", reqData)
+	assert.Nil(t, err)
+	expected, err := proto.Marshal(wrapperspb.String("TRIPLE"))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, rspData)
+
+	// error status of backend is returned
+	_, _, err = backendClient.RequestRaw(context.Background(), "/"+testInterfaceKey+"/Upper", []byte{0x0a, 0x01, 0xff})
+	tripleErr, ok := err.(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, int(codes.InvalidArgument), tripleErr.Code())
+}
+
+// testTrailerService is TripleUnaryService impl for test, method SayHello sets trailer "tri-cache-hint" by ctx,
+// and it fails if name is "fail"
+type testTrailerService struct {
+	testUnaryService
+}
+
+func (s *testTrailerService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	if err := common.SetTrailer(ctx, "tri-cache-hint", "hit"); err != nil {
+		return nil, err
+	}
+	if arguments[0].(string) == "fail" {
+		return nil, common.NewTripleError("expected failure", int(codes.Unavailable), "", nil)
+	}
+	return &testResult{result: "hello " + arguments[0].(string)}, nil
+}
+
+// testTrailerStreamService is TripleGrpcService impl for test, server-streaming method Items sets trailer
+// "tri-item-count" by the stream after sending items
+type testTrailerStreamService struct{}
+
+func (s *testTrailerStreamService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: testInterfaceKey,
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Items",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					for i := 0; i < 3; i++ {
+						if err := stream.SendMsg(wrapperspb.String("item " + strconv.Itoa(i))); err != nil {
+							return err
+						}
+					}
+					stream.SetTrailer(metadata.Pairs("tri-item-count", "3"))
+					return nil
+				},
+				ServerStreams: true,
+			},
+		},
+	}
+}
+
+func TestServerTrailer(t *testing.T) {
+	server, addr := startTestServer(t, &testTrailerService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello triple", reply)
+	assert.Equal(t, "hit", rsp.GetAttachments().Get("tri-cache-hint"))
+
+	// trailer is sent with error as well
+	rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"fail"}, &reply)
+	tripleErr, ok := rsp.GetError().(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, int(codes.Unavailable), tripleErr.Code())
+	assert.Equal(t, "hit", rsp.GetAttachments().Get("tri-cache-hint"))
+
+	// SetTrailer fails out of server rpc
+	assert.NotNil(t, common.SetTrailer(context.Background(), "tri-cache-hint", "hit"))
+}
+
+func TestServerStreamTrailer(t *testing.T) {
+	server, addr := startTestServer(t, &testTrailerStreamService{})
+	defer server.Stop()
+
+	h2Client := triHttp2.NewClient(config.Option{Logger: default_logger.GetDefaultLogger()})
+	dataChan, trailerChan, err := h2Client.StreamPost(addr, "/"+testInterfaceKey+"/Items", make(chan *bytes.Buffer), &triHttp2Conf.PostConfig{
+		ContentType: constant.TripleContentType,
+		BufferSize:  constant.DefaultHttp2ControllerReadBufferSize,
+		Timeout:     constant.DefaultTimeout,
+		HeaderField: http.Header{},
+	})
+	assert.Nil(t, err)
+	for data := range dataChan {
+		if data == nil {
+			break
+		}
+	}
+	trailer := <-trailerChan
+	assert.Equal(t, strconv.Itoa(int(codes.OK)), trailer.Get(constant.TrailerKeyGrpcStatus))
+	assert.Equal(t, "3", trailer.Get("tri-item-count"))
+
+	// client stream gets trailer after io.EOF
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+	stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Items")
+	assert.Nil(t, err)
+	for err == nil {
+		err = stream.RecvMsg(&wrapperspb.StringValue{})
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []string{"3"}, stream.Trailer().Get("tri-item-count"))
+}
+
+// testNamedService is TripleUnaryService impl for test, method SayHello replies name of the server
+type testNamedService struct {
+	testUnaryService
+	name string
+}
+
+func (s *testNamedService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	return s.name, nil
+}
+
+func TestTripleClientSRVResolver(t *testing.T) {
+	serverA, addrA := startTestServer(t, &testNamedService{name: "a"}, config.WithCodecType(constant.HessianCodecName))
+	defer serverA.Stop()
+	serverB, addrB := startTestServer(t, &testNamedService{name: "b"}, config.WithCodecType(constant.HessianCodecName))
+	defer serverB.Stop()
+	_, portA, _ := net.SplitHostPort(addrA)
+	_, portB, _ := net.SplitHostPort(addrB)
+	srvPortA, _ := strconv.Atoi(portA)
+	srvPortB, _ := strconv.Atoi(portB)
+
+	// fake SRV records of weighted targets
+	lookup := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return name, []*net.SRV{
+			{Target: "127.0.0.1.", Port: uint16(srvPortA), Priority: 10, Weight: 3},
+			{Target: "127.0.0.1.", Port: uint16(srvPortB), Priority: 10, Weight: 1},
+		}, nil
+	}
+	srvResolver, err := resolver.NewSRVResolver("srv:///_grpc._tcp.myservice", resolver.SRVConfig{LookupSRV: lookup})
+	assert.Nil(t, err)
+	defer srvResolver.Close()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithResolver(srvResolver),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	counts := make(map[string]int)
+	const total = 400
+	for i := 0; i < total; i++ {
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		counts[reply]++
+	}
+	assert.Equal(t, total, counts["a"]+counts["b"])
+	// rpcs are balanced by weight 3:1
+	assert.True(t, counts["a"] > total/2 && counts["a"] < total*7/8, "counts = %v", counts)
+}
+
+func TestTripleClientSRVResolverRemovedEndpoint(t *testing.T) {
+	serverA, addrA := startTestServer(t, &testNamedService{name: "a"}, config.WithCodecType(constant.HessianCodecName))
+	defer serverA.Stop()
+	serverB, addrB := startTestServer(t, &testNamedService{name: "b"}, config.WithCodecType(constant.HessianCodecName))
+	defer serverB.Stop()
+	_, portA, _ := net.SplitHostPort(addrA)
+	_, portB, _ := net.SplitHostPort(addrB)
+	srvPortA, _ := strconv.Atoi(portA)
+	srvPortB, _ := strconv.Atoi(portB)
+
+	var removed int32
+	lookup := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		records := []*net.SRV{{Target: "127.0.0.1.", Port: uint16(srvPortA), Priority: 10, Weight: 1}}
+		if atomic.LoadInt32(&removed) == 0 {
+			records = append(records, &net.SRV{Target: "127.0.0.1.", Port: uint16(srvPortB), Priority: 10, Weight: 1})
+		}
+		return name, records, nil
+	}
+	srvResolver, err := resolver.NewSRVResolver("srv:///_grpc._tcp.myservice", resolver.SRVConfig{
+		LookupSRV:       lookup,
+		RefreshInterval: 10 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	defer srvResolver.Close()
+
+	client, err := NewTripleClientContext(context.Background(), nil, config.NewTripleOption(config.WithResolver(srvResolver),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+	for i := 0; i < 100 && serverB.ConnectionCount() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, serverB.ConnectionCount())
+
+	// the conn of endpoint removed from records is closed, and rpcs go to the remaining one
+	atomic.StoreInt32(&removed, 1)
+	for i := 0; i < 100 && serverB.ConnectionCount() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, serverB.ConnectionCount())
+	assert.Equal(t, 1, serverA.ConnectionCount())
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "a", reply)
+}
+
+// testFlakyService is TripleUnaryService impl for test, method SayHello returns name, or fails with Unavailable
+// while failing is set
+type testFlakyService struct {
+	testUnaryService
+	name    string
+	failing int32
+}
+
+func (s *testFlakyService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	if atomic.LoadInt32(&s.failing) == 1 {
+		return nil, common.NewHandlerError(int(codes.Unavailable), s.name+" is overloaded", nil)
+	}
+	return s.name, nil
+}
+
+func TestOutlierDetection(t *testing.T) {
+	serverA, addrA := startTestServer(t, &testNamedService{name: "a"}, config.WithCodecType(constant.HessianCodecName))
+	defer serverA.Stop()
+	flaky := &testFlakyService{name: "b"}
+	serverB, addrB := startTestServer(t, flaky, config.WithCodecType(constant.HessianCodecName))
+	defer serverB.Stop()
+
+	const ejectionDuration = 300 * time.Millisecond
+	events := make(chan config.EjectionEvent, 8)
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithResolver(testResolver{{Address: addrA}, {Address: addrB}}),
+		config.WithLoadBalancePolicy(constant.RoundRobinLoadBalancePolicy), config.WithCodecType(constant.HessianCodecName),
+		config.WithOutlierDetection(config.OutlierDetection{
+			ConsecutiveFailures: 2,
+			EjectionDuration:    ejectionDuration,
+			MaxEjectionPercent:  50,
+			OnEjection: func(event config.EjectionEvent) {
+				events <- event
+			},
+		})))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	sayHello := func() (string, error) {
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		return reply, rsp.GetError()
+	}
+
+	// b is ejected after 2 consecutive failures, and all rpcs are sent to a
+	atomic.StoreInt32(&flaky.failing, 1)
+	failures := 0
+	for i := 0; i < 4; i++ {
+		if _, err := sayHello(); err != nil {
+			failures++
+		}
+	}
+	assert.Equal(t, 2, failures)
+	event := <-events
+	assert.Equal(t, addrB, event.Address)
+	assert.True(t, event.Ejected)
+	assert.Equal(t, []string{addrB}, client.EjectedEndpoints())
+	for i := 0; i < 10; i++ {
+		reply, err := sayHello()
+		assert.Nil(t, err)
+		assert.Equal(t, "a", reply)
+	}
+
+	// b recovers, and it is picked again after ejection and the gradual reintroduction
+	atomic.StoreInt32(&flaky.failing, 0)
+	time.Sleep(2*ejectionDuration + 50*time.Millisecond)
+	replies := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		reply, err := sayHello()
+		assert.Nil(t, err)
+		replies[reply]++
+	}
+	assert.Equal(t, map[string]int{"a": 5, "b": 5}, replies)
+	event = <-events
+	assert.Equal(t, addrB, event.Address)
+	assert.False(t, event.Ejected)
+	assert.Empty(t, client.EjectedEndpoints())
+}
+
+func TestTripleClientWeightedRoundRobin(t *testing.T) {
+	serverA, addrA := startTestServer(t, &testNamedService{name: "a"}, config.WithCodecType(constant.HessianCodecName))
+	defer serverA.Stop()
+	serverB, addrB := startTestServer(t, &testNamedService{name: "b"}, config.WithCodecType(constant.HessianCodecName))
+	defer serverB.Stop()
+
+	endpoints := testResolver{{Address: addrA, Weight: 3}, {Address: addrB, Weight: 1}}
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithResolver(endpoints),
+		config.WithLoadBalancePolicy(constant.WeightedRoundRobinLoadBalancePolicy),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	counts := make(map[string]int)
+	const total = 400
+	for i := 0; i < total; i++ {
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		counts[reply]++
+	}
+	// rpcs are balanced by weight 3:1
+	assert.InDelta(t, total*3/4, counts["a"], total/100, "counts = %v", counts)
+	assert.InDelta(t, total/4, counts["b"], total/100, "counts = %v", counts)
+
+	// unknown policy
+	_, err = NewTripleClient(nil, config.NewTripleOption(config.WithResolver(endpoints),
+		config.WithLoadBalancePolicy("unknown"), config.WithCodecType(constant.HessianCodecName)))
+	assert.NotNil(t, err)
+}
+
+func TestTripleClientConsistentHash(t *testing.T) {
+	endpoints := make(testResolver, 0, 3)
+	for _, name := range []string{"a", "b", "c"} {
+		server, addr := startTestServer(t, &testNamedService{name: name}, config.WithCodecType(constant.HessianCodecName))
+		defer server.Stop()
+		endpoints = append(endpoints, config.Endpoint{Address: addr})
+	}
+
+	// the key is user id in attachment of rpc
+	userID := func(ctx context.Context) string {
+		attachment, _ := ctx.Value(string(constant.CtxAttachmentKey)).(common.DubboAttachment)
+		id, _ := attachment["tri-user-id"].(string)
+		return id
+	}
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithResolver(endpoints),
+		config.WithConsistentHashKey(userID), config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	backends := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		id := "user-" + strconv.Itoa(i%30)
+		ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), common.DubboAttachment{"tri-user-id": id})
+		var reply string
+		rsp := client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		if backend, ok := backends[id]; ok {
+			assert.Equal(t, backend, reply, "rpcs of %s are sent to different backends", id)
+		}
+		backends[id] = reply
+	}
+	used := make(map[string]bool)
+	for _, backend := range backends {
+		used[backend] = true
+	}
+	assert.Equal(t, 3, len(used), "backends = %v", backends)
+}
+
+// testSubscribeService is TripleGrpcService impl for test, server-streaming method Subscribe idles for a while,
+// and then sends an event
+type testSubscribeService struct {
+	idle time.Duration
+}
+
+func (s *testSubscribeService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: testInterfaceKey,
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Subscribe",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					time.Sleep(s.idle)
+					return stream.SendMsg(wrapperspb.String("event"))
+				},
+				ServerStreams: true,
+			},
+		},
+	}
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	const path = "/" + testInterfaceKey + "/Subscribe"
+	server, addr := startTestServer(t, &testSubscribeService{idle: 300 * time.Millisecond},
+		config.WithMethodStreamHeartbeat(path, config.StreamHeartbeat{Interval: 50 * time.Millisecond}))
+	defer server.Stop()
+
+	// heartbeats are received as empty messages without predicate
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+	stream, err := client.StreamRequest(context.Background(), path)
+	assert.Nil(t, err)
+	heartbeats := 0
+	for {
+		msg := &wrapperspb.StringValue{}
+		if err := stream.RecvMsg(msg); err != nil {
+			break
+		}
+		if msg.GetValue() == "" {
+			heartbeats++
+		}
+	}
+	assert.True(t, heartbeats >= 3, "heartbeats = %d", heartbeats)
+
+	// heartbeats are skipped by client with predicate
+	skipClient, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr),
+		config.WithHeartbeatPredicate(config.EmptyHeartbeatPredicate)))
+	assert.Nil(t, err)
+	defer skipClient.Close()
+	stream, err = skipClient.StreamRequest(context.Background(), path)
+	assert.Nil(t, err)
+	msg := &wrapperspb.StringValue{}
+	assert.Nil(t, stream.RecvMsg(msg))
+	assert.Equal(t, "event", msg.GetValue())
+	assert.Equal(t, io.EOF, stream.RecvMsg(msg))
+}
+
+// testSearchService is TripleGrpcService impl for test, server-streaming method Search sends results until its ctx is
+// canceled, and the time it stops is sent to stopped
+type testSearchService struct {
+	stopped chan time.Time
+}
+
+func (s *testSearchService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: testInterfaceKey,
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Search",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					defer func() {
+						s.stopped <- time.Now()
+					}()
+					for i := 0; ; i++ {
+						select {
+						case <-stream.Context().Done():
+							return stream.Context().Err()
+						case <-time.After(time.Millisecond):
+						}
+						if err := stream.SendMsg(wrapperspb.String("result-" + strconv.Itoa(i))); err != nil {
+							return err
+						}
+					}
+				},
+				ServerStreams: true,
+			},
+		},
+	}
+}
+
+func TestClientStreamStop(t *testing.T) {
+	service := &testSearchService{stopped: make(chan time.Time, 1)}
+	server, addr := startTestServer(t, service)
+	defer server.Stop()
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Search")
+	assert.Nil(t, err)
+	clientStream := NewClientStream(stream)
+	// only the first 3 results are wanted
+	for i := 0; i < 3; i++ {
+		msg := &wrapperspb.StringValue{}
+		assert.Nil(t, clientStream.RecvMsg(msg))
+		assert.Equal(t, "result-"+strconv.Itoa(i), msg.GetValue())
+	}
+	stopAt := time.Now()
+	assert.Nil(t, clientStream.Stop())
+	select {
+	case stoppedAt := <-service.stopped:
+		assert.True(t, stoppedAt.Sub(stopAt) < 500*time.Millisecond, "server stops %v after client Stop", stoppedAt.Sub(stopAt))
+	case <-time.After(3 * time.Second):
+		t.Fatal("server doesn't stop after client Stop")
+	}
+
+	// results already received are skipped, then RecvMsg returns Canceled
+	var recvErr error
+	for recvErr == nil {
+		recvErr = clientStream.RecvMsg(&wrapperspb.StringValue{})
+	}
+	tripleErr, ok := recvErr.(*common.TripleError)
+	assert.True(t, ok, "recv error = %v", recvErr)
+	assert.Equal(t, int(codes.Canceled), tripleErr.Code())
+	assert.Nil(t, clientStream.Stop())
+
+	// the conn is still available for other rpcs
+	stream, err = client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Search")
+	assert.Nil(t, err)
+	msg := &wrapperspb.StringValue{}
+	assert.Nil(t, stream.RecvMsg(msg))
+	assert.Equal(t, "result-0", msg.GetValue())
+	assert.Nil(t, NewClientStream(stream).Stop())
+	<-service.stopped
+}
+
+func TestMaxStreamMessages(t *testing.T) {
+	service := &testSearchService{stopped: make(chan time.Time, 1)}
+	server, addr := startTestServer(t, service)
+	defer server.Stop()
+
+	// server streams results forever, client terminates the stream after 3 of them
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr), config.WithMaxStreamMessages(3)))
+	assert.Nil(t, err)
+	defer client.Close()
+	stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Search")
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, stream.RecvMsg(&wrapperspb.StringValue{}))
+	}
+	err = stream.RecvMsg(&wrapperspb.StringValue{})
+	tripleErr, ok := err.(*common.TripleError)
+	if assert.True(t, ok, "error = %v", err) {
+		assert.Equal(t, int(codes.ResourceExhausted), tripleErr.Code())
+	}
+	select {
+	case <-service.stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("server doesn't stop after client terminates the stream")
+	}
+	assert.Equal(t, err, stream.RecvMsg(&wrapperspb.StringValue{}))
+}
+
+// startFakeServer starts http2 server which sends response header of requests but never finishes them. @afterHeaders
+// is called after the response header of each request is sent, if it returns true, later requests on the conn
+// are ignored. If @afterHeaders is nil, only response headers are sent.
+func startFakeServer(t *testing.T, afterHeaders func(conn net.Conn, framer *h2.Framer, streamID uint32) bool) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := io.ReadFull(conn, make([]byte, len(h2.ClientPreface))); err != nil {
+					return
+				}
+				framer := h2.NewFramer(conn, conn)
+				if err := framer.WriteSettings(); err != nil {
+					return
+				}
+				ignored := false
+				for {
+					frame, err := framer.ReadFrame()
+					if err != nil {
+						return
+					}
+					headers, ok := frame.(*h2.HeadersFrame)
+					if !ok || ignored {
+						continue
+					}
+					var block bytes.Buffer
+					encoder := hpack.NewEncoder(&block)
+					_ = encoder.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+					_ = encoder.WriteField(hpack.HeaderField{Name: "content-type", Value: constant.TripleContentType})
+					_ = framer.WriteHeaders(h2.HeadersFrameParam{StreamID: headers.StreamID, BlockFragment: block.Bytes(), EndHeaders: true})
+					if afterHeaders != nil {
+						ignored = afterHeaders(conn, framer, headers.StreamID)
+					}
+				}
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+// goAwayAfterHeaders sends GOAWAY and closes the conn after the first request, like server shutting down with
+// active rpcs
+func goAwayAfterHeaders(conn net.Conn, framer *h2.Framer, streamID uint32) bool {
+	_ = framer.WriteGoAway(streamID, h2.ErrCodeNo, []byte("shutdown"))
+	// the request is drained before closing, otherwise the conn is reset
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	return true
+}
+
+func TestCancelCause(t *testing.T) {
+	server, addr := startTestServer(t, &testEchoStreamService{})
+	defer server.Stop()
+	const echoPath = "/" + testInterfaceKey + "/Echo"
+	// recvErr returns the error of stream after messages of server are drained
+	recvErr := func(stream grpc.ClientStream) error {
+		for {
+			if err := stream.RecvMsg(&wrapperspb.BytesValue{}); err != nil {
+				return err
+			}
+		}
+	}
+	assertCause := func(t *testing.T, err error, code codes.Code, cause error) {
+		tripleErr, ok := err.(*common.TripleError)
+		if !assert.True(t, ok, "error = %v", err) {
+			return
+		}
+		assert.Equal(t, int(code), tripleErr.Code())
+		assert.True(t, perrors.Is(err, cause), "error = %v", err)
+		assert.Equal(t, cause, errors.Unwrap(err))
+	}
+
+	t.Run("client deadline", func(t *testing.T) {
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+		assert.Nil(t, err)
+		defer client.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		stream, err := client.StreamRequest(ctx, echoPath)
+		assert.Nil(t, err)
+		assertCause(t, recvErr(stream), codes.DeadlineExceeded, context.DeadlineExceeded)
+	})
+
+	t.Run("cause of client ctx", func(t *testing.T) {
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+		assert.Nil(t, err)
+		defer client.Close()
+		shutdown := perrors.New("application shutdown")
+		ctx, cancel := common.WithCancelCause(context.Background())
+		stream, err := client.StreamRequest(ctx, echoPath)
+		assert.Nil(t, err)
+		cancel(shutdown)
+		assertCause(t, recvErr(stream), codes.Canceled, shutdown)
+	})
+
+	t.Run("stream stopped", func(t *testing.T) {
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+		assert.Nil(t, err)
+		defer client.Close()
+		stream, err := client.StreamRequest(context.Background(), echoPath)
+		assert.Nil(t, err)
+		assert.Nil(t, NewClientStream(stream).Stop())
+		assertCause(t, recvErr(stream), codes.Canceled, common.ErrStreamStopped)
+	})
+
+	t.Run("client closed", func(t *testing.T) {
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+		assert.Nil(t, err)
+		stream, err := client.StreamRequest(context.Background(), echoPath)
+		assert.Nil(t, err)
+		assert.Nil(t, stream.SendMsg(wrapperspb.Bytes([]byte("triple"))))
+		assert.Nil(t, stream.RecvMsg(&wrapperspb.BytesValue{}))
+		client.Close()
+		assertCause(t, recvErr(stream), codes.Canceled, common.ErrClientClosed)
+		_, err = client.StreamRequest(context.Background(), echoPath)
+		assertCause(t, err, codes.Canceled, common.ErrClientClosed)
+	})
+
+	t.Run("server GOAWAY", func(t *testing.T) {
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(startFakeServer(t, goAwayAfterHeaders))))
+		assert.Nil(t, err)
+		defer client.Close()
+		stream, err := client.StreamRequest(context.Background(), echoPath)
+		assert.Nil(t, err)
+		assertCause(t, recvErr(stream), codes.Unavailable, common.ErrServerGoAway)
+		rsp := client.Request(context.Background(), echoPath, wrapperspb.Bytes(nil), &wrapperspb.BytesValue{})
+		assertCause(t, rsp.GetError(), codes.Unavailable, common.ErrServerGoAway)
+	})
+
+	t.Run("unary timeout", func(t *testing.T) {
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(startFakeServer(t, nil)),
+			config.WithClientTimeout(1)))
+		assert.Nil(t, err)
+		defer client.Close()
+		rsp := client.Request(context.Background(), echoPath, wrapperspb.Bytes(nil), &wrapperspb.BytesValue{})
+		assertCause(t, rsp.GetError(), codes.DeadlineExceeded, context.DeadlineExceeded)
+	})
+
+	t.Run("cancel all", func(t *testing.T) {
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+		assert.Nil(t, err)
+		defer client.Close()
+		stream, err := client.StreamRequest(context.Background(), echoPath)
+		assert.Nil(t, err)
+		assert.Nil(t, stream.SendMsg(wrapperspb.Bytes([]byte("triple"))))
+		assert.Nil(t, stream.RecvMsg(&wrapperspb.BytesValue{}))
+		unaryClient, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(startFakeServer(t, nil)),
+			config.WithClientTimeout(10)))
+		assert.Nil(t, err)
+		defer unaryClient.Close()
+		time.AfterFunc(100*time.Millisecond, unaryClient.CancelAll)
+		rsp := unaryClient.Request(context.Background(), echoPath, wrapperspb.Bytes(nil), &wrapperspb.BytesValue{})
+		assertCause(t, rsp.GetError(), codes.Canceled, common.ErrCanceledAll)
+
+		client.CancelAll()
+		assertCause(t, recvErr(stream), codes.Canceled, common.ErrCanceledAll)
+		// rpcs started after CancelAll are not affected
+		stream, err = client.StreamRequest(context.Background(), echoPath)
+		assert.Nil(t, err)
+		assert.Nil(t, stream.SendMsg(wrapperspb.Bytes([]byte("triple"))))
+		assert.Nil(t, stream.RecvMsg(&wrapperspb.BytesValue{}))
+		assert.Nil(t, NewClientStream(stream).Stop())
+	})
+
+	t.Run("unary client deadline", func(t *testing.T) {
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(startFakeServer(t, nil)),
+			config.WithClientTimeout(10)))
+		assert.Nil(t, err)
+		defer client.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		rsp := client.Request(ctx, echoPath, wrapperspb.Bytes(nil), &wrapperspb.BytesValue{})
+		assertCause(t, rsp.GetError(), codes.DeadlineExceeded, context.DeadlineExceeded)
+		// the rpc is terminated by ctx, not by client timeout
+		assert.True(t, time.Since(start) < 5*time.Second)
+	})
+
+	t.Run("cause of unary client ctx", func(t *testing.T) {
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(startFakeServer(t, nil)),
+			config.WithClientTimeout(10)))
+		assert.Nil(t, err)
+		defer client.Close()
+		shutdown := perrors.New("application shutdown")
+		ctx, cancel := common.WithCancelCause(context.Background())
+		time.AfterFunc(100*time.Millisecond, func() {
+			cancel(shutdown)
+		})
+		rsp := client.Request(ctx, echoPath, wrapperspb.Bytes(nil), &wrapperspb.BytesValue{})
+		assertCause(t, rsp.GetError(), codes.Canceled, shutdown)
+	})
+}
+
+// testProduceService is TripleGrpcService impl for test, bidi-streaming method Produce sends items in application-ack
+// mode, and counts the items sent
+type testProduceService struct {
+	ack   config.StreamAck
+	items int
+	sent  int32
+}
+
+func (s *testProduceService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: testInterfaceKey,
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Produce",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					ackStream := NewServerAckStream(stream, s.ack, func() interface{} {
+						return &wrapperspb.UInt64Value{}
+					}, func(ack interface{}) uint64 {
+						return ack.(*wrapperspb.UInt64Value).GetValue()
+					})
+					for i := 0; i < s.items; i++ {
+						if err := ackStream.SendMsg(wrapperspb.String("item " + strconv.Itoa(i))); err != nil {
+							return err
+						}
+						atomic.AddInt32(&s.sent, 1)
+					}
+					return nil
+				},
+				ServerStreams: true,
+				ClientStreams: true,
+			},
+		},
+	}
+}
+
+func TestStreamAck(t *testing.T) {
+	ack := config.StreamAck{Window: 4, AckEvery: 2}
+	service := &testProduceService{ack: ack, items: 10}
+	server, addr := startTestServer(t, service)
+	defer server.Stop()
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+	stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Produce")
+	assert.Nil(t, err)
+	consumer := NewClientAckStream(stream, ack, func(processed uint64) interface{} {
+		return wrapperspb.UInt64(processed)
+	})
+
+	// server pauses after the window is sent, as client doesn't ack
+	items := make([]string, 0, service.items)
+	for i := 0; i < ack.Window; i++ {
+		item := &wrapperspb.StringValue{}
+		assert.Nil(t, consumer.RecvMsg(item))
+		items = append(items, item.GetValue())
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(ack.Window), atomic.LoadInt32(&service.sent))
+
+	// server resumes as client acks
+	for i := 0; i < ack.Window; i++ {
+		assert.Nil(t, consumer.Ack())
+	}
+	for {
+		item := &wrapperspb.StringValue{}
+		if err := consumer.RecvMsg(item); err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		items = append(items, item.GetValue())
+		assert.Nil(t, consumer.Ack())
+	}
+	assert.Equal(t, service.items, len(items))
+	assert.Equal(t, "item 9", items[9])
+	assert.Equal(t, int32(service.items), atomic.LoadInt32(&service.sent))
+}
+
+// waitGoAway reads frames of @framer until GOAWAY, it returns nil if conn is closed or times out without GOAWAY
+func waitGoAway(conn net.Conn, framer *h2.Framer) *h2.GoAwayFrame {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return nil
+		}
+		if goAway, ok := frame.(*h2.GoAwayFrame); ok {
+			return goAway
+		}
+	}
+}
+
+func TestUnexpectedFrames(t *testing.T) {
+	const echoPath = "/" + testInterfaceKey + "/Echo"
+	const unknownFrameType = h2.FrameType(0xfa)
+	var pushHeaders bytes.Buffer
+	encoder := hpack.NewEncoder(&pushHeaders)
+	_ = encoder.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+	_ = encoder.WriteField(hpack.HeaderField{Name: ":path", Value: "/pushed"})
+
+	t.Run("client rejects push promise", func(t *testing.T) {
+		goAways := make(chan *h2.GoAwayFrame, 1)
+		addr := startFakeServer(t, func(conn net.Conn, framer *h2.Framer, streamID uint32) bool {
+			_ = framer.WritePushPromise(h2.PushPromiseParam{StreamID: streamID, PromiseID: 2, BlockFragment: pushHeaders.Bytes(), EndHeaders: true})
+			goAways <- waitGoAway(conn, framer)
+			return true
+		})
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+		assert.Nil(t, err)
+		defer client.Close()
+
+		stream, err := client.StreamRequest(context.Background(), echoPath)
+		assert.Nil(t, err)
+		err = stream.RecvMsg(&wrapperspb.BytesValue{})
+		tripleErr, ok := err.(*common.TripleError)
+		if assert.True(t, ok, "error = %v", err) {
+			assert.Equal(t, int(codes.Unavailable), tripleErr.Code())
+		}
+		goAway := <-goAways
+		if assert.NotNil(t, goAway) {
+			assert.Equal(t, h2.ErrCodeProtocol, goAway.ErrCode)
+		}
+
+		// the rejected conn is not reused, rpc is sent on a new conn
+		rsp := client.Request(context.Background(), echoPath, wrapperspb.String("ping"), &wrapperspb.StringValue{})
+		assert.NotNil(t, rsp.GetError())
+		assert.NotNil(t, <-goAways)
+	})
+
+	t.Run("client ignores unknown frames", func(t *testing.T) {
+		addr := startFakeServer(t, func(conn net.Conn, framer *h2.Framer, streamID uint32) bool {
+			_ = framer.WriteRawFrame(unknownFrameType, 0, streamID, []byte("extension"))
+			data, _ := proto.Marshal(wrapperspb.Bytes([]byte("pong")))
+			msg := make([]byte, 5, 5+len(data))
+			binary.BigEndian.PutUint32(msg[1:], uint32(len(data)))
+			_ = framer.WriteData(streamID, false, append(msg, data...))
+			return false
+		})
+		client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+		assert.Nil(t, err)
+		defer client.Close()
+
+		stream, err := client.StreamRequest(context.Background(), echoPath)
+		assert.Nil(t, err)
+		msg := &wrapperspb.BytesValue{}
+		assert.Nil(t, stream.RecvMsg(msg))
+		assert.Equal(t, "pong", string(msg.GetValue()))
+		assert.Nil(t, NewClientStream(stream).Stop())
+	})
+
+	server, addr := startTestServer(t, &testEchoStreamService{})
+	defer server.Stop()
+	// dialServer returns raw http2 conn to server, whose preface and settings are sent
+	dialServer := func(t *testing.T) (net.Conn, *h2.Framer) {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		t.Cleanup(func() { conn.Close() })
+		_, err = conn.Write([]byte(h2.ClientPreface))
+		assert.Nil(t, err)
+		framer := h2.NewFramer(conn, conn)
+		assert.Nil(t, framer.WriteSettings())
+		return conn, framer
+	}
+
+	t.Run("server rejects push promise", func(t *testing.T) {
+		conn, framer := dialServer(t)
+		assert.Nil(t, framer.WritePushPromise(h2.PushPromiseParam{StreamID: 1, PromiseID: 2, BlockFragment: pushHeaders.Bytes(), EndHeaders: true}))
+		goAway := waitGoAway(conn, framer)
+		if assert.NotNil(t, goAway) {
+			assert.Equal(t, h2.ErrCodeProtocol, goAway.ErrCode)
+		}
+	})
+
+	t.Run("server ignores unknown frames", func(t *testing.T) {
+		conn, framer := dialServer(t)
+		assert.Nil(t, framer.WriteRawFrame(unknownFrameType, 0, 0, []byte("extension")))
+		pingData := [8]byte{'t', 'r', 'i', 'p', 'l', 'e'}
+		assert.Nil(t, framer.WritePing(false, pingData))
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			frame, err := framer.ReadFrame()
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.NotEqual(t, h2.FrameGoAway, frame.Header().Type)
+			if ping, ok := frame.(*h2.PingFrame); ok {
+				assert.True(t, ping.IsAck())
+				assert.Equal(t, pingData, ping.Data)
+				return
+			}
+		}
+	})
+}
+
+func TestServerDrainAndClose(t *testing.T) {
+	service := &testSearchService{stopped: make(chan time.Time, 2)}
+	server, addr := startTestServer(t, service)
+	defer server.Stop()
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// the completed stream is canceled by client during draining, the forced one streams until conn is closed
+	completedCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	completed, err := client.StreamRequest(completedCtx, "/"+testInterfaceKey+"/Search")
+	assert.Nil(t, err)
+	assert.Nil(t, completed.RecvMsg(&wrapperspb.StringValue{}))
+	forced, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Search")
+	assert.Nil(t, err)
+	assert.Nil(t, forced.RecvMsg(&wrapperspb.StringValue{}))
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+	ctx, cancelDrain := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancelDrain()
+	var (
+		wg        sync.WaitGroup
+		summaries [2]config.DrainSummary
+	)
+	start := time.Now()
+	for i := range summaries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			summaries[i] = server.DrainAndClose(ctx)
+		}(i)
+	}
+	wg.Wait()
+	assert.True(t, time.Since(start) >= 500*time.Millisecond)
+	assert.Equal(t, config.DrainSummary{Completed: 1, Forced: 1}, summaries[0])
+	assert.Equal(t, summaries[0], summaries[1])
+	// the summary of the first call is returned
+	assert.Equal(t, summaries[0], server.DrainAndClose(context.Background()))
+
+	// both handlers exit, and the forced stream is terminated by closed conn
+	for i := 0; i < 2; i++ {
+		select {
+		case <-service.stopped:
+		case <-time.After(3 * time.Second):
+			t.Fatal("handler of stream is still running")
+		}
+	}
+	for {
+		if err := forced.RecvMsg(&wrapperspb.StringValue{}); err != nil {
+			break
+		}
+	}
+
+	// new conn is refused
+	_, err = net.DialTimeout("tcp", addr, time.Second)
+	assert.NotNil(t, err)
+}
+
+// grpcMetadataEchoDesc is ServiceDesc of plain grpc-go service for test, unary method Upper and server-streaming
+// method Echo reply request metadata, which is sent back in header "echo-*" and trailer "trailer-*"
+var grpcMetadataEchoDesc = &grpc.ServiceDesc{
+	ServiceName: testInterfaceKey,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Upper",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &wrapperspb.StringValue{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				header, trailer := grpcMetadataEcho(ctx)
+				if err := grpc.SetHeader(ctx, header); err != nil {
+					return nil, err
+				}
+				if err := grpc.SetTrailer(ctx, trailer); err != nil {
+					return nil, err
+				}
+				return wrapperspb.String(strings.ToUpper(in.Value)), nil
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Echo",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := &wrapperspb.StringValue{}
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				header, trailer := grpcMetadataEcho(stream.Context())
+				if err := stream.SendHeader(header); err != nil {
+					return err
+				}
+				stream.SetTrailer(trailer)
+				return stream.SendMsg(in)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+// grpcMetadataEcho returns header and trailer metadata replying all request metadata in @ctx of grpc-go server
+func grpcMetadataEcho(ctx context.Context) (metadata.MD, metadata.MD) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header, trailer := metadata.MD{}, metadata.MD{"trailer-keys": {}}
+	for k, v := range md {
+		if strings.HasPrefix(k, ":") {
+			continue
+		}
+		header.Append("echo-"+k, v...)
+		trailer.Append("trailer-keys", k)
+	}
+	sort.Strings(trailer["trailer-keys"])
+	// the same key in header and trailer
+	header.Append("common", "header")
+	trailer.Append("common", "trailer")
+	return header, trailer
+}
+
+func TestGrpcMetadataCompatible(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer()
+	grpcServer.RegisterService(grpcMetadataEchoDesc, struct{}{})
+	go func() {
+		_ = grpcServer.Serve(lst)
+	}()
+	defer grpcServer.Stop()
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(lst.Addr().String()),
+		config.WithGrpcMetadataCompatible()))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	attachment := common.DubboAttachment{"User-ID": "42", "tag": []string{"a", "b"}}
+	common.SetBinaryAttachment(attachment, "token", []byte{0xff, 0x00})
+	ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), attachment)
+	check := func(t *testing.T, rspAttachment common.TripleAttachment) {
+		// request attachments are the only metadata of grpc server, besides fields of http2 transport
+		assert.Equal(t, []string{"accept-encoding", "content-type", "tag", "token-bin", "user-agent", "user-id"},
+			rspAttachment.Values("trailer-keys"))
+		assert.Equal(t, "42", rspAttachment.Get("echo-user-id"))
+		assert.Equal(t, []string{"a", "b"}, rspAttachment.Values("echo-tag"))
+		token, ok := common.GetBinaryAttachment(rspAttachment, "echo-token")
+		assert.True(t, ok)
+		assert.Equal(t, []byte{0xff, 0x00}, token)
+		// header values come first
+		assert.Equal(t, []string{"header", "trailer"}, rspAttachment.Values("common"))
+		_, ok = rspAttachment.Lookup("content-type")
+		assert.False(t, ok)
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		reply := &wrapperspb.StringValue{}
+		rsp := client.Request(ctx, "/"+testInterfaceKey+"/Upper", wrapperspb.String("triple"), reply)
+		assert.Nil(t, rsp.GetError())
+		assert.Equal(t, "TRIPLE", reply.GetValue())
+		check(t, rsp.GetAttachments())
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		stream, err := client.StreamRequest(ctx, "/"+testInterfaceKey+"/Echo")
+		assert.Nil(t, err)
+		assert.Nil(t, stream.SendMsg(wrapperspb.String("triple")))
+		reply := &wrapperspb.StringValue{}
+		assert.Nil(t, stream.RecvMsg(reply))
+		assert.Equal(t, "triple", reply.GetValue())
+		assert.Equal(t, io.EOF, stream.RecvMsg(reply))
+		check(t, common.TripleAttachment(stream.Trailer()))
+	})
+}
+
+// startAuthorityServer starts http2 server which replies each request with an empty message, and sends its :authority
+// to the returned channel
+func startAuthorityServer(t *testing.T) (string, chan string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { lis.Close() })
+	authorities := make(chan string, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorities <- r.Host
+		_, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("content-type", constant.TripleContentType)
+		w.Header().Set("Trailer", constant.TrailerKeyGrpcStatus)
+		_, _ = w.Write(make([]byte, 5))
+		w.Header().Set(constant.TrailerKeyGrpcStatus, "0")
+	})
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go (&h2.Server{}).ServeConn(conn, &h2.ServeConnOpts{Handler: handler})
+		}
+	}()
+	return lis.Addr().String(), authorities
+}
+
+func TestClientAuthority(t *testing.T) {
+	addr, authorities := startAuthorityServer(t)
+	const path = "/" + testInterfaceKey + "/Upper"
+	request := func(client *TripleClient, ctx context.Context) error {
+		rsp := client.Request(ctx, path, wrapperspb.String("triple"), &wrapperspb.StringValue{})
+		return rsp.GetError()
+	}
+
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+	// address is the authority by default
+	assert.Nil(t, request(client, context.Background()))
+	assert.Equal(t, addr, <-authorities)
+
+	client, err = NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr),
+		config.WithAuthority("upper.svc.internal:443")))
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Nil(t, request(client, context.Background()))
+	assert.Equal(t, "upper.svc.internal:443", <-authorities)
+	stream, err := client.StreamRequest(context.Background(), path)
+	assert.Nil(t, err)
+	assert.Nil(t, stream.SendMsg(wrapperspb.String("triple")))
+	assert.Equal(t, "upper.svc.internal:443", <-authorities)
+
+	// authority of a single call overrides the one of client
+	ctx := common.WithAuthority(context.Background(), "[::1]:8443")
+	assert.Nil(t, request(client, ctx))
+	assert.Equal(t, "[::1]:8443", <-authorities)
+	reader, err := client.RequestChunked(ctx, path, wrapperspb.String("triple"))
+	assert.Nil(t, err)
+	assert.Equal(t, "[::1]:8443", <-authorities)
+	assert.Nil(t, reader.Close())
+
+	// illegal authorities are rejected before sending
+	for _, authority := range []string{"", "user@upper.svc", "upper.svc/path", "upper.svc:port", "::1", ":443", "upper svc"} {
+		assert.NotNil(t, common.ValidateAuthority(authority), authority)
+		ctx := common.WithAuthority(context.Background(), authority)
+		assert.NotNil(t, request(client, ctx), authority)
+		if authority != "" {
+			_, err = NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr), config.WithAuthority(authority)))
+			assert.NotNil(t, err, authority)
+		}
+	}
+	assert.Equal(t, 0, len(authorities))
+}
+
+// testRetryService is TripleUnaryService impl for test, method SayHello fails with code of the first @failures calls,
+// and the number of calls is counted in attempts
+type testRetryService struct {
+	testUnaryService
+	code     codes.Code
+	failures int32
+	attempts int32
+	// pushback is sent in grpc-retry-pushback-ms trailer of failure, if it's not empty
+	pushback string
+}
+
+func (s *testRetryService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	if atomic.AddInt32(&s.attempts, 1) <= atomic.LoadInt32(&s.failures) {
+		attachments := common.DubboAttachment{}
+		if s.pushback != "" {
+			attachments[constant.TrailerKeyGrpcRetryPushbackMs] = s.pushback
+		}
+		return nil, common.NewHandlerError(int(s.code), "flaky", attachments)
+	}
+	return s.SayHello(arguments[0].(string)), nil
+}
+
+func TestClientOnRetry(t *testing.T) {
+	policy := config.RetryPolicy{
+		MaxAttempts:       4,
+		InitialBackoff:    10 * time.Millisecond,
+		MaxBackoff:        20 * time.Millisecond,
+		BackoffMultiplier: 2,
+		RetryableCodes:    []int{int(codes.Unavailable)},
+	}
+	tests := []struct {
+		name     string
+		service  *testRetryService
+		budget   config.RetryBudget
+		attempts int32
+		// retries are the Attempt of each RetryInfo, negative for the suppressed one
+		retries []int
+		code    codes.Code
+	}{
+		{
+			name:     "retry until success",
+			service:  &testRetryService{code: codes.Unavailable, failures: 2},
+			attempts: 3,
+			retries:  []int{2, 3},
+			code:     codes.OK,
+		},
+		{
+			name:     "max attempts",
+			service:  &testRetryService{code: codes.Unavailable, failures: 10},
+			attempts: 4,
+			retries:  []int{2, 3, 4},
+			code:     codes.Unavailable,
+		},
+		{
+			name:     "not retryable",
+			service:  &testRetryService{code: codes.PermissionDenied, failures: 10},
+			attempts: 1,
+			code:     codes.PermissionDenied,
+		},
+		{
+			// tokens are 3 after the first failure, and 2 after the second one, which is not more than half of 4
+			name:     "suppressed by budget",
+			service:  &testRetryService{code: codes.Unavailable, failures: 10},
+			budget:   config.RetryBudget{MaxTokens: 4, TokenRatio: 0.1},
+			attempts: 2,
+			retries:  []int{2, -3},
+			code:     codes.Unavailable,
+		},
+		{
+			name:     "negative pushback",
+			service:  &testRetryService{code: codes.Unavailable, failures: 10, pushback: "-1"},
+			attempts: 1,
+			code:     codes.Unavailable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, addr := startTestServer(t, test.service, config.WithCodecType(constant.HessianCodecName))
+			defer server.Stop()
+
+			var (
+				lock  sync.Mutex
+				infos []config.RetryInfo
+			)
+			client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+				config.WithCodecType(constant.HessianCodecName), config.WithRetryPolicy(policy),
+				config.WithRetryBudget(test.budget), config.WithOnRetry(func(info config.RetryInfo) {
+					lock.Lock()
+					infos = append(infos, info)
+					lock.Unlock()
+				})))
+			assert.Nil(t, err)
+			defer client.Close()
+
+			var reply string
+			rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+			if test.code == codes.OK {
+				assert.Nil(t, rsp.GetError())
+				assert.Equal(t, "hello triple", reply)
+			} else {
+				assert.Equal(t, int(test.code), rsp.GetError().(*common.TripleError).Code())
+			}
+			assert.Equal(t, test.attempts, atomic.LoadInt32(&test.service.attempts))
+
+			lock.Lock()
+			defer lock.Unlock()
+			var retries []int
+			for i, info := range infos {
+				assert.Equal(t, "/"+testInterfaceKey+"/SayHello", info.Method)
+				assert.Equal(t, int(codes.Unavailable), info.Err.(*common.TripleError).Code())
+				// backoff is random in [0, min(10ms * 2^i, 20ms))
+				bound := 10 * time.Millisecond << i
+				if bound > 20*time.Millisecond {
+					bound = 20 * time.Millisecond
+				}
+				assert.True(t, info.Backoff >= 0 && info.Backoff < bound, "backoff %v of retry %d", info.Backoff, i)
+				if info.Suppressed {
+					retries = append(retries, -info.Attempt)
+				} else {
+					retries = append(retries, info.Attempt)
+				}
+			}
+			assert.Equal(t, test.retries, retries)
+		})
+	}
+}
+
+func TestClientRetryPushback(t *testing.T) {
+	service := &testRetryService{code: codes.Unavailable, failures: 1, pushback: "100"}
+	server, addr := startTestServer(t, service, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	var backoff time.Duration
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName), config.WithRetryPolicy(config.RetryPolicy{
+			MaxAttempts:    2,
+			InitialBackoff: time.Millisecond,
+			RetryableCodes: []int{int(codes.Unavailable)},
+		}), config.WithOnRetry(func(info config.RetryInfo) {
+			backoff = info.Backoff
+		})))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// the delay told by server is the backoff
+	var reply string
+	start := time.Now()
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, 100*time.Millisecond, backoff)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&service.attempts))
+}
+
+// testIdempotencyService is testRetryService which records request attachment tri-idempotency-key of each attempt
+type testIdempotencyService struct {
+	testRetryService
+	lock sync.Mutex
+	keys []string
+}
+
+func (s *testIdempotencyService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	s.lock.Lock()
+	s.keys = append(s.keys, ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment).Get(constant.TripleIdempotencyKey))
+	s.lock.Unlock()
+	return s.testRetryService.InvokeWithArgs(ctx, methodName, arguments)
+}
+
+func TestClientIdempotencyKey(t *testing.T) {
+	service := &testIdempotencyService{testRetryService: testRetryService{code: codes.Unavailable, failures: 2}}
+	server, addr := startTestServer(t, service, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName), config.WithRetryPolicy(config.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			RetryableCodes: []int{int(codes.Unavailable)},
+		}), config.WithIdempotencyKeyGenerator(common.NewIdempotencyKey)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// key is generated once, and is the same in all the attempts
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, 3, len(service.keys))
+	assert.Equal(t, 32, len(service.keys[0]))
+	assert.Equal(t, []string{service.keys[0], service.keys[0], service.keys[0]}, service.keys)
+
+	// the next call has another key
+	rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, 4, len(service.keys))
+	assert.NotEqual(t, service.keys[0], service.keys[3])
+
+	// key of caller overrides the generator
+	ctx := common.WithIdempotencyKey(context.Background(), "order-42")
+	rsp = client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "order-42", service.keys[4])
+}
+
+// testLargeAttachmentService is TripleUnaryService impl for test, method SayHello replies all request attachments
+// with prefix "x-large-" in response attachments with prefix "echo-"
+type testLargeAttachmentService struct {
+	testUnaryService
+}
+
+func (s *testLargeAttachmentService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	attachments := make(map[string]interface{})
+	for k, v := range ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment) {
+		if strings.HasPrefix(k, "x-large-") {
+			attachments["echo-"+k] = v
+		}
+	}
+	return &testResult{result: s.SayHello(arguments[0].(string)), attachments: attachments}, nil
+}
+
+func TestLargeAttachment(t *testing.T) {
+	// 32KB of random attachments can't be compressed by hpack into a single frame of 16KB
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	attachment := make(common.DubboAttachment)
+	for i := 0; i < 8; i++ {
+		value := make([]byte, 4096)
+		for j := range value {
+			value[j] = letters[rand.Intn(len(letters))]
+		}
+		attachment["x-large-"+strconv.Itoa(i)] = string(value)
+	}
+	ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), attachment)
+
+	server, addr := startTestServer(t, &testLargeAttachmentService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	var continuations [2]int32
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName), config.WithFrameObserver(func(info config.FrameInfo) {
+			if info.Type != h2.FrameContinuation {
+				return
+			}
+			if info.Outbound {
+				atomic.AddInt32(&continuations[0], 1)
+			} else {
+				atomic.AddInt32(&continuations[1], 1)
+			}
+		})))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	rsp := client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello triple", reply)
+	for k, v := range attachment {
+		assert.Equal(t, v, rsp.GetAttachments().Get("echo-"+k), k)
+	}
+	// header blocks of request and trailer span multiple frames
+	assert.True(t, atomic.LoadInt32(&continuations[0]) > 0)
+	assert.True(t, atomic.LoadInt32(&continuations[1]) > 0)
+
+	// request header list larger than the max of server fails
+	limitedServer, limitedAddr := startTestServer(t, &testLargeAttachmentService{}, config.WithCodecType(constant.HessianCodecName),
+		config.WithMaxHeaderListSize(16*1024))
+	defer limitedServer.Stop()
+	limitedClient, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(limitedAddr),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer limitedClient.Close()
+	rsp = limitedClient.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.NotNil(t, rsp.GetError())
+
+	// trailer larger than the max of client is truncated
+	limitedClient, err = NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName), config.WithMaxHeaderListSize(20*1024)))
+	assert.Nil(t, err)
+	defer limitedClient.Close()
+	rsp = limitedClient.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	// the truncated trailer loses grpc-status
+	tripleErr, ok := rsp.GetError().(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Internal, codes.Code(tripleErr.Code()))
+}
+
+// newTestTLSConfig returns TLS config of server with self-signed cert of 127.0.0.1, and the one of client trusting it
+func newTestTLSConfig(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "triple test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: roots}
+}
+
+func TestTLSNextProtos(t *testing.T) {
+	serverConf, clientConf := newTestTLSConfig(t)
+	offered := make(chan []string, 1)
+	serverConf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		offered <- hello.SupportedProtos
+		return nil, nil
+	}
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName),
+		config.WithTLSConfig(serverConf))
+	defer server.Stop()
+
+	for _, c := range []struct {
+		protos []string
+		want   []string
+	}{
+		{want: []string{"h2"}},
+		{protos: []string{"my-proxy", "h2"}, want: []string{"my-proxy", "h2"}},
+	} {
+		client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+			config.WithCodecType(constant.HessianCodecName), config.WithTLSConfig(clientConf),
+			config.WithNextProtos(c.protos...)))
+		assert.Nil(t, err)
+		var reply string
+		rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		assert.Equal(t, "hello triple", reply)
+		assert.Equal(t, c.want, <-offered)
+		client.Close()
+	}
+
+	// triple can't speak h2 over TLS without "h2"
+	_, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName), config.WithTLSConfig(clientConf),
+		config.WithNextProtos("my-proxy")))
+	assert.NotNil(t, err)
+}
+
+// testKeyCaseService is TripleUnaryService impl for test, method SayHello replies sorted keys of request attachments
+// with prefix "x-legacy-" in any case, and response attachment "x-legacy-trailer"
+type testKeyCaseService struct {
+	testUnaryService
+}
+
+func (s *testKeyCaseService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	var keys []string
+	for k := range ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment) {
+		if strings.HasPrefix(strings.ToLower(k), "x-legacy-") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return &testResult{result: strings.Join(keys, ","), attachments: map[string]interface{}{"x-legacy-trailer": "red"}}, nil
+}
+
+func TestPreserveCaseKeys(t *testing.T) {
+	server, addr := startTestServer(t, &testKeyCaseService{}, config.WithCodecType(constant.HessianCodecName),
+		config.WithPreserveCaseKeys("X-Legacy-Key"))
+	defer server.Stop()
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName), config.WithPreserveCaseKeys("X-Legacy-Trailer")))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), common.DubboAttachment{
+		"X-Legacy-Key":   "blue",
+		"X-Legacy-Other": "green",
+	})
+	var reply string
+	rsp := client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	// keys are lower case on the wire, only the configured ones are restored
+	assert.Equal(t, "X-Legacy-Key,x-legacy-other", reply)
+	assert.Equal(t, []string{"red"}, rsp.GetAttachments()["X-Legacy-Trailer"])
+	assert.Equal(t, "red", rsp.GetAttachments().Get("x-legacy-trailer"))
+}
+
+// testAttachmentUser is struct value of attachment for test
+type testAttachmentUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+// jsonAttachmentCodec is config.AttachmentValueCodec impl for test
+type jsonAttachmentCodec struct{}
+
+func (jsonAttachmentCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonAttachmentCodec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+// testUserAttachmentService is TripleUnaryService impl for test, method SayHello replies name of request attachment
+// "x-user", and the user one year older in response attachment "x-echo-user"
+type testUserAttachmentService struct {
+	testUnaryService
+}
+
+func (s *testUserAttachmentService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	var user testAttachmentUser
+	ok, err := common.UnmarshalAttachment(ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment), "x-user", jsonAttachmentCodec{}, &user)
+	if !ok || err != nil {
+		return nil, common.NewTripleError(fmt.Sprintf("invalid x-user: %v", err), int(codes.InvalidArgument), "", nil)
+	}
+	user.Age++
+	return &testResult{result: s.SayHello(user.Name), attachments: map[string]interface{}{"x-echo-user": &user}}, nil
+}
+
+func TestAttachmentValueCodec(t *testing.T) {
+	server, addr := startTestServer(t, &testUserAttachmentService{}, config.WithCodecType(constant.HessianCodecName),
+		config.WithAttachmentValueCodec("x-user", jsonAttachmentCodec{}),
+		config.WithAttachmentValueCodec("x-echo-user", jsonAttachmentCodec{}))
+	defer server.Stop()
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName),
+		config.WithAttachmentValueCodec("X-User", jsonAttachmentCodec{}),
+		config.WithAttachmentValueCodec("x-echo-user", jsonAttachmentCodec{})))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), common.DubboAttachment{
+		"X-User":  testAttachmentUser{Name: "triple", Age: 3},
+		"x-plain": "value",
+	})
+	var reply string
+	rsp := client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello triple", reply)
+	var user testAttachmentUser
+	ok, err := common.UnmarshalAttachment(rsp.GetAttachments(), "x-echo-user", jsonAttachmentCodec{}, &user)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, testAttachmentUser{Name: "triple", Age: 4}, user)
+
+	// value which can't be serialized fails the rpc before sending
+	ctx = context.WithValue(context.Background(), string(constant.CtxAttachmentKey), common.DubboAttachment{
+		"x-user": make(chan int),
+	})
+	rsp = client.Request(ctx, "/"+testInterfaceKey+"/SayHello", []interface{}{"triple"}, &reply)
+	assert.NotNil(t, rsp.GetError())
+	assert.Contains(t, rsp.GetError().Error(), "marshal attachment x-user")
+}
+
+// testPlainCalculator is a plain struct registered by common.NewReflectionUnaryService for test
+type testPlainCalculator struct{}
+
+func (c *testPlainCalculator) Greet(name string) string {
+	return "hello " + name
+}
+
+func (c *testPlainCalculator) Divide(ctx context.Context, a, b int64) (int64, error) {
+	if b == 0 {
+		return 0, common.NewTripleError("divided by zero", int(codes.InvalidArgument), "", nil)
+	}
+	return a / b, nil
+}
+
+// Close has no reply, so it is not provided
+func (c *testPlainCalculator) Close() {}
+
+func TestReflectionUnaryService(t *testing.T) {
+	_, err := common.NewReflectionUnaryService(struct{}{})
+	assert.NotNil(t, err)
+
+	service, err := common.NewReflectionUnaryService(&testPlainCalculator{})
+	assert.Nil(t, err)
+	server, addr := startTestServer(t, service, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+	assert.Equal(t, []string{"Divide", "Greet"}, server.ListServices()[0].Methods)
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var greeting string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/Greet", []interface{}{"triple"}, &greeting)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello triple", greeting)
+
+	var quotient int64
+	rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/Divide", []interface{}{int64(7), int64(2)}, &quotient)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, int64(3), quotient)
+
+	rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/Divide", []interface{}{int64(7), int64(0)}, &quotient)
+	tripleErr, ok := rsp.GetError().(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, codes.Code(tripleErr.Code()))
+
+	rsp = client.Request(context.Background(), "/"+testInterfaceKey+"/Close", []interface{}{}, &quotient)
+	tripleErr, ok = rsp.GetError().(*common.TripleError)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Unimplemented, codes.Code(tripleErr.Code()))
+}
+
+// testSlowService is TripleUnaryService impl for test, method SayHello sleeps 100ms before replying "slow"
+type testSlowService struct {
+	testUnaryService
+}
+
+func (s *testSlowService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	if arguments[0].(string) == "slow" {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return s.testUnaryService.InvokeWithArgs(ctx, methodName, arguments)
+}
+
+func TestServerSlowRequestThreshold(t *testing.T) {
+	const path = "/" + testInterfaceKey + "/SayHello"
+	core, logs := observer.New(zapcore.WarnLevel)
+	server, addr := startTestServer(t, &testSlowService{}, config.WithCodecType(constant.HessianCodecName),
+		config.WithLogger(zap.New(core).Sugar()), config.WithSlowRequestThreshold(time.Hour),
+		config.WithMethodSlowRequestThreshold(path, 50*time.Millisecond))
+	defer server.Stop()
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	rsp := client.Request(context.Background(), path, []interface{}{"fast"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Zero(t, logs.FilterMessageSnippet("slow rpc").Len())
+
+	rsp = client.Request(context.Background(), path, []interface{}{"slow"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello slow", reply)
+	// the warning is logged after the trailer is sent
+	var warnings []observer.LoggedEntry
+	for i := 0; i < 50 && len(warnings) == 0; i++ {
+		warnings = logs.FilterMessageSnippet("slow rpc").All()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, len(warnings))
+	assert.Contains(t, warnings[0].Message, path)
+	assert.Contains(t, warnings[0].Message, "127.0.0.1")
+}
+
+// testStatusDetailsService is TripleUnaryService impl for test, method SayHello returns grpc status error with
+// BadRequest details for empty name
+type testStatusDetailsService struct {
+	testUnaryService
+}
+
+func (s *testStatusDetailsService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	if arguments[0].(string) == "" {
+		st, err := grpcstatus.New(grpccodes.InvalidArgument, "name is required").WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "name", Description: "empty"}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return nil, st.Err()
+	}
+	return s.testUnaryService.InvokeWithArgs(ctx, methodName, arguments)
+}
+
+func TestUnaryServiceStatusDetails(t *testing.T) {
+	server, addr := startTestServer(t, &testStatusDetailsService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	rsp := client.Request(context.Background(), "/"+testInterfaceKey+"/SayHello", []interface{}{""}, &reply)
+	assert.NotNil(t, rsp.GetError())
+	st, ok := grpcstatus.FromError(rsp.GetError())
+	assert.True(t, ok)
+	assert.Equal(t, grpccodes.InvalidArgument, st.Code())
+	assert.Equal(t, "name is required", st.Message())
+	details := st.Details()
+	assert.Equal(t, 1, len(details))
+	badRequest, ok := details[0].(*errdetails.BadRequest)
+	assert.True(t, ok)
+	assert.Equal(t, "name", badRequest.GetFieldViolations()[0].GetField())
+	assert.Equal(t, "empty", badRequest.GetFieldViolations()[0].GetDescription())
+
+	// errors without details keep code and msg
+	server2, addr2 := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server2.Stop()
+	client2, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr2),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client2.Close()
+	rsp = client2.Request(context.Background(), "/"+testInterfaceKey+"/Unknown", []interface{}{"name"}, &reply)
+	st, ok = grpcstatus.FromError(rsp.GetError())
+	assert.True(t, ok)
+	assert.Equal(t, grpccodes.Unimplemented, st.Code())
+}
+
+// testRecordService is TripleUnaryService impl for test, it records the name and attachment "user" of each SayHello
+type testRecordService struct {
+	testUnaryService
+	names chan string
+}
+
+func (s *testRecordService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	attachment := ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment)
+	s.names <- attachment.Get("user") + ":" + arguments[0].(string)
+	return s.testUnaryService.InvokeWithArgs(ctx, methodName, arguments)
+}
+
+func TestShadowTarget(t *testing.T) {
+	const path = "/" + testInterfaceKey + "/SayHello"
+	primary := &testRecordService{names: make(chan string, 8)}
+	primaryServer, primaryAddr := startTestServer(t, primary, config.WithCodecType(constant.HessianCodecName))
+	defer primaryServer.Stop()
+	shadow := &testRecordService{names: make(chan string, 8)}
+	shadowServer, shadowAddr := startTestServer(t, shadow, config.WithCodecType(constant.HessianCodecName))
+	defer shadowServer.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(primaryAddr),
+		config.WithCodecType(constant.HessianCodecName), config.WithShadowTarget(shadowAddr)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	ctx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), common.DubboAttachment{"user": "alice"})
+	rsp := client.Request(ctx, path, []interface{}{"dubbo"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello dubbo", reply)
+	assert.Equal(t, "alice:dubbo", <-primary.names)
+	select {
+	case name := <-shadow.names:
+		assert.Equal(t, "alice:dubbo", name)
+	case <-time.After(time.Second):
+		t.Fatal("shadow endpoint doesn't receive the copy of rpc")
+	}
+
+	// blocked shadow endpoint doesn't delay the primary rpc, and its error is only logged
+	blocking := &testBlockingService{unblock: make(chan struct{})}
+	defer close(blocking.unblock)
+	blockingServer, blockingAddr := startTestServer(t, blocking, config.WithCodecType(constant.HessianCodecName))
+	defer blockingServer.Stop()
+	core, logs := observer.New(zapcore.WarnLevel)
+	client2, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(primaryAddr),
+		config.WithCodecType(constant.HessianCodecName), config.WithShadowTarget(blockingAddr),
+		config.WithLogger(zap.New(core).Sugar())))
+	assert.Nil(t, err)
+	defer client2.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	rsp = client2.Request(ctx, path, []interface{}{"dubbo"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.True(t, time.Since(begin) < 200*time.Millisecond)
+	assert.Equal(t, ":dubbo", <-primary.names)
+	// shadow rpc keeps the deadline of primary rpc but isn't canceled when the primary one returns
+	time.Sleep(400 * time.Millisecond)
+	warnings := logs.FilterMessageSnippet("shadow rpc of path " + path).All()
+	assert.Equal(t, 1, len(warnings))
+	assert.Contains(t, warnings[0].Message, "deadline")
+
+	// rpcs are not mirrored while ShadowMaxInFlight shadow rpcs are in flight
+	client3, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(primaryAddr),
+		config.WithCodecType(constant.HessianCodecName), config.WithShadowTarget(blockingAddr),
+		config.WithShadowMaxInFlight(1)))
+	assert.Nil(t, err)
+	defer client3.Close()
+	for i := 0; i < 3; i++ {
+		rsp = client3.Request(context.Background(), path, []interface{}{"dubbo"}, &reply)
+		assert.Nil(t, rsp.GetError())
+		<-primary.names
+	}
+	assert.Equal(t, uint64(2), client3.Controller().ShadowDropped())
+}
+
+func TestServerMaxRequestBytes(t *testing.T) {
+	const path = "/" + testInterfaceKey + "/SayHello"
+	server, addr := startTestServer(t, &testUnaryService{}, config.WithCodecType(constant.HessianCodecName),
+		config.WithMaxRequestBytes(12*1024))
+	defer server.Stop()
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCodecType(constant.HessianCodecName)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	largeValue := strings.Repeat("a", 8*1024)
+	attachmentCtx := context.WithValue(context.Background(), string(constant.CtxAttachmentKey), common.DubboAttachment{"tri-large": largeValue})
+	var reply string
+	// either header list or body alone is within the limit
+	rsp := client.Request(attachmentCtx, path, []interface{}{"dubbo"}, &reply)
+	assert.Nil(t, rsp.GetError())
+	rsp = client.Request(context.Background(), path, []interface{}{largeValue}, &reply)
+	assert.Nil(t, rsp.GetError())
+	assert.Equal(t, "hello "+largeValue, reply)
+
+	rsp = client.Request(attachmentCtx, path, []interface{}{largeValue}, &reply)
+	assert.NotNil(t, rsp.GetError())
+	assert.Equal(t, int(codes.ResourceExhausted), rsp.GetError().(*common.TripleError).Code())
+	assert.Contains(t, rsp.GetError().Error(), "exceeds max size of 12288 bytes")
+}
+
+func TestStrayFrameStrategy(t *testing.T) {
+	const echoPath = "/" + testInterfaceKey + "/Echo"
+	var trailers bytes.Buffer
+	encoder := hpack.NewEncoder(&trailers)
+	_ = encoder.WriteField(hpack.HeaderField{Name: constant.TrailerKeyGrpcStatus, Value: "0"})
+	_ = encoder.WriteField(hpack.HeaderField{Name: constant.TrailerKeyGrpcMessage, Value: ""})
+	data, _ := proto.Marshal(wrapperspb.Bytes([]byte("pong")))
+	msg := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(data)))
+	msg = append(msg, data...)
+	// finishWithStrayFrames finishes the request, and then sends duplicate trailers and data after END_STREAM
+	finishWithStrayFrames := func(framer *h2.Framer, streamID uint32) {
+		_ = framer.WriteData(streamID, false, msg)
+		_ = framer.WriteHeaders(h2.HeadersFrameParam{StreamID: streamID, BlockFragment: trailers.Bytes(), EndStream: true, EndHeaders: true})
+		_ = framer.WriteHeaders(h2.HeadersFrameParam{StreamID: streamID, BlockFragment: trailers.Bytes(), EndStream: true, EndHeaders: true})
+		_ = framer.WriteData(streamID, true, msg)
+	}
+	// newClient returns client to @addr with @strategy, and the counter of conns dialed by it
+	newClient := func(t *testing.T, addr string, strategy config.StrayFrameStrategy, fs ...config.OptionFunction) (*TripleClient, *int32) {
+		var dials int32
+		client, err := NewTripleClient(nil, config.NewTripleOption(append(fs, config.WithLocation(addr),
+			config.WithStrayFrameStrategy(strategy),
+			config.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+				atomic.AddInt32(&dials, 1)
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			}))...))
+		assert.Nil(t, err)
+		t.Cleanup(client.Close)
+		return client, &dials
+	}
+
+	t.Run("ignore", func(t *testing.T) {
+		addr := startFakeServer(t, func(conn net.Conn, framer *h2.Framer, streamID uint32) bool {
+			finishWithStrayFrames(framer, streamID)
+			return false
+		})
+		client, dials := newClient(t, addr, config.StrayFrameIgnore)
+		// the stray frames are ignored by transport, and the conn is reused
+		for i := 0; i < 3; i++ {
+			reply := &wrapperspb.BytesValue{}
+			rsp := client.Request(context.Background(), echoPath, wrapperspb.Bytes([]byte("ping")), reply)
+			assert.Nil(t, rsp.GetError())
+			assert.Equal(t, "pong", string(reply.GetValue()))
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(dials))
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		goAways := make(chan *h2.GoAwayFrame, 2)
+		addr := startFakeServer(t, func(conn net.Conn, framer *h2.Framer, streamID uint32) bool {
+			finishWithStrayFrames(framer, streamID)
+			goAways <- waitGoAway(conn, framer)
+			return true
+		})
+		client, dials := newClient(t, addr, config.StrayFrameReject)
+		for i := 0; i < 2; i++ {
+			// the rpc ended before the stray frames is not affected
+			reply := &wrapperspb.BytesValue{}
+			rsp := client.Request(context.Background(), echoPath, wrapperspb.Bytes([]byte("ping")), reply)
+			assert.Nil(t, rsp.GetError())
+			assert.Equal(t, "pong", string(reply.GetValue()))
+			goAway := <-goAways
+			if assert.NotNil(t, goAway) {
+				assert.Equal(t, h2.ErrCodeStreamClosed, goAway.ErrCode)
+			}
+		}
+		// the rejected conn is not reused
+		assert.Equal(t, int32(2), atomic.LoadInt32(dials))
+	})
+}
+
+func TestClientStreamOnClose(t *testing.T) {
+	tests := []struct {
+		name    string
+		service interface{}
+		method  string
+		// recv receives from the stream until it ends
+		recv func(stream *ClientStream)
+		code grpccodes.Code
+	}{
+		{
+			name:    "eof",
+			service: &testSubscribeService{},
+			method:  "Subscribe",
+			recv: func(stream *ClientStream) {
+				for stream.RecvMsg(&wrapperspb.StringValue{}) == nil {
+				}
+			},
+			code: grpccodes.OK,
+		},
+		{
+			name:    "error status",
+			service: &testPartialStreamService{},
+			method:  "Items",
+			recv: func(stream *ClientStream) {
+				for stream.RecvMsg(&wrapperspb.StringValue{}) == nil {
+				}
+			},
+			code: grpccodes.DeadlineExceeded,
+		},
+		{
+			name:    "stop",
+			service: &testSearchService{stopped: make(chan time.Time, 1)},
+			method:  "Search",
+			recv: func(stream *ClientStream) {
+				assert.Nil(t, stream.RecvMsg(&wrapperspb.StringValue{}))
+				assert.Nil(t, stream.Stop())
+				for stream.RecvMsg(&wrapperspb.StringValue{}) == nil {
+				}
+			},
+			code: grpccodes.Canceled,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, addr := startTestServer(t, test.service)
+			defer server.Stop()
+
+			path := "/" + testInterfaceKey + "/" + test.method
+			optionErrs := make(chan error, 2)
+			client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr),
+				config.WithOnStreamClose(func(method string, err error) {
+					assert.Equal(t, path, method)
+					optionErrs <- err
+				})))
+			assert.Nil(t, err)
+			defer client.Close()
+
+			stream, err := client.StreamRequest(context.Background(), path)
+			assert.Nil(t, err)
+			clientStream := NewClientStream(stream)
+			streamErrs := make(chan error, 2)
+			assert.Nil(t, clientStream.OnClose(func(err error) {
+				streamErrs <- err
+			}))
+			test.recv(clientStream)
+
+			for _, errs := range []chan error{optionErrs, streamErrs} {
+				select {
+				case err := <-errs:
+					if test.code == grpccodes.OK {
+						assert.Nil(t, err)
+					} else {
+						assert.Equal(t, test.code, grpcstatus.Code(err))
+					}
+				case <-time.After(3 * time.Second):
+					t.Fatal("close callback isn't called")
+				}
+			}
+			// callback registered after the end is called at once
+			lateErrs := make(chan error, 1)
+			assert.Nil(t, clientStream.OnClose(func(err error) {
+				lateErrs <- err
+			}))
+			assert.Equal(t, test.code, grpcstatus.Code(<-lateErrs))
+			// callbacks are called exactly once
+			time.Sleep(100 * time.Millisecond)
+			assert.Equal(t, 0, len(optionErrs))
+			assert.Equal(t, 0, len(streamErrs))
+		})
+	}
+}
+
+func TestTypedClientConn(t *testing.T) {
+	server, addr := startTestServer(t, &testSubscribeService{})
+	defer server.Stop()
+
+	// paths of the stub in example are rewritten to the test service
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr),
+		config.WithRewritePath(func(ctx context.Context, path string) string {
+			return strings.Replace(path, "com.apache.dubbo.sample.basic.IGreeter", testInterfaceKey, 1)
+		})))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	greeter := newGreeterClient(client)
+	events, err := greeter.Subscribe(context.Background(), wrapperspb.String("news"))
+	assert.Nil(t, err)
+	event, err := events.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, "event", event.GetValue())
+	_, err = events.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	// error of unary rpc is returned without reply
+	reply, _, err := greeter.SayHello(context.Background(), wrapperspb.String("dubbo"))
+	assert.Nil(t, reply)
+	assert.Equal(t, grpccodes.Unimplemented, grpcstatus.Code(err))
+}
+
+// testTimeoutHeaderService is TripleUnaryService impl for test, all methods reply grpc-timeout of request
+type testTimeoutHeaderService struct {
+	testUnaryService
+}
+
+func (s *testTimeoutHeaderService) GetReqParamsInterfaces(methodName string) ([]interface{}, bool) {
+	var name string
+	return []interface{}{&name}, true
+}
+
+func (s *testTimeoutHeaderService) InvokeWithArgs(ctx context.Context, methodName string, arguments []interface{}) (interface{}, error) {
+	return ctx.Value(constant.CtxAttachmentKey).(common.TripleAttachment).Get(constant.GrpcTimeout), nil
+}
+
+func TestMethodTimeouts(t *testing.T) {
+	server, addr := startTestServer(t, &testTimeoutHeaderService{}, config.WithCodecType(constant.HessianCodecName))
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation(addr), config.WithCodecType(constant.HessianCodecName),
+		config.WithMethodTimeout("/"+testInterfaceKey+"/Search", 2*time.Second),
+		config.WithMethodTimeout("/"+testInterfaceKey+"/Report", 30*time.Second)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	background := context.Background()
+	deadlineCtx, cancel := context.WithTimeout(background, time.Second)
+	defer cancel()
+	tests := []struct {
+		ctx    context.Context
+		method string
+		// timeout is the expected grpc-timeout
+		timeout time.Duration
+	}{
+		{ctx: background, method: "Search", timeout: 2 * time.Second},
+		// longer than Timeout of option
+		{ctx: background, method: "Report", timeout: 30 * time.Second},
+		// the method isn't listed, Timeout of option is applied as the deadline
+		{ctx: background, method: "SayHello", timeout: constant.DefaultTimeout * time.Second},
+		// deadline of ctx is kept
+		{ctx: deadlineCtx, method: "Search", timeout: time.Second},
+	}
+	for _, test := range tests {
+		var reply string
+		rsp := client.Request(test.ctx, "/"+testInterfaceKey+"/"+test.method, []interface{}{"triple"}, &reply)
+		assert.Nil(t, rsp.GetError(), test.method)
+		timeout, err := common.DecodeGrpcTimeout(reply)
+		assert.Nil(t, err, test.method)
+		assert.True(t, timeout <= test.timeout && timeout > test.timeout-500*time.Millisecond, "%s: grpc-timeout = %s", test.method, reply)
+	}
+}
+
+func TestLastGoAwayStreamID(t *testing.T) {
+	// server sends GOAWAY after the third request of stream 5, which is the last one processed
+	addr := startFakeServer(t, func(conn net.Conn, framer *h2.Framer, streamID uint32) bool {
+		if streamID < 5 {
+			return false
+		}
+		return goAwayAfterHeaders(conn, framer, streamID)
+	})
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr)))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	_, ok := client.Controller().LastGoAwayStreamID()
+	assert.False(t, ok)
+	streams := make([]grpc.ClientStream, 0, 3)
+	for i := 0; i < 3; i++ {
+		stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Echo")
+		assert.Nil(t, err)
+		streams = append(streams, stream)
+	}
+	for _, stream := range streams {
+		assert.NotNil(t, stream.RecvMsg(&wrapperspb.BytesValue{}))
+	}
+	lastStreamID, ok := client.Controller().LastGoAwayStreamID()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5), lastStreamID)
+
+	// GOAWAY of the old conn is forgotten once a new conn to the address replaces it
+	_, err = client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Echo")
+	assert.Nil(t, err)
+	_, ok = client.Controller().LastGoAwayStreamID()
+	assert.False(t, ok)
+}
+
+// resultBreaker is config.CircuitBreaker which allows all rpcs, and tells results of them
+type resultBreaker struct {
+	results chan error
+}
+
+func (b *resultBreaker) Allow(string, string) (func(err error), bool) {
+	return func(err error) {
+		b.results <- err
+	}, true
+}
+
+func TestCircuitBreakerStreamClientClosed(t *testing.T) {
+	server, addr := startTestServer(t, &testEchoStreamService{})
+	defer server.Stop()
+	breaker := &resultBreaker{results: make(chan error, 2)}
+	client, err := NewTripleClient(&testStubImpl{}, config.NewTripleOption(config.WithLocation(addr),
+		config.WithCircuitBreaker(breaker)))
+	assert.Nil(t, err)
+
+	stream, err := client.StreamRequest(context.Background(), "/"+testInterfaceKey+"/Echo")
+	assert.Nil(t, err)
+	assert.Nil(t, stream.SendMsg(wrapperspb.Bytes([]byte("triple"))))
+	assert.Nil(t, stream.RecvMsg(&wrapperspb.BytesValue{}))
+	// the result of stream broken by Close is told to breaker once, so that a half-open probe can't leak
+	client.Close()
+	select {
+	case err := <-breaker.results:
+		assert.True(t, perrors.Is(err, common.ErrClientClosed), "error = %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("result of stream isn't told to breaker")
+	}
+	select {
+	case err := <-breaker.results:
+		t.Fatalf("result of stream is told twice, error = %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.NotNil(t, stream.RecvMsg(&wrapperspb.BytesValue{}))
+}
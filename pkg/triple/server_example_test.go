@@ -0,0 +1,49 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package triple
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+func ExampleTripleServer_DrainAndClose() {
+	serviceMap := &sync.Map{}
+	serviceMap.Store("com.apache.dubbo.sample.basic.IGreeter", &subscribeService{events: make(chan string)})
+	server := NewTripleServer(serviceMap, config.NewTripleOption(config.WithLocation("127.0.0.1:20001")))
+	server.Start()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	// requests being handled have 30s to finish, then they are closed by force
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	summary := server.DrainAndClose(ctx)
+	fmt.Printf("%d requests completed, %d requests forced\n", summary.Completed, summary.Forced)
+}
@@ -0,0 +1,268 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package triple
+
+import (
+	"sync"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	"google.golang.org/grpc"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+// ClientStream wraps grpc.ClientStream returned by TripleClient.StreamRequest, with helpers for common streaming patterns
+type ClientStream struct {
+	grpc.ClientStream
+}
+
+// NewClientStream returns ClientStream wrapping @stream
+func NewClientStream(stream grpc.ClientStream) *ClientStream {
+	return &ClientStream{
+		ClientStream: stream,
+	}
+}
+
+// Exchange sends @req and waits for the corresponding response, which is unmarshalled to @rsp. The stream is kept open
+// for the next exchange, so it simplifies ping-pong protocols over a bidi-streaming method, whose server replies each
+// request with exactly one response.
+// Exchange is not safe to be called concurrently on the same stream, nor with SendMsg and RecvMsg in other goroutines,
+// otherwise the responses may be received by the wrong caller.
+func (s *ClientStream) Exchange(req, rsp interface{}) error {
+	if err := s.SendMsg(req); err != nil {
+		return err
+	}
+	return s.RecvMsg(rsp)
+}
+
+// recvMsgTimeouter is implemented by streams of triple, which can give up a single RecvMsg without closing the stream
+type recvMsgTimeouter interface {
+	RecvMsgTimeout(m interface{}, timeout time.Duration) error
+}
+
+// RecvMsgTimeout receives message to @msg like RecvMsg, but it returns DeadlineExceeded error if no message arrives
+// in @d, e.g. to detect the stalled producer which should send heartbeats. The stream is not torn down by the timeout,
+// so the caller can decide to receive again or close it.
+func (s *ClientStream) RecvMsgTimeout(msg interface{}, d time.Duration) error {
+	st, ok := s.ClientStream.(recvMsgTimeouter)
+	if !ok {
+		return perrors.Errorf("stream %T doesn't support RecvMsgTimeout", s.ClientStream)
+	}
+	return st.RecvMsgTimeout(msg, d)
+}
+
+// stopper is implemented by client streams of triple, which can be terminated early by client
+type stopper interface {
+	Stop()
+}
+
+// Stop terminates the stream early and cleanly, e.g. after the first N results of server streaming rpc are received.
+// RST_STREAM is sent to server, whose handler ctx is canceled so that it stops producing, and the following RecvMsg
+// returns Canceled error. It is safe to call it repeatedly, and it is no-op after the rpc is finished.
+func (s *ClientStream) Stop() error {
+	st, ok := s.ClientStream.(stopper)
+	if !ok {
+		return perrors.Errorf("stream %T doesn't support Stop", s.ClientStream)
+	}
+	st.Stop()
+	return nil
+}
+
+// closeNotifier is implemented by client streams of triple, which report the end of rpc
+type closeNotifier interface {
+	OnClose(callback func(err error))
+}
+
+// OnClose registers @callback, which is called exactly once when the stream ends for any reason, e.g. EOF, error
+// status, Stop or cancel, with nil if the final status is OK, otherwise the error of final status, which can be
+// converted by grpc status.FromError. It is called at once if the stream has already ended.
+func (s *ClientStream) OnClose(callback func(err error)) error {
+	st, ok := s.ClientStream.(closeNotifier)
+	if !ok {
+		return perrors.Errorf("stream %T doesn't support OnClose", s.ClientStream)
+	}
+	st.OnClose(callback)
+	return nil
+}
+
+// ServerAckStream wraps bidi-streaming grpc.ServerStream in application-ack mode: client acks the cumulative count of
+// messages it has processed, and SendMsg blocks while Window messages are not acked, e.g. for at-least-once delivery
+// to a slow consumer. Messages of client are all taken as acks, so RecvMsg is not available.
+type ServerAckStream struct {
+	grpc.ServerStream
+	window uint64
+
+	lock    sync.Mutex
+	sent    uint64
+	acked   uint64
+	recvErr error
+	// notify is signaled when ack arrives, done is closed when client stops sending acks
+	notify chan struct{}
+	done   chan struct{}
+}
+
+// NewServerAckStream returns ServerAckStream wrapping @stream with window of @ack. Acks are received in background to
+// message returned by @newAck, and @ackedCount gets the cumulative count of processed messages carried by it.
+func NewServerAckStream(stream grpc.ServerStream, ack config.StreamAck, newAck func() interface{},
+	ackedCount func(ack interface{}) uint64) *ServerAckStream {
+	s := &ServerAckStream{
+		ServerStream: stream,
+		window:       uint64(ack.Window),
+		notify:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	if s.window > 0 {
+		go s.recvAcks(newAck, ackedCount)
+	}
+	return s
+}
+
+func (s *ServerAckStream) recvAcks(newAck func() interface{}, ackedCount func(ack interface{}) uint64) {
+	for {
+		ack := newAck()
+		if err := s.ServerStream.RecvMsg(ack); err != nil {
+			s.lock.Lock()
+			s.recvErr = err
+			s.lock.Unlock()
+			close(s.done)
+			return
+		}
+		count := ackedCount(ack)
+		s.lock.Lock()
+		// acks are cumulative, the stale one is ignored
+		if count > s.acked {
+			s.acked = count
+		}
+		s.lock.Unlock()
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SendMsg sends @m like grpc.ServerStream, it blocks while Window messages are not acked. It returns error if the ctx
+// of rpc is done, or client stops acking by closing its send side when the window is full.
+func (s *ServerAckStream) SendMsg(m interface{}) error {
+	if s.window > 0 {
+		if err := s.waitWindow(); err != nil {
+			return err
+		}
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *ServerAckStream) waitWindow() error {
+	var ctxDone <-chan struct{}
+	if ctx := s.ServerStream.Context(); ctx != nil {
+		ctxDone = ctx.Done()
+	}
+	for {
+		s.lock.Lock()
+		if s.sent-s.acked < s.window {
+			s.sent++
+			s.lock.Unlock()
+			return nil
+		}
+		recvErr := s.recvErr
+		s.lock.Unlock()
+		if recvErr != nil {
+			return perrors.Errorf("ServerAckStream: %d messages are not acked and client stops acking, error = %v",
+				s.window, recvErr)
+		}
+		select {
+		case <-s.notify:
+		case <-s.done:
+		case <-ctxDone:
+			return s.ServerStream.Context().Err()
+		}
+	}
+}
+
+// RecvMsg returns error, as messages of client are received as acks
+func (s *ServerAckStream) RecvMsg(m interface{}) error {
+	if s.window == 0 {
+		return s.ServerStream.RecvMsg(m)
+	}
+	return perrors.New("ServerAckStream: messages of client are received as acks")
+}
+
+// Unacked returns the number of messages sent but not acked by client
+func (s *ServerAckStream) Unacked() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.sent - s.acked
+}
+
+// ClientAckStream wraps bidi-streaming grpc.ClientStream in application-ack mode, see ServerAckStream. Client calls
+// Ack after processing each received message, and the ack of cumulative count is sent every AckEvery messages.
+// It is not safe to call Ack and Flush concurrently.
+type ClientAckStream struct {
+	*ClientStream
+	every     uint64
+	processed uint64
+	acked     uint64
+	newAck    func(processed uint64) interface{}
+}
+
+// NewClientAckStream returns ClientAckStream wrapping @stream with policy @ack, @newAck returns the ack message
+// carrying the cumulative count of @processed messages, which is sent to server
+func NewClientAckStream(stream grpc.ClientStream, ack config.StreamAck, newAck func(processed uint64) interface{}) *ClientAckStream {
+	every := ack.AckEvery
+	if every <= 0 {
+		every = (ack.Window + 1) / 2
+	}
+	if ack.Window > 0 && every > ack.Window {
+		every = ack.Window
+	}
+	if every <= 0 {
+		every = 1
+	}
+	return &ClientAckStream{
+		ClientStream: NewClientStream(stream),
+		every:        uint64(every),
+		newAck:       newAck,
+	}
+}
+
+// Ack marks a received message as processed, and sends ack if AckEvery messages are processed since the last ack
+func (s *ClientAckStream) Ack() error {
+	s.processed++
+	if s.processed-s.acked < s.every {
+		return nil
+	}
+	return s.Flush()
+}
+
+// Flush sends ack of all processed messages at once, e.g. before the consumer pauses
+func (s *ClientAckStream) Flush() error {
+	if s.processed == s.acked {
+		return nil
+	}
+	if err := s.SendMsg(s.newAck(s.processed)); err != nil {
+		return err
+	}
+	s.acked = s.processed
+	return nil
+}
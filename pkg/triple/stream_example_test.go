@@ -0,0 +1,193 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package triple
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+import (
+	"google.golang.org/grpc"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/config"
+)
+
+func ExampleClientStream_Exchange() {
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation("127.0.0.1:20001")))
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	stream, err := client.StreamRequest(context.Background(), "/com.apache.dubbo.sample.basic.IGreeter/PingPong")
+	if err != nil {
+		panic(err)
+	}
+	pingPong := NewClientStream(stream)
+	for i := 0; i < 3; i++ {
+		rsp := &wrapperspb.StringValue{}
+		if err := pingPong.Exchange(wrapperspb.String(fmt.Sprintf("ping %d", i)), rsp); err != nil {
+			panic(err)
+		}
+		fmt.Println(rsp.GetValue())
+	}
+}
+
+// subscribeService is TripleGrpcService with server-streaming method Subscribe, which sends events to subscriber
+type subscribeService struct {
+	events chan string
+}
+
+func (s *subscribeService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: "com.apache.dubbo.sample.basic.IGreeter",
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Subscribe",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					for event := range s.events {
+						if err := stream.SendMsg(wrapperspb.String(event)); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+				ServerStreams: true,
+			},
+		},
+	}
+}
+
+func Example_streamHeartbeat() {
+	const path = "/com.apache.dubbo.sample.basic.IGreeter/Subscribe"
+	service := &subscribeService{events: make(chan string)}
+	serviceMap := &sync.Map{}
+	serviceMap.Store("com.apache.dubbo.sample.basic.IGreeter", service)
+	// server sends heartbeat after the subscription is idle for 30s, to keep it alive through proxies
+	server := NewTripleServer(serviceMap, config.NewTripleOption(config.WithLocation("127.0.0.1:20001"),
+		config.WithMethodStreamHeartbeat(path, config.StreamHeartbeat{Interval: 30 * time.Second})))
+	server.Start()
+	defer server.Stop()
+
+	// client skips heartbeats, only events are received
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation("127.0.0.1:20001"),
+		config.WithHeartbeatPredicate(config.EmptyHeartbeatPredicate)))
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	stream, err := client.StreamRequest(context.Background(), path)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		// the subscription idles for minutes before the event
+		time.Sleep(5 * time.Minute)
+		service.events <- "event"
+		close(service.events)
+	}()
+	for {
+		event := &wrapperspb.StringValue{}
+		if err := stream.RecvMsg(event); err == io.EOF {
+			return
+		} else if err != nil {
+			panic(err)
+		}
+		fmt.Println(event.GetValue())
+	}
+}
+
+// produceService is TripleGrpcService with bidi-streaming method Produce, which sends jobs in application-ack mode
+type produceService struct {
+	ack config.StreamAck
+}
+
+func (s *produceService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: "com.apache.dubbo.sample.basic.IGreeter",
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Produce",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					// acks are cumulative counts of jobs processed by client
+					producer := NewServerAckStream(stream, s.ack, func() interface{} {
+						return &wrapperspb.UInt64Value{}
+					}, func(ack interface{}) uint64 {
+						return ack.(*wrapperspb.UInt64Value).GetValue()
+					})
+					for i := 0; i < 100; i++ {
+						// it blocks while 8 jobs are not acked
+						if err := producer.SendMsg(wrapperspb.String(fmt.Sprintf("job %d", i))); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+				ServerStreams: true,
+				ClientStreams: true,
+			},
+		},
+	}
+}
+
+func Example_streamAck() {
+	ack := config.StreamAck{Window: 8, AckEvery: 4}
+	serviceMap := &sync.Map{}
+	serviceMap.Store("com.apache.dubbo.sample.basic.IGreeter", &produceService{ack: ack})
+	server := NewTripleServer(serviceMap, config.NewTripleOption(config.WithLocation("127.0.0.1:20001")))
+	server.Start()
+	defer server.Stop()
+
+	client, err := NewTripleClient(nil, config.NewTripleOption(config.WithLocation("127.0.0.1:20001")))
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	stream, err := client.StreamRequest(context.Background(), "/com.apache.dubbo.sample.basic.IGreeter/Produce")
+	if err != nil {
+		panic(err)
+	}
+	consumer := NewClientAckStream(stream, ack, func(processed uint64) interface{} {
+		return wrapperspb.UInt64(processed)
+	})
+	for {
+		job := &wrapperspb.StringValue{}
+		if err := consumer.RecvMsg(job); err == io.EOF {
+			return
+		} else if err != nil {
+			panic(err)
+		}
+		// the slow consumer doesn't ack while processing, so server pauses after sending 8 jobs
+		time.Sleep(time.Second)
+		fmt.Println(job.GetValue())
+		// ack is sent every 4 processed jobs, then server resumes
+		if err := consumer.Ack(); err != nil {
+			panic(err)
+		}
+	}
+}
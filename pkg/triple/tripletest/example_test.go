@@ -0,0 +1,112 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tripletest_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+import (
+	"google.golang.org/grpc"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/triple/tripletest"
+)
+
+const greeterInterfaceKey = "com.apache.dubbo.sample.basic.IGreeter"
+
+// greeterService is TripleGrpcService with unary method Upper and bidi-streaming method Echo
+type greeterService struct{}
+
+func (s *greeterService) ServiceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: greeterInterfaceKey,
+		Methods: []grpc.MethodDesc{
+			{
+				MethodName: "Upper",
+				Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+					in := &wrapperspb.StringValue{}
+					if err := dec(in); err != nil {
+						return nil, err
+					}
+					return wrapperspb.String(strings.ToUpper(in.Value)), nil
+				},
+			},
+		},
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName: "Echo",
+				Handler: func(srv interface{}, stream grpc.ServerStream) error {
+					for {
+						msg := &wrapperspb.StringValue{}
+						if err := stream.RecvMsg(msg); err == io.EOF {
+							return nil
+						} else if err != nil {
+							return err
+						}
+						if err := stream.SendMsg(msg); err != nil {
+							return err
+						}
+					}
+				},
+				ServerStreams: true,
+				ClientStreams: true,
+			},
+		},
+	}
+}
+
+func ExampleNewInMemory() {
+	m, err := tripletest.NewInMemory(map[string]interface{}{greeterInterfaceKey: &greeterService{}})
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	reply := &wrapperspb.StringValue{}
+	rsp := m.Client.Request(context.Background(), "/"+greeterInterfaceKey+"/Upper", wrapperspb.String("triple"), reply)
+	if err := rsp.GetError(); err != nil {
+		panic(err)
+	}
+	fmt.Println(reply.GetValue())
+
+	stream, err := m.Client.StreamRequest(context.Background(), "/"+greeterInterfaceKey+"/Echo")
+	if err != nil {
+		panic(err)
+	}
+	for _, msg := range []string{"ping", "pong"} {
+		if err := stream.SendMsg(wrapperspb.String(msg)); err != nil {
+			panic(err)
+		}
+		echo := &wrapperspb.StringValue{}
+		if err := stream.RecvMsg(echo); err != nil {
+			panic(err)
+		}
+		fmt.Println(echo.GetValue())
+	}
+	// Output:
+	// TRIPLE
+	// ping
+	// pong
+}
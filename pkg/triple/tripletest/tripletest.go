@@ -0,0 +1,156 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+Package tripletest wires TripleServer and TripleClient over in-memory conns, so that services are tested without
+network or free ports. Conns of Listener are net.Pipe, which supports deadlines like tcp conns.
+*/
+package tripletest
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	"github.com/dubbogo/triple/pkg/config"
+	"github.com/dubbogo/triple/pkg/triple"
+)
+
+// Address is the address of Listener, which is the Location of server and client of InMemory
+const Address = "tripletest:0"
+
+var errListenerClosed = perrors.New("tripletest: listener is closed")
+
+// Listener is in-memory net.Listener, which accepts conns dialed by its DialContext
+type Listener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener returns in-memory Listener, it's used by server with config.WithListener, and by client with
+// config.WithDialContext(listener.DialContext)
+func NewListener() *Listener {
+	return &Listener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept implements net.Listener, it blocks until a conn is dialed or listener is closed
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+// Close implements net.Listener, conns accepted before are not closed
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr implements net.Listener
+func (l *Listener) Addr() net.Addr {
+	return inMemoryAddr{}
+}
+
+// DialContext dials conn to listener, @network and @addr are ignored. It blocks until the conn is accepted, @ctx is
+// done or listener is closed.
+func (l *Listener) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+type inMemoryAddr struct{}
+
+func (inMemoryAddr) Network() string {
+	return "memory"
+}
+
+func (inMemoryAddr) String() string {
+	return Address
+}
+
+// InMemory is TripleServer and TripleClient connected by in-memory Listener
+type InMemory struct {
+	Server *triple.TripleServer
+	// Client is created with nil impl, it sends rpcs by Request, RequestRaw and StreamRequest
+	Client *triple.TripleClient
+
+	listener *Listener
+	opts     []config.OptionFunction
+}
+
+// NewInMemory starts TripleServer with @services, whose keys are interface keys to register, and returns it with
+// connected TripleClient. @fs are applied to both server and client, e.g. config.WithCodecType, except that Location,
+// Listener and DialContext are set by InMemory.
+func NewInMemory(services map[string]interface{}, fs ...config.OptionFunction) (*InMemory, error) {
+	serviceMap := &sync.Map{}
+	for interfaceKey, service := range services {
+		serviceMap.Store(interfaceKey, service)
+	}
+	listener := NewListener()
+	server := triple.NewTripleServer(serviceMap, config.NewTripleOption(
+		append(fs, config.WithLocation(Address), config.WithListener(listener))...))
+	server.Start()
+
+	m := &InMemory{
+		Server:   server,
+		listener: listener,
+		opts:     fs,
+	}
+	client, err := m.NewClient(nil)
+	if err != nil {
+		server.Stop()
+		return nil, err
+	}
+	m.Client = client
+	return m, nil
+}
+
+// NewClient returns another TripleClient of @impl connected to server, e.g. client of pb stub. @fs are applied after
+// options of NewInMemory. The client is closed by caller.
+func (m *InMemory) NewClient(impl interface{}, fs ...config.OptionFunction) (*triple.TripleClient, error) {
+	opts := append(append(append([]config.OptionFunction{}, m.opts...), fs...),
+		config.WithLocation(Address), config.WithDialContext(m.listener.DialContext))
+	return triple.NewTripleClient(impl, config.NewTripleOption(opts...))
+}
+
+// Close closes Client and stops Server
+func (m *InMemory) Close() {
+	m.Client.Close()
+	m.Server.Stop()
+}